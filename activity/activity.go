@@ -0,0 +1,129 @@
+// Package activity records significant events in the history of each user,
+// such as their creation, updates and logins, in the "activities"
+// collection, for support and debugging.
+//
+// Recording is best effort: a write or login that succeeded is not failed
+// because its activity could not be stored. Activities are kept for 90
+// days.
+package activity
+
+import (
+	"context"
+	"time"
+
+	"golang/clientip"
+	"golang/db"
+	"golang/requestid"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Activity types.
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+	UserLogin   = "user.login"
+)
+
+// retention is how long activities are kept.
+const retention = 90 * 24 * time.Hour
+
+func init() {
+	keep := retention
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "activities", Name: "user_feed", Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		db.IndexSpec{Collection: "activities", Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, ExpireAfter: &keep},
+	)
+}
+
+// Activity is an event in the history of a user.
+type Activity struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID string             `bson:"tenant_id,omitempty" json:"-"`
+	UserID   string             `bson:"user_id" json:"user_id"`
+	Type     string             `bson:"type" json:"type"`
+	// Fields are the names of the fields an update set.
+	Fields []string `bson:"fields,omitempty" json:"fields,omitempty"`
+	// RequestID is the request that caused the activity, for finding its
+	// logs and traces.
+	RequestID string `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	// ClientIP is the address the request came from, kept for audits
+	// but not served with the feed.
+	ClientIP  string    `bson:"client_ip,omitempty" json:"-"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Log stores activities.
+type Log struct {
+	mc *db.MongoClient
+}
+
+// New returns a log stored through mc.
+func New(mc *db.MongoClient) *Log {
+	return &Log{mc: mc}
+}
+
+// Record stores an activity of typ for user userID. Its id, tenant,
+// request id, client address and time are taken from ctx and the clock.
+func (l *Log) Record(ctx context.Context, userID, typ string, fields ...string) error {
+	_, err := l.mc.Collection("activities").InsertOne(ctx, Activity{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenant.FromContext(ctx),
+		UserID:    userID,
+		Type:      typ,
+		Fields:    fields,
+		RequestID: requestid.FromContext(ctx),
+		ClientIP:  clientip.FromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+	}, options.InsertOne().SetComment(db.Comment(ctx)))
+	return err
+}
+
+// Anonymize strips the activities of user userID in the tenant of ctx of
+// what ties them to the user, its id, the client address and the request,
+// keeping what happened and when. It returns how many it changed.
+func (l *Log) Anonymize(ctx context.Context, userID string) (int64, error) {
+	filter := bson.M{"tenant_id": nil, "user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	// A fresh id keeps the erased user's activities together without
+	// naming the user
+	res, err := l.mc.Collection("activities").UpdateMany(ctx, filter, bson.M{
+		"$set":   bson.M{"user_id": "erased:" + primitive.NewObjectID().Hex()},
+		"$unset": bson.M{"client_ip": "", "request_id": ""},
+	}, options.Update().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// List returns up to limit activities of user userID in the tenant of ctx,
+// newest first, starting after activity before unless it is zero. A limit
+// of zero returns them all.
+func (l *Log) List(ctx context.Context, userID string, before primitive.ObjectID, limit int) ([]Activity, error) {
+	filter := bson.M{"tenant_id": nil, "user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	if !before.IsZero() {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+	cur, err := l.mc.ReadCollection("activities").Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Activity{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
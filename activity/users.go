@@ -0,0 +1,61 @@
+package activity
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"golang/store"
+)
+
+// Users records an activity for every successful write through the
+// wrapped UserRepository.
+type Users struct {
+	store.UserRepository
+	l *Log
+}
+
+// NewUsers returns next recording its writes in l.
+func NewUsers(next store.UserRepository, l *Log) *Users {
+	return &Users{UserRepository: next, l: l}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	if err := u.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	u.record(ctx, user.ID, UserCreated)
+	return nil
+}
+
+// Update records the names of the fields it set; a new password hash
+// shows as "password".
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		if k == "password_hash" {
+			k = "password"
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	u.record(ctx, id, UserUpdated, names...)
+	return nil
+}
+
+func (u *Users) Delete(ctx context.Context, id string) error {
+	if err := u.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	u.record(ctx, id, UserDeleted)
+	return nil
+}
+
+func (u *Users) record(ctx context.Context, id, typ string, fields ...string) {
+	if err := u.l.Record(ctx, id, typ, fields...); err != nil {
+		slog.ErrorContext(ctx, "failed to record activity", "type", typ, "user_id", id, "error", err)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// SetAccessLogSample replaces the percentage of the requests answered
+// below 400 that are access logged; 0 or 100 logs them all.
+func (rt *Router) SetAccessLogSample(percent int) {
+	if percent <= 0 {
+		percent = 100
+	}
+	rt.logSample.Store(int64(percent))
+}
+
+// accessLogMiddleware writes one structured log line per request, for the
+// percentage of the requests answered below 400 sample returns and for
+// all others.
+func accessLogMiddleware(mux *http.ServeMux, sample func() int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ri := withRoute(mux, r)
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.Status()
+		if pct := sample(); status < 400 && pct < 100 && rand.Int63n(100) >= pct {
+			return
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", ri.name),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("client_ip", ClientIP(r)),
+		)
+	})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"golang/activity"
+	"golang/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Page sizes of activity feeds.
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 200
+)
+
+// userActivity - GET /users/{id}/activity
+// Returns the activities of the user newest first, as {data, next}. next
+// is the URL of the older ones, selected by before, an activity id, or
+// null on the last page.
+func userActivity(users store.UserRepository, log *activity.Log, w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	var before primitive.ObjectID
+	if v := q.Get("before"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid before")
+			return
+		}
+		before = oid
+	}
+	limit := defaultActivityLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxActivityLimit)
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	if _, err := users.Get(ctx, id); err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	out, err := log.List(ctx, id, before, limit)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+
+	var next *string
+	if len(out) == limit {
+		q.Set("before", out[len(out)-1].ID.Hex())
+		q.Set("limit", strconv.Itoa(limit))
+		s := r.URL.Path + "?" + q.Encode()
+		next = &s
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": out, "next": next})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"golang/buildinfo"
+	"golang/db"
+	"golang/selfcheck"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// startTime is used to report uptime.
+var startTime = time.Now()
+
+// adminInfo - GET /admin/info
+// report, the startup self-check report, may be nil.
+func adminInfo(mc *db.MongoClient, f *inflight, report *selfcheck.Report, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	resp := map[string]any{
+		"build":          buildinfo.Get(),
+		"started_at":     startTime.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(startTime).Seconds()),
+		"runtime": map[string]any{
+			"goroutines":       runtime.NumGoroutine(),
+			"gomaxprocs":       runtime.GOMAXPROCS(0),
+			"num_cpu":          runtime.NumCPU(),
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"heap_inuse_bytes": mem.HeapInuse,
+			"sys_bytes":        mem.Sys,
+			"num_gc":           mem.NumGC,
+			"last_gc":          time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339),
+			"gc_pause_total":   time.Duration(mem.PauseTotalNs).String(),
+		},
+		"in_flight": map[string]any{
+			"requests":  f.snapshot(),
+			"mutations": f.mutations.Load(),
+		},
+	}
+	if report != nil {
+		resp["self_check"] = report
+	}
+	if mc == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	resp["mongo_pool"] = db.Pool()
+	resp["mongo_breaker"] = mc.Breaker.State()
+	if since, err := mc.Failover(); err != nil {
+		resp["mongo_failover_since"] = since.UTC().Format(time.RFC3339)
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	mongo := map[string]any{"database": mc.DB.Name()}
+	var build bson.M
+	if err := mc.DB.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+		mongo["error"] = err.Error()
+	} else {
+		mongo["version"] = db.ToJSON(build["version"])
+	}
+	var hello bson.M
+	if err := mc.DB.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		mongo["error"] = err.Error()
+	} else {
+		topology := map[string]any{
+			"writable_primary": hello["isWritablePrimary"],
+		}
+		for _, k := range []string{"setName", "primary", "hosts", "msg"} {
+			if v, ok := hello[k]; ok {
+				topology[k] = db.ToJSON(v)
+			}
+		}
+		mongo["topology"] = topology
+	}
+	resp["mongo"] = mongo
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed adminui
+var adminUIFiles embed.FS
+
+// adminUI serves the single-page admin UI under /admin/ui/. The page asks
+// for the admin token and sends it as a bearer token with every API call.
+// The static files are served behind adminUIAuth.
+func adminUI() http.Handler {
+	sub, err := fs.Sub(adminUIFiles, "adminui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(sub)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// adminUIAuth puts next behind adminOnly for browsers, which can't send a
+// bearer token when navigating: requests without credentials are challenged
+// for HTTP basic authentication, and its password is taken as the token.
+func adminUIAuth(token string, next http.Handler) http.Handler {
+	protected := adminOnly(token, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); ok {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+password)
+		} else if token != "" && r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+// adminUIConfig - GET /admin/ui/config
+// Tells the UI how to address tenants. It is admin only, so the UI also uses
+// it to check the token at sign-in.
+func adminUIConfig(tenants *tenantResolver, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resp := map[string]string{}
+	if tenants != nil && tenants.opts.Mode == "header" {
+		resp["tenant_header"] = tenants.opts.Header
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,173 @@
+// Admin UI for the users API. Every request carries the admin token as a
+// bearer token; cookies are never sent, so the UI works the same with or
+// without cookie sessions enabled.
+"use strict";
+
+const pageSize = 25;
+const state = { token: sessionStorage.getItem("adminToken") || "", config: null, offset: 0, q: "", editing: null };
+
+const $ = (id) => document.getElementById(id);
+
+async function api(method, path, body) {
+  const headers = { Authorization: "Bearer " + state.token };
+  if (state.config && state.config.tenant_header && $("tenant").value) {
+    headers[state.config.tenant_header] = $("tenant").value;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const res = await fetch(path, {
+    method,
+    headers,
+    credentials: "omit",
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await res.json().catch(() => ({}));
+  if (!res.ok) {
+    const err = new Error(data.error || res.statusText);
+    err.status = res.status;
+    throw err;
+  }
+  return data;
+}
+
+// quote makes s a string literal of the filter language.
+function quote(s) {
+  return '"' + s.replace(/\\/g, "\\\\").replace(/"/g, '\\"') + '"';
+}
+
+async function signIn() {
+  try {
+    state.config = await api("GET", "/admin/ui/config");
+  } catch (err) {
+    if (err.status === 401) {
+      sessionStorage.removeItem("adminToken");
+      state.token = "";
+    }
+    $("signin-error").textContent = err.message;
+    return;
+  }
+  sessionStorage.setItem("adminToken", state.token);
+  $("signin").hidden = true;
+  $("app").hidden = false;
+  $("signout").hidden = false;
+  $("tenant").hidden = !state.config.tenant_header;
+  load();
+}
+
+async function load() {
+  $("error").textContent = "";
+  const params = new URLSearchParams({ offset: state.offset, limit: pageSize + 1 });
+  if (state.q) {
+    params.set("filter", "name ~ " + quote(state.q) + " or email ~ " + quote(state.q));
+  }
+  let users;
+  try {
+    users = await api("GET", "/users?" + params);
+  } catch (err) {
+    $("error").textContent = err.message;
+    return;
+  }
+  const more = users.length > pageSize;
+  render(users.slice(0, pageSize));
+  $("prev").disabled = state.offset === 0;
+  $("next").disabled = !more;
+  $("page").textContent = users.length ? `${state.offset + 1}–${state.offset + Math.min(users.length, pageSize)}` : "No users";
+}
+
+function render(users) {
+  const rows = $("rows");
+  rows.replaceChildren();
+  for (const u of users) {
+    const tr = document.createElement("tr");
+    for (const v of [u.name, u.email, u.age, u.created_at ? new Date(u.created_at).toLocaleString() : ""]) {
+      const td = document.createElement("td");
+      td.textContent = v ?? "";
+      tr.append(td);
+    }
+    const actions = document.createElement("td");
+    const edit = document.createElement("button");
+    edit.textContent = "Edit";
+    edit.onclick = () => openEditor(u);
+    const del = document.createElement("button");
+    del.textContent = "Delete";
+    del.onclick = () => remove(u);
+    actions.append(edit, " ", del);
+    tr.append(actions);
+    rows.append(tr);
+  }
+}
+
+function openEditor(u) {
+  state.editing = u;
+  const form = $("edit");
+  form.name.value = u.name || "";
+  form.email.value = u.email || "";
+  form.age.value = u.age ?? "";
+  $("edit-error").textContent = "";
+  $("editor").showModal();
+}
+
+async function save(event) {
+  event.preventDefault();
+  const form = $("edit");
+  const u = state.editing;
+  const changes = {};
+  if (form.name.value !== (u.name || "")) changes.name = form.name.value;
+  if (form.email.value !== (u.email || "")) changes.email = form.email.value;
+  if (form.age.value !== String(u.age ?? "")) changes.age = Number(form.age.value);
+  if (Object.keys(changes).length === 0) {
+    $("editor").close();
+    return;
+  }
+  try {
+    await api("PUT", "/users/" + encodeURIComponent(u.id), changes);
+  } catch (err) {
+    $("edit-error").textContent = err.message;
+    return;
+  }
+  $("editor").close();
+  load();
+}
+
+async function remove(u) {
+  if (!confirm(`Delete ${u.name || u.email || u.id}?`)) {
+    return;
+  }
+  try {
+    await api("DELETE", "/users/" + encodeURIComponent(u.id));
+  } catch (err) {
+    $("error").textContent = err.message;
+    return;
+  }
+  load();
+}
+
+$("signin").onsubmit = (event) => {
+  event.preventDefault();
+  state.token = $("token").value;
+  signIn();
+};
+$("signout").onclick = () => {
+  sessionStorage.removeItem("adminToken");
+  location.reload();
+};
+$("search").onsubmit = (event) => {
+  event.preventDefault();
+  state.q = $("q").value.trim();
+  state.offset = 0;
+  load();
+};
+$("prev").onclick = () => {
+  state.offset = Math.max(0, state.offset - pageSize);
+  load();
+};
+$("next").onclick = () => {
+  state.offset += pageSize;
+  load();
+};
+$("save").onclick = save;
+
+if (state.token) {
+  signIn();
+}
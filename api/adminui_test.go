@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang/store"
+)
+
+func TestAdminUIRequiresToken(t *testing.T) {
+	router := NewRouter(nil, Options{Users: store.NewMemoryUsers(), AdminToken: "secret"})
+	tests := []struct {
+		name  string
+		path  string
+		auth  func(r *http.Request)
+		want  int
+		basic bool // whether the response challenges for basic auth
+	}{
+		{"no credentials", "/admin/ui/", func(*http.Request) {}, http.StatusUnauthorized, true},
+		{"redirect without credentials", "/admin", func(*http.Request) {}, http.StatusUnauthorized, true},
+		{"wrong bearer token", "/admin/ui/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, false},
+		{"wrong basic password", "/admin/ui/", func(r *http.Request) { r.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized, false},
+		{"bearer token", "/admin/ui/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK, false},
+		{"basic password", "/admin/ui/app.js", func(r *http.Request) { r.SetBasicAuth("", "secret") }, http.StatusOK, false},
+		{"redirect", "/admin", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			tt.auth(req)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if basic := strings.HasPrefix(challenge, "Basic"); basic != tt.basic {
+				t.Errorf("WWW-Authenticate = %q, want basic challenge %v", challenge, tt.basic)
+			}
+		})
+	}
+}
+
+func TestAdminUIDisabledWithoutToken(t *testing.T) {
+	router := NewRouter(nil, Options{Users: store.NewMemoryUsers()})
+	req := httptest.NewRequest(http.MethodGet, "/admin/ui/", nil)
+	req.SetBasicAuth("admin", "")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("GET /admin/ui/ without ADMIN_TOKEN = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
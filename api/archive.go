@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"golang/db"
+)
+
+// archive - GET, POST /admin/archive
+// GET reports the current or last archive run; POST starts a run in the
+// background and answers 202, or 409 while one is in progress.
+func archive(job *db.ArchiveJob, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, job.Status())
+
+	case http.MethodPost:
+		// The run outlives the request
+		if err := job.Start(context.WithoutCancel(r.Context())); err != nil {
+			writeError(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job.Status())
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"golang/authz"
+	"golang/tenant"
+)
+
+// AuthzOptions configures delegating the decision whether to serve each
+// request to a policy engine.
+type AuthzOptions struct {
+	Authorizer authz.Authorizer
+	// Skip are paths served without asking, such as the health checks.
+	Skip []string
+}
+
+// authzMiddleware asks opts.Authorizer about every request, answering 403
+// to those it denies. It fails closed: requests it can't decide on get a
+// 503. It must run inside the session middleware.
+func authzMiddleware(adminToken string, opts AuthzOptions, next http.Handler) http.Handler {
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, p := range opts.Skip {
+		skip[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		in := authz.Input{
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Route:    routeName(r),
+			Tenant:   tenant.FromContext(r.Context()),
+			Role:     callerRole(adminToken, r),
+			ClientIP: ClientIP(r),
+		}
+		switch sess := sessionFromContext(r.Context()); {
+		case in.Role == RoleAdmin:
+			in.Subject = "admin"
+		case sess != nil:
+			in.Subject = "user:" + sess.UserID.Hex()
+		}
+
+		ctx, cancel := opContext(r)
+		d, err := opts.Authorizer.Authorize(ctx, in)
+		cancel()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "authorization failed", "error", err)
+			writeError(w, r, http.StatusServiceUnavailable, "authorization unavailable")
+			return
+		}
+		if !d.Allow {
+			msg := d.Reason
+			if msg == "" {
+				msg = "forbidden"
+			}
+			writeError(w, r, http.StatusForbidden, msg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
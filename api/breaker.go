@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"golang/db"
+)
+
+// breakerKey holds the breaker of the request, for dbError.
+type breakerKey struct{}
+
+// breakerMiddleware rejects requests with 503 while the database circuit is
+// open, so they fail fast instead of piling up until they time out. Probes,
+// metrics and admin routes don't need Mongo to answer and always pass. With
+// degraded reads, reads of single users pass too, to be answered from
+// their last copy; degraded may be nil.
+func breakerMiddleware(b *db.Breaker, degraded *degraded, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/version", r.URL.Path == "/metrics",
+			strings.HasPrefix(r.URL.Path, "/admin/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait, err := b.Allow(); err != nil {
+			if degraded.servable(r) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), breakerOpenKey{}, wait)))
+				return
+			}
+			writeRetryError(w, r, http.StatusServiceUnavailable, CodeDBUnavailable, err.Error(), wait)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), breakerKey{}, b)))
+	})
+}
+
+// dbError writes the error for a failed database operation. Errors showing
+// Mongo is down or too busy map to 503, or to 504 when the request ran past
+// its deadline.
+func dbError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if timedOut(r, err) {
+		_, d := deadline(r)
+		writeTimeout(w, r, d)
+		return
+	}
+	if db.Unavailable(err) {
+		// The breaker may have opened on this failure; until it does,
+		// clients back off for the minimum
+		b, _ := r.Context().Value(breakerKey{}).(*db.Breaker)
+		writeRetryError(w, r, http.StatusServiceUnavailable, CodeDBUnavailable, op+" error: "+db.ErrUnavailable.Error(), b.RetryAfter())
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, op+" error: "+err.Error())
+}
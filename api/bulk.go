@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang/store"
+	"golang/validation"
+)
+
+// maxBulkChanges caps the entries of one bulk update.
+const maxBulkChanges = 1000
+
+// bulkChange is an entry of a bulk update request.
+type bulkChange struct {
+	ID      string         `json:"id"`
+	Changes map[string]any `json:"changes"`
+}
+
+// bulkResult is the outcome of one entry, with the status and error code
+// PUT /users/{id} would have answered.
+type bulkResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// readBulkChanges decodes the entries of a bulk update one at a time, so
+// a body with too many is refused without reading the rest.
+func readBulkChanges(body io.Reader) ([]bulkChange, error) {
+	tooMany := fmt.Errorf("expected 1 to %d entries", maxBulkChanges)
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("invalid json body")
+	}
+	var in []bulkChange
+	for dec.More() {
+		if len(in) == maxBulkChanges {
+			return nil, tooMany
+		}
+		var c bulkChange
+		if err := dec.Decode(&c); err != nil {
+			return nil, errors.New("invalid json body")
+		}
+		in = append(in, c)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.New("invalid json body")
+	}
+	if len(in) == 0 {
+		return nil, tooMany
+	}
+	return in, nil
+}
+
+// bulkUpdateUsers - PATCH /users/bulk
+// Applies a list of {id, changes} entries independently, each like PUT
+// /users/{id}, and reports the outcome of each in order. Valid entries go
+// to the repository in one batch when it is a store.BulkUsers, otherwise,
+// such as when writes are published, one at a time.
+func bulkUpdateUsers(users store.UserRepository, rules *validation.Validator, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	in, err := readBulkChanges(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := make([]bulkResult, len(in))
+	var changes []store.UserChange
+	var index []int // entry of each change
+	seen := make(map[string]bool, len(in))
+	for i, c := range in {
+		results[i] = bulkResult{ID: c.ID, Status: http.StatusBadRequest, Code: CodeValidationFailed}
+		if c.ID == "" {
+			results[i].Error = localize(w, r, "id is required")
+			continue
+		}
+		if seen[c.ID] {
+			// Unordered batches would apply both in any order
+			results[i].Error = localize(w, r, "duplicate id")
+			continue
+		}
+		seen[c.ID] = true
+		fields, err := userChanges(rules, c.Changes)
+		if err != nil {
+			results[i].Error = localize(w, r, err.Error())
+			continue
+		}
+		changes = append(changes, store.UserChange{ID: c.ID, Fields: fields})
+		index = append(index, i)
+	}
+
+	if len(changes) > 0 {
+		ctx, cancel := opContext(r)
+		defer cancel()
+
+		var errs []error
+		if bulk, ok := users.(store.BulkUsers); ok {
+			errs, err = bulk.UpdateMany(ctx, changes)
+		} else {
+			errs, err = store.UpdateEach(ctx, users, changes)
+		}
+		if err != nil {
+			dbError(w, r, "update", err)
+			return
+		}
+		for j, err := range errs {
+			res := &results[index[j]]
+			switch {
+			case err == nil:
+				res.Status, res.Code = http.StatusOK, ""
+			case errors.Is(err, store.ErrInvalidID):
+				res.Code, res.Error = CodeInvalidID, localize(w, r, "invalid id")
+			case errors.Is(err, store.ErrNotFound):
+				res.Status, res.Code, res.Error = http.StatusNotFound, CodeUserNotFound, localize(w, r, "not found")
+			case errors.Is(err, store.ErrDuplicateEmail):
+				res.Status, res.Code, res.Error = http.StatusConflict, CodeDuplicateEmail, localize(w, r, "email already in use")
+			default:
+				res.Error = localize(w, r, err.Error())
+			}
+		}
+	}
+
+	updated := 0
+	for _, res := range results {
+		if res.Status == http.StatusOK {
+			updated++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"updated": updated,
+		"failed":  len(results) - updated,
+		"results": results,
+	})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// privateCache is the Cache-Control of responses to requests with
+// credentials, which may be personal to the caller: no cache keeps them.
+const privateCache = "private, no-store"
+
+// cachePolicy holds the configured Cache-Control per route.
+type cachePolicy struct {
+	routes map[string]string // route name -> Cache-Control
+	// vary are request headers responses depend on without the handlers
+	// naming them, such as the tenant header.
+	vary []string
+}
+
+// newCachePolicy returns the policy of routes, whose values may separate
+// directives with ";" as commas separate entries in the environment.
+func newCachePolicy(routes map[string]string, vary []string) *cachePolicy {
+	p := &cachePolicy{routes: make(map[string]string, len(routes)), vary: vary}
+	for route, cc := range routes {
+		parts := strings.FieldsFunc(cc, func(r rune) bool { return r == ';' || r == ',' })
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		p.routes[route] = strings.Join(parts, ", ")
+	}
+	return p
+}
+
+// forResponse returns the Cache-Control for the response to r with
+// status, or "" to send none. Only successful reads get the route's
+// policy, and only without credentials.
+func (p *cachePolicy) forResponse(r *http.Request, status int) string {
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return privateCache
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	if status < 200 || status >= 300 {
+		return ""
+	}
+	return p.routes[routeName(r)]
+}
+
+// cacheMiddleware sets the Cache-Control of responses whose handler set
+// none from the policy current returns. With no routes configured it
+// leaves every response alone.
+func cacheMiddleware(current func() *cachePolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := current()
+		if p == nil || len(p.routes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cacheWriter{ResponseWriter: w, r: r, p: p}, r)
+	})
+}
+
+// cacheWriter sets Cache-Control when the status is known, after the
+// handler has refined the route name.
+type cacheWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	p    *cachePolicy
+	done bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.done && code >= 200 {
+		cw.done = true
+		h := cw.Header()
+		if h.Get("Cache-Control") == "" {
+			if cc := cw.p.forResponse(cw.r, code); cc != "" {
+				h.Set("Cache-Control", cc)
+				if cc != privateCache {
+					for _, name := range cw.p.vary {
+						h.Add("Vary", name)
+					}
+				}
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.done {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if !cw.done {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Versions of a canary route, in the X-Handler-Version response header
+// and the version label of the canary metrics.
+const (
+	VersionStable = "stable"
+	VersionCanary = "canary"
+)
+
+var (
+	canaryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_canary_requests_total",
+		Help: "Requests to canary routes by route, version (stable or canary) and status code.",
+	}, []string{"route", "version", "status"})
+	canaryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "api_canary_request_duration_seconds",
+		Help:    "Latency of requests to canary routes by route and version (stable or canary).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "version"})
+)
+
+// SetCanaryWeights replaces the percentage of the requests to each canary
+// route (see Registrar.Canary) that its canary serves.
+func (rt *Router) SetCanaryWeights(weights map[string]int) {
+	rt.canary.Store(&weights)
+}
+
+// canaryWeight returns the percentage of requests to pattern the canary
+// serves.
+func (rt *Router) canaryWeight(pattern string) int {
+	if w := rt.canary.Load(); w != nil {
+		return (*w)[pattern]
+	}
+	return 0
+}
+
+// Canary registers two implementations of pattern, such as the current
+// and a rewritten list pipeline: each request goes to canary with the
+// probability of the route's weight in Options.CanaryWeights, a
+// percentage, and to stable otherwise. Responses name the version in
+// X-Handler-Version, and the canary metrics count both by status and
+// latency, so a rewrite can be rolled out gradually and rolled back by
+// setting the weight to 0.
+func (rc *Registrar) Canary(pattern string, stable, canary http.Handler) {
+	rt := rc.rt
+	rc.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, h := VersionStable, stable
+		if weight := rt.canaryWeight(pattern); weight > 0 && rand.Intn(100) < weight {
+			version, h = VersionCanary, canary
+		}
+		w.Header().Set("X-Handler-Version", version)
+		sr := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sr, r)
+		canaryDuration.WithLabelValues(pattern, version).Observe(time.Since(start).Seconds())
+		canaryRequests.WithLabelValues(pattern, version, strconv.Itoa(sr.Status())).Inc()
+	}))
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/capture"
+	"golang/requestid"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// captureTimeout bounds storing a capture, which happens after the
+// response is written.
+const captureTimeout = 5 * time.Second
+
+// captureMiddleware records a sample of the requests and their responses
+// through rec. Operational routes, such as /admin and the health checks,
+// are not captured.
+func captureMiddleware(rec *capture.Recorder, next http.Handler) http.Handler {
+	opts := rec.Options()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, operational := operationalRoute(r.URL.Path); operational || !rec.Sample() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqBody := &captureBuffer{max: opts.MaxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		cw := &captureWriter{ResponseWriter: w, body: captureBuffer{max: opts.MaxBody}}
+		start := time.Now()
+		next.ServeHTTP(cw, r)
+
+		e := &capture.Exchange{
+			TenantID:  tenant.FromContext(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     rec.Query(r.URL.Query()),
+			Status:    cw.Status(),
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID: requestid.FromContext(r.Context()),
+		}
+		for _, name := range opts.Headers {
+			if v := r.Header.Get(name); v != "" {
+				if e.Header == nil {
+					e.Header = map[string]string{}
+				}
+				e.Header[http.CanonicalHeaderKey(name)] = v
+			}
+		}
+		e.Body, e.BodyOmitted = rec.Body(reqBody.Bytes(), r.Header.Get("Content-Type"), reqBody.over)
+		e.ResponseBody, e.ResponseBodyOmitted = rec.Body(cw.body.Bytes(), w.Header().Get("Content-Type"), cw.body.over)
+
+		// Stored after the response, without holding up the request
+		go func(ctx context.Context) {
+			ctx, cancel := context.WithTimeout(ctx, captureTimeout)
+			defer cancel()
+			if err := rec.Record(ctx, e); err != nil {
+				slog.WarnContext(ctx, "failed to store capture", "error", err)
+			}
+		}(context.WithoutCancel(r.Context()))
+	})
+}
+
+// captureBuffer keeps the first max bytes written to it, noting whether
+// there were more.
+type captureBuffer struct {
+	bytes.Buffer
+	max  int
+	over bool
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.over = true
+		p = p[:max(room, 0)]
+	}
+	b.Buffer.Write(p)
+	return len(p), nil
+}
+
+// captureWriter keeps the status and the start of the body of a response.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   captureBuffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.status == 0 && code >= 200 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *captureWriter) Status() int {
+	if cw.status == 0 {
+		return http.StatusOK
+	}
+	return cw.status
+}
+
+// Page sizes of capture lists.
+const (
+	defaultCaptureLimit = 50
+	maxCaptureLimit     = 500
+)
+
+// capturesHandler - GET /admin/captures
+// Lists captures newest first, filtered by the optional method, path (a
+// prefix) and status query parameters, as {data, next}. next is the URL
+// of the older ones, selected by before, a capture id, or null on the
+// last page.
+func capturesHandler(rec *capture.Recorder, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	f := capture.Filter{Method: strings.ToUpper(q.Get("method")), PathPrefix: q.Get("path"), Limit: defaultCaptureLimit}
+	if v := q.Get("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid status")
+			return
+		}
+		f.Status = n
+	}
+	if v := q.Get("before"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid before")
+			return
+		}
+		f.Before = oid
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		f.Limit = min(n, maxCaptureLimit)
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	out, err := rec.List(ctx, f)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+
+	var next *string
+	if len(out) == f.Limit {
+		q.Set("before", out[len(out)-1].ID.Hex())
+		q.Set("limit", strconv.Itoa(f.Limit))
+		s := r.URL.Path + "?" + q.Encode()
+		next = &s
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": out, "next": next})
+}
+
+// captureHandler - GET /admin/captures/{id}
+func captureHandler(rec *capture.Recorder, w http.ResponseWriter, r *http.Request) {
+	setRouteName(r, "/admin/captures/{id}")
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(r.URL.Path, "/admin/captures/"))
+	if err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, CodeInvalidID, "invalid id")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	e, err := rec.Get(ctx, id)
+	if errors.Is(err, capture.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
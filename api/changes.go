@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang/store"
+)
+
+// Long polls of GET /users/changes.
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+	maxChangesWait      = time.Minute
+	// changesMargin is kept of the request deadline to answer in after
+	// the wait.
+	changesMargin = time.Second
+)
+
+// pollUserChanges - GET /users/changes
+// Long polls the writes to users. since is the token of the last response,
+// empty to start from now; the response is {changes, token}, with the token
+// to pass next. wait, a duration of at most 1m, holds the request until
+// there are changes or it runs out; it is cut to the route's timeout, so
+// ROUTE_TIMEOUTS and HTTP_WRITE_TIMEOUT must allow for it. limit defaults
+// to 100 and is at most 1000. A token the backend can no longer continue
+// from is a 400, after which clients start over without one.
+func pollUserChanges(users store.UserWatcher, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	var wait time.Duration
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid wait")
+			return
+		}
+		wait = min(d, maxChangesWait)
+	}
+	limit, ok := resultLimit(w, r, defaultChangesLimit, maxChangesLimit)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	at, _ := deadline(r)
+	wait = max(min(wait, time.Until(at)-changesMargin), 0)
+
+	out, token, err := users.Changes(ctx, q.Get("since"), wait, limit)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		writeError(w, r, http.StatusNotImplemented, "storage backend does not support change polling")
+		return
+	case errors.Is(err, store.ErrInvalidToken):
+		writeError(w, r, http.StatusBadRequest, "invalid since")
+		return
+	case err != nil:
+		dbError(w, r, "find", err)
+		return
+	}
+
+	changes := make([]map[string]any, len(out))
+	for i, e := range out {
+		changes[i] = map[string]any{"op": e.Op, "id": e.ID, "at": e.At, "user": nil}
+		if e.User != nil {
+			changes[i]["user"] = maskUser(r, e.User)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"changes": changes, "token": token})
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"golang/clientip"
+)
+
+// clientIPMiddleware stores the address of the client in the request
+// context, found by res behind the trusted proxies.
+func clientIPMiddleware(res *clientip.Resolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(clientip.NewContext(r.Context(), res.ClientIP(r))))
+	})
+}
+
+// ClientIP returns the address of the client of r: the peer of the
+// connection, or behind trusted proxies the client they forward for.
+// Outside the middleware it is the peer.
+func ClientIP(r *http.Request) string {
+	if ip := clientip.FromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionOptions configures response compression.
+type CompressionOptions struct {
+	// Encodings are the content codings offered, "zstd" and "gzip", in
+	// order of preference; the client's q-values take precedence.
+	Encodings []string
+	// MinSize is the smallest body compressed, in bytes; defaults to 1024.
+	MinSize int
+	// ExcludedTypes are media types sent as they are because they are
+	// compressed already, where "image/*" matches any image type.
+	ExcludedTypes []string
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return zw
+	}}
+)
+
+// encoder is a pooled compressor.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func getEncoder(coding string, w io.Writer) encoder {
+	var e encoder
+	switch coding {
+	case "gzip":
+		e = gzipWriters.Get().(*gzip.Writer)
+	case "zstd":
+		e = zstdWriters.Get().(*zstd.Encoder)
+	}
+	e.Reset(w)
+	return e
+}
+
+func putEncoder(coding string, e encoder) {
+	e.Reset(nil)
+	switch coding {
+	case "gzip":
+		gzipWriters.Put(e)
+	case "zstd":
+		zstdWriters.Put(e)
+	}
+}
+
+// negotiateEncoding returns the coding of offered the Accept-Encoding
+// header value prefers, or "" for none. Ties go to the earlier offer.
+func negotiateEncoding(accept string, offered []string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			weight = f
+		}
+		q[strings.ToLower(strings.TrimSpace(coding))] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		weight, ok := q[coding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// compressMiddleware compresses response bodies in the encoding the
+// client accepts. Bodies are buffered up to MinSize to decide: smaller
+// ones, excluded types, range responses and bodies a handler already
+// encoded are sent unchanged.
+func compressMiddleware(opts CompressionOptions, next http.Handler) http.Handler {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		coding := negotiateEncoding(r.Header.Get("Accept-Encoding"), opts.Encodings)
+		if coding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, opts: &opts, coding: coding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a body until it knows whether to
+// compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	opts   *CompressionOptions
+	coding string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder // nil when sending the body unchanged
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// Informational responses such as 103 go out right away
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if !bodyAllowed(code) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.opts.MinSize {
+			return len(b), nil
+		}
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start decides on the buffered body and writes it.
+func (cw *compressWriter) start() error {
+	cw.decide(cw.compressible())
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compressible reports whether the response is worth compressing.
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if len(cw.buf) < cw.opts.MinSize || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ct)
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, x := range cw.opts.ExcludedTypes {
+		if x == mt {
+			return false
+		}
+		if prefix, ok := strings.CutSuffix(x, "/*"); ok && strings.HasPrefix(mt, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// decide writes the header, set up for compression when compress is true.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.coding)
+		h.Del("Content-Length")
+		// The compressed body is a different representation, so a strong
+		// validator of the uncompressed one no longer matches it byte for byte
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = getEncoder(cw.coding, cw.ResponseWriter)
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Flush sends what is buffered, deciding on compression early if it must.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		if err := cw.start(); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the body once the handler returns.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written; let net/http send its implicit 200
+			return
+		}
+		_ = cw.start()
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+		putEncoder(cw.coding, cw.enc)
+		cw.enc = nil
+	}
+}
+
+// bodyAllowed reports whether a response with status code may have a body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified && code >= 200
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ConcurrencyOptions caps the requests served at once per route group,
+// so a few expensive requests, such as exports, can't take up every
+// MongoDB connection.
+type ConcurrencyOptions struct {
+	// Limits maps route groups to the requests each serves at once. A
+	// group is a route, such as /users/{id}/export, whose {name} segments
+	// match any one segment, or a path prefix ending in /*, such as
+	// /admin/*. A request counts against the most specific group it
+	// matches: routes before prefixes, longer prefixes first.
+	Limits map[string]int
+	// Queue is how many more requests of a group wait for a slot; those
+	// beyond are refused at once.
+	Queue int
+	// QueueTimeout is how long a request waits for a slot before it is
+	// refused.
+	QueueTimeout time.Duration
+}
+
+var (
+	concurrencyActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "api_concurrency_active",
+		Help: "Requests being served per concurrency-limited route group.",
+	}, []string{"group"})
+	concurrencyQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "api_concurrency_queued",
+		Help: "Requests waiting for a slot per concurrency-limited route group.",
+	}, []string{"group"})
+	concurrencyRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_concurrency_rejected_total",
+		Help: "Requests refused by the concurrency limit of their route group, its queue being full or the wait running out.",
+	}, []string{"group"})
+)
+
+// concurrencyGroup is a route group with its slots.
+type concurrencyGroup struct {
+	routePattern
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+// newConcurrencyGroups returns the groups of limits, most specific first.
+func newConcurrencyGroups(limits map[string]int) []*concurrencyGroup {
+	groups := make([]*concurrencyGroup, 0, len(limits))
+	for name, n := range limits {
+		if n <= 0 {
+			continue
+		}
+		groups = append(groups, &concurrencyGroup{routePattern: newRoutePattern(name), slots: make(chan struct{}, n)})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].moreSpecific(groups[j].routePattern)
+	})
+	return groups
+}
+
+// concurrencyMiddleware serves at most the limit of each group's requests
+// at once. Others wait in its queue up to QueueTimeout, and are refused
+// with 503 and a Retry-After when the queue is full or the wait runs out.
+func concurrencyMiddleware(opts ConcurrencyOptions, next http.Handler) http.Handler {
+	groups := newConcurrencyGroups(opts.Limits)
+	if len(groups) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var g *concurrencyGroup
+		for _, c := range groups {
+			if c.matches(r.URL.Path) {
+				g = c
+				break
+			}
+		}
+		if g == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case g.slots <- struct{}{}:
+		default:
+			if !g.wait(r, opts) {
+				writeRetryError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "too many concurrent requests to "+g.name, time.Second)
+				return
+			}
+		}
+		concurrencyActive.WithLabelValues(g.name).Inc()
+		defer func() {
+			concurrencyActive.WithLabelValues(g.name).Dec()
+			<-g.slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait waits in the queue of g for a slot, reporting whether it got one.
+func (g *concurrencyGroup) wait(r *http.Request, opts ConcurrencyOptions) bool {
+	if g.waiting.Add(1) > int64(opts.Queue) {
+		g.waiting.Add(-1)
+		concurrencyRejected.WithLabelValues(g.name).Inc()
+		return false
+	}
+	concurrencyQueued.WithLabelValues(g.name).Inc()
+	defer func() {
+		g.waiting.Add(-1)
+		concurrencyQueued.WithLabelValues(g.name).Dec()
+	}()
+
+	timer := time.NewTimer(opts.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	concurrencyRejected.WithLabelValues(g.name).Inc()
+	return false
+}
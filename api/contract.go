@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"golang/openapi"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ContractOptions configures checking requests and responses against the
+// OpenAPI spec served at /openapi.yaml, for catching drift between the
+// handlers and the spec in staging.
+type ContractOptions struct {
+	// Enforce rejects requests that violate the spec with 400 and
+	// replaces responses that do with 500. Otherwise violations are only
+	// logged and counted.
+	Enforce bool
+}
+
+// maxContractBody is the largest body checked; larger ones pass unchecked.
+const maxContractBody = 1 << 20
+
+var contractViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_contract_violations_total",
+	Help: "Requests and responses that do not match the OpenAPI spec, by route, method and kind (request, response or undocumented).",
+}, []string{"route", "method", "kind"})
+
+// contractMiddleware checks the requests to documented paths and their
+// responses against the spec. Paths the spec doesn't have, such as
+// /metrics, pass unchecked.
+func contractMiddleware(opts ContractOptions, next http.Handler) http.Handler {
+	b, _ := docsFiles.ReadFile("docs/openapi.yaml")
+	spec, err := openapi.Load(b)
+	if err != nil {
+		slog.Error("contract validation disabled: invalid OpenAPI spec", "error", err)
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, documented := spec.Find(r.Method, r.URL.Path)
+		if !documented {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if op == nil {
+			// The handler should refuse methods the spec doesn't list
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if status := rec.Status(); status < 400 {
+				reportViolation(r, r.URL.Path, "undocumented", "method not documented, answered "+http.StatusText(status))
+			}
+			return
+		}
+
+		if body, ok := peekBody(r); ok {
+			if err := op.ValidateRequest(r, body); err != nil {
+				reportViolation(r, op.Path, "request", err.Error())
+				if opts.Enforce {
+					writeError(w, r, http.StatusBadRequest, "request does not match the API contract: "+err.Error())
+					return
+				}
+			}
+		}
+
+		cw := &contractWriter{ResponseWriter: w, hold: opts.Enforce}
+		next.ServeHTTP(cw, r)
+		if cw.unchecked {
+			return
+		}
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if err := op.ValidateResponse(status, cw.Header(), cw.buf.Bytes()); err != nil {
+			reportViolation(r, op.Path, "response", err.Error())
+			if opts.Enforce {
+				for _, k := range []string{"Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Location"} {
+					cw.Header().Del(k)
+				}
+				writeError(w, r, http.StatusInternalServerError, "response does not match the API contract: "+err.Error())
+				return
+			}
+		}
+		cw.commit()
+	})
+}
+
+func reportViolation(r *http.Request, route, kind, detail string) {
+	contractViolations.WithLabelValues(route, r.Method, kind).Inc()
+	slog.WarnContext(r.Context(), "API contract violation",
+		"kind", kind, "method", r.Method, "path", r.URL.Path, "route", route, "violation", detail)
+}
+
+// peekBody reads the body of r for checking and leaves it for the
+// handler to read again. It returns false when the body is too large to
+// check or could not be read.
+func peekBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxContractBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil || len(b) > maxContractBody {
+		return nil, false
+	}
+	return b, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contractWriter keeps a copy of the response for checking. When hold is
+// set it also holds the response back until it has been checked, unless
+// the handler flushes or the body outgrows maxContractBody.
+type contractWriter struct {
+	http.ResponseWriter
+	hold bool
+
+	status    int
+	buf       bytes.Buffer
+	unchecked bool // the body was streamed or too large
+	sent      bool // the status and held body went out
+}
+
+func (cw *contractWriter) WriteHeader(code int) {
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+	if !cw.hold {
+		cw.send()
+	}
+}
+
+func (cw *contractWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.unchecked && cw.buf.Len()+len(b) > maxContractBody {
+		cw.unchecked = true
+		if cw.hold {
+			cw.commit()
+		}
+		cw.buf.Reset()
+	}
+	if !cw.unchecked {
+		cw.buf.Write(b)
+		if cw.hold {
+			return len(b), nil
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush gives up checking a held response, whose client is waiting for it.
+func (cw *contractWriter) Flush() {
+	if cw.hold && !cw.sent {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.unchecked = true
+		cw.commit()
+		cw.buf.Reset()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *contractWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// send writes the status once.
+func (cw *contractWriter) send() {
+	if !cw.sent && cw.status != 0 {
+		cw.sent = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// commit sends the status and the body held back.
+func (cw *contractWriter) commit() {
+	if !cw.hold || cw.sent {
+		return
+	}
+	cw.send()
+	if cw.buf.Len() > 0 {
+		_, _ = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+}
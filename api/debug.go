@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// NewDebugHandler returns the handler for the separate admin listener,
+// exposing net/http/pprof under /debug/pprof/ and expvar under /debug/vars.
+// When token is non-empty every request must send it as a bearer token.
+func NewDebugHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	if token == "" {
+		return mux
+	}
+	return requireToken(token, mux)
+}
+
+// adminOnly protects admin endpoints on the public API listener. Unlike the
+// separate debug listener they are never open: without a token configured
+// they are disabled.
+func adminOnly(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusForbidden, "admin endpoints are disabled; set ADMIN_TOKEN to enable them")
+		})
+	}
+	return requireToken(token, next)
+}
+
+// requireToken rejects requests that don't carry "Authorization: Bearer
+// <token>".
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang/db"
+	"golang/store"
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// DegradedReadOptions serves GET /users/{id} from the last copy of the
+// user read while Mongo is down, for consumers that prefer stale data to
+// none.
+type DegradedReadOptions struct {
+	// MaxAge is how long after it was read a copy may still be served.
+	MaxAge time.Duration
+	// MaxEntries caps the copies held in memory; unused with Redis.
+	MaxEntries int
+	// Redis, when set, holds the copies instead of memory, shared by all
+	// instances.
+	Redis *redis.Client
+}
+
+var degradedReads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_degraded_reads_total",
+	Help: "Reads of single users while Mongo is down, by result (stale when served from the last copy read, missing when there was none).",
+}, []string{"result"})
+
+// breakerOpenKey holds how long until the database circuit lets requests
+// through again, for requests it let pass to be served degraded.
+type breakerOpenKey struct{}
+
+// goodCopy is the last copy of a user read from storage, or the mark a
+// write to the user left.
+type goodCopy struct {
+	User store.User `json:"user"`
+	// Read is when the read of the copy started, or when the write ended.
+	Read time.Time `json:"read"`
+	// Dropped marks the user as written, so that reads started before
+	// the write don't keep what they read.
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// goodCopies holds the copies of users, keyed by tenant and id.
+type goodCopies interface {
+	get(ctx context.Context, key string) (*goodCopy, error)
+	// set keeps c unless the copy held is newer, read or dropped after c
+	// was read.
+	set(ctx context.Context, key string, c *goodCopy, ttl time.Duration) error
+}
+
+// memoryCopies is goodCopies in process memory.
+type memoryCopies struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*goodCopy
+}
+
+func newMemoryCopies(size int) *memoryCopies {
+	if size <= 0 {
+		size = 10000
+	}
+	return &memoryCopies{size: size, entries: make(map[string]*goodCopy)}
+}
+
+func (m *memoryCopies) get(_ context.Context, key string) (*goodCopy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[key], nil
+}
+
+// set keeps c; ttl is checked when serving, against the time c was read.
+func (m *memoryCopies) set(_ context.Context, key string, c *goodCopy, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held, ok := m.entries[key]
+	if ok && held.Read.After(c.Read) {
+		return nil
+	}
+	if !ok && len(m.entries) >= m.size {
+		// Drop an arbitrary half rather than track use
+		for k := range m.entries {
+			if len(m.entries) < m.size/2+1 {
+				break
+			}
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = c
+	return nil
+}
+
+const goodCopyPrefix = "users:v1:good:"
+
+// redisCopies is goodCopies in Redis.
+type redisCopies struct {
+	rdb *redis.Client
+}
+
+func (s redisCopies) get(ctx context.Context, key string) (*goodCopy, error) {
+	b, err := s.rdb.Get(ctx, goodCopyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c goodCopy
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// setNewer stores the copy ARGV[1], read at ARGV[2] in microseconds, for
+// ARGV[3] milliseconds or, for 0, for good, unless the copy held in
+// KEYS[1] is newer.
+var setNewer = redis.NewScript(`
+local held = redis.call("GET", KEYS[1])
+if held then
+	local ok, c = pcall(cjson.decode, held)
+	if ok and type(c) == "table" and type(c.at) == "number" and c.at > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+func (s redisCopies) set(ctx context.Context, key string, c *goodCopy, ttl time.Duration) error {
+	at := c.Read.UnixMicro()
+	b, err := json.Marshal(struct {
+		*goodCopy
+		At int64 `json:"at"` // Read, for setNewer
+	}{c, at})
+	if err != nil {
+		return err
+	}
+	return setNewer.Run(ctx, s.rdb, []string{goodCopyPrefix + key}, b, at, ttl.Milliseconds()).Err()
+}
+
+// degraded keeps the last copy of each user GET /users/{id} read and
+// serves it while the readiness check fails or the database circuit is
+// open. Successful writes to /users/{id} and /admin/users/{id}, and
+// what hangs off them, drop the copy of the user, and reads started
+// before such a write don't keep what they read. Users changed or removed
+// otherwise keep theirs until read again or MaxAge passes, so they may
+// still be served: users of bulk updates and imports, users archived by
+// /admin/archive, removed with their tenant or by the scheduled purge,
+// and users written outside the API.
+type degraded struct {
+	opts   DegradedReadOptions
+	copies goodCopies
+	ready  *readiness
+}
+
+func newDegraded(opts DegradedReadOptions, ready *readiness) *degraded {
+	d := &degraded{opts: opts, ready: ready}
+	if opts.Redis != nil {
+		d.copies = redisCopies{rdb: opts.Redis}
+	} else {
+		d.copies = newMemoryCopies(opts.MaxEntries)
+	}
+	return d
+}
+
+// key returns the key of the copy of user id in the tenant of ctx.
+func (d *degraded) key(ctx context.Context, id string) string {
+	return tenant.FromContext(ctx) + ":" + id
+}
+
+// remember keeps u, read from storage by a read started at read.
+func (d *degraded) remember(ctx context.Context, u *store.User, read time.Time) {
+	if d == nil {
+		return
+	}
+	c := &goodCopy{User: *u, Read: read}
+	if err := d.copies.set(ctx, d.key(ctx, u.ID), c, d.opts.MaxAge); err != nil {
+		slog.WarnContext(ctx, "user copy for degraded reads not kept", "error", err)
+	}
+}
+
+// forget drops the copy of user id.
+func (d *degraded) forget(ctx context.Context, id string) {
+	// The write happened, so don't let a cancelled request skip this
+	ctx = context.WithoutCancel(ctx)
+	c := &goodCopy{Read: time.Now(), Dropped: true}
+	if err := d.copies.set(ctx, d.key(ctx, id), c, d.opts.MaxAge); err != nil {
+		slog.ErrorContext(ctx, "user copy for degraded reads not dropped", "id", id, "error", err)
+	}
+}
+
+// serve answers r with the copy of user id if Mongo is down, reporting
+// whether it did. Without a copy, while the circuit is open, it answers
+// as the breaker would have.
+func (d *degraded) serve(w http.ResponseWriter, r *http.Request, id string) bool {
+	if d == nil {
+		return false
+	}
+	wait, open := r.Context().Value(breakerOpenKey{}).(time.Duration)
+	if !open && d.ready.check() == nil {
+		return false
+	}
+
+	c, err := d.copies.get(r.Context(), d.key(r.Context(), id))
+	if err != nil {
+		slog.WarnContext(r.Context(), "user copies for degraded reads unavailable", "error", err)
+	}
+	if c == nil || c.Dropped || time.Since(c.Read) >= d.opts.MaxAge {
+		degradedReads.WithLabelValues("missing").Inc()
+		if open {
+			writeRetryError(w, r, http.StatusServiceUnavailable, CodeDBUnavailable, db.ErrUnavailable.Error(), wait)
+			return true
+		}
+		return false
+	}
+
+	degradedReads.WithLabelValues("stale").Inc()
+	h := w.Header()
+	h.Set("Warning", `110 - "Response is Stale"`)
+	h.Set("Age", strconv.Itoa(int(time.Since(c.Read).Seconds())))
+	h.Set("X-Data-As-Of", c.Read.UTC().Format(time.RFC3339))
+	h.Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, maskUser(r, &c.User))
+	return true
+}
+
+// servable reports whether r may be served degraded: it is a read of a
+// single user.
+func (d *degraded) servable(r *http.Request) bool {
+	if d == nil || r.Method != http.MethodGet || r.URL.Query().Has("as_of") {
+		return false
+	}
+	id, ok := strings.CutPrefix(r.URL.Path, "/users/")
+	return ok && id != "" && !strings.Contains(id, "/")
+}
+
+// middleware drops the copy of the user a successful write went to, such
+// as PUT /users/{id}, DELETE /users/{id}/data or
+// POST /admin/users/{id}/restore.
+func (d *degraded) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isMutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		if sr.Status() >= 400 {
+			return
+		}
+		rest, ok := strings.CutPrefix(r.URL.Path, "/users/")
+		if !ok {
+			rest, ok = strings.CutPrefix(r.URL.Path, "/admin/users/")
+		}
+		if id, _, _ := strings.Cut(rest, "/"); ok && id != "" {
+			d.forget(r.Context(), id)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang/store"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDegradedReads(t *testing.T) {
+	d := newDegraded(DegradedReadOptions{MaxAge: time.Minute}, &readiness{})
+	ctx := context.Background()
+	u := &store.User{ID: "000000000000000000000001", Name: "Ada"}
+
+	// serve answers a read of u while the circuit is open
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/users/"+u.ID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), breakerOpenKey{}, time.Second))
+		rec := httptest.NewRecorder()
+		if !d.serve(rec, req, u.ID) {
+			t.Fatal("not served while the circuit is open")
+		}
+		return rec.Code
+	}
+
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("read without a copy = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	beforeWrite := time.Now()
+	d.remember(ctx, u, beforeWrite)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("read with a copy = %d, want %d", code, http.StatusOK)
+	}
+
+	d.forget(ctx, u.ID)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("read after a write = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	// A read that started before the write ends after it
+	d.remember(ctx, u, beforeWrite)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("read after a write, with the copy of a read started before = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	d.remember(ctx, u, time.Now())
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("read after a write, with the copy of a read started after = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRedisCopies(t *testing.T) {
+	mr := miniredis.RunT(t)
+	copies := redisCopies{rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	ctx := context.Background()
+	u := store.User{ID: "000000000000000000000001", Name: "Ada"}
+	held := func() *goodCopy {
+		t.Helper()
+		c, err := copies.get(ctx, "t:1")
+		if err != nil || c == nil {
+			t.Fatalf("get = %v, %v", c, err)
+		}
+		return c
+	}
+
+	read := time.Now()
+	if err := copies.set(ctx, "t:1", &goodCopy{User: u, Read: read}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if c := held(); c.Dropped || c.User != u {
+		t.Errorf("copy = %+v, want %+v", c, u)
+	}
+	if ttl := mr.TTL(goodCopyPrefix + "t:1"); ttl != time.Minute {
+		t.Errorf("TTL = %v, want %v", ttl, time.Minute)
+	}
+
+	written := read.Add(time.Millisecond)
+	if err := copies.set(ctx, "t:1", &goodCopy{Read: written, Dropped: true}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := copies.set(ctx, "t:1", &goodCopy{User: u, Read: read}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if c := held(); !c.Dropped {
+		t.Errorf("copy of a read started before the write = %+v, want it dropped", c)
+	}
+	if err := copies.set(ctx, "t:1", &goodCopy{User: u, Read: written.Add(time.Millisecond)}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if c := held(); c.Dropped {
+		t.Errorf("copy of a read started after the write = %+v, want it kept", c)
+	}
+}
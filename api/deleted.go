@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang/store"
+	"golang/tenant"
+)
+
+// adminTenant returns ctx scoped to the tenant named by the tenant query
+// parameter. Admin routes bypass tenant resolution, so without it they only
+// see data stored without a tenant.
+func adminTenant(ctx context.Context, r *http.Request) (context.Context, bool) {
+	t := r.URL.Query().Get("tenant")
+	if t == "" {
+		return ctx, true
+	}
+	if !tenant.Valid(t) {
+		return ctx, false
+	}
+	return tenant.NewContext(ctx, t), true
+}
+
+// deletedUsers - GET /admin/users/deleted
+// Lists soft-deleted users. Takes the GET /users query parameters plus
+// tenant.
+func deletedUsers(users store.DeletedUsers, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	f, err := parseUserFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	ctx, ok := adminTenant(ctx, r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid tenant")
+		return
+	}
+
+	out, err := users.ListDeleted(ctx, f)
+	if err != nil {
+		deletedError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, maskUsers(r, out))
+}
+
+// adminUser - POST /admin/users/{id}/restore, DELETE /admin/users/{id}
+// Restore undoes a soft delete; DELETE removes the user for good, whether
+// soft-deleted or not, or with dry_run=true reports what it would remove.
+// Both take an optional tenant query parameter. sessions may be nil.
+func adminUser(users store.DeletedUsers, sessions *sessionStore, w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/users/"), "/")
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	ctx, ok := adminTenant(ctx, r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid tenant")
+		return
+	}
+
+	switch {
+	case sub == "restore" && r.Method == http.MethodPost:
+		setRouteName(r, "/admin/users/{id}/restore")
+		if err := users.Restore(ctx, id); err != nil {
+			deletedError(w, r, "restore", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"restored": id})
+	case sub == "" && r.Method == http.MethodDelete:
+		setRouteName(r, "/admin/users/{id}")
+		dry, err := dryRun(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if dry {
+			_, err := users.GetDeleted(ctx, id)
+			if live, ok := users.(store.UserRepository); ok && errors.Is(err, store.ErrNotFound) {
+				_, err = live.Get(ctx, id)
+			}
+			if err != nil {
+				deletedError(w, r, "find", err)
+				return
+			}
+			dryRunUser(ctx, sessions, w, r, id)
+			return
+		}
+		if err := users.Purge(ctx, id); err != nil {
+			deletedError(w, r, "delete", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"purged": id})
+	case sub == "restore", sub == "":
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, r, http.StatusNotFound, "not found")
+	}
+}
+
+func deletedError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if errors.Is(err, errors.ErrUnsupported) {
+		writeError(w, r, http.StatusNotImplemented, "storage backend does not support soft delete")
+		return
+	}
+	userError(w, r, op, err)
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deprecation marks a route, or a single field of a route's JSON request
+// body, as scheduled for removal.
+type Deprecation struct {
+	Method string    // HTTP method; empty matches every method
+	Path   string    // exact path, or a prefix when it ends with "/"
+	Field  string    // top-level request body field; empty deprecates the whole route
+	Since  time.Time // when the deprecation was announced
+	Sunset time.Time // when the route or field stops working; zero if not yet scheduled
+	Link   string    // optional migration guide URL
+}
+
+// deprecations lists everything currently deprecated. Add an entry here before
+// changing or removing a route or field so clients receive Deprecation/Sunset
+// headers and remaining usage shows up in /admin/deprecations.
+var deprecations = []Deprecation{}
+
+func (d Deprecation) key() string {
+	return d.Method + " " + d.Path + "#" + d.Field
+}
+
+func (d Deprecation) matches(r *http.Request) bool {
+	if d.Method != "" && d.Method != r.Method {
+		return false
+	}
+	if strings.HasSuffix(d.Path, "/") {
+		return strings.HasPrefix(r.URL.Path, d.Path)
+	}
+	return r.URL.Path == d.Path
+}
+
+// callerID identifies the client for usage reporting. API keys are never
+// stored or reported verbatim, only a short fingerprint of them.
+func callerID(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+type deprecationUsage struct {
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// deprecationTracker sets deprecation headers and counts deprecated usage per
+// caller.
+type deprecationTracker struct {
+	list []Deprecation
+
+	mu    sync.Mutex
+	usage map[string]map[string]*deprecationUsage // deprecation key -> caller -> usage
+}
+
+func newDeprecationTracker(list []Deprecation) *deprecationTracker {
+	return &deprecationTracker{
+		list:  list,
+		usage: map[string]map[string]*deprecationUsage{},
+	}
+}
+
+func (t *deprecationTracker) record(d Deprecation, caller string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byCaller, ok := t.usage[d.key()]
+	if !ok {
+		byCaller = map[string]*deprecationUsage{}
+		t.usage[d.key()] = byCaller
+	}
+	u, ok := byCaller[caller]
+	if !ok {
+		u = &deprecationUsage{}
+		byCaller[caller] = u
+	}
+	u.Count++
+	u.LastSeen = time.Now().UTC()
+
+	// Warn on the first hit per caller and then periodically, so logs show
+	// who is affected without flooding on every request.
+	if u.Count == 1 || u.Count%100 == 0 {
+		what := d.Method + " " + d.Path
+		if d.Field != "" {
+			what += " field " + d.Field
+		}
+		slog.Warn("deprecated usage", "deprecation", strings.TrimSpace(what), "api_key", caller, "count", u.Count)
+	}
+}
+
+// middleware matches each request against the deprecation list, records hits
+// and adds the Deprecation, Sunset and Link response headers.
+func (t *deprecationTracker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hits, fields []Deprecation
+		for _, d := range t.list {
+			if !d.matches(r) {
+				continue
+			}
+			if d.Field == "" {
+				hits = append(hits, d)
+			} else {
+				fields = append(fields, d)
+			}
+		}
+
+		// Field deprecations need a look at the body; restore it afterwards
+		// so the handler can still decode it. It is read whole, so it is held
+		// to the size the contract check reads.
+		if len(fields) > 0 && r.Body != nil {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxContractBody))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, "request body exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var top map[string]json.RawMessage
+			if err == nil && json.Unmarshal(body, &top) == nil {
+				for _, d := range fields {
+					if _, ok := top[d.Field]; ok {
+						hits = append(hits, d)
+					}
+				}
+			}
+		}
+
+		if len(hits) > 0 {
+			caller := callerID(r)
+			for _, d := range hits {
+				t.record(d, caller)
+			}
+			setDeprecationHeaders(w, hits)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setDeprecationHeaders writes RFC 9745 Deprecation and RFC 8594 Sunset
+// headers. When several deprecations apply the earliest dates win.
+func setDeprecationHeaders(w http.ResponseWriter, hits []Deprecation) {
+	var since, sunset time.Time
+	for _, d := range hits {
+		if !d.Since.IsZero() && (since.IsZero() || d.Since.Before(since)) {
+			since = d.Since
+		}
+		if !d.Sunset.IsZero() && (sunset.IsZero() || d.Sunset.Before(sunset)) {
+			sunset = d.Sunset
+		}
+		if d.Link != "" {
+			w.Header().Add("Link", "<"+d.Link+`>; rel="deprecation"`)
+		}
+	}
+	if since.IsZero() {
+		since = time.Now()
+	}
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(since.Unix(), 10))
+	if !sunset.IsZero() {
+		w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+// report returns every deprecation with its usage broken down by caller.
+func (t *deprecationTracker) report() []map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := []map[string]any{}
+	for _, d := range t.list {
+		entry := map[string]any{
+			"method": d.Method,
+			"path":   d.Path,
+		}
+		if d.Field != "" {
+			entry["field"] = d.Field
+		}
+		if !d.Since.IsZero() {
+			entry["since"] = d.Since.UTC().Format(time.RFC3339)
+		}
+		if !d.Sunset.IsZero() {
+			entry["sunset"] = d.Sunset.UTC().Format(time.RFC3339)
+		}
+		if d.Link != "" {
+			entry["link"] = d.Link
+		}
+
+		var total int64
+		callers := []map[string]any{}
+		for caller, u := range t.usage[d.key()] {
+			total += u.Count
+			callers = append(callers, map[string]any{
+				"api_key":   caller,
+				"count":     u.Count,
+				"last_seen": u.LastSeen.Format(time.RFC3339),
+			})
+		}
+		sort.Slice(callers, func(i, j int) bool {
+			return callers[i]["count"].(int64) > callers[j]["count"].(int64)
+		})
+		entry["total"] = total
+		entry["callers"] = callers
+
+		out = append(out, entry)
+	}
+	return out
+}
+
+// deprecationReport - GET /admin/deprecations
+func deprecationReport(t *deprecationTracker, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, t.report())
+}
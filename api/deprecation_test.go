@@ -0,0 +1,39 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeprecatedFieldBodyLimit(t *testing.T) {
+	tracker := newDeprecationTracker([]Deprecation{{Method: http.MethodPost, Path: "/users", Field: "nickname"}})
+	var got string
+	h := tracker.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+
+	body := `{"name":"Ada","nickname":"ada"}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+	if rec.Header().Get("Deprecation") == "" {
+		t.Error("no Deprecation header for a deprecated field")
+	}
+	if got != body {
+		t.Errorf("handler read %q, want the body %q", got, body)
+	}
+
+	got = ""
+	large := `{"name":"` + strings.Repeat("a", maxContractBody) + `"}`
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(large)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over %d bytes = %d, want %d", maxContractBody, rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got != "" {
+		t.Error("handler ran for a body over the limit")
+	}
+}
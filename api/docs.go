@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed docs
+var docsFiles embed.FS
+
+// DocsOptions configures the Swagger UI served at /docs.
+type DocsOptions struct {
+	// AssetsURL is where swagger-ui.css and swagger-ui-bundle.js of the
+	// swagger-ui-dist package are fetched from by the browser, a CDN or a
+	// self-hosted copy.
+	AssetsURL string
+}
+
+// openAPISpec - GET /openapi.yaml
+func openAPISpec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	b, _ := docsFiles.ReadFile("docs/openapi.yaml")
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
+
+// docsHandler serves /docs, the Swagger UI page for the spec, and its
+// script at /docs/init.js.
+func docsHandler(opts DocsOptions) (http.Handler, error) {
+	t, err := template.ParseFS(docsFiles, "docs/index.html")
+	if err != nil {
+		return nil, err
+	}
+	var page bytes.Buffer
+	if err := t.Execute(&page, map[string]string{"Assets": strings.TrimSuffix(opts.AssetsURL, "/")}); err != nil {
+		return nil, err
+	}
+	script, _ := docsFiles.ReadFile("docs/init.js")
+
+	// The page may load scripts and styles only from here and the assets
+	// origin; Swagger UI inlines styles and shows data: images
+	assets := "'self'"
+	if u, err := url.Parse(opts.AssetsURL); err == nil && u.Host != "" {
+		assets += " " + u.Scheme + "://" + u.Host
+	}
+	csp := "default-src 'self'; script-src " + assets + "; style-src 'unsafe-inline' " + assets +
+		"; img-src 'self' data:; frame-ancestors 'none'"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var body []byte
+		switch r.URL.Path {
+		case "/docs", "/docs/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", csp)
+			body = page.Bytes()
+		case "/docs/init.js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			body = script
+		default:
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(body)
+	}), nil
+}
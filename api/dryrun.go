@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// dryRunSample caps the ids a dry run lists.
+const dryRunSample = 10
+
+// dryRunReport is the answer of a destructive request made with
+// ?dry_run=true: what it would remove, without removing anything.
+type dryRunReport struct {
+	DryRun    bool     `json:"dry_run"`
+	Count     int64    `json:"count"`
+	SampleIDs []string `json:"sample_ids"`
+	// Related counts documents of other kinds removed along, e.g. the
+	// sessions of a deleted user.
+	Related map[string]int64 `json:"related,omitempty"`
+}
+
+// dryRun reports whether the request asks for a dry run.
+func dryRun(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("invalid dry_run")
+	}
+	return b, nil
+}
+
+// dryRunUser answers the dry run of removing user id, which the caller has
+// found, along with its sessions.
+func dryRunUser(ctx context.Context, sessions *sessionStore, w http.ResponseWriter, r *http.Request, id string) {
+	var related map[string]int64
+	if sessions != nil {
+		n, err := sessions.countUser(ctx, r, id)
+		if err != nil {
+			sessions.dbError(w, r, "count", err)
+			return
+		}
+		related = map[string]int64{"sessions": n}
+	}
+	writeDryRun(w, 1, []string{id}, related)
+}
+
+// writeDryRun answers a dry run affecting count items, ids being some of
+// them.
+func writeDryRun(w http.ResponseWriter, count int64, ids []string, related map[string]int64) {
+	if ids == nil {
+		ids = []string{}
+	}
+	writeJSON(w, http.StatusOK, dryRunReport{DryRun: true, Count: count, SampleIDs: ids, Related: related})
+}
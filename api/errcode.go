@@ -0,0 +1,122 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang/buildinfo"
+	"golang/logging"
+	"golang/requestid"
+)
+
+// Error codes sent in the code field of every error payload. Clients
+// branch on them rather than on the message, which is translated and may
+// be reworded; codes never change once released.
+const (
+	// CodeValidationFailed: the request is malformed or a value is
+	// invalid.
+	CodeValidationFailed = "VALIDATION_FAILED"
+	// CodeInvalidID: an id is not in the format of the storage backend.
+	CodeInvalidID = "INVALID_ID"
+	// CodeUserNotFound: the user does not exist, or not in the tenant.
+	CodeUserNotFound = "USER_NOT_FOUND"
+	// CodeDuplicateEmail: another user of the tenant has the email.
+	CodeDuplicateEmail = "DUPLICATE_EMAIL"
+	// CodeUserReferenced: the user can't be deleted while records of a
+	// restrict relation refer to it.
+	CodeUserReferenced = "USER_REFERENCED"
+	// CodeDBUnavailable: the database cannot be reached; retry later.
+	CodeDBUnavailable = "DB_UNAVAILABLE"
+	// CodeReadOnly: the service is in read-only mode and refuses writes
+	// until an operator switches it off; reads keep working.
+	CodeReadOnly = "READ_ONLY"
+	// CodeQuotaExceeded: the API key used its monthly request quota;
+	// retry when it resets.
+	CodeQuotaExceeded = "QUOTA_EXCEEDED"
+	// CodeUserQuotaExceeded: the tenant, or the service, stores as many
+	// users as its quota allows.
+	CodeUserQuotaExceeded = "USER_QUOTA_EXCEEDED"
+	// CodeStorageQuotaExceeded: the user doesn't fit in the storage quota
+	// of the tenant or the service.
+	CodeStorageQuotaExceeded = "STORAGE_QUOTA_EXCEEDED"
+
+	// Codes of errors without a more specific one, by status.
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeNotFound             = "NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeConflict             = "CONFLICT"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeInternal             = "INTERNAL"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeUnavailable          = "SERVICE_UNAVAILABLE"
+	CodeTimeout              = "TIMEOUT"
+)
+
+// statusCodes are the codes of errors written without one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeValidationFailed,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeValidationFailed,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusNotImplemented:        CodeNotImplemented,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusGatewayTimeout:        CodeTimeout,
+}
+
+// errorCode returns the code of an error response with status.
+func errorCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeValidationFailed
+}
+
+// Helper: write a JSON error with a specific code, including the request
+// id, in the language of the request's Accept-Language
+func writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	writeJSON(w, status, errorBody(w, r, code, msg))
+}
+
+// errorBody is the payload of an error response, for errors adding their
+// own fields.
+func errorBody(w http.ResponseWriter, r *http.Request, code, msg string) map[string]any {
+	return map[string]any{
+		"error":      logging.Redact(localize(w, r, msg)),
+		"code":       code,
+		"request_id": requestid.FromContext(r.Context()),
+		"version":    buildinfo.Get().Version,
+	}
+}
+
+// writeRetryError writes an error, typically a 429 or 503, that the
+// client should retry no sooner than after wait: in the Retry-After
+// header and, for clients that don't read headers, the retry_after field,
+// both in whole seconds rounded up.
+func writeRetryError(w http.ResponseWriter, r *http.Request, status int, code, msg string, wait time.Duration) {
+	secs := retrySeconds(wait)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	body := errorBody(w, r, code, msg)
+	body["retry_after"] = secs
+	writeJSON(w, status, body)
+}
+
+// retrySeconds rounds wait up to whole seconds, at least one: a
+// Retry-After of 0 invites clients to retry at once.
+func retrySeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang/logging"
+)
+
+func TestErrorBodyRedacted(t *testing.T) {
+	red := logging.Redaction{Patterns: []string{`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+`}}
+	if err := logging.Setup(io.Discard, "json", "info", red); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = logging.Setup(io.Discard, "json", "info", logging.Redaction{}) })
+
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodPost, "/users", nil), http.StatusConflict, `email "ada@example.com" already in use`)
+
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if want := `email "` + logging.Redacted + `" already in use`; body.Error != want {
+		t.Errorf("error = %q, want %q", body.Error, want)
+	}
+	if body.Code == "" {
+		t.Error("code is empty")
+	}
+}
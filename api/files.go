@@ -0,0 +1,394 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/db"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileOptions configures the GridFS backed /files endpoints.
+type FileOptions struct {
+	// MaxSize is the largest accepted upload in bytes; defaults to 10 MiB.
+	MaxSize int64
+	// AllowedTypes restricts uploads to these media types, where "image/*"
+	// matches any image type. Empty allows every type.
+	AllowedTypes []string
+}
+
+// FileInfo is the metadata of a stored file.
+type FileInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// fileDoc is a document of the GridFS files collection.
+type fileDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Length     int64              `bson:"length"`
+	UploadDate time.Time          `bson:"uploadDate"`
+	Filename   string             `bson:"filename"`
+	Metadata   struct {
+		ContentType string `bson:"content_type"`
+		TenantID    string `bson:"tenant_id,omitempty"`
+	} `bson:"metadata"`
+}
+
+func (d fileDoc) info() FileInfo {
+	return FileInfo{
+		ID:          d.ID.Hex(),
+		Name:        d.Filename,
+		Size:        d.Length,
+		ContentType: d.Metadata.ContentType,
+		UploadedAt:  d.UploadDate.UTC(),
+	}
+}
+
+type fileStore struct {
+	mc     *db.MongoClient
+	bucket *gridfs.Bucket
+	opts   FileOptions
+}
+
+func newFileStore(mc *db.MongoClient, opts FileOptions) (*fileStore, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+	bucket, err := newBucket(mc)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{mc: mc, bucket: bucket, opts: opts}, nil
+}
+
+// newBucket returns the GridFS bucket of the files.
+func newBucket(mc *db.MongoClient) (*gridfs.Bucket, error) {
+	// The bucket's files and chunks collections are <name>.files and
+	// <name>.chunks, so the "fs" resource names the bucket
+	fsColl := mc.Collection("fs")
+	return gridfs.NewBucket(fsColl.Database(), options.GridFSBucket().SetName(fsColl.Name()))
+}
+
+// allowed reports whether uploads of media type ct are accepted.
+func (fs *fileStore) allowed(ct string) bool {
+	if len(fs.opts.AllowedTypes) == 0 {
+		return true
+	}
+	for _, a := range fs.opts.AllowedTypes {
+		if a == ct {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(ct, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// files - GET, POST /files
+func (fs *fileStore) files(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		fs.list(w, r)
+	case http.MethodPost:
+		fs.upload(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// file - GET, HEAD, DELETE /files/{id} and GET /files/{id}/metadata
+func (fs *fileStore) file(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/files/")
+	id, sub, _ := strings.Cut(rest, "/")
+	switch {
+	case sub == "metadata" && r.Method == http.MethodGet:
+		setRouteName(r, "/files/{id}/metadata")
+		if d, ok := fs.find(w, r, id); ok {
+			writeJSON(w, http.StatusOK, d.info())
+		}
+	case sub != "":
+		setRouteName(r, "/files/{id}/*")
+		writeError(w, r, http.StatusNotFound, "not found")
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		setRouteName(r, "/files/{id}")
+		fs.download(w, r, id)
+	case r.Method == http.MethodDelete:
+		setRouteName(r, "/files/{id}")
+		fs.delete(w, r, id)
+	default:
+		setRouteName(r, "/files/{id}")
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// upload - POST /files
+// The body is the file content, named by the name query parameter. Its
+// media type is the Content-Type header or, when absent or generic,
+// detected from the content.
+func (fs *fileStore) upload(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, "name is required")
+		return
+	}
+	if r.ContentLength > fs.opts.MaxSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file exceeds "+strconv.FormatInt(fs.opts.MaxSize, 10)+" bytes")
+		return
+	}
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, fs.opts.MaxSize))
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "" || ct == "application/octet-stream" {
+		head, _ := body.Peek(512)
+		ct, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+	if !fs.allowed(ct) {
+		writeError(w, r, http.StatusUnsupportedMediaType, "content type "+ct+" is not allowed")
+		return
+	}
+
+	meta := bson.M{"content_type": ct}
+	if t := tenant.FromContext(r.Context()); t != "" {
+		meta["tenant_id"] = t
+	}
+	// The owner, for what becomes of the file when the user is deleted
+	if s := sessionFromContext(r.Context()); s != nil {
+		meta["user_id"] = s.UserID
+	}
+	us, err := fs.bucket.OpenUploadStream(name, options.GridFSUpload().SetMetadata(meta))
+	if err != nil {
+		dbError(w, r, "upload", err)
+		return
+	}
+	if d, _ := deadline(r); !d.IsZero() {
+		_ = us.SetWriteDeadline(d)
+	}
+	n, err := io.Copy(us, body)
+	if err != nil {
+		_ = us.Abort()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "file exceeds "+strconv.FormatInt(fs.opts.MaxSize, 10)+" bytes")
+			return
+		}
+		dbError(w, r, "upload", err)
+		return
+	}
+	if err := us.Close(); err != nil {
+		dbError(w, r, "upload", err)
+		return
+	}
+
+	id, _ := us.FileID.(primitive.ObjectID)
+	writeJSON(w, http.StatusCreated, FileInfo{
+		ID:          id.Hex(),
+		Name:        name,
+		Size:        n,
+		ContentType: ct,
+		UploadedAt:  time.Now().UTC(),
+	})
+}
+
+// list - GET /files
+// Optional query parameters: name, offset, limit (default 100).
+func (fs *fileStore) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := fs.scope(r, bson.M{})
+	if name := q.Get("name"); name != "" {
+		filter["filename"] = name
+	}
+	opts := options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetLimit(100)
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		opts.SetLimit(int32(n))
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid offset")
+			return
+		}
+		opts.SetSkip(int32(n))
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	cur, err := fs.bucket.FindContext(ctx, filter, opts)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	var docs []fileDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	out := make([]FileInfo, len(docs))
+	for i, d := range docs {
+		out[i] = d.info()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// scope restricts a files collection filter to the request's tenant.
+func (fs *fileStore) scope(r *http.Request, filter bson.M) bson.M {
+	if t := tenant.FromContext(r.Context()); t != "" {
+		filter["metadata.tenant_id"] = t
+	} else {
+		filter["metadata.tenant_id"] = nil
+	}
+	return filter
+}
+
+// find looks up file id of the request's tenant, writing the error
+// response if there is none.
+func (fs *fileStore) find(w http.ResponseWriter, r *http.Request, id string) (fileDoc, bool) {
+	var d fileDoc
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, CodeInvalidID, "invalid id")
+		return d, false
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+	err = fs.bucket.GetFilesCollection().FindOne(ctx, fs.scope(r, bson.M{"_id": oid}),
+		options.FindOne().SetComment(opComment(r))).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return d, false
+	}
+	if err != nil {
+		dbError(w, r, "find", err)
+		return d, false
+	}
+	return d, true
+}
+
+// download - GET, HEAD /files/{id}
+// Supports Range and conditional requests through http.ServeContent.
+func (fs *fileStore) download(w http.ResponseWriter, r *http.Request, id string) {
+	d, ok := fs.find(w, r, id)
+	if !ok {
+		return
+	}
+	rs := &gridfsReader{bucket: fs.bucket, id: d.ID, size: d.Length}
+	rs.deadline, _ = deadline(r)
+	defer rs.Close()
+
+	w.Header().Set("Content-Type", d.Metadata.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.Filename}))
+	w.Header().Set("ETag", `"`+d.ID.Hex()+`"`)
+	http.ServeContent(w, r, "", d.UploadDate, rs)
+}
+
+// delete - DELETE /files/{id}
+// With dry_run=true it only reports the file it would remove.
+func (fs *fileStore) delete(w http.ResponseWriter, r *http.Request, id string) {
+	dry, err := dryRun(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	d, ok := fs.find(w, r, id)
+	if !ok {
+		return
+	}
+	if dry {
+		writeDryRun(w, 1, []string{id}, nil)
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+	if err := fs.bucket.DeleteContext(ctx, d.ID); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		dbError(w, r, "delete", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// gridfsReader is an io.ReadSeeker over a GridFS file for
+// http.ServeContent. GridFS streams only move forward, so seeking reopens
+// the stream at the new offset; the stream is opened on the first read.
+type gridfsReader struct {
+	bucket   *gridfs.Bucket
+	id       primitive.ObjectID
+	size     int64
+	deadline time.Time
+
+	pos    int64
+	stream *gridfs.DownloadStream
+}
+
+func (g *gridfsReader) Read(p []byte) (int, error) {
+	if g.pos >= g.size {
+		return 0, io.EOF
+	}
+	if g.stream == nil {
+		ds, err := g.bucket.OpenDownloadStream(g.id)
+		if err != nil {
+			return 0, err
+		}
+		if !g.deadline.IsZero() {
+			_ = ds.SetReadDeadline(g.deadline)
+		}
+		if _, err := ds.Skip(g.pos); err != nil {
+			ds.Close()
+			return 0, err
+		}
+		g.stream = ds
+	}
+	n, err := g.stream.Read(p)
+	g.pos += int64(n)
+	return n, err
+}
+
+func (g *gridfsReader) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += g.pos
+	case io.SeekEnd:
+		pos += g.size
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	if pos != g.pos && g.stream != nil {
+		g.stream.Close()
+		g.stream = nil
+	}
+	g.pos = pos
+	return pos, nil
+}
+
+func (g *gridfsReader) Close() error {
+	if g.stream == nil {
+		return nil
+	}
+	return g.stream.Close()
+}
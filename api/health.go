@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang/buildinfo"
+	"golang/db"
+)
+
+// readinessCacheTTL bounds how often /readyz actually pings Mongo, so
+// aggressive probe intervals don't turn into a ping per probe.
+const readinessCacheTTL = 5 * time.Second
+
+// readiness caches the result of the last Mongo ping.
+type readiness struct {
+	mc *db.MongoClient
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// check fails while Mongo is failing over to a new primary. When the
+// background health monitor runs, it returns its last result. Otherwise
+// it returns the cached ping result, pinging again once that is older
+// than readinessCacheTTL. Concurrent callers wait for the ping in flight.
+func (rd *readiness) check() error {
+	if rd.mc == nil {
+		return nil
+	}
+	if _, err := rd.mc.Failover(); err != nil {
+		return err
+	}
+	if at, err := rd.mc.Health(); !at.IsZero() {
+		return err
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if !rd.checked.IsZero() && time.Since(rd.checked) < readinessCacheTTL {
+		return rd.err
+	}
+
+	// The result is shared, so don't let one prober's cancellation fail it
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rd.err = rd.mc.Client.Ping(ctx, nil)
+	rd.checked = time.Now()
+	return rd.err
+}
+
+// healthz - GET /healthz
+// Liveness only: the process is up and serving HTTP.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// version - GET /version
+// The build serving the request.
+func version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildinfo.Get())
+}
+
+// readyz - GET /readyz
+// Readiness: Mongo answered a (possibly cached) ping.
+func (rd *readiness) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := rd.check(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+			"error":  err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
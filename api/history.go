@@ -0,0 +1,157 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/history"
+	"golang/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Page sizes of change histories.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// actorMiddleware names who makes the request for the user history:
+// "admin" for the admin token, "user:<id>" for a session.
+func actorMiddleware(adminToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := ""
+		if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && adminToken != "" &&
+			subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) == 1 {
+			actor = "admin"
+		} else if sess := sessionFromContext(r.Context()); sess != nil {
+			actor = "user:" + sess.UserID.Hex()
+		}
+		if actor != "" {
+			r = r.WithContext(history.NewContext(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userHistory - GET /users/{id}/history
+// Returns the changes of the user's fields newest first, as {data, next}.
+// Optional query parameters: field, since and until, RFC 3339 times, until
+// excluded. next is the URL of the older ones, selected by before, a
+// change id, or null on the last page. Changes of fields masked from the
+// caller are left out.
+func userHistory(users store.UserRepository, h *history.Store, w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	f := history.Filter{Field: q.Get("field"), Limit: defaultHistoryLimit}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid "+p.name)
+				return
+			}
+			*p.t = t
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid before")
+			return
+		}
+		f.Before = oid
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		f.Limit = min(n, maxHistoryLimit)
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	if _, err := users.Get(ctx, id); err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	out, err := h.List(ctx, id, f)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+
+	var next *string
+	if len(out) == f.Limit {
+		q.Set("before", out[len(out)-1].ID.Hex())
+		q.Set("limit", strconv.Itoa(f.Limit))
+		s := r.URL.Path + "?" + q.Encode()
+		next = &s
+	}
+	// Changes of fields hidden from the caller are left out
+	if hidden, _ := r.Context().Value(maskKey{}).(map[string]bool); hidden != nil {
+		shown := out[:0]
+		for _, c := range out {
+			if !hidden[c.Field] {
+				shown = append(shown, c)
+			}
+		}
+		out = shown
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": out, "next": next})
+}
+
+// getUserAsOf - GET /users/{id}?as_of=<time>
+// Returns the user as it was at as_of, an RFC 3339 time, rebuilt from the
+// current user and the history of its fields; soft-deleted users deleted
+// since are found too. 404 when the user didn't exist then. h may be nil,
+// when history is not recorded.
+func getUserAsOf(users store.UserRepository, h *history.Store, w http.ResponseWriter, r *http.Request, id string) {
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("as_of"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid as_of")
+		return
+	}
+	if h == nil {
+		writeError(w, r, http.StatusBadRequest, "as_of needs HISTORY_ENABLED")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	u, err := users.Get(ctx, id)
+	if deleted, ok := users.(store.DeletedUsers); ok && errors.Is(err, store.ErrNotFound) {
+		if du, derr := deleted.GetDeleted(ctx, id); derr == nil && du.DeletedAt != nil && du.DeletedAt.After(at) {
+			u, err = du, nil
+		}
+	}
+	if err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	if u.CreatedAt.After(at) {
+		userError(w, r, "find", store.ErrNotFound)
+		return
+	}
+	then, err := h.AsOf(ctx, u, at)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	then.DeletedAt = nil
+	writeJSON(w, http.StatusOK, maskUser(r, then))
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"net/http"
+
+	"golang/i18n"
+)
+
+// localize returns msg in the language the request's Accept-Language
+// header prefers and labels the response with it.
+func localize(w http.ResponseWriter, r *http.Request, msg string) string {
+	lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
+	return i18n.Translate(lang, msg)
+}
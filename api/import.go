@@ -0,0 +1,562 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/quota"
+	"golang/store"
+	"golang/validation"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ImportOptions tunes POST /users/import.
+type ImportOptions struct {
+	// BatchSize is how many rows are stored at a time, and so held in
+	// memory; defaults to 500.
+	BatchSize int
+	// MaxSize caps the body of one import, in bytes; defaults to 1 GiB.
+	MaxSize int64
+}
+
+// maxImportErrors caps the problems the JSON answer of an import lists,
+// so bad files don't grow it with every row; Failed counts them all.
+const maxImportErrors = 1000
+
+func (o ImportOptions) withDefaults() ImportOptions {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = 1 << 30
+	}
+	return o
+}
+
+// importFields are the user fields columns may map to.
+var importFields = []string{"name", "email", "age", "password", "created_at"}
+
+// importError is a problem with a row of an import. Row counts lines of
+// records as a spreadsheet does: the header is row 1. Field is empty for
+// problems with the row as a whole.
+type importError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// importReport is the answer of POST /users/import.
+type importReport struct {
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+	// ErrorsTruncated says Errors stops at maxImportErrors problems.
+	ErrorsTruncated bool `json:"errors_truncated,omitempty"`
+	// Stopped is why the import ended before the end of the file; the
+	// rows read until then were imported or reported.
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// failedRow is a rejected record, kept for the CSV error report.
+type failedRow struct {
+	row    int
+	record []string
+	errs   []importError
+}
+
+// userImport is the state of one import.
+type userImport struct {
+	users store.UserRepository
+	rules *validation.Validator
+	opts  ImportOptions
+	w     http.ResponseWriter
+	r     *http.Request
+	// csvReport is set when the answer is the CSV error report, which
+	// needs the rejected records; otherwise only their problems are kept.
+	csvReport bool
+
+	header []string
+	cols   map[string]int // column of each mapped user field
+
+	batch   []store.User
+	pending []failedRow // the row of each user in batch
+
+	report importReport
+	failed []failedRow
+}
+
+// importUsers - POST /users/import
+// Creates users from a CSV file, the "file" part of a multipart/form-data
+// body, or from a JSON array of users, streaming either in batches of
+// opts.BatchSize so memory use doesn't grow with the body. The first
+// record of a CSV file is the header. An optional "mapping" part before
+// the file maps column names to the user fields in importFields as a
+// JSON object, such as {"E-mail": "email"}; without it, columns named
+// like fields are used. Other columns are ignored. Each row is checked
+// like POST /users and fails on its own; the answer counts the rows and
+// lists the problems of the rejected ones, up to maxImportErrors, or, to
+// clients accepting text/csv, is a CSV error report: the rejected rows
+// with their row number and problems, which can be corrected and
+// imported again. Rows of a JSON array are its elements, counted from 1.
+func importUsers(users store.UserRepository, rules *validation.Validator, opts ImportOptions, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	opts = opts.withDefaults()
+	if r.ContentLength > opts.MaxSize {
+		importTooLarge(w, r, opts)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, opts.MaxSize)
+	im := &userImport{users: users, rules: rules, opts: opts, w: w, r: r, report: importReport{Errors: []importError{}}}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		im.runJSON(r.Body)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "expected a multipart/form-data body")
+		return
+	}
+
+	var mapping map[string]string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			writeError(w, r, http.StatusBadRequest, "file is required")
+			return
+		}
+		if err != nil {
+			im.readError(err)
+			return
+		}
+		switch part.FormName() {
+		case "mapping":
+			if err := json.NewDecoder(io.LimitReader(part, 64<<10)).Decode(&mapping); err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid mapping")
+				return
+			}
+		case "file":
+			im.run(part, mapping)
+			return
+		}
+	}
+}
+
+func importTooLarge(w http.ResponseWriter, r *http.Request, opts ImportOptions) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, "file exceeds "+strconv.FormatInt(opts.MaxSize, 10)+" bytes")
+}
+
+// readError answers a failure to read the body before any row.
+func (im *userImport) readError(err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		importTooLarge(im.w, im.r, im.opts)
+		return
+	}
+	writeError(im.w, im.r, http.StatusBadRequest, "invalid multipart body")
+}
+
+// stopReading ends the import after reading the body failed; the rows read
+// until then are still stored.
+func (im *userImport) stopReading(err error) {
+	im.report.Stopped = localize(im.w, im.r, "reading the file failed")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		im.report.Stopped = localize(im.w, im.r, "file exceeds "+strconv.FormatInt(im.opts.MaxSize, 10)+" bytes")
+	}
+}
+
+// columns maps the user fields to the columns of the header.
+func (im *userImport) columns(mapping map[string]string) error {
+	im.cols = map[string]int{}
+	for i, name := range im.header {
+		name = strings.TrimSpace(name)
+		field := strings.ToLower(name)
+		if mapping != nil {
+			field = mapping[name]
+			delete(mapping, name)
+		}
+		if field == "" {
+			continue
+		}
+		known := false
+		for _, f := range importFields {
+			known = known || f == field
+		}
+		if !known {
+			if mapping == nil {
+				continue
+			}
+			return fmt.Errorf("mapping: unknown field %s", field)
+		}
+		if _, dup := im.cols[field]; dup {
+			return fmt.Errorf("more than one column maps to %s", field)
+		}
+		im.cols[field] = i
+	}
+	for name := range mapping {
+		return fmt.Errorf("mapping: no column %s", name)
+	}
+	if len(im.cols) == 0 {
+		return errors.New("no column maps to a user field")
+	}
+	return nil
+}
+
+// run imports the CSV in body and answers.
+func (im *userImport) run(body io.Reader, mapping map[string]string) {
+	w, r := im.w, im.r
+	w.Header().Add("Vary", "Accept")
+	im.csvReport = accepts(r, "text/csv")
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		writeError(w, r, http.StatusBadRequest, "file is empty")
+		return
+	}
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			writeError(w, r, http.StatusBadRequest, "invalid csv header")
+			return
+		}
+		im.readError(err)
+		return
+	}
+	// A byte order mark, as spreadsheets write, isn't part of the name
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	im.header = header
+	if err := im.columns(mapping); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	row := 1
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		var perr *csv.ParseError
+		if err != nil && !errors.As(err, &perr) {
+			im.stopReading(err)
+			break
+		}
+		im.report.Rows++
+		if perr != nil {
+			im.reject(row, rec, importError{Code: CodeValidationFailed, Error: localize(w, r, "invalid csv: "+perr.Err.Error())})
+			continue
+		}
+		if len(rec) != len(header) {
+			im.reject(row, rec, importError{Code: CodeValidationFailed,
+				Error: localize(w, r, fmt.Sprintf("expected %d columns, got %d", len(header), len(rec)))})
+			continue
+		}
+		if err := im.add(ctx, row, rec); err != nil {
+			im.stop(err)
+			break
+		}
+	}
+	im.finish(ctx)
+}
+
+// runJSON imports the JSON array of users in body and answers. Elements
+// are decoded one at a time, so only a batch is held in memory.
+func (im *userImport) runJSON(body io.Reader) {
+	w, r := im.w, im.r
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			importTooLarge(w, r, im.opts)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "expected a json array of users")
+		return
+	}
+	// Elements are parsed as records with a column per field
+	im.header = importFields
+	im.cols = make(map[string]int, len(importFields))
+	for i, f := range importFields {
+		im.cols[f] = i
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	row := 0
+	for dec.More() {
+		row++
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			im.stopReading(err)
+			break
+		}
+		im.report.Rows++
+		rec, errs := jsonRecord(v)
+		if len(errs) > 0 {
+			for i := range errs {
+				errs[i].Error = localize(w, r, errs[i].Error)
+			}
+			im.reject(row, rec, errs...)
+			continue
+		}
+		if err := im.add(ctx, row, rec); err != nil {
+			im.stop(err)
+			break
+		}
+	}
+	if im.report.Stopped == "" {
+		if _, err := dec.Token(); err != nil {
+			im.stopReading(err)
+		}
+	}
+	im.finish(ctx)
+}
+
+// jsonRecord returns the record of a user in a JSON import, with a column
+// per field of importFields, or the problems of its fields.
+func jsonRecord(v any) ([]string, []importError) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, []importError{{Code: CodeValidationFailed, Error: "expected a json object"}}
+	}
+	rec := make([]string, len(importFields))
+	var errs []importError
+	for i, field := range importFields {
+		switch x := obj[field].(type) {
+		case nil:
+		case string:
+			rec[i] = x
+		case json.Number:
+			rec[i] = x.String()
+		default:
+			errs = append(errs, importError{Field: field, Code: CodeValidationFailed, Error: "must be a string or a number"})
+		}
+	}
+	return rec, errs
+}
+
+// add parses the record of row and adds its user to the batch, storing the
+// batch once full. It returns an error when storing fails.
+func (im *userImport) add(ctx context.Context, row int, rec []string) error {
+	u, errs := im.parse(rec)
+	if len(errs) > 0 {
+		im.reject(row, rec, errs...)
+		return nil
+	}
+	im.batch = append(im.batch, u)
+	im.pending = append(im.pending, failedRow{row: row, record: rec})
+	if len(im.batch) >= im.opts.BatchSize {
+		return im.flush(ctx)
+	}
+	return nil
+}
+
+// finish stores the last batch and answers.
+func (im *userImport) finish(ctx context.Context) {
+	// After a read error the rows read are still stored
+	if len(im.batch) > 0 {
+		if err := im.flush(ctx); err != nil {
+			im.stop(err)
+		}
+	}
+
+	slog.InfoContext(ctx, "users imported", "rows", im.report.Rows, "imported", im.report.Imported,
+		"failed", im.report.Failed, "stopped", im.report.Stopped)
+	if im.csvReport {
+		im.writeCSV()
+		return
+	}
+	writeJSON(im.w, http.StatusOK, im.report)
+}
+
+// parse returns the user of record rec, or the problems of its fields.
+func (im *userImport) parse(rec []string) (store.User, []importError) {
+	var u store.User
+	var errs []importError
+	bad := func(field, msg string) {
+		errs = append(errs, importError{Field: field, Code: CodeValidationFailed, Error: localize(im.w, im.r, msg)})
+	}
+	for field, i := range im.cols {
+		v := strings.TrimSpace(rec[i])
+		if v == "" {
+			continue
+		}
+		switch field {
+		case "name":
+			u.Name = v
+		case "email":
+			u.Email = v
+		case "password":
+			u.Password = rec[i]
+		case "age":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				bad(field, "age must be a whole number")
+				continue
+			}
+			u.Age = n
+		case "created_at":
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				if t, err = time.Parse(time.DateOnly, v); err != nil {
+					bad(field, "created_at must be an RFC 3339 time or a date")
+					continue
+				}
+			}
+			u.CreatedAt = t.UTC()
+		}
+	}
+	for _, fe := range userFieldErrors(im.rules, im.w, im.r, &u) {
+		errs = append(errs, importError{Field: fe.Field, Code: fe.Code, Error: fe.Error})
+	}
+	if len(errs) > 0 {
+		return u, errs
+	}
+
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	if u.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			bad("password", "invalid password")
+			return u, errs
+		}
+		u.PasswordHash = string(hash)
+		u.Password = ""
+	}
+	return u, nil
+}
+
+// reject records the problems of a row.
+func (im *userImport) reject(row int, rec []string, errs ...importError) {
+	for i := range errs {
+		errs[i].Row = row
+	}
+	im.report.Failed++
+	if room := maxImportErrors - len(im.report.Errors); room < len(errs) {
+		im.report.ErrorsTruncated = true
+		im.report.Errors = append(im.report.Errors, errs[:room]...)
+	} else {
+		im.report.Errors = append(im.report.Errors, errs...)
+	}
+	if im.csvReport {
+		im.failed = append(im.failed, failedRow{row: row, record: rec, errs: errs})
+	}
+}
+
+// flush stores the batch: at once when the repository is a
+// store.BulkUsers, and one user at a time otherwise, or after the batch
+// failed, so each row fails on its own. It returns an error when storing
+// itself fails, or a quota is reached: the row is rejected and the rest of
+// the file isn't read.
+func (im *userImport) flush(ctx context.Context) error {
+	defer func() { im.batch, im.pending = im.batch[:0], im.pending[:0] }()
+	if len(im.batch) == 0 {
+		return nil
+	}
+	if bulk, ok := im.users.(store.BulkUsers); ok {
+		if err := bulk.CreateMany(ctx, im.batch); err != nil {
+			slog.DebugContext(ctx, "import batch failed, storing users one at a time", "error", err)
+		}
+	}
+	for i := range im.batch {
+		u := &im.batch[i]
+		if u.ID != "" {
+			im.report.Imported++
+			continue
+		}
+		err := im.users.Create(ctx, u)
+		switch {
+		case err == nil:
+			im.report.Imported++
+		case errors.Is(err, store.ErrDuplicateEmail):
+			p := im.pending[i]
+			im.reject(p.row, p.record, importError{Field: "email", Code: CodeDuplicateEmail, Error: localize(im.w, im.r, "email already in use")})
+		case errors.As(err, new(*quota.ExceededError)):
+			p := im.pending[i]
+			im.reject(p.row, p.record, quotaImportError(im.w, im.r, err))
+			return err
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// stop ends the import after storing failed.
+func (im *userImport) stop(err error) {
+	if errors.As(err, new(*quota.ExceededError)) {
+		im.report.Stopped = quotaImportError(im.w, im.r, err).Error
+		return
+	}
+	slog.ErrorContext(im.r.Context(), "user import stopped", "error", err)
+	if timedOut(im.r, err) {
+		im.report.Stopped = localize(im.w, im.r, "request timed out")
+		return
+	}
+	im.report.Stopped = localize(im.w, im.r, "storing users failed")
+}
+
+// writeCSV answers with the error report: the header and rejected rows of
+// the file, after their row number, with their problems in a last column.
+// Passwords are left out.
+func (im *userImport) writeCSV() {
+	w := im.w
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-errors.csv"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Import-Rows", strconv.Itoa(im.report.Rows))
+	w.Header().Set("Import-Imported", strconv.Itoa(im.report.Imported))
+	w.Header().Set("Import-Failed", strconv.Itoa(im.report.Failed))
+	w.WriteHeader(http.StatusOK)
+
+	password, hasPassword := im.cols["password"]
+	cw := csv.NewWriter(w)
+	_ = cw.Write(append(append([]string{"row"}, im.header...), "errors"))
+	for _, f := range im.failed {
+		msgs := make([]string, len(f.errs))
+		for i, e := range f.errs {
+			msgs[i] = e.Error
+			if e.Field != "" {
+				msgs[i] = e.Field + ": " + e.Error
+			}
+		}
+		// Short and unparsable records are padded so the problems line up
+		rec := append([]string(nil), f.record...)
+		for len(rec) < len(im.header) {
+			rec = append(rec, "")
+		}
+		if hasPassword && password < len(rec) {
+			rec[password] = ""
+		}
+		_ = cw.Write(append(append([]string{strconv.Itoa(f.row)}, rec...), strings.Join(msgs, "; ")))
+	}
+	if im.report.Stopped != "" {
+		_ = cw.Write([]string{"", "stopped: " + im.report.Stopped})
+	}
+	cw.Flush()
+}
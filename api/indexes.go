@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// indexStatus - GET /admin/indexes
+// Lists registered indexes with their state (ok, missing, drift) and
+// indexes that exist but are not registered.
+func indexStatus(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	status, err := mc.IndexStatus(ctx)
+	if err != nil {
+		dbError(w, r, "list indexes", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// rebuildIndex - POST /admin/indexes/rebuild
+// Body: {"collection": "users", "name": "email_1"}. Drops the index and
+// builds it again from its registered spec.
+func rebuildIndex(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var in struct {
+		Collection string `json:"collection"`
+		Name       string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Collection == "" || in.Name == "" {
+		writeError(w, r, http.StatusBadRequest, "collection and name are required")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	if err := mc.RebuildIndex(ctx, in.Collection, in.Name); err != nil {
+		if errors.Is(err, db.ErrUnknownIndex) {
+			writeError(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		dbError(w, r, "rebuild index", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"rebuilt": in.Collection + "." + in.Name})
+}
+
+// indexRequest is the body of POST /admin/indexes/{collection}.
+type indexRequest struct {
+	Name               string        `json:"name"`
+	Keys               []db.IndexKey `json:"keys"`
+	Unique             bool          `json:"unique"`
+	ExpireAfterSeconds *int64        `json:"expire_after_seconds"`
+	Partial            bson.M        `json:"partial"`
+}
+
+// indexKinds are the string values of index keys.
+var indexKinds = map[string]bool{"text": true, "2dsphere": true, "2d": true, "hashed": true}
+
+// spec validates the request and returns the index it asks for on coll.
+func (in indexRequest) spec(coll string) (db.IndexSpec, error) {
+	s := db.IndexSpec{Collection: coll, Name: in.Name, Unique: in.Unique, Partial: in.Partial}
+	if in.Name == "" || len(in.Keys) == 0 {
+		return s, errors.New("name and keys are required")
+	}
+	for _, k := range in.Keys {
+		if k.Field == "" || strings.HasPrefix(k.Field, "$") {
+			return s, errors.New("invalid index key")
+		}
+		switch v := k.Value.(type) {
+		case float64:
+			if v != 1 && v != -1 {
+				return s, errors.New("invalid index key")
+			}
+			s.Keys = append(s.Keys, bson.E{Key: k.Field, Value: int32(v)})
+		case string:
+			if !indexKinds[v] {
+				return s, errors.New("invalid index key")
+			}
+			s.Keys = append(s.Keys, bson.E{Key: k.Field, Value: v})
+		default:
+			return s, errors.New("invalid index key")
+		}
+	}
+	if in.ExpireAfterSeconds != nil {
+		if *in.ExpireAfterSeconds < 0 || len(s.Keys) != 1 {
+			return s, errors.New("a TTL index needs one key and expire_after_seconds of at least 0")
+		}
+		d := time.Duration(*in.ExpireAfterSeconds) * time.Second
+		s.ExpireAfter = &d
+	}
+	return s, nil
+}
+
+// indexHandler - GET, POST /admin/indexes/{collection} and
+// DELETE /admin/indexes/{collection}/{name}
+// The collection is a resource name, as in the registry. GET lists its
+// indexes with their usage ($indexStats); POST builds one outside the
+// registry from {"name", "keys": [{"field", "value"}], "unique",
+// "expire_after_seconds", "partial"}. Indexes of the registry are only
+// dropped with force=true, and come back on the next start.
+func indexHandler(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
+	coll, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/indexes/"), "/")
+	if ns, err := db.ParseNamespace(coll); err != nil || ns.Database != "" {
+		writeError(w, r, http.StatusBadRequest, "invalid collection")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	if name != "" {
+		setRouteName(r, "/admin/indexes/{collection}/{name}")
+		if r.Method != http.MethodDelete {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		force, err := strconv.ParseBool(r.URL.Query().Get("force"))
+		if err != nil && r.URL.Query().Has("force") {
+			writeError(w, r, http.StatusBadRequest, "invalid force")
+			return
+		}
+		if err := mc.DropIndex(ctx, coll, name, force); err != nil {
+			indexError(w, r, "drop index", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"dropped": coll + "." + name})
+		return
+	}
+
+	setRouteName(r, "/admin/indexes/{collection}")
+	switch r.Method {
+	case http.MethodGet:
+		out, err := mc.Indexes(ctx, coll)
+		if err != nil {
+			indexError(w, r, "list indexes", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		var in indexRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		s, err := in.spec(coll)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := mc.CreateIndex(ctx, s); err != nil {
+			indexError(w, r, "create index", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"created": coll + "." + s.Name})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// indexError writes the error of a failed index command.
+func indexError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, db.ErrIndexNotFound):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, db.ErrInvalidIndex):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, db.ErrRegisteredIndex), errors.Is(err, db.ErrIndexConflict):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		dbError(w, r, op, err)
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served by route pattern and method.",
+	}, []string{"route", "method"})
+
+	mutationsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_mutations_in_flight",
+		Help: "Mutating HTTP requests currently being served; shutdown drains these before disconnecting Mongo.",
+	})
+)
+
+// inflight tracks the requests currently being served. Counts are kept per
+// matched mux pattern, since handlers refine the route name only once they
+// run.
+type inflight struct {
+	mu     sync.Mutex
+	active map[string]int64 // "METHOD pattern" -> count
+
+	mutations atomic.Int64
+}
+
+func newInflight() *inflight {
+	return &inflight{active: map[string]int64{}}
+}
+
+func (f *inflight) add(key string, n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active[key] += n; f.active[key] <= 0 {
+		delete(f.active, key)
+	}
+}
+
+// middleware counts requests while they are served.
+func (f *inflight) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ri := withRoute(mux, r)
+		route := ri.name
+		key := r.Method + " " + route
+
+		gauge := httpInFlight.WithLabelValues(route, r.Method)
+		gauge.Inc()
+		f.add(key, 1)
+		mutating := isMutating(r.Method)
+		if mutating {
+			f.mutations.Add(1)
+			mutationsInFlight.Inc()
+		}
+		defer func() {
+			gauge.Dec()
+			f.add(key, -1)
+			if mutating {
+				f.mutations.Add(-1)
+				mutationsInFlight.Dec()
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// snapshot returns the active request counts keyed by "METHOD pattern".
+func (f *inflight) snapshot() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]int64, len(f.active))
+	for k, v := range f.active {
+		out[k] = v
+	}
+	return out
+}
+
+// waitMutations blocks until no mutating request is in flight or ctx is
+// done, returning how many are still running.
+func (f *inflight) waitMutations(ctx context.Context) int64 {
+	t := time.NewTicker(50 * time.Millisecond)
+	defer t.Stop()
+	for {
+		n := f.mutations.Load()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-t.C:
+		}
+	}
+}
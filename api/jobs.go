@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang/jobs"
+)
+
+// jobsHandler - GET /admin/jobs
+// Lists jobs newest first, filtered by the optional type and state query
+// parameters, with offset and limit (default 50).
+func jobsHandler(q *jobs.Queue, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	f := jobs.Filter{Type: query.Get("type"), State: query.Get("state"), Limit: 50}
+	switch f.State {
+	case "", jobs.StateQueued, jobs.StateRunning, jobs.StateSucceeded, jobs.StateFailed:
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid state")
+		return
+	}
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"offset", &f.Offset}, {"limit", &f.Limit}} {
+		if s := query.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				writeError(w, r, http.StatusBadRequest, "invalid "+p.name)
+				return
+			}
+			*p.v = n
+		}
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	out, err := q.List(ctx, f)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// jobHandler - GET /admin/jobs/{id} and POST /admin/jobs/{id}/retry
+// Retry queues a failed job again with a fresh set of attempts.
+func jobHandler(q *jobs.Queue, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"), "/")
+	id := parts[0]
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	switch {
+	case len(parts) == 1:
+		setRouteName(r, "/admin/jobs/{id}")
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		j, err := q.Get(ctx, id)
+		if err != nil {
+			jobError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+
+	case len(parts) == 2 && parts[1] == "retry":
+		setRouteName(r, "/admin/jobs/{id}/retry")
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := q.Retry(ctx, id); err != nil {
+			jobError(w, r, "update", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id})
+
+	default:
+		writeError(w, r, http.StatusNotFound, "not found")
+	}
+}
+
+func jobError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "not found")
+	case errors.Is(err, jobs.ErrNotFailed):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		dbError(w, r, op, err)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Key cases of JSON responses.
+const (
+	KeySnake = "snake"
+	KeyCamel = "camel"
+)
+
+// KeyCaseOptions configures the case of the keys of JSON responses. The
+// handlers and the spec use snake_case; camelCase responses are rewritten
+// from it.
+type KeyCaseOptions struct {
+	// Default is KeySnake or KeyCamel.
+	Default string
+	// Header, when set, is the request header through which a client picks
+	// KeySnake or KeyCamel for its request.
+	Header string
+}
+
+// snakeKey matches the keys rewritten: snake_case identifiers. Keys that
+// are data rather than names, such as routes, dates or collection:field
+// pairs, are kept.
+var snakeKey = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)+$`)
+
+// camelCase returns snake_case key in camelCase.
+func camelCase(key string) string {
+	if !snakeKey.MatchString(key) {
+		return key
+	}
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// keyCaseMiddleware rewrites the keys of JSON responses in the case the
+// request asks for. It must run outside the contract check, which
+// expects the keys of the spec.
+func keyCaseMiddleware(opts KeyCaseOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyCase := opts.Default
+		if opts.Header != "" {
+			w.Header().Add("Vary", opts.Header)
+			switch v := strings.ToLower(strings.TrimSpace(r.Header.Get(opts.Header))); v {
+			case KeySnake, KeyCamel:
+				keyCase = v
+			}
+		}
+		if keyCase != KeyCamel {
+			next.ServeHTTP(w, r)
+			return
+		}
+		kw := &keyCaseWriter{ResponseWriter: w}
+		next.ServeHTTP(kw, r)
+		kw.close()
+	})
+}
+
+// keyCaseWriter holds JSON bodies back to rewrite them whole; other bodies
+// pass through.
+type keyCaseWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	json    bool
+	buf     bytes.Buffer
+}
+
+func (kw *keyCaseWriter) WriteHeader(code int) {
+	if kw.decided {
+		return
+	}
+	if code < 200 {
+		kw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	kw.decide(code)
+}
+
+// decide picks whether to rewrite the body once the status is known.
+func (kw *keyCaseWriter) decide(code int) {
+	kw.decided, kw.status = true, code
+	mt, _, _ := mime.ParseMediaType(kw.Header().Get("Content-Type"))
+	// Event schemas describe payloads sent elsewhere, in snake_case
+	kw.json = bodyAllowed(code) && kw.Header().Get("Content-Encoding") == "" &&
+		(mt == "application/json" || strings.HasSuffix(mt, "+json") && mt != "application/schema+json")
+	if !kw.json {
+		kw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (kw *keyCaseWriter) Write(b []byte) (int, error) {
+	if !kw.decided {
+		kw.decide(http.StatusOK)
+	}
+	if kw.json {
+		return kw.buf.Write(b)
+	}
+	return kw.ResponseWriter.Write(b)
+}
+
+// Flush sends bodies that aren't rewritten as they come; JSON bodies are
+// only complete once the handler returns.
+func (kw *keyCaseWriter) Flush() {
+	if !kw.decided || kw.json {
+		return
+	}
+	if f, ok := kw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (kw *keyCaseWriter) Unwrap() http.ResponseWriter {
+	return kw.ResponseWriter
+}
+
+// close writes the rewritten JSON body.
+func (kw *keyCaseWriter) close() {
+	if !kw.json {
+		return
+	}
+	body := kw.buf.Bytes()
+	var out bytes.Buffer
+	if err := camelJSON(&out, body); err == nil {
+		body = out.Bytes()
+	}
+	kw.Header().Del("Content-Length")
+	kw.ResponseWriter.WriteHeader(kw.status)
+	_, _ = kw.ResponseWriter.Write(body)
+}
+
+// camelJSON copies the JSON values in b to w with their object keys in
+// camelCase, keeping their order, each followed by a newline as
+// json.Encoder writes them.
+func camelJSON(w *bytes.Buffer, b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	for dec.More() {
+		if err := camelValue(w, dec); err != nil {
+			return err
+		}
+		w.WriteByte('\n')
+	}
+	return nil
+}
+
+func camelValue(w *bytes.Buffer, dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		enc, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		w.Write(enc)
+		return nil
+	}
+	w.WriteByte(byte(delim))
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			enc, _ := json.Marshal(camelCase(key.(string)))
+			w.Write(enc)
+			w.WriteByte(':')
+		}
+		if err := camelValue(w, dec); err != nil {
+			return err
+		}
+	}
+	// The closing delimiter
+	end, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	w.WriteByte(byte(end.(json.Delim)))
+	return nil
+}
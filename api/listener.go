@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// adminListenerKey marks requests that came in through AdminHandler.
+type adminListenerKey struct{}
+
+// operationalRoute returns the operational route path is below: /metrics,
+// /healthz, /readyz, /version or /admin, which includes everything under
+// /admin/.
+func operationalRoute(path string) (string, bool) {
+	switch {
+	case path == "/metrics", path == "/healthz", path == "/readyz", path == "/version":
+		return path, true
+	case path == "/admin", strings.HasPrefix(path, "/admin/"):
+		return "/admin", true
+	}
+	return "", false
+}
+
+// listenerFilter answers 404 to requests for routes the listener they came
+// in through doesn't serve: the admin listener serves the operational
+// routes only, the API listener all routes but those in adminPaths.
+func listenerFilter(adminPaths []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, operational := operationalRoute(r.URL.Path)
+		admin, _ := r.Context().Value(adminListenerKey{}).(bool)
+		if admin && !operational || !admin && operational && slices.Contains(adminPaths, route) {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler returns the handler for the separate admin listener. It
+// serves the routes Options.AdminPaths moves off the API, and the other
+// operational routes as well, through the same middleware, and pprof and
+// expvar like NewDebugHandler.
+func (rt *Router) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", rt.debug)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		rt.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminListenerKey{}, true)))
+	})
+	return mux
+}
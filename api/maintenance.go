@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenance is the admin-togglable maintenance mode. While enabled,
+// mutating requests are rejected with 503 and reads keep working.
+type maintenance struct {
+	mu         sync.RWMutex
+	enabled    bool
+	since      time.Time
+	retryAfter time.Duration
+	message    string
+}
+
+type maintenanceState struct {
+	Enabled    bool   `json:"enabled"`
+	Since      string `json:"since,omitempty"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+func (m *maintenance) set(enabled bool, retryAfter time.Duration, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled && !m.enabled {
+		m.since = time.Now().UTC()
+	}
+	m.enabled = enabled
+	m.retryAfter = retryAfter
+	m.message = message
+}
+
+func (m *maintenance) state() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := maintenanceState{Enabled: m.enabled}
+	if m.enabled {
+		st.Since = m.since.Format(time.RFC3339)
+		st.RetryAfter = m.retryAfter.String()
+		st.Message = m.message
+	}
+	return st
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// middleware rejects mutating requests during maintenance. Admin routes stay
+// writable so maintenance can be switched off again.
+func (m *maintenance) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutating(r.Method) && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			m.mu.RLock()
+			enabled, retryAfter, message := m.enabled, m.retryAfter, m.message
+			m.mu.RUnlock()
+
+			if enabled {
+				if message == "" {
+					message = "service is in maintenance mode; writes are temporarily disabled"
+				}
+				if retryAfter > 0 {
+					writeRetryError(w, r, http.StatusServiceUnavailable, CodeUnavailable, message, retryAfter)
+				} else {
+					writeError(w, r, http.StatusServiceUnavailable, message)
+				}
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handle - GET/PUT /admin/maintenance
+func (m *maintenance) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, m.state())
+	case http.MethodPut, http.MethodPost:
+		var in struct {
+			Enabled    bool   `json:"enabled"`
+			RetryAfter string `json:"retry_after"`
+			Message    string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		var retryAfter time.Duration
+		if in.RetryAfter != "" {
+			d, err := time.ParseDuration(in.RetryAfter)
+			if err != nil || d < 0 {
+				writeError(w, r, http.StatusBadRequest, "invalid retry_after duration")
+				return
+			}
+			retryAfter = d
+		}
+		m.set(in.Enabled, retryAfter, in.Message)
+		writeJSON(w, http.StatusOK, m.state())
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
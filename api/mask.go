@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"golang/store"
+)
+
+// Caller roles, from least to most access.
+const (
+	RoleViewer = "viewer"
+	RoleUser   = "user"
+	RoleAdmin  = "admin"
+)
+
+var roles = []string{RoleViewer, RoleUser, RoleAdmin}
+
+// MaskingOptions configures which user fields callers see by role.
+// Callers with the admin token are admins, callers with a session users
+// and anyone else viewers.
+type MaskingOptions struct {
+	// Policy maps JSON field names of users to the least role that sees
+	// them, overriding the `visible` tags of store.User. Untagged fields
+	// are visible to all.
+	Policy map[string]string
+}
+
+// userFields are the JSON names of the fields of store.User in order;
+// userVisibility the least role that sees them, from their `visible` tags.
+var (
+	userFields     []string
+	userVisibility = map[string]string{}
+)
+
+func init() {
+	t := reflect.TypeOf(store.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		userFields = append(userFields, name)
+		userVisibility[name] = f.Tag.Get("visible")
+	}
+}
+
+// fieldPolicy holds the user fields hidden from each role.
+type fieldPolicy struct {
+	adminToken string
+	hidden     map[string]map[string]bool // role -> field -> hidden
+}
+
+func newFieldPolicy(adminToken string, opts MaskingOptions) *fieldPolicy {
+	least := map[string]string{}
+	for name, role := range userVisibility {
+		least[name] = role
+	}
+	for name, role := range opts.Policy {
+		if _, ok := least[name]; !ok {
+			slog.Warn("field masking policy names an unknown user field", "field", name)
+			continue
+		}
+		least[name] = role
+	}
+
+	p := &fieldPolicy{adminToken: adminToken, hidden: map[string]map[string]bool{}}
+	for rank, role := range roles {
+		for name, want := range least {
+			if roleRank(want) <= rank {
+				continue
+			}
+			if p.hidden[role] == nil {
+				p.hidden[role] = map[string]bool{}
+			}
+			p.hidden[role][name] = true
+		}
+	}
+	return p
+}
+
+// roleRank orders roles by access; fields without a role are visible to
+// all.
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return 0
+}
+
+// role returns the role of the caller of r.
+func (p *fieldPolicy) role(r *http.Request) string {
+	return callerRole(p.adminToken, r)
+}
+
+// callerRole returns RoleAdmin for callers with adminToken, RoleUser for
+// those with a session and RoleViewer for the others.
+func callerRole(adminToken string, r *http.Request) string {
+	if adminToken != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) == 1 {
+			return RoleAdmin
+		}
+	}
+	if sessionFromContext(r.Context()) != nil {
+		return RoleUser
+	}
+	return RoleViewer
+}
+
+type maskKey struct{}
+
+// middleware records the user fields hidden from the caller of each
+// request. It must run after the session middleware.
+func (p *fieldPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hidden := p.hidden[p.role(r)]; len(hidden) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), maskKey{}, hidden))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maskUser returns u for the response to r, without the fields hidden
+// from its caller.
+func maskUser(r *http.Request, u *store.User) any {
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+	if hidden == nil {
+		return u
+	}
+	return maskedUser{u: u, hidden: hidden}
+}
+
+// maskUsers is maskUser for lists, which are never null.
+func maskUsers(r *http.Request, users []store.User) any {
+	if users == nil {
+		users = []store.User{}
+	}
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+	if hidden == nil {
+		return users
+	}
+	out := make([]maskedUser, len(users))
+	for i := range users {
+		out[i] = maskedUser{u: &users[i], hidden: hidden}
+	}
+	return out
+}
+
+// maskedUser marshals a user without hidden fields, keeping the field
+// order of store.User.
+type maskedUser struct {
+	u      *store.User
+	hidden map[string]bool
+}
+
+func (m maskedUser) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(m.u)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, name := range userFields {
+		v, ok := fields[name]
+		if !ok || m.hidden[name] {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"golang/requestid"
+)
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) Status() int {
+	if sr.status == 0 {
+		return http.StatusOK
+	}
+	return sr.status
+}
+
+// routeInfo holds the low-cardinality route name of a request, used as a
+// label for metrics and logs. It starts as the matched mux pattern and
+// handlers serving several routes under one pattern refine it.
+type routeInfo struct {
+	name string
+}
+
+type routeKey struct{}
+
+// withRoute stores the route name for r, resolving it from mux.
+func withRoute(mux *http.ServeMux, r *http.Request) (*http.Request, *routeInfo) {
+	if ri, ok := r.Context().Value(routeKey{}).(*routeInfo); ok {
+		return r, ri
+	}
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		pattern = "unmatched"
+	}
+	ri := &routeInfo{name: pattern}
+	method := r.Method
+	ctx := context.WithValue(r.Context(), routeKey{}, ri)
+	// Mongo operations are tagged with the route; see db.Comment
+	ctx = requestid.WithRoute(ctx, func() string { return method + " " + ri.name })
+	return r.WithContext(ctx), ri
+}
+
+// setRouteName refines the route name recorded for r.
+func setRouteName(r *http.Request, name string) {
+	if ri, ok := r.Context().Value(routeKey{}).(*routeInfo); ok {
+		ri.name = name
+	}
+}
+
+// routeName returns the route name recorded for r.
+func routeName(r *http.Request) string {
+	if ri, ok := r.Context().Value(routeKey{}).(*routeInfo); ok {
+		return ri.name
+	}
+	return "unmatched"
+}
+
+// routePattern matches request paths before routing names them: a route,
+// such as /users/{id}/export, whose {name} segments match any one
+// segment, or a path prefix ending in /*, such as /admin/*.
+type routePattern struct {
+	name     string
+	segments []string // of a route; nil for a prefix
+	prefix   string
+}
+
+func newRoutePattern(name string) routePattern {
+	p := routePattern{name: name}
+	if prefix, ok := strings.CutSuffix(name, "*"); ok {
+		p.prefix = prefix
+	} else {
+		p.segments = strings.Split(strings.Trim(name, "/"), "/")
+	}
+	return p
+}
+
+func (p routePattern) matches(path string) bool {
+	if p.segments == nil {
+		return strings.HasPrefix(path, p.prefix)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(p.segments) {
+		return false
+	}
+	for i, s := range p.segments {
+		if parts[i] == "" || !strings.HasPrefix(s, "{") && s != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific orders patterns so the first a path matches is the most
+// specific: routes before prefixes, longer prefixes first.
+func (p routePattern) moreSpecific(q routePattern) bool {
+	if (p.segments == nil) != (q.segments == nil) {
+		return p.segments != nil
+	}
+	if len(p.prefix) != len(q.prefix) {
+		return len(p.prefix) > len(q.prefix)
+	}
+	return p.name < q.name
+}
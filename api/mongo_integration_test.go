@@ -0,0 +1,176 @@
+//go:build integration
+
+// The tests in this file run the /users routes over a MongoDB server
+// started in Docker:
+//
+//	go test -tags integration ./api
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang/db"
+	"golang/events"
+	"golang/stats"
+	"golang/store"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// startMongo starts a MongoDB server for the duration of t and connects to
+// it, with the registered indexes.
+func startMongo(t *testing.T) *db.MongoClient {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	container, err := mongodb.RunContainer(ctx, testcontainers.WithImage("mongo:7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc, err := db.Connect(uri, "test", db.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mc.Client.Disconnect(context.Background()) })
+	if _, err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return mc
+}
+
+func TestMongoUsers(t *testing.T) {
+	mc := startMongo(t)
+	users := mc.Collection("users")
+	h := NewRouter(mc, Options{Users: store.NewMongoUsers(mc)})
+
+	// Each test starts from an empty collection
+	run := func(name string, f func(t *testing.T)) {
+		if _, err := users.DeleteMany(context.Background(), bson.M{}); err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, f)
+	}
+
+	run("lifecycle", func(t *testing.T) { usersLifecycle(t, h) })
+	run("created_at", func(t *testing.T) { userCreatedAt(t, h) })
+
+	run("duplicate email", func(t *testing.T) {
+		body := `{"name":"Ada","email":"ada@example.com"}`
+		if rec := sendUsers(h, http.MethodPost, "/users", "application/json", body); rec.Code != http.StatusCreated {
+			t.Fatalf("POST /users = %d: %s", rec.Code, rec.Body)
+		}
+		rec := sendUsers(h, http.MethodPost, "/users", "application/json", body)
+		var got struct {
+			Code string `json:"code"`
+		}
+		decodeJSON(t, rec, &got)
+		if rec.Code != http.StatusConflict || got.Code != CodeDuplicateEmail {
+			t.Errorf("POST /users with a taken email = %d %s, want %d %s", rec.Code, rec.Body, http.StatusConflict, CodeDuplicateEmail)
+		}
+	})
+
+	run("erasure", func(t *testing.T) {
+		st := stats.New(mc, false)
+		h := NewRouter(mc, Options{
+			Users:      store.NewMongoUsers(mc),
+			AdminToken: "admin",
+			Outbox:     events.NewOutbox(mc, nil, events.Options{}),
+			Stats:      st,
+		})
+		rec := sendUsers(h, http.MethodPost, "/users", "application/json", `{"name":"Ada","email":"ada@example.com"}`)
+		var created struct {
+			ID string `json:"id"`
+		}
+		decodeJSON(t, rec, &created)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+created.ID+"/data", nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("DELETE /users/{id}/data = %d: %s", rec.Code, rec.Body)
+		}
+
+		n, err := mc.Collection("outbox").CountDocuments(context.Background(), bson.M{"type": events.UserDeleted, "key": created.ID})
+		if err != nil || n != 1 {
+			t.Errorf("%s events in the outbox = %d, %v, want 1", events.UserDeleted, n, err)
+		}
+		s, err := st.Get(context.Background())
+		if err != nil || s.Total != 0 || s.ByStatus[stats.Active] != 0 {
+			t.Errorf("stats after erasure = %+v, %v, want no users", s, err)
+		}
+	})
+
+	run("last login", func(t *testing.T) {
+		h := NewRouter(mc, Options{Users: store.NewMongoUsers(mc), Sessions: &SessionOptions{}})
+		rec := sendUsers(h, http.MethodPost, "/users", "application/json", `{"name":"Ada","email":"ada@example.com","password":"secret"}`)
+		var created struct {
+			ID string `json:"id"`
+		}
+		decodeJSON(t, rec, &created)
+
+		before := time.Now().Add(-time.Second)
+		rec = sendUsers(h, http.MethodPost, "/auth/login", "application/json", `{"email":"ada@example.com","password":"secret"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /auth/login = %d: %s", rec.Code, rec.Body)
+		}
+		oid, _ := primitive.ObjectIDFromHex(created.ID)
+		var doc struct {
+			LastLoginAt time.Time `bson:"last_login_at"`
+		}
+		if err := users.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.LastLoginAt.Before(before) {
+			t.Errorf("last_login_at = %v, want the time of the login", doc.LastLoginAt)
+		}
+	})
+
+	// Documents as older versions and imports wrote them
+	run("legacy documents", func(t *testing.T) {
+		want := "2023-01-02T01:04:05Z"
+		at, _ := time.Parse(time.RFC3339, want)
+		tests := []struct {
+			name      string
+			createdAt any
+			age       any
+		}{
+			{"date", primitive.NewDateTimeFromTime(at), int32(36)},
+			{"RFC 3339 string", "2023-01-02T03:04:05+02:00", int64(36)},
+			{"extended JSON", bson.M{"$date": want}, 36.0},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res, err := users.InsertOne(context.Background(), bson.M{"name": "Ada", "created_at": tt.createdAt, "age": tt.age})
+				if err != nil {
+					t.Fatal(err)
+				}
+				path := "/users/" + res.InsertedID.(primitive.ObjectID).Hex()
+				rec := sendUsers(h, http.MethodGet, path, "", "")
+				var got map[string]any
+				decodeJSON(t, rec, &got)
+				if rec.Code != http.StatusOK || got["created_at"] != want || got["age"] != 36.0 {
+					t.Errorf("GET %s = %d %v, want created_at %s and age 36", path, rec.Code, got, want)
+				}
+			})
+		}
+	})
+}
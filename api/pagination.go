@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang/store"
+)
+
+// listMediaType selects version 2 of GET /users through the Accept header:
+// the users wrapped in an envelope with pagination metadata. Other
+// clients keep getting the bare array.
+const listMediaType = "application/vnd.users.v2+json"
+
+// Page sizes of version 2 lists.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// userPage is a version 2 list response. Total is null when the storage
+// backend cannot count, and Next when this is the last page.
+type userPage struct {
+	Data  any     `json:"data"`
+	Total *int64  `json:"total"`
+	Page  int     `json:"page"`
+	Next  *string `json:"next"`
+}
+
+// wantsPage reports whether the request accepts listMediaType.
+func wantsPage(r *http.Request) bool {
+	return accepts(r, listMediaType)
+}
+
+// accepts reports whether the Accept header of r names mediaType
+// without q=0. Wildcards don't count.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mt != mediaType {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(q, 64); err != nil || f == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// pageFilter applies the page size defaults and the page parameter, an
+// alternative to offset counting from 1, to f.
+func pageFilter(r *http.Request, f *store.UserFilter) error {
+	q := r.URL.Query()
+	if f.Limit == 0 {
+		f.Limit = defaultPageSize
+	}
+	f.Limit = min(f.Limit, maxPageSize)
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return errors.New("invalid page")
+		}
+		if q.Get("offset") != "" {
+			return errors.New("page cannot be combined with offset")
+		}
+		f.Offset = (n - 1) * f.Limit
+	}
+	return nil
+}
+
+// writePage writes users, the page of the list f selects, in an envelope.
+// counter may be nil.
+func writePage(counter store.UserCounter, w http.ResponseWriter, r *http.Request, f store.UserFilter, users []store.User) {
+	page := userPage{Data: maskUsers(r, users), Page: f.Offset/f.Limit + 1}
+	more := len(users) == f.Limit
+	if counter != nil {
+		ctx, cancel := opContext(r)
+		defer cancel()
+		total, err := counter.Count(ctx, f)
+		if err != nil {
+			dbError(w, r, "count", err)
+			return
+		}
+		page.Total = &total
+		more = int64(f.Offset+len(users)) < total
+	}
+	if more {
+		q := r.URL.Query()
+		q.Del("page")
+		q.Set("offset", strconv.Itoa(f.Offset+len(users)))
+		q.Set("limit", strconv.Itoa(f.Limit))
+		next := r.URL.Path + "?" + q.Encode()
+		page.Next = &next
+	}
+	w.Header().Set("Content-Type", listMediaType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep & in next readable
+	_ = enc.Encode(page)
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang/store"
+	"golang/validation"
+)
+
+// Media types of patches: RFC 6902 JSON Patch and RFC 7386 JSON Merge
+// Patch documents.
+const (
+	jsonPatchType  = "application/json-patch+json"
+	mergePatchType = "application/merge-patch+json"
+)
+
+// maxPatchOps caps the operations of one patch.
+const maxPatchOps = 100
+
+// patchOp is an operation of a JSON Patch. Value is nil when the member
+// is missing, and the JSON null when it is null.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchError is a patch that can't be applied, answered with status.
+type patchError struct {
+	status int
+	msg    string
+}
+
+func (e *patchError) Error() string { return e.msg }
+
+func badPatch(format string, args ...any) error {
+	return &patchError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflictingPatch(format string, args ...any) error {
+	return &patchError{status: http.StatusConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+// patchUser - PATCH /users/{id}
+// Applies a patch to the user's writable fields, the top-level fields of
+// PUT, and answers with the user as updated. Only the fields the patch
+// changes are written, removed ones with $unset, so concurrent updates of
+// other fields are kept.
+//
+// A JSON Merge Patch (RFC 7386), sent as application/merge-patch+json, is
+// an object of fields to set, null removing one; the password can't be
+// removed.
+//
+// A JSON Patch (RFC 6902), sent as application/json-patch+json, has
+// operations on the paths /name, /email, /age and /password, which can be
+// set but not read, tested, copied or removed. They apply in order to the
+// user as read, all or none: 409 when a test fails or a path to read or
+// replace is unset. Tests are checked against the user as read, not
+// atomically with the update.
+func patchUser(users store.UserRepository, rules *validation.Validator, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	var body map[string]any
+	switch mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt {
+	case mergePatchType:
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, "merge patch must be a json object")
+			return
+		}
+	case jsonPatchType:
+		ops, err := readPatch(r)
+		if err != nil {
+			writePatchError(w, r, err)
+			return
+		}
+		u, err := users.Get(ctx, id)
+		if err != nil {
+			userError(w, r, "find", err)
+			return
+		}
+		if body, err = applyPatch(r, u, ops); err != nil {
+			writePatchError(w, r, err)
+			return
+		}
+		if len(body) == 0 {
+			writeJSON(w, http.StatusOK, maskUser(r, u))
+			return
+		}
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, "content type "+mt+" is not allowed")
+		return
+	}
+	fields, err := userChanges(rules, body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := users.Update(ctx, id, fields); err != nil {
+		userError(w, r, "update", err)
+		return
+	}
+	// A concurrent delete may leave nothing to answer with
+	u, err := users.Get(ctx, id)
+	if err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, maskUser(r, u))
+}
+
+// applyPatch applies the operations of a JSON Patch to u and returns the
+// fields they change, nil for removed ones.
+func applyPatch(r *http.Request, u *store.User, ops []patchOp) (map[string]any, error) {
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+	doc := patchDocument(u)
+	for _, op := range ops {
+		if err := op.apply(doc, hidden); err != nil {
+			return nil, err
+		}
+	}
+
+	body := map[string]any{}
+	before := patchDocument(u)
+	for k := range userWritableFields {
+		if k == "password" {
+			if pw, ok := doc[k]; ok {
+				body[k] = pw
+			}
+			continue
+		}
+		if !reflect.DeepEqual(doc[k], before[k]) {
+			body[k] = doc[k]
+		}
+	}
+	return body, nil
+}
+
+func writePatchError(w http.ResponseWriter, r *http.Request, err error) {
+	var pe *patchError
+	if errors.As(err, &pe) {
+		writeError(w, r, pe.status, pe.msg)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, "invalid json patch")
+}
+
+// readPatch decodes the operations of a patch one at a time, checking
+// each as it is read, so a bad or oversized patch is refused without
+// reading it whole.
+func readPatch(r *http.Request) ([]patchOp, error) {
+	dec := json.NewDecoder(r.Body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, badPatch("invalid json patch")
+	}
+	var ops []patchOp
+	for dec.More() {
+		if len(ops) == maxPatchOps {
+			return nil, badPatch("patch has more than %d operations", maxPatchOps)
+		}
+		var op patchOp
+		if err := dec.Decode(&op); err != nil {
+			return nil, badPatch("invalid json patch")
+		}
+		if err := op.check(); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, badPatch("invalid json patch")
+	}
+	if len(ops) == 0 {
+		return nil, badPatch("no fields to update")
+	}
+	return ops, nil
+}
+
+// check checks the form of op: a known operation on paths of writable
+// fields, with the members it needs.
+func (op *patchOp) check() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return badPatch("op %s needs a value", op.Op)
+		}
+	case "remove":
+	case "move", "copy":
+		if _, err := patchField(op.From); err != nil {
+			return err
+		}
+	default:
+		return badPatch("unsupported op %s", op.Op)
+	}
+	_, err := patchField(op.Path)
+	return err
+}
+
+// patchField returns the field a JSON Pointer names, which must be a
+// writable top-level user field.
+func patchField(pointer string) (string, error) {
+	field, ok := strings.CutPrefix(pointer, "/")
+	if !ok {
+		return "", badPatch("invalid path %s", strconv.Quote(pointer))
+	}
+	field = strings.NewReplacer("~1", "/", "~0", "~").Replace(field)
+	if strings.Contains(pointer[1:], "/") || !userWritableFields[field] {
+		return "", badPatch("path %s is not allowed", pointer)
+	}
+	return field, nil
+}
+
+// patchDocument returns the fields of u a patch applies to, with the
+// values and presence they have in responses.
+func patchDocument(u *store.User) map[string]any {
+	doc := map[string]any{}
+	if u.Name != "" {
+		doc["name"] = u.Name
+	}
+	if u.Email != "" {
+		doc["email"] = u.Email
+	}
+	if u.Age != 0 {
+		doc["age"] = float64(u.Age)
+	}
+	return doc
+}
+
+// apply applies op to doc. Fields in hidden, masked from the caller, and
+// the password can be written but not read.
+func (op *patchOp) apply(doc map[string]any, hidden map[string]bool) error {
+	field, _ := patchField(op.Path)
+	read := func(field, pointer string) (any, error) {
+		if field == "password" || hidden[field] {
+			return nil, badPatch("path %s can't be read", pointer)
+		}
+		v, ok := doc[field]
+		if !ok {
+			return nil, conflictingPatch("path %s does not exist", pointer)
+		}
+		return v, nil
+	}
+	var value any
+	if op.Value != nil {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return badPatch("invalid json patch")
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		doc[field] = value
+	case "replace":
+		if field != "password" {
+			if _, err := read(field, op.Path); err != nil {
+				return err
+			}
+		}
+		doc[field] = value
+	case "remove":
+		if field == "password" {
+			return badPatch("path %s can't be removed", op.Path)
+		}
+		if _, err := read(field, op.Path); err != nil {
+			return err
+		}
+		delete(doc, field)
+	case "test":
+		v, err := read(field, op.Path)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(v, value) {
+			return conflictingPatch("test failed at %s", op.Path)
+		}
+	case "move", "copy":
+		from, _ := patchField(op.From)
+		v, err := read(from, op.From)
+		if err != nil {
+			return err
+		}
+		if op.Op == "move" {
+			delete(doc, from)
+		}
+		doc[field] = v
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang/activity"
+	"golang/events"
+	"golang/history"
+	"golang/stats"
+	"golang/store"
+	"golang/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// privacy serves data subject requests: the export and erasure of
+// everything stored about a user. Only the user, through their session,
+// and admins, with the admin token, may make them.
+type privacy struct {
+	users      store.UserRepository
+	bucket     *gridfs.Bucket // nil unless files are enabled
+	log        *activity.Log  // nil unless activity is recorded
+	history    *history.Store // nil unless history is recorded
+	sessions   *sessionStore  // may be nil
+	adminToken string
+
+	// Erasure goes around the decorators of Registrar.Users, so it
+	// publishes and counts the removal itself
+	outbox   *events.Outbox       // nil unless events are published
+	webhooks *webhooks.Dispatcher // nil unless webhooks are enabled
+	stats    *stats.Store         // nil unless statistics are kept
+}
+
+func newPrivacy(rc *Registrar) *privacy {
+	p := &privacy{
+		users:      rc.users,
+		log:        rc.Options.Activity,
+		history:    rc.Options.History,
+		sessions:   rc.sessions,
+		adminToken: rc.Options.AdminToken,
+		outbox:     rc.Options.Outbox,
+		webhooks:   rc.Options.Webhooks,
+		stats:      rc.Options.Stats,
+	}
+	if rc.Mongo != nil && rc.Options.Files != nil {
+		bucket, err := newBucket(rc.Mongo)
+		if err != nil {
+			slog.Error("user data exports leave out files", "error", err)
+		}
+		p.bucket = bucket
+	}
+	return p
+}
+
+// allowed reports whether the request may access the data of user id,
+// writing the error response if not.
+func (p *privacy) allowed(w http.ResponseWriter, r *http.Request, id string) bool {
+	if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && p.adminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(got), []byte(p.adminToken)) == 1 {
+		return true
+	}
+	sess := sessionFromContext(r.Context())
+	if sess == nil {
+		writeError(w, r, http.StatusUnauthorized, "authentication required")
+		return false
+	}
+	if sess.UserID.Hex() != id {
+		writeError(w, r, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// exportedActivity is an activity with the client address, which the
+// activity feed leaves out.
+type exportedActivity struct {
+	activity.Activity
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// export - GET /users/{id}/export
+// Returns everything stored about the user, soft-deleted or not, as a zip
+// archive: user.json, the profile; activity.json, the whole activity
+// history with client addresses; history.json, the changes of its
+// fields; sessions.json; files.json and the
+// contents of the files the user uploaded under files/; and a JSON file
+// per collection:field relation of USER_RELATIONS.
+func (p *privacy) export(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !p.allowed(w, r, id) {
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	u, err := p.users.Get(ctx, id)
+	if deleted, ok := p.users.(store.DeletedUsers); ok && errors.Is(err, store.ErrNotFound) {
+		if du, derr := deleted.GetDeleted(ctx, id); !errors.Is(derr, errors.ErrUnsupported) {
+			u, err = du, derr
+		}
+	}
+	if err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+
+	type part struct {
+		name string
+		v    any
+	}
+	parts := []part{{"user.json", u}}
+	if pd, ok := p.users.(store.PersonalData); ok {
+		related, err := pd.Related(ctx, id)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			userError(w, r, "find", err)
+			return
+		}
+		names := make([]string, 0, len(related))
+		for name := range related {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			parts = append(parts, part{strings.ReplaceAll(name, ":", ".") + ".json", related[name]})
+		}
+	}
+	if p.log != nil {
+		acts, err := p.log.List(ctx, id, primitive.NilObjectID, 0)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		out := make([]exportedActivity, len(acts))
+		for i, a := range acts {
+			out[i] = exportedActivity{Activity: a, ClientIP: a.ClientIP}
+		}
+		parts = append(parts, part{"activity.json", out})
+	}
+	if p.history != nil {
+		changes, err := p.history.List(ctx, id, history.Filter{})
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		parts = append(parts, part{"history.json", changes})
+	}
+
+	// Sessions and files are only kept in MongoDB, where ids are
+	// ObjectIDs
+	uid, _ := primitive.ObjectIDFromHex(id)
+	if p.sessions != nil && !uid.IsZero() {
+		cur, err := p.sessions.coll().Find(ctx, bson.M{"user_id": uid}, options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).SetComment(opComment(r)))
+		if err != nil {
+			p.sessions.dbError(w, r, "find", err)
+			return
+		}
+		out := []Session{}
+		if err := cur.All(ctx, &out); err != nil {
+			p.sessions.dbError(w, r, "find", err)
+			return
+		}
+		parts = append(parts, part{"sessions.json", out})
+	}
+	var files []fileDoc
+	if p.bucket != nil && !uid.IsZero() {
+		cur, err := p.bucket.FindContext(ctx, bson.M{"metadata.user_id": uid}, options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}))
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		if err := cur.All(ctx, &files); err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		infos := make([]FileInfo, len(files))
+		for i, d := range files {
+			infos[i] = d.info()
+		}
+		parts = append(parts, part{"files.json", infos})
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "user-" + id + ".zip"}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// The status is sent, so a failure can only cut the archive short;
+	// aborting makes the client see the download fail rather than get an
+	// archive that looks complete
+	abort := func(err error) {
+		slog.ErrorContext(ctx, "user data export failed", "user_id", id, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	for _, pt := range parts {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: pt.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			abort(err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(pt.v); err != nil {
+			abort(err)
+		}
+	}
+	deadline, _ := deadline(r)
+	for _, d := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: "files/" + d.ID.Hex() + "-" + path.Base(d.Filename), Method: zip.Deflate, Modified: d.UploadDate})
+		if err != nil {
+			abort(err)
+		}
+		rs := &gridfsReader{bucket: p.bucket, id: d.ID, size: d.Length, deadline: deadline}
+		_, err = io.Copy(f, rs)
+		rs.Close()
+		if err != nil {
+			abort(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		abort(err)
+	}
+}
+
+// erase - DELETE /users/{id}/data
+// Erases the user: removes it for good, soft-deleted or not, with its
+// sessions, files, pending email verifications, what USER_RELATIONS
+// cascades to and the history of its fields, and anonymizes its activity
+// history. Restrict relations refuse with 409 as DELETE /users/{id} does.
+// Repeating the request finishes an erasure that failed part way. Like
+// DELETE /users/{id}, it publishes user.deleted and updates the
+// statistics.
+func (p *privacy) erase(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodDelete {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !p.allowed(w, r, id) {
+		return
+	}
+	sess := sessionFromContext(r.Context())
+	self := sess != nil && sess.UserID.Hex() == id
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	live := false
+	if p.stats != nil {
+		_, gerr := p.users.Get(ctx, id)
+		live = gerr == nil
+	}
+	var err error
+	if p.outbox != nil {
+		// The event is stored if and only if the user is removed, as for
+		// DELETE /users/{id}
+		err = p.outbox.Transaction(ctx, func(ctx context.Context) error {
+			if err := p.remove(ctx, id); err != nil {
+				return err
+			}
+			return p.outbox.Add(ctx, events.UserDeleted, &store.User{ID: id})
+		})
+	} else {
+		err = p.remove(ctx, id)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		userError(w, r, "delete", err)
+		return
+	}
+	if err == nil {
+		if p.webhooks != nil {
+			if werr := p.webhooks.Publish(ctx, webhooks.UserDeleted, map[string]string{"id": id}); werr != nil {
+				slog.ErrorContext(ctx, "failed to publish webhook event", "event", webhooks.UserDeleted, "error", werr)
+			}
+		}
+		if p.stats != nil {
+			if serr := p.stats.Erased(ctx, live); serr != nil {
+				slog.ErrorContext(ctx, "failed to update user stats", "user_id", id, "error", serr)
+			}
+		}
+	}
+
+	// Activities outlive their user, so a user already erased may still
+	// have some left
+	var anonymized int64
+	if p.log != nil {
+		n, aerr := p.log.Anonymize(ctx, id)
+		if aerr != nil {
+			dbError(w, r, "update", aerr)
+			return
+		}
+		anonymized = n
+	}
+	var changes int64
+	if p.history != nil {
+		n, herr := p.history.Erase(ctx, id)
+		if herr != nil {
+			dbError(w, r, "delete", herr)
+			return
+		}
+		changes = n
+	}
+	if errors.Is(err, store.ErrNotFound) && anonymized == 0 && changes == 0 {
+		userError(w, r, "delete", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "user data erased", "user_id", id, "activities_anonymized", anonymized, "history_erased", changes)
+	if self {
+		p.sessions.clearCookie(w)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"erased": id, "activities_anonymized": anonymized, "history_erased": changes})
+}
+
+// remove removes user id for good, with what refers to it when the
+// repository keeps track of that.
+func (p *privacy) remove(ctx context.Context, id string) error {
+	err := errors.ErrUnsupported
+	if pd, ok := p.users.(store.PersonalData); ok {
+		err = pd.Erase(ctx, id)
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	// Nothing but the user itself is stored about it
+	if deleted, ok := p.users.(store.DeletedUsers); ok {
+		return deleted.Purge(ctx, id)
+	}
+	return p.users.Delete(ctx, id)
+}
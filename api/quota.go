@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang/quota"
+	"golang/tenant"
+)
+
+// quotaError answers a create refused by a quota: 402 for the user count,
+// which a bigger plan raises, 413 for the storage. The quota field names
+// the scope, "_global" or a tenant id, and the resource with its limit
+// and usage.
+func quotaError(w http.ResponseWriter, r *http.Request, err error) {
+	var qe *quota.ExceededError
+	if !errors.As(err, &qe) {
+		dbError(w, r, "insert", err)
+		return
+	}
+	status, code, msg := quotaStatus(qe)
+	body := errorBody(w, r, code, msg)
+	body["quota"] = map[string]any{
+		"scope":    qe.Scope,
+		"resource": qe.Resource,
+		"limit":    qe.Limit,
+		"used":     qe.Used,
+	}
+	writeJSON(w, status, body)
+}
+
+// quotaStatus returns the status, code and message of a quota error.
+func quotaStatus(qe *quota.ExceededError) (int, string, string) {
+	if qe.Resource == quota.ResourceBytes {
+		return http.StatusRequestEntityTooLarge, CodeStorageQuotaExceeded, "storage quota exceeded"
+	}
+	return http.StatusPaymentRequired, CodeUserQuotaExceeded, "user quota exceeded"
+}
+
+// quotaImportError is the problem of an imported row refused by a quota.
+func quotaImportError(w http.ResponseWriter, r *http.Request, err error) importError {
+	var qe *quota.ExceededError
+	errors.As(err, &qe)
+	_, code, msg := quotaStatus(qe)
+	return importError{Code: code, Error: localize(w, r, msg)}
+}
+
+// quotasHandler - GET /admin/quotas
+// Lists the global quota and the tenants' own quotas, without usage.
+func quotasHandler(q *quota.Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+	out, err := q.List(ctx)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// quotaHandler - GET, PUT, DELETE /admin/quotas/{scope}
+// The scope is "_global" or a tenant id. GET returns its quota with the
+// usage, PUT sets its limits from {max_users, max_bytes}, 0 for
+// unlimited, and DELETE returns it to the configured defaults.
+func quotaHandler(q *quota.Store, w http.ResponseWriter, r *http.Request) {
+	setRouteName(r, "/admin/quotas/{scope}")
+	scope := strings.TrimPrefix(r.URL.Path, "/admin/quotas/")
+	if scope != quota.Global && !tenant.Valid(scope) {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		st, err := q.Get(ctx, scope)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+
+	case http.MethodPut:
+		var in quota.Limits
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if in.MaxUsers < 0 || in.MaxBytes < 0 {
+			writeError(w, r, http.StatusBadRequest, "limits must not be negative")
+			return
+		}
+		if err := q.Set(ctx, scope, in); err != nil {
+			dbError(w, r, "update", err)
+			return
+		}
+		st, err := q.Get(ctx, scope)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+
+	case http.MethodDelete:
+		if err := q.Reset(ctx, scope); err != nil {
+			dbError(w, r, "delete", err)
+			return
+		}
+		st, err := q.Get(ctx, scope)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
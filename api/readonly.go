@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readOnly is the admin-togglable read-only mode, for incidents and
+// database maintenance windows. While enabled, mutating requests are
+// rejected with 503 and code READ_ONLY, without a Retry-After as it lasts
+// until switched off, and reads keep working.
+type readOnly struct {
+	mu      sync.RWMutex
+	enabled bool
+	since   time.Time
+	message string
+}
+
+type readOnlyState struct {
+	Enabled bool   `json:"enabled"`
+	Since   string `json:"since,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (ro *readOnly) set(enabled bool, message string) {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	if enabled && !ro.enabled {
+		ro.since = time.Now().UTC()
+	}
+	ro.enabled = enabled
+	ro.message = message
+}
+
+func (ro *readOnly) state() readOnlyState {
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+	st := readOnlyState{Enabled: ro.enabled}
+	if ro.enabled {
+		st.Since = ro.since.Format(time.RFC3339)
+		st.Message = ro.message
+	}
+	return st
+}
+
+// middleware rejects mutating requests in read-only mode. Admin routes stay
+// writable so the mode can be switched off again.
+func (ro *readOnly) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutating(r.Method) && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			st := ro.state()
+			if st.Enabled {
+				message := st.Message
+				if message == "" {
+					message = "service is read-only; writes are disabled"
+				}
+				body := errorBody(w, r, CodeReadOnly, message)
+				body["read_only_since"] = st.Since
+				writeJSON(w, http.StatusServiceUnavailable, body)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handle - GET/PUT /admin/read-only
+func (ro *readOnly) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, ro.state())
+	case http.MethodPut, http.MethodPost:
+		var in struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		ro.set(in.Enabled, in.Message)
+		writeJSON(w, http.StatusOK, ro.state())
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverMiddleware turns a handler panic into a logged stack trace and a
+// 500 JSON error instead of a dropped connection.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate abort; let net/http handle it quietly
+				panic(p)
+			}
+
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(p),
+				"stack", string(debug.Stack()),
+			)
+			if rec.status == 0 {
+				writeError(rec, r, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"golang/db"
+	"golang/store"
+	"golang/validation"
+)
+
+// Resource is a group of routes added to the API. Packages, such as those
+// of downstream forks, add their own with Register instead of editing
+// NewRouter; the built-in routes are resources too.
+type Resource interface {
+	// Routes registers the handlers of the resource with rc.
+	Routes(rc *Registrar)
+}
+
+// ResourceFunc adapts a function to Resource.
+type ResourceFunc func(rc *Registrar)
+
+func (f ResourceFunc) Routes(rc *Registrar) { f(rc) }
+
+// Middleware is a stage of the handler chain around the routes.
+type Middleware struct {
+	// Name identifies the stage in Router.Middleware.
+	Name string
+	// Order places the stage in the chain: lower is further out and sees
+	// requests first. The built-in stages use the Order constants, spaced
+	// so others fit between them; stages of the same order keep the order
+	// they were registered in.
+	Order int
+	// Wrap returns next wrapped by the stage.
+	Wrap func(rc *Registrar, next http.Handler) http.Handler
+}
+
+// Orders of the built-in middleware, outermost first. Stages inside
+// OrderClientIP see the client address (see ClientIP), those inside
+// OrderRequestID the request id, those inside OrderTenants the tenant,
+// those inside OrderSessions the session, and those inside OrderActor the
+// actor of history.ActorFromContext.
+const (
+	OrderTracing       = 100
+	OrderCompression   = 200
+	OrderClientIP      = 250
+	OrderRequestID     = 300
+	OrderMetrics       = 400
+	OrderInflight      = 500
+	OrderAccessLog     = 600
+	OrderKeyCase       = 650
+	OrderContract      = 700
+	OrderRecover       = 800
+	OrderTimeout       = 900
+	OrderMaintenance   = 1000
+	OrderReadOnly      = 1050
+	OrderConcurrency   = 1075
+	OrderBreaker       = 1100
+	OrderCache         = 1200
+	OrderDeprecations  = 1300
+	OrderTenants       = 1400
+	OrderUsage         = 1450
+	OrderCapture       = 1460
+	OrderSessions      = 1500
+	OrderCSRF          = 1600
+	OrderVerification  = 1700
+	OrderActor         = 1750
+	OrderAuthz         = 1775
+	OrderDegradedReads = 1780
+	OrderResponseCache = 1790
+	OrderMasking       = 1800
+)
+
+// registry holds what Register and RegisterMiddleware add, for the
+// routers created after.
+var registry struct {
+	sync.Mutex
+	resources  []Resource
+	middleware []Middleware
+}
+
+// Register adds a resource to the routers NewRouter creates from then on,
+// typically from the init function of the package defining it. Its routes
+// are registered after the built-in ones; like http.ServeMux, NewRouter
+// panics when a pattern is registered twice.
+func Register(r Resource) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.resources = append(registry.resources, r)
+}
+
+// RegisterMiddleware adds a stage to the handler chain of the routers
+// NewRouter creates from then on.
+func RegisterMiddleware(m Middleware) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.middleware = append(registry.middleware, m)
+}
+
+func registered() ([]Resource, []Middleware) {
+	registry.Lock()
+	defer registry.Unlock()
+	return append([]Resource(nil), registry.resources...), append([]Middleware(nil), registry.middleware...)
+}
+
+// Registrar registers the routes of resources, and holds the dependencies
+// they share with the built-in routes.
+type Registrar struct {
+	// Mongo is nil when users are stored in another backend.
+	Mongo *db.MongoClient
+	// Users stores users. Writes through it are published, recorded and
+	// notified like those of the built-in routes.
+	Users store.UserRepository
+	// Rules check the fields of users written through Users, as the
+	// built-in routes do.
+	Rules *validation.Validator
+	// Options are those of the router.
+	Options Options
+
+	mux *http.ServeMux
+	rt  *Router
+	// users is Users without the side effects of writes, and implements
+	// the optional interfaces of its backend such as store.UserCounter.
+	users    store.UserRepository
+	tenants  *tenantResolver
+	sessions *sessionStore
+	verify   *verifier
+	tracker  *deprecationTracker
+	ready    *readiness
+	degraded *degraded
+}
+
+// Handle registers h for pattern as http.ServeMux does. Its route name,
+// in metrics and logs, is the pattern.
+func (rc *Registrar) Handle(pattern string, h http.Handler) {
+	rc.mux.Handle(pattern, h)
+}
+
+// HandleFunc registers f for pattern as http.ServeMux does.
+func (rc *Registrar) HandleFunc(pattern string, f http.HandlerFunc) {
+	rc.mux.Handle(pattern, f)
+}
+
+// Admin registers f for pattern behind the admin token, like the /admin
+// endpoints.
+func (rc *Registrar) Admin(pattern string, f http.HandlerFunc) {
+	rc.mux.Handle(pattern, adminOnly(rc.Options.AdminToken, f))
+}
+
+// chain wraps h in stages, sorted by order, and returns it with the names
+// of the stages outermost first.
+func (rc *Registrar) chain(h http.Handler, stages []Middleware) (http.Handler, []string) {
+	sort.SliceStable(stages, func(i, j int) bool { return stages[i].Order < stages[j].Order })
+	names := make([]string, len(stages))
+	for i := len(stages) - 1; i >= 0; i-- {
+		h = stages[i].Wrap(rc, h)
+		names[i] = stages[i].Name
+	}
+	return h, names
+}
+
+// WriteJSON writes v as a JSON response with status.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	writeJSON(w, status, v)
+}
+
+// WriteError writes an error response like those of the built-in routes:
+// msg in the language of the request if the catalogs have it, the code of
+// status and the request id.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeError(w, r, status, msg)
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"golang/requestid"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDMiddleware accepts a well-formed X-Request-ID from the client or
+// generates one, stores it in the request context and echoes it back.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
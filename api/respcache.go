@@ -0,0 +1,368 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// ResponseCacheOptions caches the responses of read routes, so spikes of
+// identical reads are served without reaching storage.
+type ResponseCacheOptions struct {
+	// Routes are the cached routes, such as /users or /users/{id}, whose
+	// {name} segments match any one segment, or path prefixes ending in
+	// /*. Only successful GET requests without credentials or
+	// conditional headers are cached; responses are held back whole, so
+	// streaming routes such as exports don't belong here.
+	Routes []string
+	// TTL is how long a response is served as is.
+	TTL time.Duration
+	// Stale is how long after TTL a response is still served while one
+	// request refreshes it in the background.
+	Stale time.Duration
+	// MaxEntries caps the responses held in memory; unused with Redis.
+	MaxEntries int
+	// Redis, when set, holds the responses instead of memory, shared by
+	// all instances.
+	Redis *redis.Client
+}
+
+// Responses larger than maxCachedResponse are not cached.
+const maxCachedResponse = 1 << 20
+
+// responseRefreshTimeout bounds a background refresh, which outlives the
+// request that started it and so its deadline.
+const responseRefreshTimeout = 30 * time.Second
+
+var responseCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_response_cache_requests_total",
+	Help: "Requests to response-cached routes by result (hit, stale, miss or error).",
+}, []string{"result"})
+
+// cachedResponse is a response held by the cache.
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+// responseStore holds cached responses. Keys include a generation per
+// tenant that writes bump, so a write makes the earlier responses of its
+// tenant unreachable; they expire by TTL.
+type responseStore interface {
+	generation(ctx context.Context, tenant string) (int64, error)
+	bump(ctx context.Context, tenant string) error
+	get(ctx context.Context, key string) (*cachedResponse, error)
+	set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error
+}
+
+// memoryResponses is a responseStore in process memory.
+type memoryResponses struct {
+	mu      sync.Mutex
+	size    int
+	gens    map[string]int64
+	entries map[string]memoryResponse
+}
+
+type memoryResponse struct {
+	resp    *cachedResponse
+	expires time.Time
+}
+
+func newMemoryResponses(size int) *memoryResponses {
+	if size <= 0 {
+		size = 1000
+	}
+	return &memoryResponses{size: size, gens: make(map[string]int64), entries: make(map[string]memoryResponse)}
+}
+
+func (m *memoryResponses) generation(_ context.Context, tenant string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gens[tenant], nil
+}
+
+func (m *memoryResponses) bump(_ context.Context, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gens[tenant]++
+	return nil
+}
+
+func (m *memoryResponses) get(_ context.Context, key string) (*cachedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, nil
+	}
+	return e.resp, nil
+}
+
+func (m *memoryResponses) set(_ context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.size {
+		m.evict(now)
+	}
+	m.entries[key] = memoryResponse{resp: resp, expires: now.Add(ttl)}
+	return nil
+}
+
+// evict drops the expired entries, or when none are, an arbitrary half.
+func (m *memoryResponses) evict(now time.Time) {
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	for k := range m.entries {
+		if len(m.entries) < m.size/2+1 {
+			break
+		}
+		delete(m.entries, k)
+	}
+}
+
+const (
+	respCachePrefix = "responses:v1:"
+	respCacheGen    = "responses:v1:gen:"
+)
+
+// redisResponses is a responseStore in Redis.
+type redisResponses struct {
+	rdb *redis.Client
+}
+
+func (s redisResponses) generation(ctx context.Context, tenant string) (int64, error) {
+	gen, err := s.rdb.Get(ctx, respCacheGen+tenant).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return gen, err
+}
+
+func (s redisResponses) bump(ctx context.Context, tenant string) error {
+	return s.rdb.Incr(ctx, respCacheGen+tenant).Err()
+}
+
+func (s redisResponses) get(ctx context.Context, key string) (*cachedResponse, error) {
+	b, err := s.rdb.Get(ctx, respCachePrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp cachedResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (s redisResponses) set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, respCachePrefix+key, b, ttl).Err()
+}
+
+// responseCache is the response cache middleware.
+type responseCache struct {
+	opts       ResponseCacheOptions
+	routes     []routePattern
+	store      responseStore
+	refreshing sync.Map // keys being refreshed
+}
+
+func newResponseCache(opts ResponseCacheOptions) *responseCache {
+	c := &responseCache{opts: opts}
+	for _, name := range opts.Routes {
+		c.routes = append(c.routes, newRoutePattern(name))
+	}
+	sort.Slice(c.routes, func(i, j int) bool { return c.routes[i].moreSpecific(c.routes[j]) })
+	if opts.Redis != nil {
+		c.store = redisResponses{rdb: opts.Redis}
+	} else {
+		c.store = newMemoryResponses(opts.MaxEntries)
+	}
+	return c
+}
+
+// route returns the cached route r is a request to, if any.
+func (c *responseCache) route(r *http.Request) (routePattern, bool) {
+	if r.Method != http.MethodGet {
+		return routePattern{}, false
+	}
+	for _, h := range []string{"Authorization", "Cookie", "If-None-Match", "If-Modified-Since"} {
+		if r.Header.Get(h) != "" {
+			return routePattern{}, false
+		}
+	}
+	for _, p := range c.routes {
+		if p.matches(r.URL.Path) {
+			return p, true
+		}
+	}
+	return routePattern{}, false
+}
+
+// key returns the cache key of r: its path and query with the parameters
+// sorted, the headers responses vary by, and the generation of its tenant.
+func (c *responseCache) key(r *http.Request, route routePattern) (string, error) {
+	t := tenant.FromContext(r.Context())
+	gen, err := c.store.generation(r.Context(), t)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		route.name, r.URL.Path, r.URL.Query().Encode(),
+		r.Header.Get("Accept"), r.Header.Get("Accept-Language"),
+	}, "\n")))
+	return t + ":" + strconv.FormatInt(gen, 10) + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// middleware serves cached responses to reads of the configured routes
+// and invalidates those of the tenant on successful writes. A response
+// past TTL but within Stale is served while one request refreshes it in
+// the background. Cache failures are logged and fall through to the
+// handler.
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutating(r.Method) {
+			sr := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(sr, r)
+			if sr.Status() < 400 {
+				if err := c.store.bump(r.Context(), tenant.FromContext(r.Context())); err != nil {
+					slog.WarnContext(r.Context(), "response cache not invalidated", "error", err)
+				}
+			}
+			return
+		}
+		route, ok := c.route(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := c.key(r, route)
+		if err == nil {
+			var resp *cachedResponse
+			if resp, err = c.store.get(r.Context(), key); err == nil && resp != nil {
+				age := time.Since(resp.Stored)
+				if age < c.opts.TTL {
+					responseCacheRequests.WithLabelValues("hit").Inc()
+					writeCached(w, resp, "HIT", age)
+					return
+				}
+				if age < c.opts.TTL+c.opts.Stale {
+					responseCacheRequests.WithLabelValues("stale").Inc()
+					c.refresh(r, next, key)
+					writeCached(w, resp, "STALE", age)
+					return
+				}
+			}
+		}
+		if err != nil {
+			responseCacheRequests.WithLabelValues("error").Inc()
+			slog.WarnContext(r.Context(), "response cache unavailable", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		responseCacheRequests.WithLabelValues("miss").Inc()
+		resp := c.fill(r.Context(), r, next, key)
+		writeCached(w, resp, "MISS", 0)
+	})
+}
+
+// fill serves r and caches the response if it can be.
+func (c *responseCache) fill(ctx context.Context, r *http.Request, next http.Handler, key string) *cachedResponse {
+	rb := &responseBuffer{header: make(http.Header)}
+	next.ServeHTTP(rb, r)
+	resp := &cachedResponse{Status: rb.Status(), Header: rb.header, Body: rb.body.Bytes(), Stored: time.Now()}
+	// Stale responses, such as degraded reads, are not fresh enough to cache
+	if resp.Status == http.StatusOK && len(resp.Body) <= maxCachedResponse && resp.Header.Get("Set-Cookie") == "" && resp.Header.Get("Warning") == "" {
+		if err := c.store.set(ctx, key, resp, c.opts.TTL+c.opts.Stale); err != nil {
+			slog.WarnContext(ctx, "response not cached", "error", err)
+		}
+	}
+	return resp
+}
+
+// refresh serves a copy of r in the background to replace the cached
+// response under key, unless a refresh of key is under way.
+func (c *responseCache) refresh(r *http.Request, next http.Handler, key string) {
+	if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), responseRefreshTimeout)
+	// the handler refines the route name of the copy, not of r
+	ctx = context.WithValue(ctx, routeKey{}, &routeInfo{name: routeName(r)})
+	r = r.Clone(ctx)
+	go func() {
+		defer c.refreshing.Delete(key)
+		defer cancel()
+		c.fill(ctx, r, next, key)
+	}()
+}
+
+// writeCached writes resp with the X-Cache result and its Age.
+func writeCached(w http.ResponseWriter, resp *cachedResponse, result string, age time.Duration) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = v
+	}
+	h.Set("X-Cache", result)
+	if result != "MISS" {
+		h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// responseBuffer holds a response back whole to cache it.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rb *responseBuffer) Header() http.Header { return rb.header }
+
+func (rb *responseBuffer) WriteHeader(code int) {
+	if rb.status == 0 && code >= 200 {
+		rb.status = code
+	}
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	if rb.status == 0 {
+		rb.status = http.StatusOK
+	}
+	return rb.body.Write(b)
+}
+
+func (rb *responseBuffer) Status() int {
+	if rb.status == 0 {
+		return http.StatusOK
+	}
+	return rb.status
+}
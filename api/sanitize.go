@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userWritableFields is the allowlist of top-level user fields clients may
+// set through the API.
+var userWritableFields = map[string]bool{
+	"name":     true,
+	"email":    true,
+	"age":      true,
+	"password": true,
+}
+
+// sanitizeDocument validates client supplied data before it is used in a
+// Mongo query or update. It rejects operator ($-prefixed) and dotted keys,
+// so a body cannot smuggle in operators like $rename or reach into nested
+// paths, top-level fields outside the allowlist, and objects and arrays as
+// values, as in {"name": {"$gt": ""}}: the allowed fields are all scalars.
+func sanitizeDocument(in map[string]any, allowed map[string]bool) (bson.M, error) {
+	out := bson.M{}
+	for k, v := range in {
+		if err := checkKey(k); err != nil {
+			return nil, err
+		}
+		if !allowed[k] {
+			return nil, fmt.Errorf("field %q is not allowed", k)
+		}
+		switch v.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("field %q must be a string, number, boolean or null", k)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func checkKey(k string) error {
+	if k == "" {
+		return fmt.Errorf("empty field name")
+	}
+	if strings.HasPrefix(k, "$") {
+		return fmt.Errorf("field %q: operators are not allowed", k)
+	}
+	if strings.Contains(k, ".") {
+		return fmt.Errorf("field %q: dotted paths are not allowed", k)
+	}
+	return nil
+}
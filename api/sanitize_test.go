@@ -0,0 +1,30 @@
+package api
+
+import "testing"
+
+func TestSanitizeDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]any
+		ok   bool
+	}{
+		{"scalars", map[string]any{"name": "Ada", "age": 36.0, "email": nil}, true},
+		{"operator key", map[string]any{"$set": map[string]any{"name": "Ada"}}, false},
+		{"dotted key", map[string]any{"name.first": "Ada"}, false},
+		{"field outside the allowlist", map[string]any{"role": "admin"}, false},
+		{"operator object", map[string]any{"name": map[string]any{"$gt": ""}}, false},
+		{"plain object", map[string]any{"name": map[string]any{"first": "Ada"}}, false},
+		{"array", map[string]any{"email": []any{"a@example.com"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := sanitizeDocument(tt.in, userWritableFields)
+			if (err == nil) != tt.ok {
+				t.Fatalf("sanitizeDocument(%v) error = %v, want ok %v", tt.in, err, tt.ok)
+			}
+			if tt.ok && len(out) != len(tt.in) {
+				t.Errorf("sanitizeDocument(%v) = %v, want every field kept", tt.in, out)
+			}
+		})
+	}
+}
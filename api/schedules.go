@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang/scheduler"
+)
+
+// schedulesHandler - GET /admin/schedules
+// Lists the scheduled tasks with their schedule, next and last run.
+func schedulesHandler(s *scheduler.Scheduler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+	out, err := s.Tasks(ctx)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// scheduleRun - POST /admin/schedules/{name}/run
+// Makes the task due now; an instance picks it up within its poll interval
+// unless it is running already.
+func scheduleRun(s *scheduler.Scheduler, w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/schedules/"), "/run")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	setRouteName(r, "/admin/schedules/{name}/run")
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+	if err := s.Trigger(ctx, name); err != nil {
+		if errors.Is(err, scheduler.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		dbError(w, r, "update", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"name": name})
+}
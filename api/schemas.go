@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang/schemas"
+)
+
+// schemaList - GET /schemas
+// Lists the event schemas with where each version is served.
+func schemaList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	type entry struct {
+		schemas.Ref
+		Path string `json:"path"`
+	}
+	out := []entry{}
+	for _, ref := range schemas.List() {
+		out = append(out, entry{Ref: ref, Path: ref.Path()})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schemas": out})
+}
+
+// schemaGet - GET /schemas/{event}/{version}
+// Serves the JSON Schema of a version of the payloads of an event.
+func schemaGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name, v, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/schemas/"), "/")
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	setRouteName(r, "/schemas/{event}/{version}")
+	b, ok := schemas.Get(name, version)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	// A version never changes once published
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(b)
+}
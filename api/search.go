@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang/store"
+)
+
+// Result counts of GET /users/search and GET /users/suggest.
+const (
+	defaultSearchLimit  = 20
+	maxSearchLimit      = 100
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 25
+)
+
+// maxSuggestPrefix caps the length of what suggestions are asked for.
+const maxSuggestPrefix = 100
+
+// searchUsers - GET /users/search
+// Finds users whose name or email resemble q, tolerating typos, best
+// matches first. limit defaults to 20 and is at most 100. Only the fields
+// the caller sees are matched, as by suggestUsers.
+func searchUsers(users store.UserSearcher, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit, ok := resultLimit(w, r, defaultSearchLimit, maxSearchLimit)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	out, err := users.Search(ctx, q, matchFields(r), limit)
+	if errors.Is(err, errors.ErrUnsupported) {
+		writeError(w, r, http.StatusNotImplemented, "storage backend does not support search")
+		return
+	}
+	if err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, maskUsers(r, out))
+}
+
+// matchFields returns those of name and email the caller of r sees, which
+// searches and suggestions may match.
+func matchFields(r *http.Request) []string {
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+	var fields []string
+	for _, f := range []string{"name", "email"} {
+		if !hidden[f] {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// resultLimit reads the limit query parameter of r: def when absent, and
+// capped at most. It writes the error and returns false when invalid.
+func resultLimit(w http.ResponseWriter, r *http.Request, def, most int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid limit")
+		return 0, false
+	}
+	return min(n, most), true
+}
+
+// suggestion is a user as GET /users/suggest returns it.
+type suggestion struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// suggestUsers - GET /users/suggest
+// Lists users whose name or email starts with q, ignoring case, by name,
+// for typeahead. limit defaults to 10 and is at most 25. Only the fields
+// the caller sees are matched, so masked emails don't leak.
+func suggestUsers(users store.UserSuggester, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+	if len(q) > maxSuggestPrefix {
+		writeError(w, r, http.StatusBadRequest, "q is too long")
+		return
+	}
+	limit, ok := resultLimit(w, r, defaultSuggestLimit, maxSuggestLimit)
+	if !ok {
+		return
+	}
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	found, err := users.Suggest(ctx, q, matchFields(r), limit)
+	if errors.Is(err, errors.ErrUnsupported) {
+		writeError(w, r, http.StatusNotImplemented, "storage backend does not support suggestions")
+		return
+	}
+	if err != nil {
+		userError(w, r, "find", err)
+		return
+	}
+	out := make([]suggestion, len(found))
+	for i, u := range found {
+		out[i] = suggestion{ID: u.ID}
+		if !hidden["name"] {
+			out[i].Name = u.Name
+		}
+		if !hidden["email"] {
+			out[i].Email = u.Email
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
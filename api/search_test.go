@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang/store"
+)
+
+// maskedRouter returns a router with field masking on, over users Ada
+// Lovelace, whose email viewers don't see, and Grace Hopper.
+func maskedRouter(t *testing.T) http.Handler {
+	t.Helper()
+	users := store.NewMemoryUsers()
+	for _, u := range []store.User{
+		{Name: "Ada Lovelace", Email: "countess@example.com", Age: 36},
+		{Name: "Grace Hopper", Email: "grace@example.com", Age: 85},
+	} {
+		if err := users.Create(context.Background(), &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewRouter(nil, Options{Users: users, AdminToken: "secret", Masking: &MaskingOptions{}})
+}
+
+func TestHiddenFieldsNotFiltered(t *testing.T) {
+	router := maskedRouter(t)
+	tests := []struct {
+		query  url.Values
+		viewer int
+	}{
+		{url.Values{"email": {"countess@example.com"}}, http.StatusBadRequest},
+		{url.Values{"filter": {`email~"countess"`}}, http.StatusBadRequest},
+		{url.Values{"filter": {`age>=18 and not email="x"`}}, http.StatusBadRequest},
+		{url.Values{"name": {"Ada Lovelace"}}, http.StatusOK},
+		{url.Values{"filter": {`name~"ada"`}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, admin := range []bool{false, true} {
+			req := httptest.NewRequest(http.MethodGet, "/users?"+tt.query.Encode(), nil)
+			want := tt.viewer
+			if admin {
+				req.Header.Set("Authorization", "Bearer secret")
+				want = http.StatusOK
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Errorf("GET /users?%s as admin %v = %d, want %d: %s", tt.query.Encode(), admin, rec.Code, want, rec.Body)
+			}
+		}
+	}
+}
+
+func TestHiddenFieldsNotSearched(t *testing.T) {
+	router := maskedRouter(t)
+	search := func(path string, admin bool) []map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+		}
+		var out []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	for _, path := range []string{"/users/search?q=countess", "/users/suggest?q=countess"} {
+		if got := search(path, false); len(got) != 0 {
+			t.Errorf("GET %s as viewer matched the hidden email: %v", path, got)
+		}
+		if got := search(path, true); len(got) != 1 {
+			t.Errorf("GET %s as admin = %v, want Ada", path, got)
+		}
+	}
+	if got := search("/users/search?q=lovelace", false); len(got) != 1 || got[0]["email"] != nil {
+		t.Errorf("GET /users/search?q=lovelace as viewer = %v, want Ada without email", got)
+	}
+}
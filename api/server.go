@@ -3,57 +3,693 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"golang/activity"
+	"golang/capture"
+	"golang/clientip"
 	"golang/db"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"golang/email"
+	"golang/events"
+	"golang/history"
+	"golang/jobs"
+	"golang/metering"
+	"golang/query"
+	"golang/quota"
+	"golang/scheduler"
+	"golang/selfcheck"
+	"golang/stats"
+	"golang/store"
+	"golang/validation"
+	"golang/webhooks"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// User represents a user stored in MongoDB
-type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string             `bson:"name,omitempty" json:"name,omitempty"`
-	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
-	Age       int                `bson:"age,omitempty" json:"age,omitempty"`
-	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+// Options configures optional router features.
+type Options struct {
+	// Sessions enables cookie session authentication when non-nil.
+	Sessions *SessionOptions
+
+	// RequestTimeout is the deadline of a request, after which it fails
+	// with 504; defaults to 10s.
+	RequestTimeout time.Duration
+	// RouteTimeouts overrides RequestTimeout per route name, e.g. "/users/{id}".
+	RouteTimeouts map[string]time.Duration
+
+	// CacheControl is the Cache-Control of successful reads per route
+	// name, e.g. "public, max-age=60" for "/users". Requests with
+	// credentials get "private, no-store" once any route has a policy.
+	CacheControl map[string]string
+
+	// CanaryWeights is the percentage of the requests to each canary
+	// route, registered with Registrar.Canary, that its canary serves.
+	CanaryWeights map[string]int
+
+	// AccessLogSample is the percentage of the requests answered below
+	// 400 that are access logged; 0 logs them all.
+	AccessLogSample int
+
+	// AdminToken is the bearer token for /admin endpoints, which are
+	// disabled when it is empty.
+	AdminToken string
+
+	// TrustedProxies are the reverse proxies and load balancers whose
+	// forwarding headers name the client address; none by default.
+	TrustedProxies []netip.Prefix
+
+	// AdminPaths are operational routes, among /metrics, /healthz, /readyz,
+	// /version and /admin (with everything under it), served only by the admin
+	// listener's AdminHandler; the API answers them with 404.
+	AdminPaths []string
+
+	// Users stores users; defaults to the Mongo "users" collection.
+	Users store.UserRepository
+
+	// Tenancy scopes user requests to a tenant when non-nil. Tenants holds
+	// the provisioned tenants; defaults to the Mongo "tenants" collection.
+	Tenancy *TenancyOptions
+	Tenants store.TenantRepository
+
+	// Import tunes POST /users/import.
+	Import ImportOptions
+
+	// Files enables the GridFS backed /files endpoints when non-nil.
+	Files *FileOptions
+
+	// Archiver enables /admin/archive when non-nil.
+	Archiver *db.ArchiveJob
+
+	// Webhooks publishes user events and enables /admin/webhooks when
+	// non-nil.
+	Webhooks *webhooks.Dispatcher
+
+	// Mailer sends welcome and change notification emails when non-nil.
+	Mailer *email.Mailer
+
+	// Outbox receives user events for the message broker when non-nil.
+	Outbox *events.Outbox
+
+	// Capture records a sample of the requests and their responses, and
+	// enables /admin/captures, when non-nil.
+	Capture *capture.Recorder
+
+	// Activity records user activity and enables /users/{id}/activity
+	// when non-nil.
+	Activity *activity.Log
+
+	// History records the changes of user fields and enables
+	// /users/{id}/history when non-nil.
+	History *history.Store
+
+	// Stats keeps user statistics up to date and enables /stats when
+	// non-nil.
+	Stats *stats.Store
+
+	// Verification enables email address verification at /auth/verify
+	// when non-nil. It needs Mailer and MongoDB.
+	Verification *VerificationOptions
+
+	// Jobs enables /admin/jobs when non-nil.
+	Jobs *jobs.Queue
+
+	// Scheduler enables /admin/schedules when non-nil.
+	Scheduler *scheduler.Scheduler
+
+	// Usage meters requests per tenant and API key, enforcing its quota,
+	// and enables /admin/usage when non-nil.
+	Usage *metering.Meter
+
+	// Quotas refuses creates that would exceed the user count or storage
+	// quota of the tenant or the service, and enables /admin/quotas when
+	// non-nil.
+	Quotas *quota.Store
+
+	// Docs enables Swagger UI at /docs when non-nil. The spec is always
+	// served at /openapi.yaml.
+	Docs *DocsOptions
+
+	// Compression compresses responses when non-nil.
+	Compression *CompressionOptions
+
+	// KeyCase renders the keys of JSON responses in camelCase by default
+	// or on request when non-nil.
+	KeyCase *KeyCaseOptions
+
+	// Concurrency caps the requests served at once per route group when
+	// non-nil.
+	Concurrency *ConcurrencyOptions
+
+	// ResponseCache caches the responses of read routes when non-nil.
+	ResponseCache *ResponseCacheOptions
+
+	// DegradedReads serves users from their last copy read while Mongo is
+	// down when non-nil; it needs MongoDB.
+	DegradedReads *DegradedReadOptions
+
+	// UserRules check the fields of users written through the API; nil
+	// uses the validate tags of store.User.
+	UserRules *validation.Validator
+
+	// SelfCheck is the report of the startup checks, shown in /admin/info
+	// when non-nil.
+	SelfCheck *selfcheck.Report
+
+	// Authz asks a policy engine whether to serve each request when
+	// non-nil.
+	Authz *AuthzOptions
+
+	// Masking hides user fields from callers by role when non-nil.
+	Masking *MaskingOptions
+
+	// Contract checks requests and responses against the OpenAPI spec
+	// when non-nil.
+	Contract *ContractOptions
+}
+
+// Router is the API handler. Settings that may change at runtime are
+// updated through its methods.
+type Router struct {
+	handler     http.Handler
+	timeouts    atomic.Pointer[opTimeouts]
+	cache       atomic.Pointer[cachePolicy]
+	canary      atomic.Pointer[map[string]int]
+	logSample   atomic.Int64
+	cacheVary   []string
+	maintenance maintenance
+	readOnly    readOnly
+	inflight    *inflight
+	middleware  []string
+	// debug serves pprof and expvar on the admin listener.
+	debug http.Handler
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.handler.ServeHTTP(w, r)
+}
+
+// Middleware returns the names of the stages of the handler chain,
+// outermost first.
+func (rt *Router) Middleware() []string {
+	return rt.middleware
+}
+
+// SetRequestTimeouts replaces the default and per-route request timeouts.
+func (rt *Router) SetRequestTimeouts(def time.Duration, routes map[string]time.Duration) {
+	rt.timeouts.Store(&opTimeouts{def: def, routes: routes})
+}
+
+// SetCacheControl replaces the Cache-Control policy per route.
+func (rt *Router) SetCacheControl(routes map[string]string) {
+	rt.cache.Store(newCachePolicy(routes, rt.cacheVary))
+}
+
+// SetMaintenance switches maintenance mode, in which mutating requests get
+// 503 with a Retry-After of retryAfter while reads keep working.
+func (rt *Router) SetMaintenance(enabled bool, retryAfter time.Duration, message string) {
+	rt.maintenance.set(enabled, retryAfter, message)
+}
+
+// SetReadOnly switches read-only mode, in which mutating requests get 503
+// with code READ_ONLY and message, or a default one, while reads keep
+// working.
+func (rt *Router) SetReadOnly(enabled bool, message string) {
+	rt.readOnly.set(enabled, message)
+}
+
+// WaitMutations waits until no mutating request is in flight or ctx is done
+// and returns how many are still running. Call it after http.Server.Shutdown
+// so writes are not cut off by disconnecting Mongo.
+func (rt *Router) WaitMutations(ctx context.Context) int64 {
+	return rt.inflight.waitMutations(ctx)
+}
+
+// NewRouter returns a Router with the built-in routes and those of the
+// registered resources, and the built-in and registered middleware. mc may
+// be nil when opts.Users uses another backend; sessions then stay
+// disabled.
+func NewRouter(mc *db.MongoClient, opts Options) *Router {
+	rt := &Router{inflight: newInflight(), debug: NewDebugHandler(opts.AdminToken)}
+	rt.SetRequestTimeouts(opts.RequestTimeout, opts.RouteTimeouts)
+
+	users := opts.Users
+	if users == nil {
+		users = store.NewMongoUsers(mc)
+	}
+	rules := opts.UserRules
+	if rules == nil {
+		rules, _ = validation.New(store.User{}, nil) // the tags are valid
+	}
+	rc := &Registrar{
+		Mongo:   mc,
+		Rules:   rules,
+		Options: opts,
+		mux:     http.NewServeMux(),
+		rt:      rt,
+		users:   users,
+		tracker: newDeprecationTracker(deprecations),
+		ready:   &readiness{mc: mc},
+	}
+	if opts.DegradedReads != nil {
+		if mc == nil {
+			slog.Warn("degraded reads need MongoDB and stay disabled")
+		} else {
+			rc.degraded = newDegraded(*opts.DegradedReads, rc.ready)
+		}
+	}
+
+	if opts.Tenancy != nil {
+		repo := opts.Tenants
+		if repo == nil {
+			repo = store.NewMongoTenants(mc)
+		}
+		rc.tenants = newTenantResolver(repo, *opts.Tenancy)
+		if opts.Tenancy.Mode == "header" {
+			// Shared caches must keep each tenant's responses apart
+			rt.cacheVary = append(rt.cacheVary, rc.tenants.opts.Header)
+		}
+	}
+	rt.SetCacheControl(opts.CacheControl)
+	rt.SetCanaryWeights(opts.CanaryWeights)
+	rt.SetAccessLogSample(opts.AccessLogSample)
+
+	if opts.Sessions != nil && mc == nil {
+		slog.Warn("sessions need MongoDB and stay disabled")
+	} else if opts.Sessions != nil {
+		rc.sessions = newSessionStore(mc, users, *opts.Sessions)
+		rc.sessions.activity = opts.Activity
+	}
+
+	// Writes through the API are published to the message broker and
+	// webhook subscribers and notify the users by email. The outbox goes
+	// first: it shares the transaction of the write.
+	crud := users
+	if opts.Outbox != nil {
+		crud = events.NewUsers(crud, opts.Outbox)
+	}
+	if opts.Activity != nil {
+		crud = activity.NewUsers(crud, opts.Activity)
+	}
+	if opts.History != nil {
+		crud = history.NewUsers(crud, opts.History)
+	}
+	if opts.Stats != nil {
+		crud = stats.NewUsers(crud, opts.Stats)
+	}
+	if opts.Webhooks != nil {
+		crud = webhooks.NewUsers(crud, opts.Webhooks)
+	}
+	if opts.Mailer != nil {
+		crud = email.NewUsers(crud, opts.Mailer)
+	}
+	if opts.Verification != nil && (mc == nil || opts.Mailer == nil) {
+		slog.Warn("email verification needs MongoDB and email and stays disabled")
+	} else if opts.Verification != nil {
+		rc.verify = newVerifier(mc, users, opts.Mailer, *opts.Verification)
+		crud = &verifyingUsers{UserRepository: crud, v: rc.verify}
+	}
+	// Quotas go last: nothing is written or sent for refused creates
+	if opts.Quotas != nil {
+		crud = quota.NewUsers(crud, opts.Quotas)
+	}
+	rc.Users = crud
+
+	resources, middleware := registered()
+	for _, r := range append(builtinResources, resources...) {
+		r.Routes(rc)
+	}
+	rt.handler, rt.middleware = rc.chain(listenerFilter(opts.AdminPaths, rc.mux), append(builtinMiddleware(rc), middleware...))
+	return rt
 }
 
-// NewRouter returns an http.Handler with user CRUD routes registered.
-func NewRouter(mc *db.MongoClient) http.Handler {
-	mux := http.NewServeMux()
+// builtinResources are the route groups of every router; each registers
+// what the options enable.
+var builtinResources = []Resource{
+	ResourceFunc(authRoutes),
+	ResourceFunc(userRoutes),
+	ResourceFunc(fileRoutes),
+	ResourceFunc(metaRoutes),
+	ResourceFunc(adminRoutes),
+}
 
-	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+// authRoutes registers /auth: sessions and email verification.
+func authRoutes(rc *Registrar) {
+	if rc.sessions != nil {
+		rc.HandleFunc("/auth/login", rc.sessions.login)
+		rc.HandleFunc("/auth/logout", rc.sessions.logout)
+	}
+	if rc.verify != nil {
+		rc.HandleFunc("/auth/verify", rc.verify.verify)
+		rc.HandleFunc("/auth/verify/resend", rc.verify.resend)
+	}
+}
+
+// userRoutes registers /users and what hangs off it, and /stats.
+func userRoutes(rc *Registrar) {
+	users, crud, sessions, opts := rc.users, rc.Users, rc.sessions, rc.Options
+
+	counter, _ := users.(store.UserCounter)
+	priv := newPrivacy(rc)
+	rc.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			listUsers(mc, w, r)
+			listUsers(crud, counter, w, r)
 		case http.MethodPost:
-			createUser(mc, w, r)
+			createUser(crud, rc.Rules, w, r)
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		}
 	})
 
+	if opts.Stats != nil {
+		rc.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+			userStats(opts.Stats, w, r)
+		})
+	}
+
+	rc.HandleFunc("/users/validate", func(w http.ResponseWriter, r *http.Request) {
+		validateUser(users, rc.Rules, w, r)
+	})
+
+	rc.HandleFunc("/users/bulk", func(w http.ResponseWriter, r *http.Request) {
+		bulkUpdateUsers(crud, rc.Rules, w, r)
+	})
+
+	rc.HandleFunc("/users/import", func(w http.ResponseWriter, r *http.Request) {
+		importUsers(crud, rc.Rules, opts.Import, w, r)
+	})
+
+	if searcher, ok := users.(store.UserSearcher); ok {
+		rc.HandleFunc("/users/search", func(w http.ResponseWriter, r *http.Request) {
+			searchUsers(searcher, w, r)
+		})
+	}
+	if suggester, ok := users.(store.UserSuggester); ok {
+		rc.HandleFunc("/users/suggest", func(w http.ResponseWriter, r *http.Request) {
+			suggestUsers(suggester, w, r)
+		})
+	}
+	if watcher, ok := users.(store.UserWatcher); ok {
+		rc.HandleFunc("/users/changes", func(w http.ResponseWriter, r *http.Request) {
+			pollUserChanges(watcher, w, r)
+		})
+	}
+
 	// Routes with ID: /users/{id}
-	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+	rc.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		if sessions != nil {
+			if id, sid, ok := splitSessionsPath(strings.TrimPrefix(r.URL.Path, "/users/")); ok {
+				if sid == "" {
+					setRouteName(r, "/users/{id}/sessions")
+				} else {
+					setRouteName(r, "/users/{id}/sessions/{sid}")
+				}
+				sessions.userSessions(w, r, id, sid)
+				return
+			}
+		}
+
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/export"); ok {
+			setRouteName(r, "/users/{id}/export")
+			priv.export(w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/data"); ok {
+			setRouteName(r, "/users/{id}/data")
+			priv.erase(w, r, id)
+			return
+		}
+
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/activity"); ok && opts.Activity != nil {
+			setRouteName(r, "/users/{id}/activity")
+			userActivity(users, opts.Activity, w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/history"); ok && opts.History != nil {
+			setRouteName(r, "/users/{id}/history")
+			userHistory(users, opts.History, w, r, id)
+			return
+		}
+
+		setRouteName(r, "/users/{id}")
 		switch r.Method {
 		case http.MethodGet:
-			getUser(mc, w, r)
+			if r.URL.Query().Has("as_of") {
+				getUserAsOf(users, opts.History, w, r, strings.TrimPrefix(r.URL.Path, "/users/"))
+				return
+			}
+			getUser(crud, rc.degraded, w, r)
 		case http.MethodPut:
-			updateUser(mc, w, r)
+			updateUser(crud, rc.Rules, w, r)
+		case http.MethodPatch:
+			patchUser(crud, rc.Rules, w, r)
 		case http.MethodDelete:
-			deleteUser(mc, w, r)
+			deleteUser(crud, sessions, w, r)
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		}
 	})
+}
 
-	return mux
+// fileRoutes registers /files.
+func fileRoutes(rc *Registrar) {
+	if rc.Options.Files == nil {
+		return
+	}
+	if rc.Mongo == nil {
+		slog.Warn("file storage needs MongoDB and stays disabled")
+		return
+	}
+	files, err := newFileStore(rc.Mongo, *rc.Options.Files)
+	if err != nil {
+		slog.Error("file storage disabled", "error", err)
+		return
+	}
+	rc.HandleFunc("/files", files.files)
+	rc.HandleFunc("/files/", files.file)
+}
+
+// metaRoutes registers the routes about the service: metrics, the spec
+// and its docs, the event schemas, and health checks.
+func metaRoutes(rc *Registrar) {
+	rc.Handle("/metrics", promhttp.Handler())
+	rc.HandleFunc("/openapi.yaml", openAPISpec)
+	rc.HandleFunc("/schemas", schemaList)
+	rc.HandleFunc("/schemas/", schemaGet)
+	if rc.Options.Docs != nil {
+		docs, err := docsHandler(*rc.Options.Docs)
+		if err != nil {
+			slog.Error("API docs disabled", "error", err)
+		} else {
+			rc.Handle("/docs", docs)
+			rc.Handle("/docs/", docs)
+		}
+	}
+
+	rc.HandleFunc("/healthz", healthz)
+	rc.HandleFunc("/readyz", rc.ready.readyz)
+	rc.HandleFunc("/version", version)
+}
+
+// adminRoutes registers /admin.
+func adminRoutes(rc *Registrar) {
+	mc, opts, rt, tenants := rc.Mongo, rc.Options, rc.rt, rc.tenants
+
+	rc.Admin("/admin/deprecations", func(w http.ResponseWriter, r *http.Request) {
+		deprecationReport(rc.tracker, w, r)
+	})
+	rc.Admin("/admin/maintenance", rt.maintenance.handle)
+	rc.Admin("/admin/read-only", rt.readOnly.handle)
+	rc.Admin("/admin/info", func(w http.ResponseWriter, r *http.Request) {
+		adminInfo(mc, rt.inflight, opts.SelfCheck, w, r)
+	})
+	rc.Handle("/admin/ui/", adminUIAuth(opts.AdminToken, adminUI()))
+	rc.Handle("/admin", adminUIAuth(opts.AdminToken, http.RedirectHandler("/admin/ui/", http.StatusFound)))
+	rc.Admin("/admin/ui/config", func(w http.ResponseWriter, r *http.Request) {
+		adminUIConfig(tenants, w, r)
+	})
+	if deleted, ok := rc.users.(store.DeletedUsers); ok {
+		rc.Admin("/admin/users/deleted", func(w http.ResponseWriter, r *http.Request) {
+			deletedUsers(deleted, w, r)
+		})
+		rc.Admin("/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+			adminUser(deleted, rc.sessions, w, r)
+		})
+	}
+	if opts.Archiver != nil {
+		rc.Admin("/admin/archive", func(w http.ResponseWriter, r *http.Request) {
+			archive(opts.Archiver, w, r)
+		})
+	}
+	if opts.Webhooks != nil {
+		rc.Admin("/admin/webhooks", func(w http.ResponseWriter, r *http.Request) {
+			webhooksHandler(opts.Webhooks, w, r)
+		})
+		rc.Admin("/admin/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+			webhookHandler(opts.Webhooks, w, r)
+		})
+	}
+	if opts.Jobs != nil {
+		rc.Admin("/admin/jobs", func(w http.ResponseWriter, r *http.Request) {
+			jobsHandler(opts.Jobs, w, r)
+		})
+		rc.Admin("/admin/jobs/", func(w http.ResponseWriter, r *http.Request) {
+			jobHandler(opts.Jobs, w, r)
+		})
+	}
+	if opts.Scheduler != nil {
+		rc.Admin("/admin/schedules", func(w http.ResponseWriter, r *http.Request) {
+			schedulesHandler(opts.Scheduler, w, r)
+		})
+		rc.Admin("/admin/schedules/", func(w http.ResponseWriter, r *http.Request) {
+			scheduleRun(opts.Scheduler, w, r)
+		})
+	}
+	if opts.Usage != nil {
+		rc.Admin("/admin/usage", func(w http.ResponseWriter, r *http.Request) {
+			usageReport(opts.Usage, w, r)
+		})
+	}
+	if opts.Capture != nil {
+		rc.Admin("/admin/captures", func(w http.ResponseWriter, r *http.Request) {
+			capturesHandler(opts.Capture, w, r)
+		})
+		rc.Admin("/admin/captures/", func(w http.ResponseWriter, r *http.Request) {
+			captureHandler(opts.Capture, w, r)
+		})
+	}
+	if opts.Quotas != nil {
+		rc.Admin("/admin/quotas", func(w http.ResponseWriter, r *http.Request) {
+			quotasHandler(opts.Quotas, w, r)
+		})
+		rc.Admin("/admin/quotas/", func(w http.ResponseWriter, r *http.Request) {
+			quotaHandler(opts.Quotas, w, r)
+		})
+	}
+	if tenants != nil {
+		rc.Admin("/admin/tenants", tenants.tenantsHandler)
+		rc.Admin("/admin/tenants/", tenants.tenantHandler)
+	}
+	if mc != nil {
+		rc.Admin("/admin/indexes", func(w http.ResponseWriter, r *http.Request) {
+			indexStatus(mc, w, r)
+		})
+		rc.Admin("/admin/indexes/rebuild", func(w http.ResponseWriter, r *http.Request) {
+			rebuildIndex(mc, w, r)
+		})
+		rc.Admin("/admin/indexes/", func(w http.ResponseWriter, r *http.Request) {
+			indexHandler(mc, w, r)
+		})
+	}
+}
+
+// builtinMiddleware returns the built-in stages the options enable.
+func builtinMiddleware(rc *Registrar) []Middleware {
+	opts, rt, mux := rc.Options, rc.rt, rc.mux
+	stage := func(name string, order int, wrap func(http.Handler) http.Handler) Middleware {
+		return Middleware{Name: name, Order: order, Wrap: func(_ *Registrar, next http.Handler) http.Handler { return wrap(next) }}
+	}
+	withMux := func(f func(*http.ServeMux, http.Handler) http.Handler) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler { return f(mux, next) }
+	}
+
+	stages := []Middleware{
+		stage("tracing", OrderTracing, withMux(tracingMiddleware)),
+		stage("client_ip", OrderClientIP, func(next http.Handler) http.Handler {
+			return clientIPMiddleware(clientip.NewResolver(opts.TrustedProxies), next)
+		}),
+		stage("request_id", OrderRequestID, requestIDMiddleware),
+		stage("metrics", OrderMetrics, withMux(metricsMiddleware)),
+		stage("inflight", OrderInflight, withMux(rt.inflight.middleware)),
+		stage("access_log", OrderAccessLog, func(next http.Handler) http.Handler {
+			return accessLogMiddleware(mux, rt.logSample.Load, next)
+		}),
+		stage("recover", OrderRecover, recoverMiddleware),
+		stage("timeout", OrderTimeout, func(next http.Handler) http.Handler {
+			return timeoutMiddleware(rt.timeouts.Load, next)
+		}),
+		stage("maintenance", OrderMaintenance, rt.maintenance.middleware),
+		stage("read_only", OrderReadOnly, rt.readOnly.middleware),
+		stage("cache", OrderCache, func(next http.Handler) http.Handler {
+			return cacheMiddleware(rt.cache.Load, next)
+		}),
+		stage("deprecations", OrderDeprecations, rc.tracker.middleware),
+	}
+	if opts.Concurrency != nil {
+		stages = append(stages, stage("concurrency", OrderConcurrency, func(next http.Handler) http.Handler {
+			return concurrencyMiddleware(*opts.Concurrency, next)
+		}))
+	}
+	if opts.Compression != nil {
+		stages = append(stages, stage("compression", OrderCompression, func(next http.Handler) http.Handler {
+			return compressMiddleware(*opts.Compression, next)
+		}))
+	}
+	if opts.KeyCase != nil {
+		stages = append(stages, stage("key_case", OrderKeyCase, func(next http.Handler) http.Handler {
+			return keyCaseMiddleware(*opts.KeyCase, next)
+		}))
+	}
+	if opts.Contract != nil {
+		stages = append(stages, stage("contract", OrderContract, func(next http.Handler) http.Handler {
+			return contractMiddleware(*opts.Contract, next)
+		}))
+	}
+	if rc.Mongo != nil {
+		stages = append(stages, stage("breaker", OrderBreaker, func(next http.Handler) http.Handler {
+			return breakerMiddleware(rc.Mongo.Breaker, rc.degraded, next)
+		}))
+	}
+	if rc.tenants != nil {
+		stages = append(stages, stage("tenants", OrderTenants, rc.tenants.middleware))
+	}
+	if opts.Usage != nil {
+		stages = append(stages, stage("usage", OrderUsage, func(next http.Handler) http.Handler {
+			return usageMiddleware(opts.Usage, next)
+		}))
+	}
+	if opts.Capture != nil {
+		stages = append(stages, stage("capture", OrderCapture, func(next http.Handler) http.Handler {
+			return captureMiddleware(opts.Capture, next)
+		}))
+	}
+	if rc.sessions != nil {
+		stages = append(stages,
+			stage("sessions", OrderSessions, rc.sessions.middleware),
+			stage("csrf", OrderCSRF, csrfMiddleware))
+		if rc.verify != nil {
+			stages = append(stages, stage("verification", OrderVerification, rc.verify.middleware))
+		}
+	}
+	if opts.History != nil {
+		stages = append(stages, stage("actor", OrderActor, func(next http.Handler) http.Handler {
+			return actorMiddleware(opts.AdminToken, next)
+		}))
+	}
+	if opts.Authz != nil {
+		stages = append(stages, stage("authz", OrderAuthz, func(next http.Handler) http.Handler {
+			return authzMiddleware(opts.AdminToken, *opts.Authz, next)
+		}))
+	}
+	if rc.degraded != nil {
+		stages = append(stages, stage("degraded_reads", OrderDegradedReads, rc.degraded.middleware))
+	}
+	if opts.ResponseCache != nil {
+		stages = append(stages, stage("response_cache", OrderResponseCache, newResponseCache(*opts.ResponseCache).middleware))
+	}
+	if opts.Masking != nil {
+		stages = append(stages, stage("masking", OrderMasking, newFieldPolicy(opts.AdminToken, *opts.Masking).middleware))
+	}
+	return stages
 }
 
 // Helper: write JSON
@@ -63,222 +699,290 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// Helper: write a JSON error with the generic code of status (see
+// writeErrorCode)
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeErrorCode(w, r, status, errorCode(status), msg)
+}
+
+// Helper: comment attached to Mongo operations so slow queries in the
+// profiler can be matched to the request that issued them
+func opComment(r *http.Request) string {
+	return db.Comment(r.Context())
+}
+
 // createUser - POST /users
-func createUser(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
-	var in User
+func createUser(users store.UserRepository, rules *validation.Validator, w http.ResponseWriter, r *http.Request) {
+	var in store.User
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "invalid json body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if errs := userFieldErrors(rules, w, r, &in); len(errs) > 0 {
+		writeFieldErrors(w, r, errs)
 		return
 	}
 
+	in.ID = ""
 	if in.CreatedAt.IsZero() {
 		in.CreatedAt = time.Now().UTC()
 	}
+	if in.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid password")
+			return
+		}
+		in.PasswordHash = string(hash)
+		in.Password = ""
+	}
 
-	coll := mc.DB.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := opContext(r)
 	defer cancel()
 
-	res, err := coll.InsertOne(ctx, in)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("insert error: %v", err), http.StatusInternalServerError)
+	if err := users.Create(ctx, &in); err != nil {
+		userError(w, r, "insert", err)
 		return
 	}
 
-	id := ""
-	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
-		id = oid.Hex()
-	}
-
-	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+	writeJSON(w, http.StatusCreated, map[string]string{"id": in.ID})
 }
 
 // listUsers - GET /users
-func listUsers(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
-	coll := mc.DB.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Optional query parameters: name, email, min_age, max_age, offset, limit,
+// and filter, an expression such as age>=18 and name~"jo" (see package
+// query) over the fields in store.UserFields. Clients accepting
+// listMediaType get pages, also selected by page; counter may be nil.
+func listUsers(users store.UserRepository, counter store.UserCounter, w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	f, err := parseUserFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	paged := wantsPage(r)
+	if paged {
+		if err := pageFilter(r, &f); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := opContext(r)
 	defer cancel()
 
-	cur, err := coll.Find(ctx, bson.M{})
+	out, err := users.List(ctx, f)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("find error: %v", err), http.StatusInternalServerError)
+		dbError(w, r, "find", err)
 		return
 	}
-	defer cur.Close(ctx)
 
-	var out []map[string]any
-	for cur.Next(ctx) {
-		var raw bson.M
-		if err := cur.Decode(&raw); err != nil {
-			http.Error(w, fmt.Sprintf("decode error: %v", err), http.StatusInternalServerError)
-			return
-		}
+	if paged {
+		writePage(counter, w, r, f, out)
+		return
+	}
+	writeJSON(w, http.StatusOK, maskUsers(r, out))
+}
 
-		m := map[string]any{}
-		// id
-		if idv, ok := raw["_id"].(primitive.ObjectID); ok {
-			m["id"] = idv.Hex()
-		} else {
-			m["id"] = ""
-		}
-		// name, email, age
-		if v, ok := raw["name"].(string); ok {
-			m["name"] = v
-		}
-		if v, ok := raw["email"].(string); ok {
-			m["email"] = v
-		}
-		if v, ok := raw["age"].(int32); ok {
-			m["age"] = int(v)
-		} else if v, ok := raw["age"].(int); ok {
-			m["age"] = v
-		} else if v, ok := raw["age"].(float64); ok {
-			m["age"] = int(v)
+// parseUserFilter reads the list filter from the query parameters of r.
+// Filters on fields hidden from the caller are rejected, so matches can't
+// reveal masked values.
+func parseUserFilter(r *http.Request) (store.UserFilter, error) {
+	q := r.URL.Query()
+	hidden, _ := r.Context().Value(maskKey{}).(map[string]bool)
+	for _, p := range [][2]string{{"name", "name"}, {"email", "email"}, {"min_age", "age"}, {"max_age", "age"}} {
+		if param, field := p[0], p[1]; hidden[field] && q.Has(param) {
+			return store.UserFilter{}, fmt.Errorf("cannot filter on %s", field)
 		}
+	}
 
-		// normalize created_at to RFC3339 string when possible
-		if cat, exists := raw["created_at"]; exists {
-			switch t := cat.(type) {
-			case primitive.DateTime:
-				m["created_at"] = t.Time().UTC().Format(time.RFC3339)
-			case time.Time:
-				m["created_at"] = t.UTC().Format(time.RFC3339)
-			case map[string]any:
-				// May come from older inserted doc like {"$date":"..."}
-				if s, ok := t["$date"].(string); ok {
-					if parsed, err := time.Parse(time.RFC3339, s); err == nil {
-						m["created_at"] = parsed.UTC().Format(time.RFC3339)
-					} else {
-						m["created_at"] = s
-					}
-				} else {
-					m["created_at"] = t
+	f := store.UserFilter{
+		Name:  q.Get("name"),
+		Email: q.Get("email"),
+	}
+	if expr := q.Get("filter"); expr != "" {
+		fields := store.UserFields
+		if len(hidden) > 0 {
+			fields = map[string]query.Kind{}
+			for name, kind := range store.UserFields {
+				if !hidden[name] {
+					fields[name] = kind
 				}
-			default:
-				m["created_at"] = t
 			}
 		}
-
-		out = append(out, m)
+		where, err := query.Parse(expr, fields)
+		if err != nil {
+			return f, err
+		}
+		f.Where = where
 	}
-
-	writeJSON(w, http.StatusOK, out)
+	ints := []struct {
+		name string
+		set  func(int)
+	}{
+		{"min_age", func(n int) { f.MinAge = &n }},
+		{"max_age", func(n int) { f.MaxAge = &n }},
+		{"offset", func(n int) { f.Offset = n }},
+		{"limit", func(n int) { f.Limit = n }},
+	}
+	for _, p := range ints {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid %s", p.name)
+		}
+		p.set(n)
+	}
+	return f, nil
 }
 
 // getUser - GET /users/{id}
-func getUser(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
-	oid, err := primitive.ObjectIDFromHex(idStr)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+// While Mongo is down it answers with the last copy of the user read, if
+// degraded reads are enabled; degraded may be nil.
+func getUser(users store.UserRepository, degraded *degraded, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	if degraded.serve(w, r, id) {
 		return
 	}
 
-	coll := mc.DB.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := opContext(r)
 	defer cancel()
 
-	var raw bson.M
-	err = coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&raw)
+	read := time.Now()
+	u, err := users.Get(ctx, id)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, fmt.Sprintf("find error: %v", err), http.StatusInternalServerError)
+		userError(w, r, "find", err)
 		return
 	}
+	degraded.remember(ctx, u, read)
 
-	resp := map[string]any{"id": ""}
-	if idv, ok := raw["_id"].(primitive.ObjectID); ok {
-		resp["id"] = idv.Hex()
-	}
-	if v, ok := raw["name"].(string); ok {
-		resp["name"] = v
-	}
-	if v, ok := raw["email"].(string); ok {
-		resp["email"] = v
-	}
-	if v, ok := raw["age"].(int32); ok {
-		resp["age"] = int(v)
-	} else if v, ok := raw["age"].(int); ok {
-		resp["age"] = v
-	} else if v, ok := raw["age"].(float64); ok {
-		resp["age"] = int(v)
-	}
-	if cat, exists := raw["created_at"]; exists {
-		switch t := cat.(type) {
-		case primitive.DateTime:
-			resp["created_at"] = t.Time().UTC().Format(time.RFC3339)
-		case time.Time:
-			resp["created_at"] = t.UTC().Format(time.RFC3339)
-		case map[string]any:
-			if s, ok := t["$date"].(string); ok {
-				if parsed, err := time.Parse(time.RFC3339, s); err == nil {
-					resp["created_at"] = parsed.UTC().Format(time.RFC3339)
-				} else {
-					resp["created_at"] = s
-				}
-			} else {
-				resp["created_at"] = t
-			}
-		default:
-			resp["created_at"] = t
-		}
-	}
-
-	writeJSON(w, http.StatusOK, resp)
+	writeJSON(w, http.StatusOK, maskUser(r, u))
 }
 
 // updateUser - PUT /users/{id}
-func updateUser(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
-	oid, err := primitive.ObjectIDFromHex(idStr)
+// Answers with the user as updated, or 404 when no user has the id.
+func updateUser(users store.UserRepository, rules *validation.Validator, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	fields, err := userChanges(rules, body)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var body map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid json body", http.StatusBadRequest)
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	if err := users.Update(ctx, id, fields); err != nil {
+		userError(w, r, "update", err)
+		return
+	}
+	// A concurrent delete may leave nothing to answer with
+	u, err := users.Get(ctx, id)
+	if err != nil {
+		userError(w, r, "find", err)
 		return
 	}
 
+	writeJSON(w, http.StatusOK, maskUser(r, u))
+}
+
+// userChanges validates the fields of an update from a request body and
+// returns them ready for UserRepository.Update.
+func userChanges(rules *validation.Validator, body map[string]any) (map[string]any, error) {
 	// Remove id if present
 	delete(body, "id")
 
-	coll := mc.DB.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	_, err = coll.UpdateByID(ctx, oid, bson.M{"$set": body})
+	fields, err := sanitizeDocument(body, userWritableFields)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("update error: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no fields to update")
+	}
+	for k, v := range fields {
+		if err := rules.Field(k, v); err != nil {
+			return nil, err
+		}
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"id": oid.Hex()})
+	// Never store a plaintext password
+	if pw, ok := fields["password"]; ok {
+		delete(fields, "password")
+		s, ok := pw.(string)
+		if !ok || s == "" {
+			return nil, errors.New("invalid password")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(s), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, errors.New("invalid password")
+		}
+		fields["password_hash"] = string(hash)
+	}
+	return fields, nil
 }
 
 // deleteUser - DELETE /users/{id}
-func deleteUser(mc *db.MongoClient, w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
-	oid, err := primitive.ObjectIDFromHex(idStr)
+// With dry_run=true it reports the user and its sessions that would be
+// removed. sessions may be nil.
+func deleteUser(users store.UserRepository, sessions *sessionStore, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	dry, err := dryRun(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	coll := mc.DB.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := opContext(r)
 	defer cancel()
 
-	_, err = coll.DeleteOne(ctx, bson.M{"_id": oid})
-	if err != nil {
-		http.Error(w, fmt.Sprintf("delete error: %v", err), http.StatusInternalServerError)
+	if dry {
+		if _, err := users.Get(ctx, id); err != nil {
+			userError(w, r, "find", err)
+			return
+		}
+		dryRunUser(ctx, sessions, w, r, id)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"id": oid.Hex()})
+	if err := users.Delete(ctx, id); err != nil {
+		userError(w, r, "delete", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// userError writes the error for a failed operation on a single user.
+func userError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	storeError(w, r, op, CodeUserNotFound, err)
+}
+
+// storeError writes the error for a failed operation on a single record,
+// with the code notFound when there is no such record.
+func storeError(w http.ResponseWriter, r *http.Request, op, notFound string, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		writeErrorCode(w, r, http.StatusBadRequest, CodeInvalidID, "invalid id")
+	case errors.Is(err, store.ErrNotFound):
+		writeErrorCode(w, r, http.StatusNotFound, notFound, "not found")
+	case errors.Is(err, store.ErrDuplicateEmail):
+		writeErrorCode(w, r, http.StatusConflict, CodeDuplicateEmail, "email already in use")
+	case errors.Is(err, store.ErrInvalidField):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, store.ErrRestricted):
+		writeErrorCode(w, r, http.StatusConflict, CodeUserReferenced, err.Error())
+	case errors.As(err, new(*quota.ExceededError)):
+		quotaError(w, r, err)
+	default:
+		dbError(w, r, op, err)
+	}
 }
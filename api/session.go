@@ -0,0 +1,401 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang/activity"
+	"golang/db"
+	"golang/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionOptions configures cookie based session authentication.
+type SessionOptions struct {
+	CookieName string        // defaults to "session"
+	TTL        time.Duration // session lifetime; defaults to 24h
+	Secure     bool          // send the cookie over HTTPS only
+	SameSite   http.SameSite // defaults to http.SameSiteLaxMode
+}
+
+// Session is a server-side login session stored in the "sessions" collection.
+// Only a hash of the cookie token is persisted.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CSRFToken string             `bson:"csrf_token" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	RemoteIP  string             `bson:"remote_ip,omitempty" json:"remote_ip,omitempty"`
+}
+
+type sessionKey struct{}
+
+// sessionFromContext returns the session authenticated by the request cookie,
+// or nil when the request carries no valid session.
+func sessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+func init() {
+	// expires_at drives expiry; token_hash is the cookie lookup
+	expire := time.Duration(0)
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "sessions", Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: &expire},
+		db.IndexSpec{Collection: "sessions", Name: "token_hash_1", Keys: bson.D{{Key: "token_hash", Value: 1}}, Unique: true},
+		db.IndexSpec{Collection: "sessions", Name: "user_id_1", Keys: bson.D{{Key: "user_id", Value: 1}}},
+	)
+}
+
+type sessionStore struct {
+	mc       *db.MongoClient
+	users    store.UserRepository
+	opts     SessionOptions
+	activity *activity.Log // nil unless activity is recorded
+}
+
+func newSessionStore(mc *db.MongoClient, users store.UserRepository, opts SessionOptions) *sessionStore {
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	return &sessionStore{mc: mc, users: users, opts: opts}
+}
+
+func (s *sessionStore) coll() *mongo.Collection {
+	return s.mc.Collection("sessions")
+}
+
+// randomToken returns 32 random bytes encoded for use in cookies and headers.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// middleware resolves the session cookie and stores the session in the
+// request context. Invalid or expired cookies are cleared.
+func (s *sessionStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(s.opts.CookieName)
+		if err != nil || c.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := opContext(r)
+		defer cancel()
+
+		var sess Session
+		err = s.coll().FindOne(ctx, bson.M{
+			"token_hash": hashToken(c.Value),
+			"expires_at": bson.M{"$gt": time.Now().UTC()},
+		}, options.FindOne().SetComment(opComment(r))).Decode(&sess)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				slog.ErrorContext(ctx, "session lookup failed", "error", err)
+			}
+			s.clearCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, &sess)))
+	})
+}
+
+func (s *sessionStore) setCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.opts.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		Secure:   s.opts.Secure,
+		HttpOnly: true,
+		SameSite: s.opts.SameSite,
+	})
+}
+
+func (s *sessionStore) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.opts.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   s.opts.Secure,
+		HttpOnly: true,
+		SameSite: s.opts.SameSite,
+	})
+}
+
+// dbError reports err to the circuit breaker and writes the error response.
+func (s *sessionStore) dbError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	s.mc.Breaker.Record(err)
+	dbError(w, r, op, err)
+}
+
+// login - POST /auth/login
+func (s *sessionStore) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var in struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if in.Email == "" || in.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "email and password are required")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	found, err := s.users.List(ctx, store.UserFilter{Email: in.Email, Limit: 1})
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	var uid primitive.ObjectID
+	if len(found) == 1 {
+		uid, err = primitive.ObjectIDFromHex(found[0].ID)
+	}
+	if len(found) == 0 || err != nil || found[0].PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(found[0].PasswordHash), []byte(in.Password)) != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "could not create session")
+		return
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "could not create session")
+		return
+	}
+
+	now := time.Now().UTC()
+	sess := Session{
+		ID:        primitive.NewObjectID(),
+		TokenHash: hashToken(token),
+		CSRFToken: csrf,
+		UserID:    uid,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.opts.TTL),
+		UserAgent: r.UserAgent(),
+		RemoteIP:  ClientIP(r),
+	}
+	if _, err := s.coll().InsertOne(ctx, sess, options.InsertOne().SetComment(opComment(r))); err != nil {
+		s.dbError(w, r, "insert", err)
+		return
+	}
+
+	// Activity drives archiving of stale users; failing to record it
+	// shouldn't fail the login
+	if rec, ok := s.users.(store.LoginRecorder); ok {
+		if err := rec.RecordLogin(ctx, uid.Hex(), now); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			slog.ErrorContext(ctx, "failed to record last login", "user_id", uid.Hex(), "error", err)
+		}
+	}
+	if s.activity != nil {
+		if err := s.activity.Record(ctx, uid.Hex(), activity.UserLogin); err != nil {
+			slog.ErrorContext(ctx, "failed to record activity", "type", activity.UserLogin, "user_id", uid.Hex(), "error", err)
+		}
+	}
+
+	s.setCookie(w, token, sess.ExpiresAt)
+	w.Header().Set(csrfHeader, csrf)
+	writeJSON(w, http.StatusOK, struct {
+		Session
+		CSRFToken string `json:"csrf_token"`
+	}{sess, csrf})
+}
+
+// logout - POST /auth/logout
+func (s *sessionStore) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if sess := sessionFromContext(r.Context()); sess != nil {
+		ctx, cancel := opContext(r)
+		defer cancel()
+		if _, err := s.coll().DeleteOne(ctx, bson.M{"_id": sess.ID}, options.Delete().SetComment(opComment(r))); err != nil {
+			s.dbError(w, r, "delete", err)
+			return
+		}
+	}
+
+	s.clearCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// countUser counts the sessions of user id, expired or not, which deleting
+// the user removes.
+func (s *sessionStore) countUser(ctx context.Context, r *http.Request, id string) (int64, error) {
+	uid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, nil
+	}
+	return s.coll().CountDocuments(ctx, bson.M{"user_id": uid}, options.Count().SetComment(opComment(r)))
+}
+
+// userSessions handles /users/{id}/sessions and /users/{id}/sessions/{sid}.
+// Callers may only see and revoke their own sessions.
+func (s *sessionStore) userSessions(w http.ResponseWriter, r *http.Request, idStr, sid string) {
+	uid, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, CodeInvalidID, "invalid id")
+		return
+	}
+	sess := sessionFromContext(r.Context())
+	if sess == nil {
+		writeError(w, r, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if sess.UserID != uid {
+		writeError(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	dry, err := dryRun(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	filter := bson.M{"user_id": uid}
+	if sid != "" {
+		oid, err := primitive.ObjectIDFromHex(sid)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid session id")
+			return
+		}
+		filter["_id"] = oid
+	}
+
+	switch {
+	case r.Method == http.MethodGet && sid == "":
+		cur, err := s.coll().Find(ctx, bson.M{
+			"user_id":    uid,
+			"expires_at": bson.M{"$gt": time.Now().UTC()},
+		}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetComment(opComment(r)))
+		if err != nil {
+			s.dbError(w, r, "find", err)
+			return
+		}
+		out := []Session{}
+		if err := cur.All(ctx, &out); err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("decode error: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case r.Method == http.MethodDelete && dry:
+		n, err := s.coll().CountDocuments(ctx, filter, options.Count().SetComment(opComment(r)))
+		if err != nil {
+			s.dbError(w, r, "count", err)
+			return
+		}
+		if sid != "" && n == 0 {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		cur, err := s.coll().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(dryRunSample).SetComment(opComment(r)))
+		if err != nil {
+			s.dbError(w, r, "find", err)
+			return
+		}
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.All(ctx, &docs); err != nil {
+			s.dbError(w, r, "find", err)
+			return
+		}
+		ids := make([]string, len(docs))
+		for i, d := range docs {
+			ids[i] = d.ID.Hex()
+		}
+		writeDryRun(w, n, ids, nil)
+
+	case r.Method == http.MethodDelete:
+		res, err := s.coll().DeleteMany(ctx, filter, options.Delete().SetComment(opComment(r)))
+		if err != nil {
+			s.dbError(w, r, "delete", err)
+			return
+		}
+		if sid != "" && res.DeletedCount == 0 {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		if sid == "" || sid == sess.ID.Hex() {
+			s.clearCookie(w)
+		}
+		writeJSON(w, http.StatusOK, map[string]int64{"revoked": res.DeletedCount})
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// splitSessionsPath reports whether rest (the path after "/users/") addresses
+// a user's sessions, returning the user id and optional session id.
+func splitSessionsPath(rest string) (id, sid string, ok bool) {
+	id, sub, found := strings.Cut(rest, "/")
+	if !found {
+		return "", "", false
+	}
+	if sub == "sessions" {
+		return id, "", true
+	}
+	if sid, found := strings.CutPrefix(sub, "sessions/"); found && sid != "" && !strings.Contains(sid, "/") {
+		return id, sid, true
+	}
+	return "", "", false
+}
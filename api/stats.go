@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"golang/stats"
+)
+
+// userStats - GET /stats
+// Returns the user statistics of the tenant as maintained in the stats
+// collection, without counting the users: totals, counts by status and
+// daily signups of the last 30 days.
+func userStats(s *stats.Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	out, err := s.Get(ctx)
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
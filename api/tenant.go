@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang/store"
+	"golang/tenant"
+)
+
+// TenancyOptions configures how requests are mapped to tenants.
+type TenancyOptions struct {
+	// Mode is "header" or "subdomain".
+	Mode string
+	// Header carries the tenant id in header mode; defaults to X-Tenant-ID.
+	Header string
+	// BaseDomain is the domain below which the first host label is the
+	// tenant id in subdomain mode, e.g. "api.example.com" for
+	// acme.api.example.com.
+	BaseDomain string
+}
+
+// tenantCacheTTL bounds how long a resolved tenant is trusted without
+// asking the repository again, and so how long a deleted tenant keeps
+// working on other instances.
+const tenantCacheTTL = 30 * time.Second
+
+// tenantResolver maps requests to provisioned tenants.
+type tenantResolver struct {
+	opts    TenancyOptions
+	tenants store.TenantRepository
+
+	mu    sync.Mutex
+	known map[string]time.Time // tenant id -> cache expiry
+}
+
+func newTenantResolver(tenants store.TenantRepository, opts TenancyOptions) *tenantResolver {
+	if opts.Header == "" {
+		opts.Header = "X-Tenant-ID"
+	}
+	opts.BaseDomain = strings.ToLower(strings.TrimPrefix(opts.BaseDomain, "."))
+	return &tenantResolver{opts: opts, tenants: tenants, known: map[string]time.Time{}}
+}
+
+// tenantID returns the tenant id the request names, or "" if it names none.
+func (t *tenantResolver) tenantID(r *http.Request) string {
+	if t.opts.Mode == "header" {
+		return strings.ToLower(strings.TrimSpace(r.Header.Get(t.opts.Header)))
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+t.opts.BaseDomain)
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// exists reports whether tenant id is provisioned, caching positive answers.
+func (t *tenantResolver) exists(r *http.Request, id string) (bool, error) {
+	t.mu.Lock()
+	exp, ok := t.known[id]
+	t.mu.Unlock()
+	if ok && time.Now().Before(exp) {
+		return true, nil
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	_, err := t.tenants.Get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	t.known[id] = time.Now().Add(tenantCacheTTL)
+	t.mu.Unlock()
+	return true, nil
+}
+
+func (t *tenantResolver) forget(id string) {
+	t.mu.Lock()
+	delete(t.known, id)
+	t.mu.Unlock()
+}
+
+// middleware scopes requests to their tenant. Requests naming no tenant get
+// 400 and unknown tenants 404. Admin, health, metrics, docs and schema
+// endpoints are not tenant scoped, nor is /auth/verify, whose token names
+// the user.
+func (t *tenantResolver) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/admin/"),
+			r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/version", r.URL.Path == "/metrics",
+			r.URL.Path == "/openapi.yaml", r.URL.Path == "/docs", strings.HasPrefix(r.URL.Path, "/docs/"),
+			r.URL.Path == "/schemas", strings.HasPrefix(r.URL.Path, "/schemas/"),
+			r.URL.Path == "/auth/verify":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := t.tenantID(r)
+		if id == "" {
+			writeError(w, r, http.StatusBadRequest, "tenant required")
+			return
+		}
+		if !tenant.Valid(id) {
+			writeError(w, r, http.StatusBadRequest, "invalid tenant")
+			return
+		}
+		ok, err := t.exists(r, id)
+		if err != nil {
+			dbError(w, r, "tenant lookup", err)
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "unknown tenant")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), id)))
+	})
+}
+
+// tenantsHandler - GET, POST /admin/tenants
+func (t *tenantResolver) tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		out, err := t.tenants.List(ctx)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in store.Tenant
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if !tenant.Valid(in.ID) {
+			writeError(w, r, http.StatusBadRequest, "id must be 1 to 63 lowercase letters, digits or hyphens")
+			return
+		}
+		in.CreatedAt = time.Now().UTC()
+		if err := t.tenants.Create(ctx, &in); err != nil {
+			if errors.Is(err, store.ErrExists) {
+				writeError(w, r, http.StatusConflict, "tenant already exists")
+				return
+			}
+			dbError(w, r, "insert", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, in)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// tenantHandler - GET, DELETE /admin/tenants/{id}
+// Deleting a tenant stops its requests being served but keeps its data;
+// with dry_run=true DELETE only reports the tenant it would remove.
+func (t *tenantResolver) tenantHandler(w http.ResponseWriter, r *http.Request) {
+	setRouteName(r, "/admin/tenants/{id}")
+	id := strings.TrimPrefix(r.URL.Path, "/admin/tenants/")
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		tn, err := t.tenants.Get(ctx, id)
+		if err != nil {
+			storeError(w, r, "find", CodeNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tn)
+
+	case http.MethodDelete:
+		dry, err := dryRun(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if dry {
+			if _, err := t.tenants.Get(ctx, id); err != nil {
+				storeError(w, r, "find", CodeNotFound, err)
+				return
+			}
+			writeDryRun(w, 1, []string{id}, nil)
+			return
+		}
+		if err := t.tenants.Delete(ctx, id); err != nil {
+			storeError(w, r, "delete", CodeNotFound, err)
+			return
+		}
+		t.forget(id)
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultOpTimeout is the request deadline when no route specific timeout is
+// configured.
+const defaultOpTimeout = 10 * time.Second
+
+// opTimeouts holds the configured per-route request timeouts.
+type opTimeouts struct {
+	def    time.Duration
+	routes map[string]time.Duration // route name -> timeout
+}
+
+func (t *opTimeouts) forRoute(name string) time.Duration {
+	if t == nil {
+		return defaultOpTimeout
+	}
+	if d, ok := t.routes[name]; ok && d > 0 {
+		return d
+	}
+	if t.def > 0 {
+		return t.def
+	}
+	return defaultOpTimeout
+}
+
+// requestTimeout is the timeout configuration in effect for one request and
+// when the request started.
+type requestTimeout struct {
+	timeouts *opTimeouts
+	start    time.Time
+}
+
+type requestTimeoutKey struct{}
+
+// deadline returns the deadline of r. It is looked up by the current route
+// name, so it follows handlers that refine the name while routing.
+func deadline(r *http.Request) (time.Time, time.Duration) {
+	rt, ok := r.Context().Value(requestTimeoutKey{}).(*requestTimeout)
+	if !ok {
+		return time.Now().Add(defaultOpTimeout), defaultOpTimeout
+	}
+	d := rt.timeouts.forRoute(routeName(r))
+	return rt.start.Add(d), d
+}
+
+// timeoutMiddleware enforces the per-route request deadline. Database work
+// started through opContext is cancelled once it passes, and a handler that
+// gives up without responding gets a 504.
+func timeoutMiddleware(current func() *opTimeouts, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), requestTimeoutKey{}, &requestTimeout{
+			timeouts: current(),
+			start:    time.Now(),
+		}))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 && r.Context().Err() == nil {
+			if at, d := deadline(r); time.Now().After(at) {
+				writeTimeout(rec, r, d)
+			}
+		}
+	})
+}
+
+// opContext derives the context for database work from the request, so
+// operations are cancelled when the client goes away or the request deadline
+// passes.
+func opContext(r *http.Request) (context.Context, context.CancelFunc) {
+	at, _ := deadline(r)
+	return context.WithDeadline(r.Context(), at)
+}
+
+// timedOut reports whether err comes from r running past its deadline.
+func timedOut(r *http.Request, err error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	at, _ := deadline(r)
+	return !time.Now().Before(at)
+}
+
+// writeTimeout writes the 504 for a request that ran past its deadline.
+func writeTimeout(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	body := errorBody(w, r, CodeTimeout, "request timed out")
+	body["timeout"] = d.String()
+	writeJSON(w, http.StatusGatewayTimeout, body)
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingMiddleware starts a server span for each request, continuing any
+// W3C trace context sent by the client. The span is named after the route
+// once the handler has run, so names stay low-cardinality.
+func tracingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ri := withRoute(mux, r)
+		next.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + ri.name)
+		span.SetAttributes(semconv.HTTPRoute(ri.name))
+	})
+	return otelhttp.NewHandler(named, "http.server")
+}
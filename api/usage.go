@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/db"
+	"golang/metering"
+	"golang/tenant"
+)
+
+// maxUsageDays is the longest range /admin/usage reports at once.
+const maxUsageDays = 366
+
+// usageMiddleware meters requests by tenant and API key and refuses those
+// over the monthly quota with 429. Admin, health, metrics, docs and schema
+// endpoints are not metered. Quotas are not enforced while the stored
+// usage can't be read, rather than failing every request.
+func usageMiddleware(m *metering.Meter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/admin/"),
+			r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/version", r.URL.Path == "/metrics",
+			r.URL.Path == "/openapi.yaml", r.URL.Path == "/docs", strings.HasPrefix(r.URL.Path, "/docs/"),
+			r.URL.Path == "/schemas", strings.HasPrefix(r.URL.Path, "/schemas/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s := metering.Subject{TenantID: tenant.FromContext(r.Context()), APIKey: callerID(r)}
+		ok, reset, err := m.Allow(r.Context(), s)
+		switch {
+		case err != nil:
+			slog.WarnContext(r.Context(), "failed to check usage quota", "api_key", s.APIKey, "error", err)
+		case !ok:
+			writeRetryError(w, r, http.StatusTooManyRequests, CodeQuotaExceeded, "monthly request quota exceeded", time.Until(reset))
+			return
+		}
+
+		units := &db.Units{}
+		next.ServeHTTP(w, r.WithContext(db.WithUnits(r.Context(), units)))
+		m.Record(s, units)
+	})
+}
+
+// usageReport - GET /admin/usage
+// Returns the requests and MongoDB read and write units per tenant and API
+// key from day from to day to (YYYY-MM-DD, UTC, both included), by default
+// this month so far. tenant and api_key select one tenant or key; with
+// daily=true each day is reported separately. This instance's usage is
+// stored first, so it is included up to now.
+func usageReport(m *metering.Meter, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	q := metering.Query{
+		From:     time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		To:       now,
+		TenantID: query.Get("tenant"),
+		APIKey:   query.Get("api_key"),
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if s := query.Get(p.name); s != "" {
+			t, err := time.Parse(metering.DayFormat, s)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid "+p.name)
+				return
+			}
+			*p.t = t
+		}
+	}
+	if q.To.Before(q.From) || q.To.Sub(q.From) >= maxUsageDays*24*time.Hour {
+		writeError(w, r, http.StatusBadRequest, "invalid date range")
+		return
+	}
+	if s := query.Get("daily"); s != "" {
+		daily, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid daily")
+			return
+		}
+		q.Daily = daily
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	if err := m.Flush(ctx); err != nil {
+		dbError(w, r, "update", err)
+		return
+	}
+	out, err := m.Report(ctx, q)
+	if err != nil {
+		dbError(w, r, "aggregate", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang/store"
+	"golang/store/storetest"
+)
+
+// sendUsers sends a request with body, if not empty, as contentType to h.
+func sendUsers(h http.Handler, method, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeJSON decodes the body of rec into v, failing t if it isn't JSON.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+}
+
+func TestUsersLifecycle(t *testing.T) {
+	usersLifecycle(t, NewRouter(nil, Options{Users: store.NewMemoryUsers()}))
+}
+
+// usersLifecycle creates, reads, updates, lists and deletes a user through
+// h, whose users must have none named Ada Lovelace.
+func usersLifecycle(t *testing.T, h http.Handler) {
+	rec := sendUsers(h, http.MethodPost, "/users", "application/json", `{"name":"Ada","email":"ada@example.com","age":36,"password":"secret"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /users = %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	decodeJSON(t, rec, &created)
+	path := "/users/" + created.ID
+
+	rec = sendUsers(h, http.MethodGet, path, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	var got map[string]any
+	decodeJSON(t, rec, &got)
+	if got["name"] != "Ada" || got["email"] != "ada@example.com" || got["age"] != 36.0 || got["created_at"] == nil {
+		t.Errorf("GET %s = %v", path, got)
+	}
+	if _, ok := got["password"]; ok {
+		t.Errorf("GET %s returned the password", path)
+	}
+
+	rec = sendUsers(h, http.MethodPut, path, "application/json", `{"name":"Ada Lovelace"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	rec = sendUsers(h, http.MethodPatch, path, mergePatchType, `{"age":37}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	rec = sendUsers(h, http.MethodPatch, path, jsonPatchType, `[{"op":"replace","path":"/email","value":"ada@example.org"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH %s = %d: %s", path, rec.Code, rec.Body)
+	}
+
+	rec = sendUsers(h, http.MethodGet, "/users?name=Ada+Lovelace", "", "")
+	var list []store.User
+	decodeJSON(t, rec, &list)
+	if len(list) != 1 || list[0].ID != created.ID || list[0].Age != 37 || list[0].Email != "ada@example.org" {
+		t.Errorf("GET /users?name=Ada+Lovelace = %+v, want Ada updated", list)
+	}
+
+	if rec = sendUsers(h, http.MethodDelete, path, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	if rec = sendUsers(h, http.MethodGet, path, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET %s after DELETE = %d, want %d", path, rec.Code, http.StatusNotFound)
+	}
+	if rec = sendUsers(h, http.MethodDelete, path, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE %s twice = %d, want %d", path, rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUsersValidation(t *testing.T) {
+	h := NewRouter(nil, Options{Users: store.NewMemoryUsers()})
+	rec := sendUsers(h, http.MethodPost, "/users", "application/json", `{"name":"Ada","email":"ada@example.com"}`)
+	var created struct {
+		ID string `json:"id"`
+	}
+	decodeJSON(t, rec, &created)
+	path := "/users/" + created.ID
+
+	tests := []struct {
+		method, path, contentType, body string
+		want                            int
+	}{
+		{http.MethodPost, "/users", "application/json", `{`, http.StatusBadRequest},
+		{http.MethodPost, "/users", "application/json", `{"name":"Bob","email":"not an email"}`, http.StatusBadRequest},
+		{http.MethodPost, "/users", "application/json", `{"name":"Bob","age":-1}`, http.StatusBadRequest},
+		{http.MethodPut, path, "application/json", `{}`, http.StatusBadRequest},
+		{http.MethodPut, path, "application/json", `{"role":"admin"}`, http.StatusBadRequest},
+		{http.MethodPut, path, "application/json", `{"name":{"$gt":""}}`, http.StatusBadRequest},
+		{http.MethodPatch, path, "text/plain", `age=1`, http.StatusUnsupportedMediaType},
+		{http.MethodGet, "/users?min_age=x", "", "", http.StatusBadRequest},
+		{http.MethodGet, "/users/000000000000000000000000", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := sendUsers(h, tt.method, tt.path, tt.contentType, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s %s = %d, want %d: %s", tt.method, tt.path, tt.body, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestUserCreatedAt(t *testing.T) {
+	userCreatedAt(t, NewRouter(nil, Options{Users: store.NewMemoryUsers()}))
+}
+
+// userCreatedAt creates users through h, whose users must have none named
+// A to F, and checks the created_at they are read back with.
+func userCreatedAt(t *testing.T, h http.Handler) {
+	createdAt := func(t *testing.T, id string) string {
+		t.Helper()
+		var u map[string]any
+		decodeJSON(t, sendUsers(h, http.MethodGet, "/users/"+id, "", ""), &u)
+		s, _ := u["created_at"].(string)
+		return s
+	}
+
+	tests := []struct {
+		name, body, want string
+	}{
+		{"UTC", `{"name":"A","created_at":"2023-01-02T03:04:05Z"}`, "2023-01-02T03:04:05Z"},
+		{"offset", `{"name":"B","created_at":"2023-01-02T03:04:05+02:00"}`, "2023-01-02T01:04:05Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := sendUsers(h, http.MethodPost, "/users", "application/json", tt.body)
+			var created struct {
+				ID string `json:"id"`
+			}
+			decodeJSON(t, rec, &created)
+			if got := createdAt(t, created.ID); got != tt.want {
+				t.Errorf("created_at = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		var created struct {
+			ID string `json:"id"`
+		}
+		decodeJSON(t, sendUsers(h, http.MethodPost, "/users", "application/json", `{"name":"C"}`), &created)
+		if got := createdAt(t, created.ID); !strings.HasSuffix(got, "Z") {
+			t.Errorf("created_at = %q, want the time of creation in UTC", got)
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		body := `[{"name":"D","created_at":"2023-01-02"},{"name":"E","created_at":"2023-01-02T03:04:05+02:00"},{"name":"F","created_at":"yesterday"}]`
+		rec := sendUsers(h, http.MethodPost, "/users/import", "application/json", body)
+		var report importReport
+		decodeJSON(t, rec, &report)
+		if report.Imported != 2 || report.Failed != 1 {
+			t.Fatalf("POST /users/import = %d %s, want 2 imported and 1 failed", rec.Code, rec.Body)
+		}
+		want := map[string]string{"D": "2023-01-02T00:00:00Z", "E": "2023-01-02T01:04:05Z"}
+		for name, at := range want {
+			var list []map[string]any
+			decodeJSON(t, sendUsers(h, http.MethodGet, "/users?name="+name, "", ""), &list)
+			if len(list) != 1 || list[0]["created_at"] != at {
+				t.Errorf("imported %s = %v, want created_at %s", name, list, at)
+			}
+		}
+	})
+}
+
+func TestUserStoreErrors(t *testing.T) {
+	users := storetest.NewUsers()
+	h := NewRouter(nil, Options{Users: users})
+	const id = "000000000000000000000000"
+	boom := errors.New("boom")
+
+	tests := []struct {
+		method, path, body string
+		fail               string // the method failing with err
+		err                error
+		want               int
+		code               string
+	}{
+		{http.MethodGet, "/users/" + id, "", "Get", store.ErrNotFound, http.StatusNotFound, CodeUserNotFound},
+		{http.MethodGet, "/users/" + id, "", "Get", store.ErrInvalidID, http.StatusBadRequest, CodeInvalidID},
+		{http.MethodGet, "/users/" + id, "", "Get", fmt.Errorf("find user: %w", store.ErrNotFound), http.StatusNotFound, CodeUserNotFound},
+		{http.MethodGet, "/users/" + id, "", "Get", fmt.Errorf("find user: %w", store.ErrInvalidID), http.StatusBadRequest, CodeInvalidID},
+		{http.MethodGet, "/users/" + id, "", "Get", boom, http.StatusInternalServerError, ""},
+		{http.MethodGet, "/users", "", "List", boom, http.StatusInternalServerError, ""},
+		{http.MethodPost, "/users", `{"name":"Ada","email":"ada@example.com"}`, "Create", store.ErrDuplicateEmail, http.StatusConflict, CodeDuplicateEmail},
+		{http.MethodPost, "/users", `{"name":"Ada"}`, "Create", boom, http.StatusInternalServerError, ""},
+		{http.MethodPut, "/users/" + id, `{"name":"Ada"}`, "Update", store.ErrNotFound, http.StatusNotFound, CodeUserNotFound},
+		{http.MethodPut, "/users/" + id, `{"email":"ada@example.com"}`, "Update", store.ErrDuplicateEmail, http.StatusConflict, CodeDuplicateEmail},
+		{http.MethodDelete, "/users/" + id, "", "Delete", store.ErrNotFound, http.StatusNotFound, CodeUserNotFound},
+		{http.MethodDelete, "/users/" + id, "", "Delete", boom, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		users.Reset()
+		users.Fail(tt.fail, tt.err)
+		rec := sendUsers(h, tt.method, tt.path, "application/json", tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s with %s failing with %v = %d, want %d: %s", tt.method, tt.path, tt.fail, tt.err, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		decodeJSON(t, rec, &body)
+		if tt.code != "" && body.Code != tt.code {
+			t.Errorf("%s %s with %s failing with %v: code %q, want %q", tt.method, tt.path, tt.fail, tt.err, body.Code, tt.code)
+		}
+		if calls := users.Calls(); len(calls) == 0 || calls[len(calls)-1].Method != tt.fail {
+			t.Errorf("%s %s: calls %v, want %s last", tt.method, tt.path, calls, tt.fail)
+		}
+	}
+}
+
+// TestInvalidUsersNotStored checks that requests failing validation never
+// reach the repository.
+func TestInvalidUsersNotStored(t *testing.T) {
+	users := storetest.NewUsers()
+	h := NewRouter(nil, Options{Users: users})
+	const path = "/users/000000000000000000000000"
+
+	for _, tt := range []struct{ method, path, body string }{
+		{http.MethodPost, "/users", `{"name":"Ada","email":"ada"}`},
+		{http.MethodPost, "/users", `{"name":"Ada","age":-1}`},
+		{http.MethodPut, path, `{"name":{"$gt":""}}`},
+		{http.MethodPut, path, `{"role":"admin"}`},
+		{http.MethodPatch, path, `{"email":"ada"}`},
+	} {
+		users.Reset()
+		contentType := "application/json"
+		if tt.method == http.MethodPatch {
+			contentType = mergePatchType
+		}
+		rec := sendUsers(h, tt.method, tt.path, contentType, tt.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s %s = %d, want %d", tt.method, tt.path, tt.body, rec.Code, http.StatusBadRequest)
+		}
+		for _, c := range users.Calls() {
+			if c.Method == "Create" || c.Method == "Update" {
+				t.Errorf("%s %s %s called %s", tt.method, tt.path, tt.body, c.Method)
+			}
+		}
+	}
+}
+
+func TestUsersOfUnknownTenant(t *testing.T) {
+	users := storetest.NewUsers()
+	tenants := storetest.NewTenants(store.Tenant{ID: "acme"})
+	h := NewRouter(nil, Options{
+		Users:   users,
+		Tenants: tenants,
+		Tenancy: &TenancyOptions{Mode: "header", Header: "X-Tenant-ID"},
+	})
+	get := func(tenantID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-Tenant-ID", tenantID)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("acme"); code != http.StatusOK {
+		t.Errorf("GET /users of tenant acme = %d, want %d", code, http.StatusOK)
+	}
+	users.Reset()
+	if code := get("globex"); code != http.StatusNotFound {
+		t.Errorf("GET /users of unknown tenant = %d, want %d", code, http.StatusNotFound)
+	}
+	if calls := users.Calls(); len(calls) > 0 {
+		t.Errorf("GET /users of unknown tenant called %v", calls)
+	}
+	tenants.Fail("Get", errors.New("boom"))
+	if code := get("initech"); code != http.StatusInternalServerError {
+		t.Errorf("GET /users with the tenant lookup failing = %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestImportErrorsCapped(t *testing.T) {
+	h := NewRouter(nil, Options{Users: store.NewMemoryUsers()})
+	rows := make([]string, maxImportErrors+1)
+	for i := range rows {
+		rows[i] = `{"name":"Ada","email":"not an email"}`
+	}
+	rec := sendUsers(h, http.MethodPost, "/users/import", "application/json", "["+strings.Join(rows, ",")+"]")
+	var report importReport
+	decodeJSON(t, rec, &report)
+	if report.Failed != len(rows) || len(report.Errors) != maxImportErrors || !report.ErrorsTruncated {
+		t.Errorf("POST /users/import of %d bad rows = %d failed, %d errors, truncated %t; want %d, %d, true",
+			len(rows), report.Failed, len(report.Errors), report.ErrorsTruncated, len(rows), maxImportErrors)
+	}
+}
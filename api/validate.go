@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang/store"
+	"golang/validation"
+)
+
+// fieldError is a problem with one field of a request body.
+type fieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// validationReport is the answer of POST /users/validate.
+type validationReport struct {
+	Valid  bool         `json:"valid"`
+	Errors []fieldError `json:"errors"`
+}
+
+// userFieldErrors checks the fields of a user to create against rules,
+// localized for r.
+func userFieldErrors(rules *validation.Validator, w http.ResponseWriter, r *http.Request, u *store.User) []fieldError {
+	var errs []fieldError
+	for _, fe := range rules.Struct(u) {
+		errs = append(errs, fieldError{Field: fe.Field, Code: CodeValidationFailed, Error: localize(w, r, fe.Err.Error())})
+	}
+	return errs
+}
+
+// writeFieldErrors rejects a request body with the problems of its fields.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	body := errorBody(w, r, CodeValidationFailed, "validation failed")
+	body["fields"] = errs
+	writeJSON(w, http.StatusBadRequest, body)
+}
+
+// validateUser - POST /users/validate
+// Checks a body for POST /users as creating the user would, including
+// whether the email is taken where the store enforces unique emails, and
+// reports the problems of each field without storing anything.
+func validateUser(users store.UserRepository, rules *validation.Validator, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var in store.User
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	errs := userFieldErrors(rules, w, r, &in)
+	if checker, ok := users.(store.EmailChecker); ok && in.Email != "" && !hasField(errs, "email") {
+		ctx, cancel := opContext(r)
+		defer cancel()
+
+		taken, err := checker.EmailTaken(ctx, in.Email)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+			// Behind a cache over a store without unique emails
+		case err != nil:
+			dbError(w, r, "find", err)
+			return
+		case taken:
+			errs = append(errs, fieldError{Field: "email", Code: CodeDuplicateEmail, Error: localize(w, r, "email already in use")})
+		}
+	}
+
+	if errs == nil {
+		errs = []fieldError{}
+	}
+	writeJSON(w, http.StatusOK, validationReport{Valid: len(errs) == 0, Errors: errs})
+}
+
+// hasField reports whether errs has a problem with field.
+func hasField(errs []fieldError, field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang/db"
+	"golang/email"
+	"golang/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VerificationOptions configures email address verification.
+type VerificationOptions struct {
+	// URL is the public URL of /auth/verify the emailed link points to.
+	URL string
+	// TTL is how long a link is valid; defaults to 48h.
+	TTL time.Duration
+	// RequiredPaths are path prefixes refused to sessions of unverified
+	// users.
+	RequiredPaths []string
+}
+
+// verification is a pending confirmation of an address, stored in the
+// "email_verifications" collection. Only a hash of the token is kept.
+type verification struct {
+	TokenHash string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Email     string             `bson:"email"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+func init() {
+	expire := time.Duration(0)
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "email_verifications", Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: &expire},
+		db.IndexSpec{Collection: "email_verifications", Name: "user_id_1", Keys: bson.D{{Key: "user_id", Value: 1}}},
+	)
+}
+
+type verifier struct {
+	mc     *db.MongoClient
+	users  store.UserRepository
+	mailer *email.Mailer
+	opts   VerificationOptions
+}
+
+func newVerifier(mc *db.MongoClient, users store.UserRepository, mailer *email.Mailer, opts VerificationOptions) *verifier {
+	if opts.TTL <= 0 {
+		opts.TTL = 48 * time.Hour
+	}
+	return &verifier{mc: mc, users: users, mailer: mailer, opts: opts}
+}
+
+// start marks the address of u unverified and emails it a link to confirm
+// it.
+func (v *verifier) start(ctx context.Context, u *store.User) error {
+	oid, err := primitive.ObjectIDFromHex(u.ID)
+	if err != nil {
+		return err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if _, err := v.mc.Collection("users").UpdateByID(ctx, oid, bson.M{"$set": bson.M{"email_verified": false}},
+		options.Update().SetComment(db.Comment(ctx))); err != nil {
+		return err
+	}
+	pending := verification{
+		TokenHash: hashToken(token),
+		UserID:    oid,
+		Email:     u.Email,
+		ExpiresAt: time.Now().UTC().Add(v.opts.TTL),
+	}
+	if _, err := v.mc.Collection("email_verifications").InsertOne(ctx, pending, options.InsertOne().SetComment(db.Comment(ctx))); err != nil {
+		return err
+	}
+
+	link, err := url.Parse(v.opts.URL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+	return v.mailer.Send(ctx, u.Email, email.VerifyEmail, struct {
+		Name, Email, Link string
+		ExpiresAt         time.Time
+	}{u.Name, u.Email, link.String(), pending.ExpiresAt})
+}
+
+// verify - GET, POST /auth/verify
+// Confirms the address the token was sent to. GET takes the token query
+// parameter, so the emailed link works; POST takes {"token": ...}.
+func (v *verifier) verify(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		var in struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		token = in.Token
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if token == "" {
+		writeError(w, r, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	var pending verification
+	err := v.mc.Collection("email_verifications").FindOne(ctx, bson.M{
+		"_id":        hashToken(token),
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}, options.FindOne().SetComment(opComment(r))).Decode(&pending)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, r, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+
+	// The link only confirms the address it was sent to
+	res, err := v.mc.Collection("users").UpdateOne(ctx, bson.M{"_id": pending.UserID, "email": pending.Email},
+		bson.M{"$set": bson.M{"email_verified": true}}, options.Update().SetComment(opComment(r)))
+	if err != nil {
+		dbError(w, r, "update", err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if _, err := v.mc.Collection("email_verifications").DeleteMany(ctx, bson.M{"user_id": pending.UserID},
+		options.Delete().SetComment(opComment(r))); err != nil {
+		slog.ErrorContext(ctx, "failed to remove used verification tokens", "user_id", pending.UserID.Hex(), "error", err)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": pending.UserID.Hex(), "email_verified": true})
+}
+
+// resend - POST /auth/verify/resend
+// Sends a new link to {"email": ...} if it belongs to an unverified user.
+// The answer is always 202, so it doesn't reveal which addresses exist.
+func (v *verifier) resend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if in.Email == "" {
+		writeError(w, r, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+
+	found, err := v.users.List(ctx, store.UserFilter{Email: in.Email, Limit: 1})
+	if err != nil {
+		dbError(w, r, "find", err)
+		return
+	}
+	if len(found) == 1 && found[0].EmailVerified != nil && !*found[0].EmailVerified {
+		if err := v.start(ctx, &found[0]); err != nil {
+			slog.ErrorContext(ctx, "failed to send verification email", "user_id", found[0].ID, "error", err)
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"email": in.Email})
+}
+
+// middleware refuses requests under RequiredPaths made with the session of
+// a user whose address is unverified. It must run inside the session
+// middleware.
+func (v *verifier) middleware(next http.Handler) http.Handler {
+	if len(v.opts.RequiredPaths) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := sessionFromContext(r.Context())
+		if sess == nil || !v.required(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := opContext(r)
+		defer cancel()
+		var u struct {
+			Verified *bool `bson:"email_verified"`
+		}
+		err := v.mc.Collection("users").FindOne(ctx, bson.M{"_id": sess.UserID},
+			options.FindOne().SetProjection(bson.M{"email_verified": 1}).SetComment(opComment(r))).Decode(&u)
+		if err != nil && err != mongo.ErrNoDocuments {
+			dbError(w, r, "find", err)
+			return
+		}
+		if u.Verified != nil && !*u.Verified {
+			writeError(w, r, http.StatusForbidden, "email address not verified")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *verifier) required(path string) bool {
+	for _, p := range v.opts.RequiredPaths {
+		p = strings.TrimSuffix(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyingUsers starts the verification of the address of every user
+// created through the wrapped UserRepository, and of changed addresses.
+// Failing to start one is logged and doesn't fail the write.
+type verifyingUsers struct {
+	store.UserRepository
+	v *verifier
+}
+
+func (u *verifyingUsers) Create(ctx context.Context, user *store.User) error {
+	if err := u.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	if user.Email != "" {
+		u.start(ctx, user)
+	}
+	return nil
+}
+
+func (u *verifyingUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	if _, ok := fields["email"]; !ok {
+		return u.UserRepository.Update(ctx, id, fields)
+	}
+	before, err := u.UserRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+		return err
+	}
+	after, err := u.UserRepository.Get(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", "user_id", id, "error", err)
+		return nil
+	}
+	if after.Email != before.Email && after.Email != "" {
+		u.start(ctx, after)
+	}
+	return nil
+}
+
+func (u *verifyingUsers) start(ctx context.Context, user *store.User) {
+	if err := u.v.start(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", "user_id", user.ID, "error", err)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang/webhooks"
+)
+
+// webhooksHandler - GET, POST /admin/webhooks
+// Both take an optional tenant query parameter. The secret of a new
+// subscription is only ever returned by POST.
+func webhooksHandler(d *webhooks.Dispatcher, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := opContext(r)
+	defer cancel()
+	ctx, ok := adminTenant(ctx, r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid tenant")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		out, err := d.Subscriptions(ctx)
+		if err != nil {
+			dbError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Secret string   `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		s := webhooks.Subscription{URL: in.URL, Events: in.Events, Secret: in.Secret}
+		if err := d.Subscribe(ctx, &s); err != nil {
+			webhookError(w, r, "insert", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, struct {
+			webhooks.Subscription
+			Secret string `json:"secret"`
+		}{s, s.Secret})
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// webhookHandler - GET, DELETE /admin/webhooks/{id},
+// GET /admin/webhooks/{id}/deliveries and
+// POST /admin/webhooks/{id}/deliveries/{did}/redeliver
+// The delivery log takes offset and limit (default 50) query parameters.
+func webhookHandler(d *webhooks.Dispatcher, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/webhooks/"), "/")
+	id := parts[0]
+
+	ctx, cancel := opContext(r)
+	defer cancel()
+	ctx, ok := adminTenant(ctx, r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid tenant")
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		setRouteName(r, "/admin/webhooks/{id}")
+		switch r.Method {
+		case http.MethodGet:
+			s, err := d.Subscription(ctx, id)
+			if err != nil {
+				webhookError(w, r, "find", err)
+				return
+			}
+			writeJSON(w, http.StatusOK, s)
+		case http.MethodDelete:
+			if err := d.Unsubscribe(ctx, id); err != nil {
+				webhookError(w, r, "delete", err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"id": id})
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+
+	case len(parts) == 2 && parts[1] == "deliveries":
+		setRouteName(r, "/admin/webhooks/{id}/deliveries")
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		offset, limit := 0, 50
+		for _, p := range []struct {
+			name string
+			v    *int
+		}{{"offset", &offset}, {"limit", &limit}} {
+			if s := r.URL.Query().Get(p.name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					writeError(w, r, http.StatusBadRequest, "invalid "+p.name)
+					return
+				}
+				*p.v = n
+			}
+		}
+		if _, err := d.Subscription(ctx, id); err != nil {
+			webhookError(w, r, "find", err)
+			return
+		}
+		out, err := d.Deliveries(ctx, id, offset, limit)
+		if err != nil {
+			webhookError(w, r, "find", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "redeliver":
+		setRouteName(r, "/admin/webhooks/{id}/deliveries/{did}/redeliver")
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := d.Redeliver(ctx, id, parts[2]); err != nil {
+			webhookError(w, r, "update", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": parts[2]})
+
+	default:
+		writeError(w, r, http.StatusNotFound, "not found")
+	}
+}
+
+func webhookError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "not found")
+	case errors.Is(err, webhooks.ErrInvalid):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		dbError(w, r, op, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package authz externalizes the decision whether to serve a request to a
+// policy engine, such as Open Policy Agent or a custom HTTP endpoint.
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var decisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "authz_decisions_total",
+	Help: "Authorization decisions by result (allow, deny or error) and whether they came from the cache.",
+}, []string{"result", "cached"})
+
+// Input describes a request to decide on.
+type Input struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Route is the route pattern the request matched, e.g. "/users/".
+	Route  string `json:"route"`
+	Tenant string `json:"tenant,omitempty"`
+	// Role is the caller's role: viewer, user or admin.
+	Role string `json:"role"`
+	// Subject is who calls: "admin" for the admin token, "user:<id>" for
+	// a session, empty for anonymous callers.
+	Subject  string `json:"subject,omitempty"`
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// Decision is the answer of a policy. Reason, when set, is told to denied
+// clients.
+type Decision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Authorizer decides on requests. An error means no decision could be
+// made; callers deny the request.
+type Authorizer interface {
+	Authorize(ctx context.Context, in Input) (Decision, error)
+}
+
+// Cache remembers the decisions of the wrapped Authorizer for a TTL, so
+// repeated requests don't each ask the policy engine. Errors are not
+// cached.
+type Cache struct {
+	next Authorizer
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	d       Decision
+	expires time.Time
+}
+
+// NewCache returns next with its decisions cached for ttl, at most size
+// of them.
+func NewCache(next Authorizer, ttl time.Duration, size int) *Cache {
+	return &Cache{next: next, ttl: ttl, size: size, entries: map[string]cacheEntry{}}
+}
+
+func (c *Cache) Authorize(ctx context.Context, in Input) (Decision, error) {
+	b, _ := json.Marshal(in)
+	key := string(b)
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		decisions.WithLabelValues(result(e.d, nil), "true").Inc()
+		return e.d, nil
+	}
+
+	d, err := c.next.Authorize(ctx, in)
+	decisions.WithLabelValues(result(d, err), "false").Inc()
+	if err != nil {
+		return d, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{d: d, expires: now.Add(c.ttl)}
+	return d, nil
+}
+
+// evict drops the expired entries, or when none are, an arbitrary half.
+func (c *Cache) evict(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.size/2+1 {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
+
+// Counted is an Authorizer counting the decisions of next in the metrics,
+// for when decisions are not cached.
+type Counted struct {
+	next Authorizer
+}
+
+// NewCounted returns next counting its decisions.
+func NewCounted(next Authorizer) Counted {
+	return Counted{next: next}
+}
+
+func (c Counted) Authorize(ctx context.Context, in Input) (Decision, error) {
+	d, err := c.next.Authorize(ctx, in)
+	decisions.WithLabelValues(result(d, err), "false").Inc()
+	return d, err
+}
+
+func result(d Decision, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case d.Allow:
+		return "allow"
+	}
+	return "deny"
+}
@@ -0,0 +1,90 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxResponse bounds the policy responses read.
+const maxResponse = 1 << 20
+
+// OPA asks Open Policy Agent through its data API.
+type OPA struct {
+	url    string
+	client *http.Client
+}
+
+// NewOPA returns an Authorizer querying the policy document at url, e.g.
+// http://opa:8181/v1/data/users/allow, with the Input as input. The
+// document is a boolean or an object {allow, reason}; an undefined one
+// denies.
+func NewOPA(url string, timeout time.Duration) *OPA {
+	return &OPA{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (o *OPA) Authorize(ctx context.Context, in Input) (Decision, error) {
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := post(ctx, o.client, o.url, map[string]any{"input": in}, &out); err != nil {
+		return Decision{}, err
+	}
+	var d Decision
+	switch {
+	case len(out.Result) == 0:
+		return Decision{Reason: "no policy decision"}, nil
+	case json.Unmarshal(out.Result, &d.Allow) == nil:
+		return d, nil
+	case json.Unmarshal(out.Result, &d) == nil:
+		return d, nil
+	}
+	return Decision{}, fmt.Errorf("opa %s: result is neither a boolean nor {allow, reason}", o.url)
+}
+
+// HTTP asks a custom policy endpoint.
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP returns an Authorizer posting the Input as JSON to url, which
+// answers a Decision with a 2xx status.
+func NewHTTP(url string, timeout time.Duration) *HTTP {
+	return &HTTP{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HTTP) Authorize(ctx context.Context, in Input) (Decision, error) {
+	var d Decision
+	err := post(ctx, h.client, h.url, in, &d)
+	return d, err
+}
+
+// post sends body as JSON to url and decodes the response into out.
+func post(ctx context.Context, client *http.Client, url string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("policy %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("policy %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(out); err != nil {
+		return fmt.Errorf("policy %s: decode error: %v", url, err)
+	}
+	return nil
+}
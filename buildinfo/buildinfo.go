@@ -0,0 +1,62 @@
+// Package buildinfo identifies the build of the running binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Version, Commit and Time describe the build when set through the
+// linker, e.g.
+//
+//	go build -ldflags "-X golang/buildinfo.Version=v1.4.0 \
+//	    -X golang/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X golang/buildinfo.Time=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Those left empty are taken from what the Go toolchain embeds: the
+// module version, and the VCS revision and commit time of builds from a
+// checkout.
+var (
+	Version string
+	Commit  string
+	Time    string
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	// Dirty is true for builds from a checkout with uncommitted changes.
+	Dirty     bool   `json:"dirty,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of the running binary.
+var Get = sync.OnceValue(func() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: Time, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				info.Dirty = s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+})
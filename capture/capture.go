@@ -0,0 +1,267 @@
+// Package capture records sanitized request and response pairs in the
+// capped "captures" collection, for debugging and for replaying traffic
+// against another environment.
+//
+// Capturing is opt-in and best effort: a request is not failed because
+// its capture could not be stored. Bodies are kept only when they are
+// JSON, with the values of the redacted fields, at any depth, replaced.
+// So are the values objects name a redacted field for: those of JSON
+// Patch operations whose path goes through one, and the old and new
+// values of changes to one in user histories. The redacted query
+// parameters are replaced likewise. Credentials are never kept: only the
+// request headers in Options.Headers are.
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Redacted replaces the values of redacted fields.
+const Redacted = "[redacted]"
+
+// ErrNotFound is returned for unknown captures.
+var ErrNotFound = errors.New("capture not found")
+
+// Options configures a Recorder.
+type Options struct {
+	// SamplePercent is the percentage of requests captured.
+	SamplePercent int
+	// MaxBody is the largest body kept, in bytes; larger ones are left
+	// out.
+	MaxBody int
+	// Size caps the collection, in bytes: once full, the oldest captures
+	// make room for new ones.
+	Size int64
+	// Redact are the JSON fields and query parameters whose values are
+	// replaced, matched case-insensitively.
+	Redact []string
+	// Headers are the request headers kept, such as Content-Type and the
+	// tenant header, for replays.
+	Headers []string
+}
+
+// Exchange is a captured request and its response.
+type Exchange struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Method   string             `bson:"method" json:"method"`
+	Path     string             `bson:"path" json:"path"`
+	Query    string             `bson:"query,omitempty" json:"query,omitempty"`
+	Header   map[string]string  `bson:"header,omitempty" json:"header,omitempty"`
+	Body     string             `bson:"body,omitempty" json:"body,omitempty"`
+	// BodyOmitted says why a request body isn't kept, such as not being
+	// JSON.
+	BodyOmitted string `bson:"body_omitted,omitempty" json:"body_omitted,omitempty"`
+
+	Status              int    `bson:"status" json:"status"`
+	ResponseBody        string `bson:"response_body,omitempty" json:"response_body,omitempty"`
+	ResponseBodyOmitted string `bson:"response_body_omitted,omitempty" json:"response_body_omitted,omitempty"`
+
+	LatencyMS float64   `bson:"latency_ms" json:"latency_ms"`
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Filter selects captures to list.
+type Filter struct {
+	Method string
+	// PathPrefix selects the captures of requests to paths starting with
+	// it.
+	PathPrefix string
+	Status     int
+	// Before selects the captures older than it unless zero.
+	Before primitive.ObjectID
+	Limit  int
+}
+
+// Recorder stores captures.
+type Recorder struct {
+	mc     *db.MongoClient
+	opts   Options
+	redact map[string]bool
+}
+
+// New returns a recorder storing captures through mc.
+func New(mc *db.MongoClient, opts Options) *Recorder {
+	redact := make(map[string]bool, len(opts.Redact))
+	for _, f := range opts.Redact {
+		redact[strings.ToLower(f)] = true
+	}
+	return &Recorder{mc: mc, opts: opts, redact: redact}
+}
+
+// Options returns the options of the recorder.
+func (rec *Recorder) Options() Options {
+	return rec.opts
+}
+
+// Ensure creates the capped collection unless it exists. An existing
+// collection keeps its size.
+func (rec *Recorder) Ensure(ctx context.Context) error {
+	err := rec.mc.DB.CreateCollection(ctx, "captures", options.CreateCollection().SetCapped(true).SetSizeInBytes(rec.opts.Size))
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && ce.Code == 48 { // NamespaceExists
+		return nil
+	}
+	return err
+}
+
+// Sample reports whether to capture a request.
+func (rec *Recorder) Sample() bool {
+	return rec.opts.SamplePercent >= 100 || rand.Intn(100) < rec.opts.SamplePercent
+}
+
+// Record stores e, setting its id and time.
+func (rec *Recorder) Record(ctx context.Context, e *Exchange) error {
+	e.ID = primitive.NewObjectID()
+	e.CreatedAt = time.Now().UTC()
+	_, err := rec.mc.Collection("captures").InsertOne(ctx, e, options.InsertOne().SetComment(db.Comment(ctx)))
+	return err
+}
+
+// List returns the captures f selects, newest first.
+func (rec *Recorder) List(ctx context.Context, f Filter) ([]Exchange, error) {
+	filter := bson.M{}
+	if f.Method != "" {
+		filter["method"] = f.Method
+	}
+	if f.PathPrefix != "" {
+		filter["path"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(f.PathPrefix)}
+	}
+	if f.Status != 0 {
+		filter["status"] = f.Status
+	}
+	if !f.Before.IsZero() {
+		filter["_id"] = bson.M{"$lt": f.Before}
+	}
+	cur, err := rec.mc.ReadCollection("captures").Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(f.Limit)).
+		SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Exchange{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Get returns the capture with id.
+func (rec *Recorder) Get(ctx context.Context, id primitive.ObjectID) (*Exchange, error) {
+	var e Exchange
+	err := rec.mc.ReadCollection("captures").FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetComment(db.Comment(ctx))).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Body returns body, of media type contentType, as kept in a capture, or
+// why it is left out. tooLarge says body was cut at Options.MaxBody.
+func (rec *Recorder) Body(body []byte, contentType string, tooLarge bool) (kept, omitted string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if tooLarge {
+		return "", "larger than the capture limit"
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return "", "not json"
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", "invalid json"
+	}
+	b, err := json.Marshal(rec.redactValue(v))
+	if err != nil {
+		return "", "invalid json"
+	}
+	return string(b), ""
+}
+
+// namedValues are the keys of the values of the field an object names in
+// another key, by that key: JSON Patch operations such as
+// {"op": "replace", "path": "/email", "value": ...} and history changes
+// such as {"field": "email", "old": ..., "new": ...}.
+var namedValues = map[string][]string{
+	"path":  {"value"},
+	"field": {"old", "new"},
+}
+
+// redactValue replaces the values of redacted fields in v.
+func (rec *Recorder) redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		named := map[string]bool{}
+		for key, values := range namedValues {
+			if rec.redactedName(t[key]) {
+				for _, k := range values {
+					named[k] = true
+				}
+			}
+		}
+		for k, nested := range t {
+			if rec.redact[strings.ToLower(k)] || named[k] {
+				t[k] = Redacted
+			} else {
+				t[k] = rec.redactValue(nested)
+			}
+		}
+	case []any:
+		for i, nested := range t {
+			t[i] = rec.redactValue(nested)
+		}
+	}
+	return v
+}
+
+// redactedName reports whether v is the name of a redacted field or a
+// JSON Pointer, such as /emails/0 or /profile/email, to a value in one.
+func (rec *Recorder) redactedName(v any) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	for _, seg := range strings.Split(s, "/") {
+		seg = strings.ReplaceAll(strings.ReplaceAll(seg, "~1", "/"), "~0", "~")
+		if rec.redact[strings.ToLower(seg)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the query string q with the values of redacted parameters
+// replaced.
+func (rec *Recorder) Query(q url.Values) string {
+	for k := range q {
+		if rec.redact[strings.ToLower(k)] {
+			for i := range q[k] {
+				q[k][i] = Redacted
+			}
+		}
+	}
+	return q.Encode()
+}
@@ -0,0 +1,65 @@
+package capture
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBody(t *testing.T) {
+	rec := New(nil, Options{Redact: []string{"password", "email", "name"}})
+	tests := []struct {
+		name, contentType, body, want string
+	}{
+		{
+			"fields",
+			"application/json",
+			`{"Name":"Ada","age":36,"profile":{"email":"ada@example.com"},"tags":[{"password":"x"}]}`,
+			`{"Name":"[redacted]","age":36,"profile":{"email":"[redacted]"},"tags":[{"password":"[redacted]"}]}`,
+		},
+		{
+			"JSON Patch",
+			"application/json-patch+json",
+			`[{"op":"replace","path":"/email","value":"ada@example.org"},{"op":"test","path":"/Name","value":"Ada"},{"op":"add","path":"/profile/email","value":"a@b.c"},{"op":"replace","path":"/age","value":37},{"op":"move","from":"/email","path":"/backup"}]`,
+			`[{"op":"replace","path":"/email","value":"[redacted]"},{"op":"test","path":"/Name","value":"[redacted]"},{"op":"add","path":"/profile/email","value":"[redacted]"},{"op":"replace","path":"/age","value":37},{"from":"/email","op":"move","path":"/backup"}]`,
+		},
+		{
+			"history",
+			"application/json",
+			`{"data":[{"field":"email","old":"a@b.c","new":"d@e.f"},{"field":"age","old":36,"new":37},{"field":"password","old":null,"new":null}]}`,
+			`{"data":[{"field":"email","new":"[redacted]","old":"[redacted]"},{"field":"age","new":37,"old":36},{"field":"password","new":"[redacted]","old":"[redacted]"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, omitted := rec.Body([]byte(tt.body), tt.contentType, false)
+			if omitted != "" {
+				t.Fatalf("body omitted: %s", omitted)
+			}
+			var got, want any
+			if err := json.Unmarshal([]byte(kept), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Body = %s, want %s", kept, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		contentType, body string
+		tooLarge          bool
+		want              string
+	}{
+		{"text/plain", "email=a@b.c", false, "not json"},
+		{"application/json", "{", false, "invalid json"},
+		{"application/json", `{"email":"a@b.c"}`, true, "larger than the capture limit"},
+	} {
+		if kept, omitted := rec.Body([]byte(tt.body), tt.contentType, tt.tooLarge); kept != "" || omitted != tt.want {
+			t.Errorf("Body(%s %s) = %q, %q, want it omitted as %s", tt.contentType, tt.body, kept, omitted, tt.want)
+		}
+	}
+}
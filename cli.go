@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang/config"
+	"golang/logging"
+	"golang/secrets"
+)
+
+// command is a subcommand of the server binary.
+type command struct {
+	name    string
+	args    string // synopsis of the arguments after the flags
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the subcommands in the order usage shows them. Without
+// one the binary serves the API, so existing deployments keep working.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "run the API server (the default)", serve},
+		{"worker", "", "run background jobs without serving the API (MongoDB storage only)", worker},
+		{"migrate", "", "create the SQL schema or the MongoDB indexes and exit", migrate},
+		{"seed", "[DATASET...]", "load fixture datasets, by default the sample user", seed},
+		{"generate", "", "insert fake users for load testing", generate},
+		{"export", "", "export collections to gzip dump files", exportCmd},
+		{"import", "FILE", "import a dump file written by export, or stdin with -", importCmd},
+		{"indexes", "status|ensure", "compare the MongoDB indexes with the registry, or create the missing ones", indexesCmd},
+		{"gen", "resource NAME", "generate the store and API code of a new resource", gen},
+		{"healthcheck", "", "exit 0 if the local server is ready, else 1", healthcheck},
+		{"smoke", "", "run a create, get, update, list and delete cycle against a server", smoke},
+		{"replay", "SOURCE", "re-issue requests captured by a server, or read from a file, against another server", replay},
+		{"version", "", "print the version, commit and build time of the binary", versionCmd},
+	}
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(args); err != nil {
+				fatal(name+" failed", err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// newFlagSet returns the flag set of command name with the -config flag of
+// the commands that load the configuration.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := newBareFlagSet(name)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to an optional YAML config file")
+	return fs, configPath
+}
+
+// newBareFlagSet returns the flag set of command name, printing the
+// command's synopsis in its usage.
+func newBareFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		synopsis := ""
+		for _, c := range commands {
+			if c.name == name && c.args != "" {
+				synopsis = " " + c.args
+			}
+		}
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]%s\n\nFlags:\n", os.Args[0], name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// loadConfig resolves the secrets, loads and validates the configuration
+// and sets up logging, as every command starts with. Close the returned
+// secrets when done.
+func loadConfig(configPath string) (*config.Config, *secrets.Secrets, error) {
+	// Resolve secrets from env, *_FILE files or Vault
+	sec, err := secrets.Load(context.Background())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load secrets: %v", err)
+	}
+
+	// Load and validate all settings up front so bad config fails fast
+	cfg, err := config.Load(configPath, sec.Get)
+	if err != nil {
+		sec.Close()
+		return nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	// Configure structured logging before anything else logs
+	red := logging.Redaction{Keys: cfg.Log.RedactKeys, Patterns: cfg.Log.RedactPatterns}
+	if err := logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level, red); err != nil {
+		sec.Close()
+		return nil, nil, fmt.Errorf("invalid logging configuration: %v", err)
+	}
+	return cfg, sec, nil
+}
@@ -0,0 +1,170 @@
+// Package clientip finds the address of the client behind trusted reverse
+// proxies and load balancers, and carries it through contexts.
+//
+// Forwarding headers are only believed when the connection comes from a
+// trusted proxy, and then only as far back as the chain of trusted
+// proxies goes: the client is the last address before it, so clients
+// can't pose as another address by sending the headers themselves.
+package clientip
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type key struct{}
+
+// NewContext returns a copy of ctx carrying the client address ip.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, key{}, ip)
+}
+
+// FromContext returns the client address stored in ctx, or "".
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(key{}).(string)
+	return ip
+}
+
+// Resolver finds client addresses.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver returns a resolver trusting the proxies in trusted. Without
+// any, the client is always the peer of the connection.
+func NewResolver(trusted []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// ParsePrefixes parses addresses and CIDR ranges, such as 10.0.0.0/8 or
+// 127.0.0.1.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range res.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of r. When the peer is a
+// trusted proxy, the chain of addresses in the Forwarded header, or
+// failing that X-Forwarded-For, is walked back from the peer to the first
+// address that isn't a trusted proxy; X-Real-IP is used when a trusted
+// peer sends neither. Peers on Unix sockets, which have no address, are
+// trusted when any proxy is.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer, ok := peerAddr(r.RemoteAddr)
+	switch {
+	case ok && !res.isTrusted(peer):
+		return peer.String()
+	case !ok && len(res.trusted) == 0:
+		return r.RemoteAddr
+	}
+
+	chain := forwarded(r.Header.Values("Forwarded"))
+	if len(chain) == 0 {
+		chain = forwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(chain) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	client, known := peer, ok
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(chain[i])
+		if err != nil {
+			// Obfuscated or garbled: the hop before it is all that is
+			// known
+			break
+		}
+		client, known = addr.Unmap(), true
+		if !res.isTrusted(client) {
+			break
+		}
+	}
+	if !known {
+		return r.RemoteAddr
+	}
+	return client.String()
+}
+
+// peerAddr parses the address of a connection's peer, host:port on TCP.
+func peerAddr(remoteAddr string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	return addr.Unmap(), err == nil
+}
+
+// forwardedFor returns the addresses of X-Forwarded-For headers, client
+// first.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(s))
+		}
+	}
+	return out
+}
+
+// forwarded returns the for= addresses of RFC 7239 Forwarded headers,
+// client first, without ports and brackets. Elements without for= count
+// as unknown addresses.
+func forwarded(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			node := "unknown"
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					node = forwardedNode(strings.Trim(val, `"`))
+				}
+			}
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// forwardedNode strips the port and brackets from a Forwarded node such
+// as 192.0.2.60:8080 or [2001:db8::1]:4711.
+func forwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if host, _, ok := strings.Cut(node, ":"); ok && strings.Count(node, ":") == 1 {
+		return host
+	}
+	return node
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang/buildinfo"
+	"golang/db"
+	"golang/fixtures"
+	"golang/tenant"
+)
+
+// migrate creates what the storage backend needs before serving: the SQL
+// schema, which opening the backend applies, or the MongoDB indexes.
+func migrate(args []string) error {
+	fs, configPath := newFlagSet("migrate")
+	fs.Parse(args)
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+
+	st, err := openStorage(cfg, sec, connectTool)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+
+	if st.mongo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if _, err := st.mongo.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	slog.Info("migration complete", "backend", cfg.Storage.Backend)
+	return nil
+}
+
+// seed loads fixture datasets, the built-in "sample" by default.
+func seed(args []string) error {
+	fs, configPath := newFlagSet("seed")
+	dir := fs.String("dir", "", "directory to look for <dataset>.yaml, .yml or .json in before the built-in datasets")
+	reset := fs.Bool("reset", false, "delete all documents of the dataset's collections first (MongoDB only)")
+	tenantID := fs.String("tenant", "", "tenant to load the documents into")
+	fs.Parse(args)
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"sample"}
+	}
+	if *tenantID != "" && !tenant.Valid(*tenantID) {
+		return fmt.Errorf("invalid tenant %q", *tenantID)
+	}
+
+	var datasets []*fixtures.Dataset
+	for _, name := range names {
+		d, err := fixtures.Find(*dir, name)
+		if err != nil {
+			return err
+		}
+		datasets = append(datasets, d)
+	}
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+
+	st, err := openStorage(cfg, sec, connectTool)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	opts := fixtures.Options{Reset: *reset, Tenant: *tenantID}
+	for _, d := range datasets {
+		if err := loadDataset(ctx, st, d, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDataset applies d to the storage backend and logs the counts.
+func loadDataset(ctx context.Context, st *storage, d *fixtures.Dataset, opts fixtures.Options) error {
+	var results []fixtures.Result
+	var err error
+	if st.mongo != nil {
+		results, err = d.Apply(ctx, st.mongo, opts)
+	} else {
+		results, err = d.ApplyUsers(ctx, st.users, opts)
+	}
+	for _, r := range results {
+		slog.Info("loaded fixtures", "dataset", d.Name, "collection", r.Collection,
+			"deleted", r.Deleted, "inserted", r.Inserted, "skipped", r.Skipped)
+	}
+	if err != nil {
+		return fmt.Errorf("dataset %s: %v", d.Name, err)
+	}
+	return nil
+}
+
+// indexesCmd reports or fixes how the MongoDB indexes differ from the
+// registry.
+func indexesCmd(args []string) error {
+	fs, configPath := newFlagSet("indexes")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (fs.Arg(0) != "status" && fs.Arg(0) != "ensure") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+
+	mc, err := connectTool(cfg, sec)
+	if err != nil {
+		return err
+	}
+	defer mc.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	var status []db.IndexState
+	if fs.Arg(0) == "ensure" {
+		status, err = mc.EnsureIndexes(ctx)
+	} else {
+		status, err = mc.IndexStatus(ctx)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTION\tINDEX\tSTATE\tDETAIL")
+	for _, st := range status {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", st.Collection, st.Name, st.State, st.Detail)
+	}
+	tw.Flush()
+	return err
+}
+
+// versionCmd prints the build of the binary, as GET /version serves it.
+func versionCmd(args []string) error {
+	fs := newBareFlagSet("version")
+	fs.Parse(args)
+
+	info := buildinfo.Get()
+	fmt.Println("version:   ", info.Version)
+	fmt.Println("commit:    ", info.Commit)
+	fmt.Println("build time:", info.BuildTime)
+	fmt.Println("dirty:     ", info.Dirty)
+	fmt.Println("go:        ", info.GoVersion)
+	return nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Ways Anonymizer replaces a field.
+const (
+	FakeEmail = "email" // user-<hash>@example.com
+	FakeName  = "name"  // a made-up first and last name
+	FakeIP    = "ip"    // an address in 10.0.0.0/8
+	FakeHash  = "hash"  // a hex digest
+	FakeDrop  = "drop"  // the field is removed
+)
+
+// DefaultAnonymized are the fields holding personal data by resource
+// name, as collection:field, and how they are replaced.
+var DefaultAnonymized = map[string]string{
+	"users:email":               FakeEmail,
+	"users:name":                FakeName,
+	"users:password_hash":       FakeDrop,
+	"sessions:remote_ip":        FakeIP,
+	"sessions:user_agent":       FakeDrop,
+	"activities:client_ip":      FakeIP,
+	"email_verifications:email": FakeEmail,
+	"user_history:old":          FakeHash,
+	"user_history:new":          FakeHash,
+}
+
+// Anonymizer replaces personal data in exported documents with fake values
+// derived from the real ones through a keyed hash. The same value always
+// becomes the same fake under one key, so references and unique indexes
+// such as those on emails keep working across collections and exports,
+// while without the key the fakes can't be traced back.
+type Anonymizer struct {
+	key []byte
+	// fields are the dotted field paths to replace and how, by resource
+	// name
+	fields map[string]map[string]string
+}
+
+// NewAnonymizer returns an anonymizer hashing with key and replacing
+// DefaultAnonymized with fields applied, entries collection:field=kind.
+func NewAnonymizer(key string, fields map[string]string) (*Anonymizer, error) {
+	if key == "" {
+		return nil, fmt.Errorf("anonymizing needs a key")
+	}
+	a := &Anonymizer{key: []byte(key), fields: map[string]map[string]string{}}
+	for _, m := range []map[string]string{DefaultAnonymized, fields} {
+		for name, kind := range m {
+			coll, field, ok := strings.Cut(name, ":")
+			if !ok || coll == "" || field == "" {
+				return nil, fmt.Errorf("%s: want collection:field", name)
+			}
+			switch kind {
+			case FakeEmail, FakeName, FakeIP, FakeHash, FakeDrop:
+			default:
+				return nil, fmt.Errorf("%s: unknown kind %q, want email, name, ip, hash or drop", name, kind)
+			}
+			if a.fields[coll] == nil {
+				a.fields[coll] = map[string]string{}
+			}
+			a.fields[coll][field] = kind
+		}
+	}
+	return a, nil
+}
+
+// collections returns the resource names with fields to replace.
+func (a *Anonymizer) collections() []string {
+	out := make([]string, 0, len(a.fields))
+	for coll := range a.fields {
+		out = append(out, coll)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// apply replaces the fields of resource coll in doc.
+func (a *Anonymizer) apply(coll string, doc bson.D) bson.D {
+	for field, kind := range a.fields[coll] {
+		doc = a.replace(doc, strings.Split(field, "."), kind)
+	}
+	return doc
+}
+
+// replace replaces the field at path in doc, descending into embedded
+// documents and arrays of them.
+func (a *Anonymizer) replace(doc bson.D, path []string, kind string) bson.D {
+	for i := 0; i < len(doc); i++ {
+		if doc[i].Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			if kind == FakeDrop {
+				return append(doc[:i], doc[i+1:]...)
+			}
+			doc[i].Value = a.fakeValue(doc[i].Value, kind)
+			return doc
+		}
+		doc[i].Value = a.descend(doc[i].Value, path[1:], kind)
+		return doc
+	}
+	return doc
+}
+
+func (a *Anonymizer) descend(v any, path []string, kind string) any {
+	switch t := v.(type) {
+	case bson.D:
+		return a.replace(t, path, kind)
+	case bson.A:
+		for i, e := range t {
+			t[i] = a.descend(e, path, kind)
+		}
+	}
+	return v
+}
+
+// fakeValue replaces strings and arrays of them; other values, such as a
+// null email, are kept.
+func (a *Anonymizer) fakeValue(v any, kind string) any {
+	switch t := v.(type) {
+	case string:
+		return a.Fake(t, kind)
+	case bson.A:
+		for i, e := range t {
+			t[i] = a.fakeValue(e, kind)
+		}
+	}
+	return v
+}
+
+var (
+	fakeFirstNames = []string{"Alex", "Blair", "Casey", "Dana", "Eden", "Finley", "Gray", "Harper", "Indigo", "Jordan", "Kai", "Logan", "Morgan", "Noel", "Oakley", "Parker", "Quinn", "Reese", "Sage", "Taylor", "Umber", "Val", "Wren", "Yael"}
+	fakeLastNames  = []string{"Abara", "Bekele", "Castro", "Dimitrov", "Eriksen", "Fontaine", "Girma", "Haddad", "Ito", "Jensen", "Kowalski", "Lindqvist", "Mensah", "Novak", "Okafor", "Petrov", "Quispe", "Rossi", "Sato", "Tesfaye", "Ueda", "Varga", "Weber", "Zeleke"}
+)
+
+// Fake returns the fake of value s as kind.
+func (a *Anonymizer) Fake(s, kind string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(s))
+	sum := mac.Sum(nil)
+	switch kind {
+	case FakeEmail:
+		return "user-" + hex.EncodeToString(sum[:8]) + "@example.com"
+	case FakeName:
+		n := binary.BigEndian.Uint64(sum)
+		return fakeFirstNames[n%uint64(len(fakeFirstNames))] + " " + fakeLastNames[(n>>32)%uint64(len(fakeLastNames))]
+	case FakeIP:
+		return fmt.Sprintf("10.%d.%d.%d", sum[0], sum[1], sum[2])
+	}
+	return hex.EncodeToString(sum[:16])
+}
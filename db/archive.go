@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrArchiveRunning is returned when an archive run is started while
+// another one is in progress.
+var ErrArchiveRunning = errors.New("archive run already in progress")
+
+// ArchiveStatus describes the current or last run of an ArchiveJob.
+type ArchiveStatus struct {
+	Running    bool      `json:"running"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Cutoff     time.Time `json:"cutoff,omitempty"`
+	Moved      int64     `json:"moved"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ArchiveJob moves the documents matching a filter from one collection to
+// another in batches. Each batch is copied and deleted in one transaction,
+// so a document is never lost or in both collections; on a standalone
+// server a crash between the two steps can leave a copy behind, which the
+// next run overwrites.
+type ArchiveJob struct {
+	mc        *MongoClient
+	source    string
+	dest      string
+	batchSize int
+	filter    func(now time.Time) (bson.M, time.Time)
+
+	mu     sync.Mutex
+	status ArchiveStatus
+}
+
+// NewArchiveJob returns a job moving documents from source to dest, both
+// resources resolved like Collection.
+// filter returns the selection for a run started at now and the cutoff it
+// applies, which is reported in the status.
+func (mc *MongoClient) NewArchiveJob(source, dest string, batchSize int, filter func(now time.Time) (bson.M, time.Time)) *ArchiveJob {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &ArchiveJob{mc: mc, source: source, dest: dest, batchSize: batchSize, filter: filter}
+}
+
+// Status returns the state of the current or last run.
+func (j *ArchiveJob) Status() ArchiveStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Start begins a run in the background. It returns ErrArchiveRunning if a
+// run is in progress.
+func (j *ArchiveJob) Start(ctx context.Context) error {
+	now, err := j.begin()
+	if err != nil {
+		return err
+	}
+	go j.run(ctx, now)
+	return nil
+}
+
+// Schedule runs the job every interval until ctx is done. Ticks while a
+// run is in progress are skipped.
+func (j *ArchiveJob) Schedule(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if now, err := j.begin(); err == nil {
+				j.run(ctx, now)
+			}
+		}
+	}
+}
+
+func (j *ArchiveJob) begin() (time.Time, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Running {
+		return time.Time{}, ErrArchiveRunning
+	}
+	now := time.Now().UTC()
+	j.status = ArchiveStatus{Running: true, StartedAt: now}
+	return now, nil
+}
+
+func (j *ArchiveJob) run(ctx context.Context, now time.Time) {
+	filter, cutoff := j.filter(now)
+	j.mu.Lock()
+	j.status.Cutoff = cutoff
+	j.mu.Unlock()
+
+	var err error
+	for ctx.Err() == nil {
+		var n int
+		n, err = j.moveBatch(ctx, filter)
+		if err != nil || n == 0 {
+			break
+		}
+		j.mu.Lock()
+		j.status.Moved += int64(n)
+		j.mu.Unlock()
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	j.mu.Lock()
+	j.status.Running = false
+	j.status.FinishedAt = time.Now().UTC()
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+	st := j.status
+	j.mu.Unlock()
+
+	if err != nil {
+		slog.Error("archive run failed", "collection", j.source, "moved", st.Moved, "error", err)
+		return
+	}
+	slog.Info("archive run complete", "collection", j.source, "archive", j.dest,
+		"moved", st.Moved, "cutoff", cutoff, "duration", st.FinishedAt.Sub(st.StartedAt))
+}
+
+// moveBatch moves up to batchSize matching documents and returns how many
+// it moved.
+func (j *ArchiveJob) moveBatch(ctx context.Context, filter bson.M) (int, error) {
+	moved := 0
+	err := j.mc.WithTransaction(ctx, func(ctx context.Context) error {
+		moved = 0
+		cur, err := j.mc.Collection(j.source).Find(ctx, filter,
+			options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(j.batchSize)))
+		if err != nil {
+			return err
+		}
+		var docs []bson.Raw
+		if err := cur.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		models := make([]mongo.WriteModel, len(docs))
+		ids := make(bson.A, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc.Lookup("_id")
+			// Replace, so documents left behind by an interrupted run
+			// on a standalone server don't fail as duplicates
+			models[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": ids[i]}).
+				SetReplacement(doc).
+				SetUpsert(true)
+		}
+		if _, err := j.mc.Collection(j.dest).BulkWrite(ctx, models); err != nil {
+			return err
+		}
+		if _, err := j.mc.Collection(j.source).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		moved = len(docs)
+		return nil
+	})
+	if err != nil {
+		j.mc.Breaker.Record(err)
+	}
+	return moved, err
+}
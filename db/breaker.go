@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// ErrUnavailable is returned by Breaker.Allow while the circuit is open.
+var ErrUnavailable = errors.New("database unavailable")
+
+// Breaker is a circuit breaker in front of Mongo. After Threshold
+// consecutive failures that show the deployment is down or saturated it
+// opens and rejects work for Cooldown, then lets a single trial through
+// and closes again once Mongo answers.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	trialAt  time.Time // zero unless a half-open trial is in flight
+}
+
+// NewBreaker returns a breaker; a threshold of zero or less disables it.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether an operation may go ahead. It returns ErrUnavailable
+// and how long until the next attempt is allowed while the circuit is open.
+func (b *Breaker) Allow() (time.Duration, error) {
+	if b == nil || b.threshold <= 0 {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0, nil
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return wait, ErrUnavailable
+	}
+	// Half-open: one trial at a time. A trial that never reports back
+	// (e.g. the request failed validation) is given up after cooldown.
+	if wait := b.trialWait(); wait > 0 {
+		return wait, ErrUnavailable
+	}
+	b.trialAt = time.Now()
+	return 0, nil
+}
+
+// trialWait is how long to wait for the trial in flight, if any. The
+// trial usually settles the circuit well before it is given up, so the
+// wait is capped at a second.
+func (b *Breaker) trialWait() time.Duration {
+	if b.trialAt.IsZero() || time.Since(b.trialAt) >= b.cooldown {
+		return 0
+	}
+	return min(b.cooldown-time.Since(b.trialAt), time.Second)
+}
+
+// RetryAfter returns how long until Allow lets operations through again,
+// zero when it may now. Unlike Allow it starts no trial.
+func (b *Breaker) RetryAfter() time.Duration {
+	if b == nil || b.threshold <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return wait
+	}
+	return b.trialWait()
+}
+
+// Record reports the outcome of an operation. Only errors for which
+// Unavailable is true count as failures; any other outcome means Mongo
+// answered.
+func (b *Breaker) Record(err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	if err != nil && !Unavailable(err) {
+		err = nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if !b.openedAt.IsZero() {
+			slog.Info("database circuit closed")
+		}
+		b.failures, b.openedAt, b.trialAt = 0, time.Time{}, time.Time{}
+		return
+	}
+
+	b.failures++
+	switch {
+	case !b.openedAt.IsZero():
+		// A failed trial reopens for another cooldown
+		b.openedAt, b.trialAt = time.Now(), time.Time{}
+	case b.failures >= b.threshold:
+		b.openedAt = time.Now()
+		slog.Error("database circuit opened", "failures", b.failures, "cooldown", b.cooldown, "error", err)
+	}
+}
+
+// Trip opens the circuit at once, whatever the count of failures, when
+// Mongo is known to be unable to serve, as during a failover. An open
+// circuit keeps its cooldown.
+func (b *Breaker) Trip(reason string) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.openedAt.IsZero() {
+		return
+	}
+	b.failures, b.openedAt, b.trialAt = b.threshold, time.Now(), time.Time{}
+	slog.Error("database circuit opened", "reason", reason, "cooldown", b.cooldown)
+}
+
+// State returns "closed", "open" or "half-open".
+func (b *Breaker) State() string {
+	if b == nil || b.threshold <= 0 {
+		return "disabled"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.openedAt.IsZero():
+		return "closed"
+	case time.Since(b.openedAt) < b.cooldown:
+		return "open"
+	}
+	return "half-open"
+}
+
+// Unavailable reports whether err shows Mongo is unreachable or too busy to
+// answer in time, as opposed to rejecting the operation itself. A client
+// cancelling its request is neither.
+func Unavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) ||
+		errors.As(err, &topology.ServerSelectionError{})
+}
+
+// breakerCommandMonitor closes the circuit whenever a command succeeds.
+func breakerCommandMonitor(b *Breaker) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(context.Context, *event.CommandSucceededEvent) {
+			b.Record(nil)
+		},
+	}
+}
+
+// breakerPoolMonitor counts failed connection checkouts, which is how a
+// saturated pool shows up.
+func breakerPoolMonitor(b *Breaker) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			if e.Type == event.GetFailed && (e.Reason == event.ReasonTimedOut || e.Reason == event.ReasonConnectionErrored) {
+				b.Record(topology.WaitQueueTimeoutError{})
+			}
+		},
+	}
+}
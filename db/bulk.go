@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BulkOp is one operation of a BulkWrite. Build it with InsertOp, UpdateOp
+// or DeleteOp.
+type BulkOp struct {
+	model mongo.WriteModel
+	id    any // _id of an insert
+}
+
+// InsertOp inserts doc. An _id is generated when doc has none, so it can be
+// reported back in BulkResult.InsertedIDs.
+func InsertOp(doc bson.M) BulkOp {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	return BulkOp{model: mongo.NewInsertOneModel().SetDocument(doc), id: doc["_id"]}
+}
+
+// UpdateOp applies update (an update document such as {"$set": ...}) to the
+// first document matching filter, inserting one if upsert is set.
+func UpdateOp(filter, update bson.M, upsert bool) BulkOp {
+	return BulkOp{model: mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(upsert)}
+}
+
+// DeleteOp deletes the first document matching filter.
+func DeleteOp(filter bson.M) BulkOp {
+	return BulkOp{model: mongo.NewDeleteOneModel().SetFilter(filter)}
+}
+
+// BulkFailure reports a failed operation by its index in the request.
+type BulkFailure struct {
+	Index   int    `json:"index"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BulkResult summarizes a BulkWrite.
+type BulkResult struct {
+	Inserted int64 `json:"inserted"`
+	Matched  int64 `json:"matched"`
+	Modified int64 `json:"modified"`
+	Upserted int64 `json:"upserted"`
+	Deleted  int64 `json:"deleted"`
+
+	// InsertedIDs and UpsertedIDs map op indexes to the new _id.
+	InsertedIDs map[int]any `json:"inserted_ids,omitempty"`
+	UpsertedIDs map[int]any `json:"upserted_ids,omitempty"`
+
+	Failures []BulkFailure `json:"failures,omitempty"`
+	// NotAttempted counts the ops an ordered write skipped after its first
+	// failure.
+	NotAttempted int `json:"not_attempted,omitempty"`
+}
+
+// BulkWrite runs ops against the collection of resource coll (see
+// Collection) in one round trip per batch. Ordered
+// writes stop at the first failing op; unordered ones attempt every op.
+// Per-op failures such as duplicate keys are reported in BulkResult.Failures
+// with a nil error; the error is only set when the write as a whole failed,
+// e.g. on a network error or write concern failure.
+func (mc *MongoClient) BulkWrite(ctx context.Context, coll string, ops []BulkOp, ordered bool) (BulkResult, error) {
+	var out BulkResult
+	if len(ops) == 0 {
+		return out, nil
+	}
+
+	models := make([]mongo.WriteModel, len(ops))
+	for i, op := range ops {
+		models[i] = op.model
+	}
+
+	res, err := mc.Collection(coll).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	if res != nil {
+		out.Inserted = res.InsertedCount
+		out.Matched = res.MatchedCount
+		out.Modified = res.ModifiedCount
+		out.Upserted = res.UpsertedCount
+		out.Deleted = res.DeletedCount
+		for i, id := range res.UpsertedIDs {
+			if out.UpsertedIDs == nil {
+				out.UpsertedIDs = map[int]any{}
+			}
+			out.UpsertedIDs[int(i)] = id
+		}
+	}
+
+	failed := map[int]bool{}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		for _, we := range bwe.WriteErrors {
+			out.Failures = append(out.Failures, BulkFailure{Index: we.Index, Code: we.Code, Message: we.Message})
+			failed[we.Index] = true
+		}
+		if ordered && len(bwe.WriteErrors) > 0 {
+			out.NotAttempted = len(ops) - bwe.WriteErrors[0].Index - 1
+		}
+		if bwe.WriteConcernError == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		mc.Breaker.Record(err)
+		return out, err
+	}
+
+	last := len(ops) - out.NotAttempted
+	for i := 0; i < last; i++ {
+		if ops[i].id != nil && !failed[i] {
+			if out.InsertedIDs == nil {
+				out.InsertedIDs = map[int]any{}
+			}
+			out.InsertedIDs[i] = ops[i].id
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var changeEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mongo_change_events_total",
+	Help: "Change stream events delivered to handlers by stream, handler and result (ok or error).",
+}, []string{"stream", "handler", "result"})
+
+var changeStreamRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mongo_change_stream_restarts_total",
+	Help: "Change stream restarts after an error, by stream.",
+}, []string{"stream"})
+
+// resumeTokens is the collection holding the last processed resume token of
+// each change stream, keyed by stream name.
+const resumeTokens = "change_stream_tokens"
+
+// Restart backoff of a failed change stream.
+const (
+	changeStreamBackoff    = time.Second
+	changeStreamMaxBackoff = time.Minute
+)
+
+// Server error codes that need more than a plain restart.
+const (
+	codeNotReplicaSet     = 40573 // $changeStream on a standalone server
+	codeInvalidToken      = 260
+	codeTokenNotFound     = 280 // ChangeStreamFatalError
+	codeStreamHistoryGone = 286 // ChangeStreamHistoryLost
+)
+
+// NoChangeStreams reports whether err is the server refusing change
+// streams because it is not part of a replica set.
+func NoChangeStreams(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(codeNotReplicaSet)
+}
+
+// TokenLost reports whether err is the server failing to resume a change
+// stream from a resume token: it is malformed, or the oplog no longer
+// reaches back to it.
+func TokenLost(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) &&
+		(se.HasErrorCode(codeInvalidToken) || se.HasErrorCode(codeTokenNotFound) || se.HasErrorCode(codeStreamHistoryGone))
+}
+
+// ChangeEvent is a change stream event. FullDocument is the current
+// version of the document for inserts, replaces and updates, and nil for
+// deletes or when the document was deleted again before the lookup.
+type ChangeEvent struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.M              `bson:"documentKey"`
+	FullDocument      bson.M              `bson:"fullDocument"`
+	UpdateDescription *UpdateDescription  `bson:"updateDescription"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+}
+
+// UpdateDescription lists the fields an update changed.
+type UpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// ChangeHandler processes one event. A returned error is logged and
+// counted but does not stop the stream or keep other handlers from seeing
+// the event.
+type ChangeHandler func(ctx context.Context, e ChangeEvent) error
+
+type changeHandler struct {
+	name string
+	fn   ChangeHandler
+}
+
+// ChangeStream watches a collection and dispatches its events to the
+// registered handlers in order. The resume token is persisted after each
+// event, so after a restart or failover the stream continues where it left
+// off: handlers see every event at least once and must tolerate repeats.
+//
+// Change streams need a replica set or sharded cluster.
+type ChangeStream struct {
+	mc       *MongoClient
+	name     string
+	coll     string
+	pipeline mongo.Pipeline
+
+	mu       sync.Mutex
+	handlers []changeHandler
+}
+
+// NewChangeStream returns a stream over the collection of resource coll
+// (see Collection) filtered by pipeline (nil for all events). name
+// identifies its resume token and must be unique and stable across
+// deployments.
+func (mc *MongoClient) NewChangeStream(name, coll string, pipeline mongo.Pipeline) *ChangeStream {
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+	return &ChangeStream{mc: mc, name: name, coll: coll, pipeline: pipeline}
+}
+
+// Handle registers fn under name, which labels its log lines and metrics.
+func (s *ChangeStream) Handle(name string, fn ChangeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, changeHandler{name: name, fn: fn})
+}
+
+// Run consumes the stream until ctx is done, restarting it with backoff
+// after errors. It returns early only if the deployment cannot serve
+// change streams.
+func (s *ChangeStream) Run(ctx context.Context) {
+	attempt := 0
+	for {
+		err := s.watch(ctx, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case NoChangeStreams(err):
+			slog.Error("change streams need a replica set; stream stopped", "stream", s.name, "error", err)
+			return
+		case TokenLost(err):
+			// The oplog no longer reaches back to the token, so events
+			// were missed; continue from now rather than fail forever
+			slog.Warn("change stream cannot resume; events since the last token are skipped", "stream", s.name, "error", err)
+			if err := s.saveToken(ctx, nil); err != nil {
+				slog.Error("failed to reset resume token", "stream", s.name, "error", err)
+			}
+		}
+
+		d := backoff(attempt, changeStreamBackoff, changeStreamMaxBackoff)
+		attempt++
+		changeStreamRestarts.WithLabelValues(s.name).Inc()
+		slog.Warn("change stream failed; restarting", "stream", s.name, "error", err, "retry_in", d)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+	}
+}
+
+// watch opens the stream from the saved token and processes events until
+// an error. opened is called once the stream is established.
+func (s *ChangeStream) watch(ctx context.Context, opened func()) error {
+	token, err := s.loadToken(ctx)
+	if err != nil {
+		return err
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token != nil {
+		// StartAfter, unlike ResumeAfter, also resumes past an invalidate
+		opts.SetStartAfter(token)
+	}
+
+	cs, err := s.mc.Collection(s.coll).Watch(ctx, s.pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer cs.Close(context.WithoutCancel(ctx))
+	opened()
+	slog.Info("change stream started", "stream", s.name, "collection", s.coll, "resumed", token != nil)
+
+	for cs.Next(ctx) {
+		var e ChangeEvent
+		if err := cs.Decode(&e); err != nil {
+			slog.Error("failed to decode change event", "stream", s.name, "error", err)
+		} else {
+			s.dispatch(ctx, e)
+		}
+		if err := s.saveToken(ctx, cs.ResumeToken()); err != nil && ctx.Err() == nil {
+			// Not fatal: a restart then replays a few events
+			slog.Error("failed to save resume token", "stream", s.name, "error", err)
+		}
+	}
+	return cs.Err()
+}
+
+func (s *ChangeStream) dispatch(ctx context.Context, e ChangeEvent) {
+	s.mu.Lock()
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	for _, h := range handlers {
+		if err := h.fn(ctx, e); err != nil {
+			changeEvents.WithLabelValues(s.name, h.name, "error").Inc()
+			slog.Error("change handler failed", "stream", s.name, "handler", h.name,
+				"operation", e.OperationType, "key", e.DocumentKey, "error", err)
+			continue
+		}
+		changeEvents.WithLabelValues(s.name, h.name, "ok").Inc()
+	}
+}
+
+func (s *ChangeStream) loadToken(ctx context.Context) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.mc.Collection(resumeTokens).FindOne(ctx, bson.M{"_id": s.name}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+// saveToken stores token as the stream's position; nil starts over from
+// the current time.
+func (s *ChangeStream) saveToken(ctx context.Context, token bson.Raw) error {
+	coll := s.mc.Collection(resumeTokens)
+	if token == nil {
+		_, err := coll.DeleteOne(ctx, bson.M{"_id": s.name})
+		return err
+	}
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": s.name}, bson.M{
+		"token":      token,
+		"updated_at": time.Now().UTC(),
+	}, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ToJSON converts a decoded BSON value, such as a document decoded into
+// bson.M, to the form API responses use, so every handler renders stored
+// values alike: ObjectIDs as hex strings, dates as UTC times (RFC 3339 in
+// JSON), integers of any width as int, decimals as strings and documents
+// as maps. Other values are returned as they are.
+func ToJSON(v any) any {
+	switch t := v.(type) {
+	case bson.M:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[k] = ToJSON(e)
+		}
+		return out
+	case map[string]any:
+		return ToJSON(bson.M(t))
+	case bson.D:
+		out := make(map[string]any, len(t))
+		for _, e := range t {
+			out[e.Key] = ToJSON(e.Value)
+		}
+		return out
+	case bson.A:
+		out := make([]any, len(t))
+		for i, e := range t {
+			out[i] = ToJSON(e)
+		}
+		return out
+	case []any:
+		return ToJSON(bson.A(t))
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.DateTime:
+		return t.Time().UTC()
+	case time.Time:
+		return t.UTC()
+	case primitive.Timestamp:
+		return time.Unix(int64(t.T), 0).UTC()
+	case int32:
+		return int(t)
+	case int64:
+		return int(t)
+	case primitive.Decimal128:
+		return t.String()
+	case primitive.Binary:
+		return t.Data
+	case primitive.Regex:
+		return "/" + t.Pattern + "/" + t.Options
+	case primitive.Null, primitive.Undefined:
+		return nil
+	}
+	return v
+}
+
+// Int reads an integer stored with any width, or as a double as older
+// documents and JSON imports have them, truncated.
+func Int(v any) (int, bool) {
+	switch t := v.(type) {
+	case int32:
+		return int(t), true
+	case int64:
+		return int(t), true
+	case int:
+		return t, true
+	case float64:
+		return int(t), true
+	}
+	return 0, false
+}
+
+// Time reads a date stored as a BSON date or, as older documents have
+// them, an RFC 3339 string or extended JSON {"$date": "..."}. It returns
+// the time in UTC.
+func Time(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return t.Time().UTC(), true
+	case time.Time:
+		return t.UTC(), true
+	case string:
+		tm, err := time.Parse(time.RFC3339, t)
+		return tm.UTC(), err == nil
+	case bson.M:
+		return Time(t["$date"])
+	case bson.D:
+		if len(t) == 1 && t[0].Key == "$date" {
+			return Time(t[0].Value)
+		}
+	}
+	return time.Time{}, false
+}
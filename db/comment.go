@@ -0,0 +1,22 @@
+package db
+
+import (
+	"context"
+
+	"golang/requestid"
+)
+
+// Comment returns the $comment to tag operations made for ctx with, so
+// operations in the database profiler, currentOp and the server's slow
+// query log can be traced to the API call that issued them, e.g.
+// "request_id=3bb9... route=GET /users/{id}". It is "" outside requests.
+func Comment(ctx context.Context) string {
+	id, route := requestid.FromContext(ctx), requestid.Route(ctx)
+	switch {
+	case id == "" && route == "":
+		return ""
+	case route == "":
+		return "request_id=" + id
+	}
+	return "request_id=" + id + " route=" + route
+}
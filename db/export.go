@@ -0,0 +1,317 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// Export formats. Both round-trip every BSON type.
+const (
+	// FormatJSON is one canonical Extended JSON document per line.
+	FormatJSON = "json"
+	// FormatBSON is concatenated BSON documents, as written by mongodump.
+	FormatBSON = "bson"
+)
+
+// ExportOptions selects and formats the exported documents.
+type ExportOptions struct {
+	// Format is FormatJSON (the default) or FormatBSON.
+	Format string
+	// Since (inclusive) and Until (exclusive) restrict the export to
+	// documents whose DateField lies in the range; zero values don't
+	// restrict. DateField defaults to "created_at".
+	Since     time.Time
+	Until     time.Time
+	DateField string
+	// Anonymize, if set, replaces personal data in the exported
+	// documents, for loading production data into staging.
+	Anonymize *Anonymizer
+	// Workers serialize and compress batches of BatchSize documents
+	// concurrently; zero values use GOMAXPROCS and DefaultExportBatch.
+	Workers   int
+	BatchSize int
+}
+
+// DefaultExportBatch is the default ExportOptions.BatchSize.
+const DefaultExportBatch = 1000
+
+func (o ExportOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (o ExportOptions) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return DefaultExportBatch
+}
+
+func (o ExportOptions) filter() bson.M {
+	if o.Since.IsZero() && o.Until.IsZero() {
+		return bson.M{}
+	}
+	field := o.DateField
+	if field == "" {
+		field = "created_at"
+	}
+	r := bson.M{}
+	if !o.Since.IsZero() {
+		r["$gte"] = o.Since
+	}
+	if !o.Until.IsZero() {
+		r["$lt"] = o.Until
+	}
+	return bson.M{field: r}
+}
+
+// aborter is a writer that can discard what was written, such as an
+// upload to object storage, so a failed export leaves no partial dump.
+type aborter interface {
+	Abort() error
+}
+
+// Export streams each collection in colls, resolved like Collection, or
+// every collection of DB if colls is empty, gzip-compressed to the writer open returns
+// for it, and returns the number of documents written per collection.
+// Writers with an Abort method are aborted instead of closed when their
+// collection fails.
+//
+// On a replica set all collections are read from one snapshot (MongoDB
+// 5.0+), so the export is consistent across collections. Snapshots are only
+// kept for minSnapshotHistoryWindowInSeconds (5 minutes by default); longer
+// exports fail with SnapshotTooOld.
+func (mc *MongoClient) Export(ctx context.Context, colls []string, opts ExportOptions, open func(coll string) (io.WriteCloser, error)) (map[string]int64, error) {
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.Format != FormatJSON && opts.Format != FormatBSON {
+		return nil, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	collection := mc.Collection
+	if len(colls) == 0 {
+		collection = func(name string) *mongo.Collection { return mc.DB.Collection(name) }
+		names, err := mc.DB.ListCollectionNames(ctx, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if !strings.HasPrefix(n, "system.") {
+				colls = append(colls, n)
+			}
+		}
+	}
+
+	if mc.supportsTransactions(ctx) {
+		sess, err := mc.Client.StartSession(options.Session().SetSnapshot(true))
+		if err != nil {
+			return nil, err
+		}
+		defer sess.EndSession(context.WithoutCancel(ctx))
+		ctx = mongo.NewSessionContext(ctx, sess)
+	} else {
+		slog.WarnContext(ctx, "MongoDB is a standalone server; collections are exported without a common snapshot")
+	}
+
+	// The anonymizer's fields are by resource name; the exported
+	// collections are matched by namespace
+	anonymized := map[string]string{}
+	if opts.Anonymize != nil {
+		for _, res := range opts.Anonymize.collections() {
+			c := mc.Collection(res)
+			anonymized[c.Database().Name()+"."+c.Name()] = res
+		}
+	}
+
+	counts := map[string]int64{}
+	for _, coll := range colls {
+		w, err := open(coll)
+		if err != nil {
+			return counts, err
+		}
+		c := collection(coll)
+		n, err := mc.exportCollection(ctx, w, c, opts, anonymized[c.Database().Name()+"."+c.Name()])
+		if a, ok := w.(aborter); ok && err != nil {
+			a.Abort()
+		} else if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		counts[coll] = n
+		if err != nil {
+			return counts, fmt.Errorf("export %s: %w", coll, err)
+		}
+	}
+	return counts, nil
+}
+
+// exportCollection writes the documents of coll; fields of resource res
+// are anonymized when it is set.
+func (mc *MongoClient) exportCollection(ctx context.Context, w io.Writer, coll *mongo.Collection, opts ExportOptions, res string) (int64, error) {
+	cur, err := coll.Find(ctx, opts.filter(),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(int32(opts.batchSize())))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	return exportDocuments(ctx, w, func(ctx context.Context) (bson.Raw, error) {
+		if cur.Next(ctx) {
+			return cur.Current, nil
+		}
+		if err := cur.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}, opts, res)
+}
+
+// exportDocuments writes the documents next returns until io.EOF. Batches
+// of documents are serialized and compressed concurrently, each as a gzip
+// member of its own, and written in the order next returned them. Calling
+// next waits while opts.Workers batches are pending, so memory holds at most
+// Workers+2 batches.
+func exportDocuments(ctx context.Context, w io.Writer, next func(context.Context) (bson.Raw, error), opts ExportOptions, res string) (int64, error) {
+	workers, size := opts.workers(), opts.batchSize()
+	g, gctx := errgroup.WithContext(ctx)
+	jobs := make(chan *exportBatch)
+	// pending holds the batches in cursor order for the writer
+	pending := make(chan *exportBatch, workers)
+	g.Go(func() error {
+		defer close(jobs)
+		defer close(pending)
+		send := func(b *exportBatch) error {
+			for _, ch := range []chan *exportBatch{pending, jobs} {
+				select {
+				case ch <- b:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+			return nil
+		}
+		b := newExportBatch(size)
+		for {
+			doc, err := next(gctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			// A cursor's Current is only valid until the next batch is fetched
+			b.docs = append(b.docs, bson.Raw(bytes.Clone(doc)))
+			if len(b.docs) < size {
+				continue
+			}
+			if err := send(b); err != nil {
+				return err
+			}
+			b = newExportBatch(size)
+		}
+		if len(b.docs) == 0 {
+			return nil
+		}
+		return send(b)
+	})
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			for b := range jobs {
+				b.out <- opts.encode(b.docs, res)
+			}
+			return nil
+		})
+	}
+	var n int64
+	g.Go(func() error {
+		for b := range pending {
+			var r exportResult
+			select {
+			case r = <-b.out:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			if r.err != nil {
+				return r.err
+			}
+			if _, err := w.Write(r.data); err != nil {
+				return err
+			}
+			n += int64(len(b.docs))
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		// An empty collection is still a valid gzip file
+		return 0, gzip.NewWriter(w).Close()
+	}
+	return n, nil
+}
+
+// exportBatch is a batch of documents in the export pipeline; out receives
+// its encoded form.
+type exportBatch struct {
+	docs []bson.Raw
+	out  chan exportResult
+}
+
+func newExportBatch(size int) *exportBatch {
+	return &exportBatch{docs: make([]bson.Raw, 0, size), out: make(chan exportResult, 1)}
+}
+
+type exportResult struct {
+	data []byte
+	err  error
+}
+
+// encode returns docs in the export format as a gzip member, anonymized as
+// resource res when it is set.
+func (o ExportOptions) encode(docs []bson.Raw, res string) exportResult {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, raw := range docs {
+		if res != "" {
+			var doc bson.D
+			if err := bson.Unmarshal(raw, &doc); err != nil {
+				return exportResult{err: err}
+			}
+			var err error
+			if raw, err = bson.Marshal(o.Anonymize.apply(res, doc)); err != nil {
+				return exportResult{err: err}
+			}
+		}
+		if o.Format == FormatBSON {
+			if _, err := zw.Write(raw); err != nil {
+				return exportResult{err: err}
+			}
+			continue
+		}
+		line, err := bson.MarshalExtJSON(raw, true, false)
+		if err != nil {
+			return exportResult{err: err}
+		}
+		if _, err := zw.Write(append(line, '\n')); err != nil {
+			return exportResult{err: err}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return exportResult{err: err}
+	}
+	return exportResult{data: buf.Bytes()}
+}
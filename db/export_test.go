@@ -0,0 +1,173 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// documents returns a source of n documents numbered from 0 for
+// exportDocuments. Documents past fail, if set, return its error.
+func documents(t *testing.T, n, fail int, err error) func(context.Context) (bson.Raw, error) {
+	t.Helper()
+	i := 0
+	return func(ctx context.Context) (bson.Raw, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err != nil && i == fail {
+			return nil, err
+		}
+		if i == n {
+			return nil, io.EOF
+		}
+		doc, merr := bson.Marshal(bson.D{{Key: "_id", Value: int32(i)}, {Key: "at", Value: time.Unix(int64(i), 0)}})
+		if merr != nil {
+			t.Fatal(merr)
+		}
+		i++
+		return doc, nil
+	}
+}
+
+// checkGoroutines fails t if goroutines started during it are still running
+// when it ends.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutines left running", runtime.NumGoroutine()-before)
+				return
+			}
+		}
+	})
+}
+
+// failingWriter fails the writes after the first ok.
+type failingWriter struct {
+	ok  int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.ok == 0 {
+		return 0, w.err
+	}
+	w.ok--
+	return len(p), nil
+}
+
+func TestExportDocuments(t *testing.T) {
+	ctx := context.Background()
+
+	for _, format := range []string{FormatJSON, FormatBSON} {
+		t.Run(format, func(t *testing.T) {
+			checkGoroutines(t)
+			var buf bytes.Buffer
+			opts := ExportOptions{Format: format, Workers: 4, BatchSize: 3}
+			n, err := exportDocuments(ctx, &buf, documents(t, 100, 0, nil), opts, "")
+			if err != nil || n != 100 {
+				t.Fatalf("exportDocuments = %d, %v, want 100 documents", n, err)
+			}
+
+			// Each batch is a gzip member; import reads them all, in order
+			next, err := documentReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; ; i++ {
+				doc, err := next()
+				if err == io.EOF {
+					if i != 100 {
+						t.Errorf("read %d documents, want 100", i)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("document %d: %v", i, err)
+				}
+				var got struct {
+					ID int32     `bson:"_id"`
+					At time.Time `bson:"at"`
+				}
+				if err := bson.Unmarshal(doc, &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.ID != int32(i) || !got.At.Equal(time.Unix(int64(i), 0)) {
+					t.Fatalf("document %d = %+v, want _id %d", i, got, i)
+				}
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		if n, err := exportDocuments(ctx, &buf, documents(t, 0, 0, nil), ExportOptions{}, ""); err != nil || n != 0 {
+			t.Fatalf("exportDocuments = %d, %v, want no documents", n, err)
+		}
+		next, err := documentReader(&buf, FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := next(); err != io.EOF {
+			t.Errorf("reading an empty export = %v, want io.EOF", err)
+		}
+	})
+
+	errRead := errors.New("read failed")
+	errWrite := errors.New("write failed")
+	tests := []struct {
+		name string
+		next func(context.Context) (bson.Raw, error)
+		w    io.Writer
+		want error
+	}{
+		{"read error", documents(t, 100, 50, errRead), io.Discard, errRead},
+		{"write error", documents(t, 100, 0, nil), &failingWriter{ok: 2, err: errWrite}, errWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			opts := ExportOptions{Workers: 4, BatchSize: 3}
+			if _, err := exportDocuments(ctx, tt.w, tt.next, opts, ""); !errors.Is(err, tt.want) {
+				t.Errorf("exportDocuments = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("encode error", func(t *testing.T) {
+		checkGoroutines(t)
+		next := func(context.Context) (bson.Raw, error) { return bson.Raw("not bson"), nil }
+		if _, err := exportDocuments(ctx, io.Discard, next, ExportOptions{Workers: 4, BatchSize: 3}, ""); err == nil {
+			t.Error("exportDocuments of invalid documents succeeded")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		checkGoroutines(t)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		// An endless source, cancelled once the export is under way
+		var i int32
+		next := func(ctx context.Context) (bson.Raw, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if i++; i == 50 {
+				cancel()
+			}
+			return bson.Marshal(bson.D{{Key: "_id", Value: i}})
+		}
+		if _, err := exportDocuments(ctx, io.Discard, next, ExportOptions{Workers: 4, BatchSize: 3}, ""); !errors.Is(err, context.Canceled) {
+			t.Errorf("exportDocuments = %v, want %v", err, context.Canceled)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// healthPingTimeout bounds each background ping.
+const healthPingTimeout = 2 * time.Second
+
+// healthState records the outcome of the last background ping.
+type healthState struct {
+	mu      sync.RWMutex
+	checked time.Time
+	err     error
+}
+
+// MonitorHealth pings the deployment every interval until ctx is done and
+// logs when the connection is lost or restored. Health reports the result.
+func (mc *MongoClient) MonitorHealth(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		pctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+		err := mc.Client.Ping(pctx, nil)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		mc.setHealth(err)
+		mc.Breaker.Record(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (mc *MongoClient) setHealth(err error) {
+	mc.health.mu.Lock()
+	first := mc.health.checked.IsZero()
+	wasUp := mc.health.err == nil
+	mc.health.checked = time.Now()
+	mc.health.err = err
+	mc.health.mu.Unlock()
+
+	switch {
+	case err != nil && (wasUp || first):
+		slog.Error("lost connection to MongoDB", "error", err)
+	case err == nil && !wasUp && !first:
+		slog.Info("connection to MongoDB restored")
+	}
+}
+
+// Health returns the result of the last background ping and when it ran.
+// The time is zero until MonitorHealth has completed a ping.
+func (mc *MongoClient) Health() (time.Time, error) {
+	mc.health.mu.RLock()
+	defer mc.health.mu.RUnlock()
+	return mc.health.checked, mc.health.err
+}
+
+// backoff returns the wait before retry number attempt (starting at 0):
+// exponential growth from base capped at max, with jitter over the upper
+// half so restarting replicas don't retry in lockstep.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Conflict strategies of Import for documents whose _id already exists.
+const (
+	ConflictSkip      = "skip"      // keep the stored document
+	ConflictOverwrite = "overwrite" // replace it with the imported one
+	ConflictFail      = "fail"      // stop the import
+)
+
+// duplicateKey is the server error code of a unique index violation.
+const duplicateKey = 11000
+
+// maxImportDocument bounds a BSON document read from a dump; the server
+// limit is 16 MiB.
+const maxImportDocument = 16 << 20
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	// Format is FormatJSON (the default) or FormatBSON, as written by
+	// Export.
+	Format string
+	// Conflict is ConflictSkip, ConflictOverwrite or ConflictFail (the
+	// default).
+	Conflict string
+	// BatchSize is the number of documents written per round trip;
+	// defaults to 1000.
+	BatchSize int
+	// Progress, if set, is called after every batch with the totals so far.
+	Progress func(ImportProgress)
+}
+
+// ImportProgress counts the documents an import has handled.
+type ImportProgress struct {
+	Read     int64 `json:"read"`
+	Inserted int64 `json:"inserted"`
+	Replaced int64 `json:"replaced"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// Import streams documents from r, gzip-compressed or not, into the
+// collection of resource coll (see Collection). The
+// import is not atomic: when it fails, the batches before the failure stay
+// written.
+func (mc *MongoClient) Import(ctx context.Context, r io.Reader, coll string, opts ImportOptions) (ImportProgress, error) {
+	var p ImportProgress
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictFail
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	switch opts.Conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return p, fmt.Errorf("unknown conflict strategy %q", opts.Conflict)
+	}
+
+	next, err := documentReader(r, opts.Format)
+	if err != nil {
+		return p, err
+	}
+
+	batch := make([]BulkOp, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		// Only a failing import needs to stop at the first conflict
+		res, err := mc.BulkWrite(ctx, coll, batch, opts.Conflict == ConflictFail)
+		if err != nil {
+			return err
+		}
+		p.Inserted += res.Inserted + res.Upserted
+		p.Replaced += res.Matched
+		for _, f := range res.Failures {
+			if f.Code == duplicateKey && opts.Conflict == ConflictSkip {
+				p.Skipped++
+				continue
+			}
+			return fmt.Errorf("document %d: %s", p.Read-int64(len(batch))+int64(f.Index)+1, f.Message)
+		}
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+		return nil
+	}
+
+	for {
+		doc, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, fmt.Errorf("document %d: %w", p.Read+1, err)
+		}
+		p.Read++
+		batch = append(batch, importOp(doc, opts.Conflict))
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return p, err
+			}
+		}
+	}
+	return p, flush()
+}
+
+// importOp returns the write of doc for the conflict strategy.
+func importOp(doc bson.Raw, conflict string) BulkOp {
+	id, err := doc.LookupErr("_id")
+	if conflict == ConflictOverwrite && err == nil {
+		return BulkOp{model: mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetReplacement(doc).
+			SetUpsert(true)}
+	}
+	op := BulkOp{model: mongo.NewInsertOneModel().SetDocument(doc)}
+	if err == nil {
+		op.id = id
+	}
+	return op
+}
+
+// documentReader returns a function reading the next document of r in
+// format, and io.EOF at the end. Gzip input is detected by its magic bytes.
+func documentReader(r io.Reader, format string) (func() (bson.Raw, error), error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	switch format {
+	case FormatJSON:
+		sc := bufio.NewScanner(br)
+		sc.Buffer(make([]byte, 64*1024), 4*maxImportDocument)
+		return func() (bson.Raw, error) {
+			for sc.Scan() {
+				line := bytes.TrimSpace(sc.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				var doc bson.Raw
+				err := bson.UnmarshalExtJSON(line, false, &doc)
+				return doc, err
+			}
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}, nil
+
+	case FormatBSON:
+		return func() (bson.Raw, error) {
+			var size [4]byte
+			if _, err := io.ReadFull(br, size[:]); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					return nil, errors.New("truncated document")
+				}
+				return nil, err
+			}
+			n := binary.LittleEndian.Uint32(size[:])
+			if n < 5 || n > maxImportDocument {
+				return nil, fmt.Errorf("invalid document size %d", n)
+			}
+			doc := make([]byte, n)
+			copy(doc, size[:])
+			if _, err := io.ReadFull(br, doc[4:]); err != nil {
+				return nil, errors.New("truncated document")
+			}
+			return bson.Raw(doc), bson.Raw(doc).Validate()
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
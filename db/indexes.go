@@ -0,0 +1,446 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrUnknownIndex is returned by RebuildIndex for indexes not in the
+	// registry.
+	ErrUnknownIndex = errors.New("index is not registered")
+	// ErrIndexNotFound is returned for indexes or collections that don't
+	// exist.
+	ErrIndexNotFound = errors.New("index not found")
+	// ErrRegisteredIndex is returned by CreateIndex and DropIndex for the
+	// indexes of the registry, which EnsureIndexes and RebuildIndex
+	// manage, and for _id_.
+	ErrRegisteredIndex = errors.New("index is managed by the application")
+	// ErrInvalidIndex is returned by CreateIndex for specs the server
+	// rejects.
+	ErrInvalidIndex = errors.New("invalid index")
+	// ErrIndexConflict is returned by CreateIndex when another index has
+	// the name or the keys, or the data violates a unique index.
+	ErrIndexConflict = errors.New("index conflicts with existing indexes or data")
+)
+
+// IndexSpec declares an index the application relies on. Keys take the
+// usual Mongo forms: 1/-1 for ordered indexes, "text" for text search and
+// "2dsphere" for geo queries.
+type IndexSpec struct {
+	// Collection is the resource whose collection is indexed; see
+	// MongoClient.Collection.
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// ExpireAfter makes a TTL index over a single date field; documents are
+	// removed this long after the field's time.
+	ExpireAfter *time.Duration
+	// Partial limits the index to documents matching this filter.
+	Partial bson.M
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.ExpireAfter != nil {
+		opts.SetExpireAfterSeconds(int32(s.ExpireAfter.Seconds()))
+	}
+	if s.Partial != nil {
+		opts.SetPartialFilterExpression(s.Partial)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+func (s IndexSpec) isText() bool {
+	for _, k := range s.Keys {
+		if k.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+var indexRegistry struct {
+	mu    sync.Mutex
+	specs []IndexSpec
+}
+
+// RegisterIndexes adds specs to the registry ensured by EnsureIndexes.
+// Packages call it from init for the collections they own.
+func RegisterIndexes(specs ...IndexSpec) {
+	indexRegistry.mu.Lock()
+	defer indexRegistry.mu.Unlock()
+	indexRegistry.specs = append(indexRegistry.specs, specs...)
+}
+
+func registeredIndexes() []IndexSpec {
+	indexRegistry.mu.Lock()
+	defer indexRegistry.mu.Unlock()
+	return append([]IndexSpec(nil), indexRegistry.specs...)
+}
+
+// Index states reported by IndexStatus.
+const (
+	IndexOK        = "ok"
+	IndexMissing   = "missing"
+	IndexDrift     = "drift"     // exists under the name but differs from the spec
+	IndexUnmanaged = "unmanaged" // exists but is not in the registry
+)
+
+// IndexState compares one index with the registry.
+type IndexState struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// IndexStatus compares the registered specs with the indexes that exist.
+func (mc *MongoClient) IndexStatus(ctx context.Context) ([]IndexState, error) {
+	specs := registeredIndexes()
+	byColl := map[string][]IndexSpec{}
+	for _, s := range specs {
+		byColl[s.Collection] = append(byColl[s.Collection], s)
+	}
+	colls := make([]string, 0, len(byColl))
+	for c := range byColl {
+		colls = append(colls, c)
+	}
+	sort.Strings(colls)
+
+	var out []IndexState
+	for _, coll := range colls {
+		existing, err := mc.listIndexes(ctx, coll)
+		if err != nil {
+			return nil, err
+		}
+		managed := map[string]bool{"_id_": true}
+		for _, s := range byColl[coll] {
+			managed[s.Name] = true
+			st := IndexState{Collection: coll, Name: s.Name, State: IndexOK}
+			if ex, ok := existing[s.Name]; !ok {
+				st.State = IndexMissing
+			} else if diff := indexDiff(s, ex); diff != "" {
+				st.State, st.Detail = IndexDrift, diff
+			}
+			out = append(out, st)
+		}
+		names := make([]string, 0, len(existing))
+		for name := range existing {
+			if !managed[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			out = append(out, IndexState{Collection: coll, Name: name, State: IndexUnmanaged})
+		}
+	}
+	return out, nil
+}
+
+// EnsureIndexes creates missing registered indexes and logs drift. Building
+// an index that already exists with the same spec is a no-op, so this is
+// safe on every startup. Indexes that drifted are left alone; use
+// RebuildIndex to replace them.
+func (mc *MongoClient) EnsureIndexes(ctx context.Context) ([]IndexState, error) {
+	status, err := mc.IndexStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	specs := map[string]IndexSpec{}
+	for _, s := range registeredIndexes() {
+		specs[s.Collection+"."+s.Name] = s
+	}
+
+	var errs []error
+	for i, st := range status {
+		switch st.State {
+		case IndexMissing:
+			s := specs[st.Collection+"."+st.Name]
+			if _, err := mc.Collection(s.Collection).Indexes().CreateOne(ctx, s.model()); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %v", s.Collection, s.Name, err))
+				continue
+			}
+			status[i].State = IndexOK
+			slog.Info("created index", "collection", s.Collection, "index", s.Name)
+		case IndexDrift:
+			slog.Warn("index differs from its spec", "collection", st.Collection, "index", st.Name, "detail", st.Detail)
+		case IndexUnmanaged:
+			slog.Info("index not in registry", "collection", st.Collection, "index", st.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return status, fmt.Errorf("failed to create indexes: %v", errs)
+	}
+	return status, nil
+}
+
+// RebuildIndex drops the registered index coll.name, if present, and
+// creates it again from its spec.
+func (mc *MongoClient) RebuildIndex(ctx context.Context, coll, name string) error {
+	for _, s := range registeredIndexes() {
+		if s.Collection != coll || s.Name != name {
+			continue
+		}
+		iv := mc.Collection(coll).Indexes()
+		if _, err := iv.DropOne(ctx, name); err != nil {
+			var ce mongo.CommandError
+			// 27 IndexNotFound, 26 NamespaceNotFound
+			if !(errors.As(err, &ce) && (ce.Code == 27 || ce.Code == 26)) {
+				return fmt.Errorf("failed to drop index %s.%s: %v", coll, name, err)
+			}
+		}
+		if _, err := iv.CreateOne(ctx, s.model()); err != nil {
+			return fmt.Errorf("failed to create index %s.%s: %v", coll, name, err)
+		}
+		slog.Info("rebuilt index", "collection", coll, "index", name)
+		return nil
+	}
+	return fmt.Errorf("%w: %s.%s", ErrUnknownIndex, coll, name)
+}
+
+// listIndexes returns the existing indexes of coll by name.
+func (mc *MongoClient) listIndexes(ctx context.Context, coll string) (map[string]bson.M, error) {
+	cur, err := mc.Collection(coll).Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes of %s: %v", coll, err)
+	}
+	var all []bson.M
+	if err := cur.All(ctx, &all); err != nil {
+		return nil, fmt.Errorf("failed to list indexes of %s: %v", coll, err)
+	}
+	out := make(map[string]bson.M, len(all))
+	for _, ix := range all {
+		if name, ok := ix["name"].(string); ok {
+			out[name] = ix
+		}
+	}
+	return out, nil
+}
+
+// indexDiff describes how the existing index ex differs from s, or returns
+// "" when they match.
+func indexDiff(s IndexSpec, ex bson.M) string {
+	key, _ := ex["key"].(bson.M)
+	if s.isText() {
+		if _, ok := key["_fts"]; !ok {
+			return "expected a text index"
+		}
+	} else {
+		if len(key) != len(s.Keys) {
+			return fmt.Sprintf("keys %v, want %v", key, s.Keys)
+		}
+		for _, k := range s.Keys {
+			if fmt.Sprint(key[k.Key]) != fmt.Sprint(k.Value) {
+				return fmt.Sprintf("keys %v, want %v", key, s.Keys)
+			}
+		}
+	}
+
+	unique, _ := ex["unique"].(bool)
+	if unique != s.Unique {
+		return fmt.Sprintf("unique=%v, want %v", unique, s.Unique)
+	}
+
+	exp, hasTTL := ex["expireAfterSeconds"]
+	switch {
+	case s.ExpireAfter == nil && hasTTL:
+		return "unexpected TTL"
+	case s.ExpireAfter != nil && !hasTTL:
+		return "missing TTL"
+	case s.ExpireAfter != nil && fmt.Sprint(exp) != fmt.Sprint(int32(s.ExpireAfter.Seconds())):
+		return fmt.Sprintf("TTL %vs, want %vs", exp, int32(s.ExpireAfter.Seconds()))
+	}
+
+	_, hasPartial := ex["partialFilterExpression"]
+	if hasPartial != (s.Partial != nil) {
+		return "partial filter differs"
+	}
+	return ""
+}
+
+// IndexInfo is an existing index and how often it was used.
+type IndexInfo struct {
+	Name string `json:"name"`
+	// Keys are the fields and kinds of the index in order, e.g.
+	// [{"field": "email", "value": 1}].
+	Keys               []IndexKey     `json:"keys"`
+	Unique             bool           `json:"unique,omitempty"`
+	Sparse             bool           `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int64         `json:"expire_after_seconds,omitempty"`
+	Partial            map[string]any `json:"partial,omitempty"`
+	// Registered is set for the indexes of the registry.
+	Registered bool `json:"registered"`
+	// Usage has one entry per server that used the index.
+	Usage []IndexUsage `json:"usage"`
+}
+
+// IndexKey is a key of an index: its field and 1, -1, "text",
+// "2dsphere" or "hashed".
+type IndexKey struct {
+	Field string `json:"field"`
+	Value any    `json:"value"`
+}
+
+// IndexUsage is what $indexStats reports of an index on one server: the
+// operations that used it since the server started or the index was
+// built.
+type IndexUsage struct {
+	Host  string    `json:"host"`
+	Ops   int64     `json:"ops"`
+	Since time.Time `json:"since"`
+}
+
+// Indexes returns the indexes of coll, by name, with their usage.
+func (mc *MongoClient) Indexes(ctx context.Context, coll string) ([]IndexInfo, error) {
+	cur, err := mc.Collection(coll).Indexes().List(ctx)
+	if err != nil {
+		return nil, indexError(coll, "", err)
+	}
+	var all []struct {
+		Name               string `bson:"name"`
+		Key                bson.D `bson:"key"`
+		Unique             bool   `bson:"unique"`
+		Sparse             bool   `bson:"sparse"`
+		ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+		Partial            bson.M `bson:"partialFilterExpression"`
+	}
+	if err := cur.All(ctx, &all); err != nil {
+		return nil, fmt.Errorf("failed to list indexes of %s: %v", coll, err)
+	}
+
+	usage, err := mc.indexUsage(ctx, coll)
+	if err != nil {
+		return nil, err
+	}
+	registered := map[string]bool{}
+	for _, s := range registeredIndexes() {
+		if s.Collection == coll {
+			registered[s.Name] = true
+		}
+	}
+
+	out := make([]IndexInfo, len(all))
+	for i, ix := range all {
+		keys := make([]IndexKey, len(ix.Key))
+		for j, k := range ix.Key {
+			keys[j] = IndexKey{Field: k.Key, Value: ToJSON(k.Value)}
+		}
+		u := usage[ix.Name]
+		if u == nil {
+			u = []IndexUsage{}
+		}
+		out[i] = IndexInfo{
+			Name:               ix.Name,
+			Keys:               keys,
+			Unique:             ix.Unique,
+			Sparse:             ix.Sparse,
+			ExpireAfterSeconds: ix.ExpireAfterSeconds,
+			Partial:            ToJSON(ix.Partial).(map[string]any),
+			Registered:         registered[ix.Name],
+			Usage:              u,
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// indexUsage runs $indexStats on coll and returns the usage of its indexes
+// by name.
+func (mc *MongoClient) indexUsage(ctx context.Context, coll string) (map[string][]IndexUsage, error) {
+	cur, err := mc.Collection(coll).Aggregate(ctx, mongo.Pipeline{{{Key: "$indexStats", Value: bson.M{}}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index stats of %s: %v", coll, err)
+	}
+	var stats []struct {
+		Name     string `bson:"name"`
+		Host     string `bson:"host"`
+		Accesses struct {
+			Ops   int64     `bson:"ops"`
+			Since time.Time `bson:"since"`
+		} `bson:"accesses"`
+	}
+	if err := cur.All(ctx, &stats); err != nil {
+		return nil, fmt.Errorf("failed to read index stats of %s: %v", coll, err)
+	}
+	out := map[string][]IndexUsage{}
+	for _, st := range stats {
+		out[st.Name] = append(out[st.Name], IndexUsage{Host: st.Host, Ops: st.Accesses.Ops, Since: st.Accesses.Since})
+	}
+	return out, nil
+}
+
+// CreateIndex builds an index outside the registry, such as one tried
+// while tuning a query. It waits for the build to finish.
+func (mc *MongoClient) CreateIndex(ctx context.Context, s IndexSpec) error {
+	if s.Name == "_id_" || isRegistered(s.Collection, s.Name) {
+		return fmt.Errorf("%w: %s.%s", ErrRegisteredIndex, s.Collection, s.Name)
+	}
+	if _, err := mc.Collection(s.Collection).Indexes().CreateOne(ctx, s.model()); err != nil {
+		return indexError(s.Collection, s.Name, err)
+	}
+	slog.Info("created index", "collection", s.Collection, "index", s.Name)
+	return nil
+}
+
+// DropIndex drops the index coll.name. Indexes of the registry are only
+// dropped with force, and are created again on the next start; _id_ never
+// is.
+func (mc *MongoClient) DropIndex(ctx context.Context, coll, name string, force bool) error {
+	if name == "_id_" || (isRegistered(coll, name) && !force) {
+		return fmt.Errorf("%w: %s.%s", ErrRegisteredIndex, coll, name)
+	}
+	if _, err := mc.Collection(coll).Indexes().DropOne(ctx, name); err != nil {
+		return indexError(coll, name, err)
+	}
+	slog.Info("dropped index", "collection", coll, "index", name)
+	return nil
+}
+
+func isRegistered(coll, name string) bool {
+	for _, s := range registeredIndexes() {
+		if s.Collection == coll && s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// indexError classifies the error of an index command on coll.name.
+func indexError(coll, name string, err error) error {
+	target := coll
+	if name != "" {
+		target += "." + name
+	}
+	var ce mongo.CommandError
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %v", ErrIndexConflict, err)
+	case !errors.As(err, &ce):
+	// 26 NamespaceNotFound, 27 IndexNotFound
+	case ce.Code == 26 || ce.Code == 27:
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, target)
+	// 85 IndexOptionsConflict, 86 IndexKeySpecsConflict
+	case ce.Code == 85 || ce.Code == 86:
+		return fmt.Errorf("%w: %s", ErrIndexConflict, ce.Message)
+	// 2 BadValue, 9 FailedToParse, 67 CannotCreateIndex,
+	// 197 InvalidIndexSpecificationOption
+	case ce.Code == 2 || ce.Code == 9 || ce.Code == 67 || ce.Code == 197:
+		return fmt.Errorf("%w: %s", ErrInvalidIndex, ce.Message)
+	}
+	return fmt.Errorf("index command on %s failed: %v", target, err)
+}
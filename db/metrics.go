@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "mongo_command_duration_seconds",
+	Help:    "Duration of MongoDB commands by command name and outcome.",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+}, []string{"command", "outcome"})
+
+// PoolStats is a snapshot of the driver connection pools across all servers
+// and clients.
+type PoolStats struct {
+	Open           int64 `json:"open"`
+	InUse          int64 `json:"in_use"`
+	CheckoutFailed int64 `json:"checkout_failed"`
+	PoolCleared    int64 `json:"pool_cleared"`
+}
+
+var pool struct {
+	open, inUse, checkoutFailed, cleared atomic.Int64
+}
+
+// Pool returns the current connection pool counters.
+func Pool() PoolStats {
+	return PoolStats{
+		Open:           pool.open.Load(),
+		InUse:          pool.inUse.Load(),
+		CheckoutFailed: pool.checkoutFailed.Load(),
+		PoolCleared:    pool.cleared.Load(),
+	}
+}
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections_open",
+		Help: "Open connections in the MongoDB driver pool.",
+	}, func() float64 { return float64(pool.open.Load()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections_in_use",
+		Help: "Connections currently checked out of the MongoDB driver pool.",
+	}, func() float64 { return float64(pool.inUse.Load()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "mongo_pool_checkout_failures_total",
+		Help: "Failed attempts to check a connection out of the pool.",
+	}, func() float64 { return float64(pool.checkoutFailed.Load()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "mongo_pool_cleared_total",
+		Help: "Times the connection pool was cleared after an error.",
+	}, func() float64 { return float64(pool.cleared.Load()) })
+}
+
+// metricsCommandMonitor records command durations.
+func metricsCommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			commandDuration.WithLabelValues(e.CommandName, "success").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			commandDuration.WithLabelValues(e.CommandName, "failure").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// metricsPoolMonitor keeps the pool counters up to date.
+func metricsPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				pool.open.Add(1)
+			case event.ConnectionClosed:
+				pool.open.Add(-1)
+			case event.GetSucceeded:
+				pool.inUse.Add(1)
+			case event.ConnectionReturned:
+				pool.inUse.Add(-1)
+			case event.GetFailed:
+				pool.checkoutFailed.Add(1)
+			case event.PoolCleared:
+				pool.cleared.Add(1)
+			}
+		},
+	}
+}
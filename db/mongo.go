@@ -3,29 +3,145 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 // MongoClient holds the MongoDB client instance
 type MongoClient struct {
 	Client *mongo.Client
 	DB     *mongo.Database
+	// Reads is DB with the configured read preference, for reads that may
+	// be served by secondaries and so can lag behind recent writes.
+	Reads *mongo.Database
+
+	// Breaker fails operations fast while Mongo is down or saturated.
+	Breaker *Breaker
+
+	health   healthState
+	topology *topologyState
+	warmth   *poolWarmth
+
+	txnOnce sync.Once
+	txnOK   bool
+
+	readPref    *readpref.ReadPref
+	collections map[string]Namespace
 }
 
-var clientInstance *MongoClient
+// Config holds optional client settings.
+type Config struct {
+	// SlowQueryThreshold logs commands taking at least this long;
+	// zero disables slow query logging. See SetSlowQueryThreshold.
+	SlowQueryThreshold time.Duration
+
+	// Pool and connection tuning. Zero values keep the driver defaults.
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+
+	// Compressors lists wire compressors in order of preference, e.g.
+	// "zstd", "snappy" or "zlib". The server picks the first it supports.
+	Compressors []string
+
+	// ConnectRetries is how many more times the initial ping is tried when
+	// the deployment is not reachable yet, waiting with exponential backoff
+	// from RetryBackoff up to RetryMaxBackoff between attempts.
+	ConnectRetries  int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	// BreakerThreshold consecutive unavailable errors open the circuit for
+	// BreakerCooldown; zero disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// ReadPreference is the mode for MongoClient.Reads, e.g. "primary",
+	// "secondaryPreferred" or "nearest"; empty means primary. ReadTags
+	// prefers members with these tags and MaxStaleness excludes lagging
+	// secondaries. ReadConcern sets the read concern level for all reads;
+	// empty keeps the server default.
+	ReadPreference string
+	ReadTags       map[string]string
+	MaxStaleness   time.Duration
+	ReadConcern    string
+
+	// WriteConcern is the w value for all writes: "majority", a member
+	// count such as "1", or a custom tag set name; empty keeps the default.
+	// WriteJournal waits for the journal and WriteTimeout bounds how long
+	// to wait for acknowledgment. RetryWrites retries a write once after a
+	// transient network error or primary failover.
+	WriteConcern string
+	WriteJournal bool
+	WriteTimeout time.Duration
+	RetryWrites  bool
+
+	// Collections maps resources to "collection" or "database.collection"
+	// for deployments whose data is named differently, e.g.
+	// {"users": "accounts"}. See MongoClient.Namespace.
+	Collections map[string]string
+}
+
+// clientOptions applies cfg on top of the options parsed from the URI.
+func (cfg Config) clientOptions(opts *options.ClientOptions) *options.ClientOptions {
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+	if len(cfg.Compressors) > 0 {
+		opts.SetCompressors(cfg.Compressors)
+	}
+	if rc := cfg.readConcern(); rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	if wc := cfg.writeConcern(); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	opts.SetRetryWrites(cfg.RetryWrites)
+	return opts
+}
 
-// Connect connects to MongoDB and returns a MongoClient instance
-func Connect(uri string, dbName string) (*MongoClient, error) {
-	if clientInstance != nil {
-		return clientInstance, nil
+// Connect connects to MongoDB and returns a new MongoClient whose DB is
+// dbName. Each call creates its own connection pool; use Database for other
+// databases on the same deployment.
+func Connect(uri string, dbName string, cfg Config) (*MongoClient, error) {
+	rp, err := cfg.readPreference()
+	if err != nil {
+		return nil, err
+	}
+	collections, err := parseCollections(cfg.Collections)
+	if err != nil {
+		return nil, err
 	}
 
 	// Set client options
-	clientOptions := options.Client().ApplyURI(uri)
+	SetSlowQueryThreshold(cfg.SlowQueryThreshold)
+	breaker := NewBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	topology := newTopologyState(breaker)
+	warmth := newPoolWarmth()
+	clientOptions := cfg.clientOptions(options.Client().ApplyURI(uri)).
+		SetMonitor(combineCommandMonitors(metricsCommandMonitor(), otelmongo.NewMonitor(), slowQueryMonitor(), unitsCommandMonitor(), breakerCommandMonitor(breaker), warmth.commandMonitor())).
+		SetPoolMonitor(combinePoolMonitors(metricsPoolMonitor(), breakerPoolMonitor(breaker), warmth.monitor())).
+		SetServerMonitor(topology.serverMonitor())
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -37,19 +153,62 @@ func Connect(uri string, dbName string) (*MongoClient, error) {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
 
-	// Check the connection
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
+	// Check the connection, retrying while the deployment comes up
+	if err := pingWithRetry(client, cfg); err != nil {
+		_ = client.Disconnect(context.Background())
 		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
 	}
 
-	clientInstance = &MongoClient{
-		Client: client,
-		DB:     client.Database(dbName),
+	mc := &MongoClient{
+		Client:  client,
+		DB:      client.Database(dbName),
+		Reads:   client.Database(dbName, options.Database().SetReadPreference(rp)),
+		Breaker: breaker,
+
+		topology:    topology,
+		warmth:      warmth,
+		readPref:    rp,
+		collections: collections,
 	}
 
-	log.Println("Connected to MongoDB!")
-	return clientInstance, nil
+	slog.Info("connected to MongoDB", "database", dbName)
+	return mc, nil
+}
+
+// Database returns a handle for the named database sharing mc's connection
+// pool.
+func (mc *MongoClient) Database(name string) *mongo.Database {
+	return mc.Client.Database(name)
+}
+
+// ReadDatabase is Database with the configured read preference, like Reads.
+func (mc *MongoClient) ReadDatabase(name string) *mongo.Database {
+	return mc.Client.Database(name, options.Database().SetReadPreference(mc.readPref))
+}
+
+// pingWithRetry pings until the deployment answers or cfg.ConnectRetries
+// retries are used up.
+func pingWithRetry(client *mongo.Client, cfg Config) error {
+	base, max := cfg.RetryBackoff, cfg.RetryMaxBackoff
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	if max < base {
+		max = base
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := client.Ping(ctx, nil)
+		cancel()
+		if err == nil || attempt >= cfg.ConnectRetries {
+			return err
+		}
+		wait := backoff(attempt, base, max)
+		slog.Warn("MongoDB not reachable yet, retrying",
+			"attempt", attempt+1, "retries", cfg.ConnectRetries, "wait", wait, "error", err)
+		time.Sleep(wait)
+	}
 }
 
 // Disconnect closes the MongoDB connection
@@ -57,14 +216,13 @@ func (mc *MongoClient) Disconnect() error {
 	if mc.Client != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		
+
 		err := mc.Client.Disconnect(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to disconnect from MongoDB: %v", err)
 		}
-		
-		clientInstance = nil
-		log.Println("Disconnected from MongoDB!")
+
+		slog.Info("disconnected from MongoDB")
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// combineCommandMonitors fans command events out to several monitors, since
+// the driver accepts only one.
+func combineCommandMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
+
+// combinePoolMonitors fans pool events out to several monitors.
+func combinePoolMonitors(monitors ...*event.PoolMonitor) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			for _, m := range monitors {
+				if m.Event != nil {
+					m.Event(e)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Namespace is a collection in a database. An empty Database means DB.
+type Namespace struct {
+	Database   string
+	Collection string
+}
+
+// ParseNamespace parses "collection" or "database.collection". Database
+// names can't contain dots, so the first dot separates the two.
+func ParseNamespace(s string) (Namespace, error) {
+	var ns Namespace
+	if db, coll, ok := strings.Cut(s, "."); ok {
+		ns = Namespace{Database: db, Collection: coll}
+		if db == "" {
+			return ns, fmt.Errorf("invalid namespace %q: empty database", s)
+		}
+	} else {
+		ns.Collection = s
+	}
+	if ns.Collection == "" || strings.HasPrefix(ns.Collection, "system.") || strings.ContainsAny(ns.Collection, "$\x00") {
+		return ns, fmt.Errorf("invalid namespace %q: bad collection name", s)
+	}
+	return ns, nil
+}
+
+// parseCollections parses Config.Collections.
+func parseCollections(m map[string]string) (map[string]Namespace, error) {
+	out := make(map[string]Namespace, len(m))
+	for resource, s := range m {
+		ns, err := ParseNamespace(s)
+		if err != nil {
+			return nil, fmt.Errorf("collection for %s: %v", resource, err)
+		}
+		out[resource] = ns
+	}
+	return out, nil
+}
+
+// Namespace returns where documents of resource are stored. Resources are
+// the names the code uses for each kind of document, such as "users" or
+// "sessions"; Config.Collections maps them to other collections, so
+// deployments with differently named data can share one binary. Unmapped
+// resources are the collection of the same name in DB.
+func (mc *MongoClient) Namespace(resource string) Namespace {
+	if ns, ok := mc.collections[resource]; ok {
+		if ns.Database == "" {
+			ns.Database = mc.DB.Name()
+		}
+		return ns
+	}
+	return Namespace{Database: mc.DB.Name(), Collection: resource}
+}
+
+// Collection returns the collection holding resource. See Namespace.
+func (mc *MongoClient) Collection(resource string) *mongo.Collection {
+	ns, ok := mc.collections[resource]
+	switch {
+	case !ok:
+		return mc.DB.Collection(resource)
+	case ns.Database == "":
+		return mc.DB.Collection(ns.Collection)
+	}
+	return mc.Database(ns.Database).Collection(ns.Collection)
+}
+
+// ReadCollection is Collection with the configured read preference, like
+// Reads.
+func (mc *MongoClient) ReadCollection(resource string) *mongo.Collection {
+	ns, ok := mc.collections[resource]
+	switch {
+	case !ok:
+		return mc.Reads.Collection(resource)
+	case ns.Database == "":
+		return mc.Reads.Collection(ns.Collection)
+	}
+	return mc.Client.Database(ns.Database, options.Database().SetReadPreference(mc.readPref)).Collection(ns.Collection)
+}
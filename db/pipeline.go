@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pipeline builds an aggregation pipeline. Each method appends one stage
+// and returns the pipeline for chaining:
+//
+//	p := db.NewPipeline().
+//		Match(bson.M{"age": bson.M{"$gte": 18}}).
+//		Group(db.Ref("country"), db.Count("users"), db.Avg("age", db.Ref("age"))).
+//		Sort(db.Desc("users")).
+//		Limit(10)
+type Pipeline struct {
+	stages mongo.Pipeline
+}
+
+// NewPipeline returns an empty pipeline.
+func NewPipeline() *Pipeline {
+	return &Pipeline{stages: mongo.Pipeline{}}
+}
+
+// Ref returns the expression referring to field path, e.g. "$age".
+func Ref(path string) string {
+	return "$" + path
+}
+
+func (p *Pipeline) stage(name string, spec any) *Pipeline {
+	p.stages = append(p.stages, bson.D{{Key: name, Value: spec}})
+	return p
+}
+
+// Search runs an Atlas Search query of index, e.g. a "text" operator. It
+// must be the first stage, and results come best match first.
+func (p *Pipeline) Search(index string, spec bson.M) *Pipeline {
+	full := bson.M{"index": index}
+	for k, v := range spec {
+		full[k] = v
+	}
+	return p.stage("$search", full)
+}
+
+// SearchUnsupported reports whether err says the server has no Atlas
+// Search, as self-managed servers without mongot do.
+func SearchUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	switch cmdErr.Code {
+	// Unrecognized pipeline stage name, SearchNotEnabled and no mongot
+	// connection configured
+	case 40324, 31082, 6047401:
+		return true
+	}
+	return false
+}
+
+// Match keeps documents matching filter.
+func (p *Pipeline) Match(filter bson.M) *Pipeline {
+	return p.stage("$match", filter)
+}
+
+// Accumulator is a $group output field. Build it with Sum, Count, Avg, Min,
+// Max, First, Last or Push.
+type Accumulator struct {
+	field string
+	op    string
+	expr  any
+}
+
+// Sum adds up expr.
+func Sum(field string, expr any) Accumulator { return Accumulator{field, "$sum", expr} }
+
+// Count counts the documents of the group.
+func Count(field string) Accumulator { return Accumulator{field, "$sum", 1} }
+
+// Avg averages expr.
+func Avg(field string, expr any) Accumulator { return Accumulator{field, "$avg", expr} }
+
+// Min takes the smallest expr.
+func Min(field string, expr any) Accumulator { return Accumulator{field, "$min", expr} }
+
+// Max takes the largest expr.
+func Max(field string, expr any) Accumulator { return Accumulator{field, "$max", expr} }
+
+// First takes expr of the first document in the group.
+func First(field string, expr any) Accumulator { return Accumulator{field, "$first", expr} }
+
+// Last takes expr of the last document in the group.
+func Last(field string, expr any) Accumulator { return Accumulator{field, "$last", expr} }
+
+// Push collects expr of every document into an array.
+func Push(field string, expr any) Accumulator { return Accumulator{field, "$push", expr} }
+
+// Group groups documents by the id expression (nil for a single group) and
+// computes the accumulators per group.
+func (p *Pipeline) Group(id any, accs ...Accumulator) *Pipeline {
+	spec := bson.D{{Key: "_id", Value: id}}
+	for _, a := range accs {
+		spec = append(spec, bson.E{Key: a.field, Value: bson.D{{Key: a.op, Value: a.expr}}})
+	}
+	return p.stage("$group", spec)
+}
+
+// SortField is a sort key. Build it with Asc or Desc.
+type SortField struct {
+	field string
+	dir   int
+}
+
+// Asc sorts by field in ascending order.
+func Asc(field string) SortField { return SortField{field, 1} }
+
+// Desc sorts by field in descending order.
+func Desc(field string) SortField { return SortField{field, -1} }
+
+// Sort orders documents by the fields in order of precedence.
+func (p *Pipeline) Sort(fields ...SortField) *Pipeline {
+	spec := make(bson.D, len(fields))
+	for i, f := range fields {
+		spec[i] = bson.E{Key: f.field, Value: f.dir}
+	}
+	return p.stage("$sort", spec)
+}
+
+// Projection is a $project field. Build it with Include, Exclude or
+// Computed.
+type Projection struct {
+	field string
+	value any
+}
+
+// Include keeps field.
+func Include(field string) Projection { return Projection{field, 1} }
+
+// Exclude drops field. Apart from _id, exclusions can't be mixed with
+// inclusions or computed fields.
+func Exclude(field string) Projection { return Projection{field, 0} }
+
+// Computed sets field to expr.
+func Computed(field string, expr any) Projection { return Projection{field, expr} }
+
+// Project reshapes documents.
+func (p *Pipeline) Project(fields ...Projection) *Pipeline {
+	spec := make(bson.D, len(fields))
+	for i, f := range fields {
+		spec[i] = bson.E{Key: f.field, Value: f.value}
+	}
+	return p.stage("$project", spec)
+}
+
+// Lookup joins the documents of collection from whose foreignField equals
+// localField, as an array in field as.
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	return p.stage("$lookup", bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	})
+}
+
+// Unwind outputs one document per element of the array at path. With
+// preserveEmpty, documents whose array is missing or empty are kept.
+func (p *Pipeline) Unwind(path string, preserveEmpty bool) *Pipeline {
+	return p.stage("$unwind", bson.D{
+		{Key: "path", Value: Ref(path)},
+		{Key: "preserveNullAndEmptyArrays", Value: preserveEmpty},
+	})
+}
+
+// Skip drops the first n documents.
+func (p *Pipeline) Skip(n int64) *Pipeline {
+	return p.stage("$skip", n)
+}
+
+// Limit keeps the first n documents.
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	return p.stage("$limit", n)
+}
+
+// CountInto replaces the documents with one holding their number in field.
+func (p *Pipeline) CountInto(field string) *Pipeline {
+	return p.stage("$count", field)
+}
+
+// Stages returns the pipeline for use with the driver.
+func (p *Pipeline) Stages() mongo.Pipeline {
+	return p.stages
+}
+
+// Aggregate runs p against the collection of resource coll (see
+// Collection) on the read preference of Reads and decodes
+// all results into out, a pointer to a slice.
+func (mc *MongoClient) Aggregate(ctx context.Context, coll string, p *Pipeline, out any, opts ...*options.AggregateOptions) error {
+	cur, err := mc.ReadCollection(coll).Aggregate(ctx, p.Stages(), opts...)
+	if err != nil {
+		mc.Breaker.Record(err)
+		return err
+	}
+	if err := cur.All(ctx, out); err != nil {
+		mc.Breaker.Record(err)
+		return err
+	}
+	return nil
+}
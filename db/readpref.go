@@ -0,0 +1,43 @@
+package db
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+// readPreference builds the read preference for MongoClient.Reads. Tags are
+// tried first with a fallback to any eligible member, so a missing tagged
+// member degrades to a plain secondary read rather than an error.
+func (cfg Config) readPreference() (*readpref.ReadPref, error) {
+	if cfg.ReadPreference == "" {
+		return readpref.Primary(), nil
+	}
+	mode, err := readpref.ModeFromString(cfg.ReadPreference)
+	if err != nil {
+		return nil, err
+	}
+	var opts []readpref.Option
+	if len(cfg.ReadTags) > 0 {
+		opts = append(opts, readpref.WithTagSets(tag.NewTagSetFromMap(cfg.ReadTags), tag.Set{}))
+	}
+	if cfg.MaxStaleness > 0 {
+		opts = append(opts, readpref.WithMaxStaleness(cfg.MaxStaleness))
+	}
+	rp, err := readpref.New(mode, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read preference: %v", err)
+	}
+	return rp, nil
+}
+
+// readConcern returns the configured read concern, or nil for the server
+// default.
+func (cfg Config) readConcern() *readconcern.ReadConcern {
+	if cfg.ReadConcern == "" {
+		return nil
+	}
+	return readconcern.New(readconcern.Level(cfg.ReadConcern))
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// startedCommand is what the slow query monitor remembers between a
+// command's started and finished events.
+type startedCommand struct {
+	database   string
+	collection string
+	shape      string
+}
+
+// slowQueryThreshold is read on every command so it can be changed at
+// runtime; zero disables slow query logging.
+var slowQueryThreshold atomic.Int64
+
+// SetSlowQueryThreshold changes the slow query threshold of all clients.
+func SetSlowQueryThreshold(d time.Duration) {
+	slowQueryThreshold.Store(int64(d))
+}
+
+// slowQueryMonitor logs commands slower than the threshold with their
+// collection and filter shape. Filter values are replaced by their BSON
+// type so no user data ends up in the log.
+func slowQueryMonitor() *event.CommandMonitor {
+	var inflight sync.Map // request id -> startedCommand
+
+	finish := func(ctx context.Context, e event.CommandFinishedEvent, failure string) {
+		v, ok := inflight.LoadAndDelete(e.RequestID)
+		threshold := time.Duration(slowQueryThreshold.Load())
+		if !ok || threshold <= 0 || e.Duration < threshold {
+			return
+		}
+		sc := v.(startedCommand)
+		attrs := []any{
+			"command", e.CommandName,
+			"database", sc.database,
+			"collection", sc.collection,
+			"filter", sc.shape,
+			"duration", e.Duration,
+		}
+		if failure != "" {
+			attrs = append(attrs, "failure", failure)
+		}
+		slog.WarnContext(ctx, "slow mongo command", attrs...)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			if slowQueryThreshold.Load() <= 0 {
+				return
+			}
+			inflight.Store(e.RequestID, startedCommand{
+				database:   e.DatabaseName,
+				collection: commandCollection(e.Command, e.CommandName),
+				shape:      filterShape(e.Command, e.CommandName),
+			})
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			finish(ctx, e.CommandFinishedEvent, "")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			finish(ctx, e.CommandFinishedEvent, e.Failure)
+		},
+	}
+}
+
+// commandCollection returns the collection a command targets, which by
+// convention is the value of the command name element.
+func commandCollection(cmd bson.Raw, name string) string {
+	if s, ok := cmd.Lookup(name).StringValueOK(); ok {
+		return s
+	}
+	return ""
+}
+
+// filterShape extracts the query part of common commands and renders it
+// with values replaced by type names, e.g. {"email": "string"}.
+func filterShape(cmd bson.Raw, name string) string {
+	var filter bson.RawValue
+	switch name {
+	case "find":
+		filter = cmd.Lookup("filter")
+	case "count", "findAndModify", "distinct":
+		filter = cmd.Lookup("query")
+	case "aggregate":
+		filter = cmd.Lookup("pipeline")
+	case "update":
+		filter = cmd.Lookup("updates", "0", "q")
+	case "delete":
+		filter = cmd.Lookup("deletes", "0", "q")
+	default:
+		return ""
+	}
+	if filter.Value == nil {
+		return ""
+	}
+
+	b, err := bson.MarshalExtJSON(bson.M{"f": shapeOf(filter)}, false, false)
+	if err != nil {
+		return ""
+	}
+	// strip the {"f": ...} wrapper needed to marshal a non-document value
+	return string(b[len(`{"f":`) : len(b)-1])
+}
+
+func shapeOf(v bson.RawValue) any {
+	switch v.Type {
+	case bson.TypeEmbeddedDocument:
+		out := bson.D{}
+		elems, _ := v.Document().Elements()
+		for _, el := range elems {
+			out = append(out, bson.E{Key: el.Key(), Value: shapeOf(el.Value())})
+		}
+		return out
+	case bson.TypeArray:
+		out := bson.A{}
+		vals, _ := v.Array().Values()
+		for _, el := range vals {
+			out = append(out, shapeOf(el))
+		}
+		return out
+	default:
+		return v.Type.String()
+	}
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+)
+
+// ErrNoPrimary is returned by Failover while the deployment has no server
+// that takes writes.
+var ErrNoPrimary = errors.New("MongoDB has no primary")
+
+var primaryChanges = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "mongo_primary_changes_total",
+	Help: "Times the MongoDB primary changed or was lost.",
+})
+
+var heartbeatFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mongo_heartbeat_failures_total",
+	Help: "Failed MongoDB server heartbeats by server.",
+}, []string{"server"})
+
+// topologyState follows the deployment through the driver's SDAM events.
+// While no server takes writes, as during a replica set election, the
+// breaker is opened and Failover reports it, so requests fail fast and the
+// instance is taken out of rotation instead of queueing on server selection
+// against a stale primary.
+type topologyState struct {
+	breaker *Breaker
+
+	mu      sync.Mutex
+	primary string    // "" for deployments without one, such as mongos
+	seen    bool      // a writable server has been seen
+	lostAt  time.Time // zero while a writable server is known
+	closed  bool
+	failing map[string]bool // servers whose last heartbeat failed
+}
+
+func newTopologyState(b *Breaker) *topologyState {
+	return &topologyState{breaker: b, failing: map[string]bool{}}
+}
+
+// writable returns the primary of t and whether any server takes writes:
+// a replica set primary, standalone, mongos or load balancer.
+func writable(t description.Topology) (string, bool) {
+	ok := false
+	for _, s := range t.Servers {
+		switch s.Kind {
+		case description.RSPrimary:
+			return s.Addr.String(), true
+		case description.Standalone, description.Mongos, description.LoadBalancer:
+			ok = true
+		}
+	}
+	return "", ok
+}
+
+// changed handles a new topology description. The driver holds the
+// topology lock while calling it, so it must not select a server.
+func (ts *topologyState) changed(e *event.TopologyDescriptionChangedEvent) {
+	primary, ok := writable(e.NewDescription)
+
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		return
+	}
+	prev, lostAt, seen := ts.primary, ts.lostAt, ts.seen
+	switch {
+	case ok:
+		ts.primary, ts.lostAt, ts.seen = primary, time.Time{}, true
+	case seen && lostAt.IsZero():
+		// Before the first writable server is found this is only the
+		// driver discovering the deployment
+		ts.lostAt = time.Now()
+	}
+	ts.mu.Unlock()
+
+	switch {
+	case ok && !lostAt.IsZero():
+		slog.Info("MongoDB primary elected", "primary", primary, "previous", prev, "after", time.Since(lostAt).Round(time.Millisecond))
+		ts.breaker.Record(nil)
+	case ok && seen && primary != prev:
+		primaryChanges.Inc()
+		slog.Warn("MongoDB primary changed", "primary", primary, "previous", prev)
+	case !ok && seen && lostAt.IsZero():
+		primaryChanges.Inc()
+		slog.Error("MongoDB primary lost, failing over", "previous", prev, "kind", e.NewDescription.Kind.String())
+		ts.breaker.Trip("no primary")
+	}
+}
+
+// heartbeatFailed logs the first of a run of failed heartbeats to a server.
+func (ts *topologyState) heartbeatFailed(e *event.ServerHeartbeatFailedEvent) {
+	addr := heartbeatServer(e.ConnectionID)
+	heartbeatFailures.WithLabelValues(addr).Inc()
+
+	ts.mu.Lock()
+	first := !ts.failing[addr] && !ts.closed
+	ts.failing[addr] = true
+	ts.mu.Unlock()
+
+	if first {
+		slog.Warn("MongoDB heartbeat failed", "server", addr, "duration", e.Duration, "error", e.Failure)
+	}
+}
+
+func (ts *topologyState) heartbeatSucceeded(e *event.ServerHeartbeatSucceededEvent) {
+	addr := heartbeatServer(e.ConnectionID)
+
+	ts.mu.Lock()
+	recovered := ts.failing[addr]
+	delete(ts.failing, addr)
+	ts.mu.Unlock()
+
+	if recovered {
+		slog.Info("MongoDB heartbeat recovered", "server", addr)
+	}
+}
+
+// heartbeatServer returns the server address of a heartbeat connection ID
+// such as "db-0:27017[-3]".
+func heartbeatServer(connID string) string {
+	addr, _, _ := strings.Cut(connID, "[")
+	return addr
+}
+
+// serverMonitor returns the driver monitor feeding ts.
+func (ts *topologyState) serverMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: ts.changed,
+		ServerHeartbeatFailed:      ts.heartbeatFailed,
+		ServerHeartbeatSucceeded:   ts.heartbeatSucceeded,
+		TopologyClosed: func(*event.TopologyClosedEvent) {
+			ts.mu.Lock()
+			ts.closed = true
+			ts.mu.Unlock()
+		},
+	}
+}
+
+// Failover returns ErrNoPrimary while the deployment has no server that
+// takes writes, with when it was lost.
+func (mc *MongoClient) Failover() (time.Time, error) {
+	if mc.topology == nil {
+		return time.Time{}, nil
+	}
+	mc.topology.mu.Lock()
+	defer mc.topology.mu.Unlock()
+	if mc.topology.lostAt.IsZero() {
+		return time.Time{}, nil
+	}
+	return mc.topology.lostAt, ErrNoPrimary
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// WithTransaction runs fn in a multi-document transaction so its writes
+// across collections apply atomically. The driver retries the whole
+// transaction on TransientTransactionError and the commit on
+// UnknownTransactionCommitResult until ctx is done, so fn must be safe to
+// run more than once. Operations in fn must use the context it is given.
+//
+// Called within fn of another WithTransaction, fn joins that transaction.
+// Standalone servers can't run transactions; there fn runs once without one.
+func (mc *MongoClient) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(inTransaction{}) != nil || !mc.supportsTransactions(ctx) {
+		return fn(ctx)
+	}
+
+	sess, err := mc.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.WithoutCancel(ctx))
+
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(context.WithValue(sc, inTransaction{}, true))
+	}, opts)
+	return err
+}
+
+// inTransaction marks the contexts WithTransaction passes to fn.
+type inTransaction struct{}
+
+// supportsTransactions reports whether the deployment is a replica set or
+// sharded cluster. The answer is looked up once.
+func (mc *MongoClient) supportsTransactions(ctx context.Context) bool {
+	mc.txnOnce.Do(func() {
+		var hello bson.M
+		if err := mc.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			// Assume support; the transaction itself reports a real problem
+			mc.txnOK = true
+			return
+		}
+		_, replSet := hello["setName"]
+		mc.txnOK = replSet || hello["msg"] == "isdbgrid"
+		if !mc.txnOK {
+			slog.Warn("MongoDB is a standalone server; multi-document writes run without transactions")
+		}
+	})
+	return mc.txnOK
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// readUnitBytes is the reply size of one read unit.
+const readUnitBytes = 4096
+
+// Units counts the MongoDB work done for a caller, for usage metering. A
+// read unit is up to 4 KiB of reply to a read command, so a query costs
+// at least one; a write unit is a document inserted, updated or deleted,
+// and a write changing none costs one.
+type Units struct {
+	reads, writes atomic.Int64
+}
+
+// Reads returns the read units counted so far.
+func (u *Units) Reads() int64 { return u.reads.Load() }
+
+// Writes returns the write units counted so far.
+func (u *Units) Writes() int64 { return u.writes.Load() }
+
+type unitsKey struct{}
+
+// WithUnits returns a copy of ctx counting the commands run with it, or a
+// context derived from it, in u.
+func WithUnits(ctx context.Context, u *Units) context.Context {
+	return context.WithValue(ctx, unitsKey{}, u)
+}
+
+// unitsCommandMonitor adds the units of successful commands to the Units
+// of their context.
+func unitsCommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			u, ok := ctx.Value(unitsKey{}).(*Units)
+			if !ok {
+				return
+			}
+			switch e.CommandName {
+			case "find", "getMore", "aggregate", "count", "distinct":
+				u.reads.Add(int64(max(1, (len(e.Reply)+readUnitBytes-1)/readUnitBytes)))
+			case "insert", "update", "delete":
+				n, _ := e.Reply.Lookup("n").AsInt64OK()
+				u.writes.Add(max(1, n))
+			case "findAndModify":
+				n, _ := e.Reply.Lookup("lastErrorObject", "n").AsInt64OK()
+				u.writes.Add(max(1, n))
+			}
+		},
+	}
+}
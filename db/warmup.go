@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"golang.org/x/sync/errgroup"
+)
+
+// warmUpPoll is how often WarmUp checks whether the pools are full.
+const warmUpPoll = 50 * time.Millisecond
+
+// poolWarmth tracks the connections of each server's pool that completed
+// their handshake, and when a command other than a ping last ran.
+type poolWarmth struct {
+	mu    sync.Mutex
+	ready map[string]map[uint64]bool // by server address, then connection id
+
+	lastUsed atomic.Int64 // unix nanoseconds
+}
+
+func newPoolWarmth() *poolWarmth {
+	w := &poolWarmth{ready: map[string]map[uint64]bool{}}
+	w.lastUsed.Store(time.Now().UnixNano())
+	return w
+}
+
+func (w *poolWarmth) monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.PoolReady, event.ConnectionReady, event.ConnectionClosed, event.PoolClosedEvent:
+			default:
+				return
+			}
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			conns := w.ready[e.Address]
+			if conns == nil {
+				conns = map[uint64]bool{}
+				w.ready[e.Address] = conns
+			}
+			switch e.Type {
+			case event.ConnectionReady:
+				conns[e.ConnectionID] = true
+			case event.ConnectionClosed:
+				delete(conns, e.ConnectionID)
+			case event.PoolClosedEvent:
+				// The server left the topology
+				delete(w.ready, e.Address)
+			}
+		},
+	}
+}
+
+// commandMonitor records when commands run. Pings, of the health checks
+// and KeepWarm, don't count: they keep a single connection busy.
+func (w *poolWarmth) commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			if e.CommandName != "ping" {
+				w.lastUsed.Store(time.Now().UnixNano())
+			}
+		},
+	}
+}
+
+// counts returns the established connections by server address.
+func (w *poolWarmth) counts() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]int, len(w.ready))
+	for addr, conns := range w.ready {
+		out[addr] = len(conns)
+	}
+	return out
+}
+
+// WarmUp waits until the pool of each known server holds size
+// established connections, authenticated and TLS-negotiated, so the first
+// requests don't pay for the handshakes. The driver opens them in the
+// background to keep MinPoolSize; WarmUp only waits for it. It gives up
+// at the deadline of ctx, reporting ctx's error.
+func (mc *MongoClient) WarmUp(ctx context.Context, size int) error {
+	start := time.Now()
+	t := time.NewTicker(warmUpPoll)
+	defer t.Stop()
+	for {
+		counts := mc.warmth.counts()
+		full := len(counts) > 0
+		for _, n := range counts {
+			full = full && n >= size
+		}
+		if full {
+			slog.Info("MongoDB connection pools warmed up", "connections", counts, "duration", time.Since(start))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			slog.Warn("MongoDB connection pools not warmed up in time", "connections", counts, "want", size)
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// KeepWarm pings the deployment on up to size connections at once every
+// idle/2 while no command ran for idle, until ctx is done. Pooled
+// connections dropped meanwhile, e.g. by a load balancer or NAT timing
+// them out, then fail the pings and are replaced, rather than fail the
+// next requests.
+func (mc *MongoClient) KeepWarm(ctx context.Context, idle time.Duration, size int) {
+	t := time.NewTicker(idle / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if time.Since(time.Unix(0, mc.warmth.lastUsed.Load())) < idle {
+			continue
+		}
+		g, gctx := errgroup.WithContext(ctx)
+		for i := 0; i < size; i++ {
+			g.Go(func() error {
+				pctx, cancel := context.WithTimeout(gctx, healthPingTimeout)
+				defer cancel()
+				return mc.Client.Ping(pctx, nil)
+			})
+		}
+		if err := g.Wait(); err != nil && ctx.Err() == nil {
+			slog.Warn("MongoDB pre-ping of idle connections failed", "error", err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// writeConcern returns the configured write concern, or nil to keep the
+// driver and server defaults.
+func (cfg Config) writeConcern() *writeconcern.WriteConcern {
+	if cfg.WriteConcern == "" && !cfg.WriteJournal && cfg.WriteTimeout == 0 {
+		return nil
+	}
+	wc := &writeconcern.WriteConcern{WTimeout: cfg.WriteTimeout}
+	if n, err := strconv.Atoi(cfg.WriteConcern); err == nil {
+		wc.W = n
+	} else if cfg.WriteConcern != "" {
+		wc.W = cfg.WriteConcern // "majority" or a custom tag set name
+	}
+	if cfg.WriteJournal {
+		j := true
+		wc.Journal = &j
+	}
+	return wc
+}
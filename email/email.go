@@ -0,0 +1,156 @@
+// Package email sends templated notification emails over SMTP.
+//
+// Messages are rendered when they are sent and delivered by a job of the
+// queue (see package jobs), so requests never wait for the mail server
+// and failed deliveries are retried. Templates are text/template files
+// defining a "subject" and a "body" template; the defaults are embedded
+// and a directory with files of the same names replaces them.
+package email
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang/jobs"
+)
+
+// Templates sent by the server.
+const (
+	Welcome         = "welcome"
+	EmailChanged    = "email_changed"
+	PasswordChanged = "password_changed"
+	VerifyEmail     = "verify_email"
+)
+
+// sendJob is the job type delivering one message.
+const sendJob = "email.send"
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Options configures a Mailer.
+type Options struct {
+	// Host and Port of the SMTP server.
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN when Username is set.
+	Username string
+	Password string
+	// TLS is "starttls" (the default), "tls" for implicit TLS or "none".
+	TLS string
+	// Timeout bounds one delivery; defaults to 10s.
+	Timeout time.Duration
+	// From is the sender address, e.g. "Users API <noreply@example.com>".
+	From string
+	// TemplatesDir replaces the embedded templates when set.
+	TemplatesDir string
+	// MaxAttempts is how often a message is tried before it fails;
+	// defaults to the queue's.
+	MaxAttempts int
+}
+
+// Mailer renders messages and queues them for delivery.
+type Mailer struct {
+	queue     *jobs.Queue
+	opts      Options
+	from      *mail.Address
+	templates map[string]*template.Template
+}
+
+// New returns a Mailer sending through the SMTP server of opts and
+// registers its job handler with q.
+func New(q *jobs.Queue, opts Options) (*Mailer, error) {
+	if opts.TLS == "" {
+		opts.TLS = "starttls"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %v", err)
+	}
+
+	var files fs.FS
+	if opts.TemplatesDir != "" {
+		files = os.DirFS(opts.TemplatesDir)
+	} else {
+		files, _ = fs.Sub(defaultTemplates, "templates")
+	}
+	// One set per file, since they all define subject and body
+	templates := map[string]*template.Template{}
+	for _, name := range []string{Welcome, EmailChanged, PasswordChanged, VerifyEmail} {
+		src, err := fs.ReadFile(files, name+".tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("email template %s: %v", name, err)
+		}
+		t, err := template.New(name).Option("missingkey=error").Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("email template %s: %v", name, err)
+		}
+		templates[name] = t
+	}
+
+	m := &Mailer{queue: q, opts: opts, from: from, templates: templates}
+	q.Handle(sendJob, m.deliver)
+	return m, nil
+}
+
+// message is the payload of a send job.
+type message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Send renders template name with data and queues the message to to.
+func (m *Mailer) Send(ctx context.Context, to, name string, data any) error {
+	if _, err := mail.ParseAddress(to); err != nil {
+		return fmt.Errorf("invalid recipient %q: %v", to, err)
+	}
+	subject, err := m.render(name, "subject", data)
+	if err != nil {
+		return err
+	}
+	body, err := m.render(name, "body", data)
+	if err != nil {
+		return err
+	}
+	msg := message{To: to, Subject: strings.TrimSpace(subject), Body: strings.TrimLeft(body, "\n")}
+	_, err = m.queue.Enqueue(ctx, sendJob, msg, jobs.EnqueueOptions{MaxAttempts: m.opts.MaxAttempts})
+	return err
+}
+
+// render executes the subject or body template of template name.
+func (m *Mailer) render(name, part string, data any) (string, error) {
+	t, ok := m.templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown email template %q", name)
+	}
+	var b strings.Builder
+	if err := t.ExecuteTemplate(&b, part, data); err != nil {
+		return "", fmt.Errorf("email template %s: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+// deliver is the job handler sending one message.
+func (m *Mailer) deliver(ctx context.Context, j *jobs.Job) error {
+	var msg message
+	if err := j.Decode(&msg); err != nil {
+		return jobs.Permanent(err)
+	}
+	err := m.send(ctx, &msg)
+	if err != nil && errors.Is(err, errRejected) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
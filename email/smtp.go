@@ -0,0 +1,108 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// errRejected marks permanent (5xx) SMTP replies, which are not retried.
+var errRejected = errors.New("rejected by the mail server")
+
+// send delivers msg in one SMTP session.
+func (m *Mailer) send(ctx context.Context, msg *message) error {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(m.opts.Host, strconv.Itoa(m.opts.Port))
+	tlsConfig := &tls.Config{ServerName: m.opts.Host}
+	var conn net.Conn
+	var err error
+	if m.opts.TLS == "tls" {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.opts.Host)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer c.Close()
+	if m.opts.TLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return smtpError(err)
+		}
+	}
+	if m.opts.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.opts.Username, m.opts.Password, m.opts.Host)); err != nil {
+			return smtpError(err)
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return smtpError(err)
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return smtpError(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(m.format(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return c.Quit()
+}
+
+// smtpError marks permanent SMTP failures with errRejected.
+func smtpError(err error) error {
+	var perr *textproto.Error
+	if errors.As(err, &perr) && perr.Code >= 500 {
+		return fmt.Errorf("%w: %v", errRejected, err)
+	}
+	return err
+}
+
+// format returns msg as a plain text MIME message.
+func (m *Mailer) format(msg *message) []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	var b bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+	header("From", m.from.String())
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+m.opts.Host+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write(bytes.ReplaceAll([]byte(msg.Body), []byte("\n"), []byte("\r\n")))
+	qp.Close()
+	return b.Bytes()
+}
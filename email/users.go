@@ -0,0 +1,79 @@
+package email
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"golang/store"
+)
+
+// Users sends a welcome email for every user created through the wrapped
+// UserRepository, and a notification when a user's email address or
+// password changes. Failing to queue one is logged and doesn't fail the
+// write, which has already happened.
+type Users struct {
+	store.UserRepository
+	m *Mailer
+}
+
+// NewUsers returns next sending its notifications through m.
+func NewUsers(next store.UserRepository, m *Mailer) *Users {
+	return &Users{UserRepository: next, m: m}
+}
+
+// change is the data of the change notifications.
+type change struct {
+	Name     string
+	Email    string
+	OldEmail string
+	At       time.Time
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	if err := u.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	if user.Email != "" {
+		u.send(ctx, user.Email, Welcome, user)
+	}
+	return nil
+}
+
+// Update tells the old address about a new one, so a hijacked account is
+// noticed, and the current address about a new password.
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	_, emailChange := fields["email"]
+	_, passwordChange := fields["password_hash"]
+	if !emailChange && !passwordChange {
+		return u.UserRepository.Update(ctx, id, fields)
+	}
+
+	before, err := u.UserRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+		return err
+	}
+	after, err := u.UserRepository.Get(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to queue notification email", "user_id", id, "error", err)
+		return nil
+	}
+
+	data := change{Name: after.Name, Email: after.Email, OldEmail: before.Email, At: time.Now().UTC()}
+	if before.Email != after.Email && before.Email != "" {
+		u.send(ctx, before.Email, EmailChanged, data)
+	}
+	if passwordChange && after.Email != "" {
+		u.send(ctx, after.Email, PasswordChanged, data)
+	}
+	return nil
+}
+
+func (u *Users) send(ctx context.Context, to, template string, data any) {
+	if err := u.m.Send(ctx, to, template, data); err != nil {
+		slog.ErrorContext(ctx, "failed to queue notification email", "template", template, "error", err)
+	}
+}
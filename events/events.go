@@ -0,0 +1,96 @@
+// Package events publishes domain events about users to a message broker,
+// Kafka or NATS, for other services to consume.
+//
+// Events are written to the "outbox" collection in the transaction of
+// the write they report, so there is an event exactly for every write
+// that happened. A relay publishes them from there, retrying until the
+// broker accepts them, so every event reaches the broker at least once
+// even when it is down. Consumers must therefore tolerate duplicates;
+// the event id identifies them.
+//
+// Messages are JSON or protobuf (see user_event.proto), carry the schema
+// version in their payload and in a header, and are keyed by user id so
+// brokers keep the events of one user in order. The schema header names
+// the JSON Schema of the event type in package schemas, served by the API
+// under /schemas.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang/schemas"
+	"golang/store"
+)
+
+// Event types.
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+)
+
+// SchemaVersion is the version of the message schema. It changes only
+// with incompatible changes; new fields are added without a new version.
+const SchemaVersion = 1
+
+// Message formats.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Event is a change to a user.
+type Event struct {
+	ID            string      `json:"id"`
+	Type          string      `json:"type"`
+	SchemaVersion int         `json:"schema_version"`
+	Time          time.Time   `json:"time"`
+	Tenant        string      `json:"tenant,omitempty"`
+	User          *store.User `json:"user"`
+}
+
+// Message is an encoded event as sent to the broker.
+type Message struct {
+	// Key is the user id, which brokers partition by.
+	Key         string
+	Value       []byte
+	ContentType string
+	Headers     map[string]string
+}
+
+// Publisher sends messages to a broker. Publish returns once the broker
+// has accepted the message.
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) error
+	Close() error
+}
+
+// encode returns e as a message in format.
+func encode(e *Event, format string) (*Message, error) {
+	msg := &Message{
+		Key: e.User.ID,
+		Headers: map[string]string{
+			"event-id":       e.ID,
+			"event-type":     e.Type,
+			"schema-version": fmt.Sprint(e.SchemaVersion),
+			"schema":         schemas.Ref{Name: e.Type, Version: e.SchemaVersion}.Path(),
+		},
+	}
+	switch format {
+	case FormatJSON:
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		msg.Value, msg.ContentType = b, "application/json"
+	case FormatProtobuf:
+		msg.Value, msg.ContentType = marshalProto(e), "application/x-protobuf; messageType=users.events.v1.UserEvent"
+	default:
+		return nil, fmt.Errorf("unknown event format %q", format)
+	}
+	msg.Headers["content-type"] = msg.ContentType
+	return msg, nil
+}
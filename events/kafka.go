@@ -0,0 +1,435 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KafkaOptions configures a Kafka producer.
+type KafkaOptions struct {
+	// Brokers are host:port addresses to bootstrap from.
+	Brokers []string
+	// Topic messages are produced to.
+	Topic string
+	// TLS connects with TLS.
+	TLS bool
+	// Timeout bounds connecting and producing one message; defaults to
+	// 10s.
+	Timeout time.Duration
+}
+
+// Kafka produces to a Kafka topic (Kafka 0.11 or later), waiting for all
+// in-sync replicas to acknowledge every message (acks=all). Messages are
+// assigned to partitions by the murmur2 hash of their key, like the Java
+// client does, so a key maps to the same partition across producers.
+type Kafka struct {
+	opts KafkaOptions
+
+	mu         sync.Mutex
+	conns      map[int32]*kafkaConn // by node id
+	brokers    map[int32]string     // node id to address
+	leaders    []int32              // leader node per partition
+	correlator int32
+}
+
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Kafka API keys and the versions used.
+const (
+	apiProduce      = 0
+	apiMetadata     = 3
+	produceVersion  = 3
+	metadataVersion = 4
+)
+
+// Kafka error codes handled specially.
+const (
+	errUnknownTopicOrPartition = 3
+	errLeaderNotAvailable      = 5
+	errNotLeaderForPartition   = 6
+)
+
+// NewKafka returns a producer to the cluster of opts. It connects on the
+// first publish.
+func NewKafka(opts KafkaOptions) (*Kafka, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers")
+	}
+	if opts.Topic == "" {
+		return nil, errors.New("kafka: no topic")
+	}
+	return &Kafka{opts: opts, conns: map[int32]*kafkaConn{}}, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, msg *Message) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.leaders == nil {
+		if err := k.refresh(ctx); err != nil {
+			return err
+		}
+	}
+	partition := int32(murmur2([]byte(msg.Key))&0x7fffffff) % int32(len(k.leaders))
+	leader := k.leaders[partition]
+
+	req := newKafkaRequest()
+	req.int16(-1) // no transactional id
+	req.int16(-1) // acks=all
+	req.int32(int32(k.opts.Timeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.opts.Topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(recordBatch(msg, time.Now()))
+
+	resp, err := k.roundTrip(ctx, leader, apiProduce, produceVersion, req)
+	if err != nil {
+		return err
+	}
+	// responses: [topic [partition error_code base_offset log_append_time]]
+	var code int16
+	for topics := resp.int32(); topics > 0; topics-- {
+		resp.string()
+		for parts := resp.int32(); parts > 0; parts-- {
+			resp.int32()
+			if c := resp.int16(); c != 0 {
+				code = c
+			}
+			resp.int64()
+			resp.int64()
+		}
+	}
+	if resp.err != nil {
+		return resp.err
+	}
+	if code != 0 {
+		if code == errNotLeaderForPartition || code == errLeaderNotAvailable || code == errUnknownTopicOrPartition {
+			k.leaders = nil // look the leaders up again on the next attempt
+		}
+		return fmt.Errorf("kafka: produce failed with error code %d", code)
+	}
+	return nil
+}
+
+// refresh reads the partition leaders of the topic from the first
+// reachable broker. Callers hold k.mu.
+func (k *Kafka) refresh(ctx context.Context) error {
+	var lastErr error
+	for _, addr := range k.opts.Brokers {
+		req := newKafkaRequest()
+		req.int32(1)
+		req.string(k.opts.Topic)
+		req.bool(false) // don't create the topic
+
+		c, err := k.dial(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := k.exchange(ctx, c, apiMetadata, metadataVersion, req)
+		c.conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return k.readMetadata(resp)
+	}
+	return fmt.Errorf("kafka: no broker reachable: %w", lastErr)
+}
+
+func (k *Kafka) readMetadata(resp *kafkaResponse) error {
+	resp.int32() // throttle time
+	brokers := map[int32]string{}
+	for n := resp.int32(); n > 0; n-- {
+		id := resp.int32()
+		host := resp.string()
+		port := resp.int32()
+		resp.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	resp.nullableString() // cluster id
+	resp.int32()          // controller id
+
+	var leaders []int32
+	for n := resp.int32(); n > 0; n-- {
+		code := resp.int16()
+		resp.string()
+		resp.bool()
+		parts := resp.int32()
+		if code != 0 && resp.err == nil {
+			return fmt.Errorf("kafka: topic %s unavailable, error code %d", k.opts.Topic, code)
+		}
+		leaders = make([]int32, parts)
+		for ; parts > 0; parts-- {
+			resp.int16()
+			id := resp.int32()
+			leader := resp.int32()
+			for r := resp.int32(); r > 0; r-- {
+				resp.int32()
+			}
+			for r := resp.int32(); r > 0; r-- {
+				resp.int32()
+			}
+			if id >= 0 && int(id) < len(leaders) {
+				leaders[id] = leader
+			}
+		}
+	}
+	if resp.err != nil {
+		return resp.err
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("kafka: topic %s has no partitions", k.opts.Topic)
+	}
+	for _, l := range leaders {
+		if l < 0 {
+			return fmt.Errorf("kafka: topic %s has partitions without a leader", k.opts.Topic)
+		}
+	}
+	for id, c := range k.conns {
+		if brokers[id] != k.brokers[id] {
+			c.conn.Close()
+			delete(k.conns, id)
+		}
+	}
+	k.brokers, k.leaders = brokers, leaders
+	return nil
+}
+
+// roundTrip sends req to broker node and returns its response, dropping
+// the connection when it fails. Callers hold k.mu.
+func (k *Kafka) roundTrip(ctx context.Context, node int32, api, version int16, req *kafkaRequest) (*kafkaResponse, error) {
+	c, ok := k.conns[node]
+	if !ok {
+		addr, known := k.brokers[node]
+		if !known {
+			k.leaders = nil
+			return nil, fmt.Errorf("kafka: unknown broker %d", node)
+		}
+		var err error
+		if c, err = k.dial(ctx, addr); err != nil {
+			k.leaders = nil
+			return nil, err
+		}
+		k.conns[node] = c
+	}
+	resp, err := k.exchange(ctx, c, api, version, req)
+	if err != nil {
+		c.conn.Close()
+		delete(k.conns, node)
+		k.leaders = nil
+	}
+	return resp, err
+}
+
+func (k *Kafka) dial(ctx context.Context, addr string) (*kafkaConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.opts.Timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if k.opts.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// exchange writes one request to c and reads its response.
+func (k *Kafka) exchange(ctx context.Context, c *kafkaConn, api, version int16, req *kafkaRequest) (*kafkaResponse, error) {
+	deadline := time.Now().Add(k.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	k.correlator++
+	id := k.correlator
+	// Header: api key, api version, correlation id, client id
+	head := newKafkaRequest()
+	head.int16(api)
+	head.int16(version)
+	head.int32(id)
+	head.string("users-api")
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(head.b)+len(req.b)))
+	frame = append(append(frame, head.b...), req.b...)
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	resp := &kafkaResponse{b: body}
+	if got := resp.int32(); got != id {
+		return nil, fmt.Errorf("kafka: response %d to request %d", got, id)
+	}
+	return resp, nil
+}
+
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, c := range k.conns {
+		c.conn.Close()
+		delete(k.conns, id)
+	}
+	return nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes msg as a record batch (message format v2) of one
+// record.
+func recordBatch(msg *Message, now time.Time) []byte {
+	var rec []byte
+	rec = append(rec, 0)              // attributes
+	rec = binary.AppendVarint(rec, 0) // timestamp delta
+	rec = binary.AppendVarint(rec, 0) // offset delta
+	rec = appendVarBytes(rec, []byte(msg.Key))
+	rec = appendVarBytes(rec, msg.Value)
+	rec = binary.AppendVarint(rec, int64(len(msg.Headers)))
+	for k, v := range msg.Headers {
+		rec = appendVarBytes(rec, []byte(k))
+		rec = appendVarBytes(rec, []byte(v))
+	}
+
+	ts := now.UnixMilli()
+	// From attributes on, covered by the CRC
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, 0)          // attributes
+	body = binary.BigEndian.AppendUint32(body, 0)          // last offset delta
+	body = binary.BigEndian.AppendUint64(body, uint64(ts)) // first timestamp
+	body = binary.BigEndian.AppendUint64(body, uint64(ts)) // max timestamp
+	body = binary.BigEndian.AppendUint64(body, ^uint64(0)) // producer id -1
+	body = binary.BigEndian.AppendUint16(body, ^uint16(0)) // producer epoch -1
+	body = binary.BigEndian.AppendUint32(body, ^uint32(0)) // base sequence -1
+	body = binary.BigEndian.AppendUint32(body, 1)          // records
+	body = binary.AppendVarint(body, int64(len(rec)))
+	body = append(body, rec...)
+
+	var b []byte
+	b = binary.BigEndian.AppendUint64(b, 0)                       // base offset
+	b = binary.BigEndian.AppendUint32(b, uint32(4+1+4+len(body))) // batch length
+	b = binary.BigEndian.AppendUint32(b, ^uint32(0))              // partition leader epoch -1
+	b = append(b, 2)                                              // magic
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(body, castagnoli))
+	return append(b, body...)
+}
+
+func appendVarBytes(b, v []byte) []byte {
+	b = binary.AppendVarint(b, int64(len(v)))
+	return append(b, v...)
+}
+
+// murmur2 is the hash of the Java client's default partitioner.
+func murmur2(data []byte) int32 {
+	const m, r = 0x5bd1e995, 24
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) % 4 {
+	case 3:
+		h ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[n])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaRequest builds a request body in the Kafka protocol encoding.
+type kafkaRequest struct{ b []byte }
+
+func newKafkaRequest() *kafkaRequest { return &kafkaRequest{} }
+
+func (r *kafkaRequest) int16(v int16) { r.b = binary.BigEndian.AppendUint16(r.b, uint16(v)) }
+func (r *kafkaRequest) int32(v int32) { r.b = binary.BigEndian.AppendUint32(r.b, uint32(v)) }
+func (r *kafkaRequest) bool(v bool) {
+	if v {
+		r.b = append(r.b, 1)
+	} else {
+		r.b = append(r.b, 0)
+	}
+}
+func (r *kafkaRequest) string(s string) {
+	r.int16(int16(len(s)))
+	r.b = append(r.b, s...)
+}
+func (r *kafkaRequest) bytes(v []byte) {
+	r.int32(int32(len(v)))
+	r.b = append(r.b, v...)
+}
+
+// kafkaResponse reads a response body. The first read past the end sets
+// err and later reads return zero values.
+type kafkaResponse struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaResponse) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b) < n {
+		if r.err == nil {
+			r.err = errors.New("kafka: truncated response")
+		}
+		return make([]byte, max(n, 0))
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaResponse) bool() bool     { return r.next(1)[0] != 0 }
+func (r *kafkaResponse) int16() int16   { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaResponse) int32() int32   { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaResponse) int64() int64   { return int64(binary.BigEndian.Uint64(r.next(8))) }
+func (r *kafkaResponse) string() string { return string(r.next(int(r.int16()))) }
+
+func (r *kafkaResponse) nullableString() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
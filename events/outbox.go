@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"golang/db"
+	"golang/store"
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// retention is how long published events stay in the outbox.
+const retention = 7 * 24 * time.Hour
+
+var published = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_published_total",
+	Help: "Event publish attempts by type and result (success or retry).",
+}, []string{"type", "result"})
+
+func init() {
+	keep := retention
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "outbox", Name: "pending", Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "due_at", Value: 1}}},
+		db.IndexSpec{Collection: "outbox", Name: "sent_at_ttl", Keys: bson.D{{Key: "sent_at", Value: 1}}, ExpireAfter: &keep},
+	)
+}
+
+// Options configures an Outbox. Zero fields take the defaults.
+type Options struct {
+	// Format of the messages, FormatJSON (the default) or FormatProtobuf.
+	Format string
+	// PollInterval is how often the relay looks for events to publish;
+	// defaults to 1s.
+	PollInterval time.Duration
+	// Lease is how long a relay owns an event it is publishing before
+	// another may take it over; defaults to 1m.
+	Lease time.Duration
+	// Backoff is the wait before retrying a failed event, doubled for
+	// every further attempt up to MaxBackoff; default 5s and 5m.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// entry is a document of the "outbox" collection: an encoded message
+// and its publishing state. Events are due from due_at until sent_at is
+// set.
+type entry struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Type        string             `bson:"type"`
+	Key         string             `bson:"key"`
+	Value       []byte             `bson:"value"`
+	ContentType string             `bson:"content_type"`
+	Headers     map[string]string  `bson:"headers"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	DueAt       time.Time          `bson:"due_at"`
+	Attempts    int                `bson:"attempts"`
+	LastError   string             `bson:"last_error,omitempty"`
+	SentAt      *time.Time         `bson:"sent_at"`
+}
+
+// Outbox stores events and relays them to a Publisher.
+type Outbox struct {
+	mc   *db.MongoClient
+	pub  Publisher
+	opts Options
+	wake chan struct{}
+}
+
+// NewOutbox returns an outbox stored through mc relaying to pub.
+func NewOutbox(mc *db.MongoClient, pub Publisher, opts Options) *Outbox {
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = time.Minute
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 5 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+	return &Outbox{mc: mc, pub: pub, opts: opts, wake: make(chan struct{}, 1)}
+}
+
+// Add stores an event of type about u for the relay to publish. Call it
+// in the transaction of the write, with the context WithTransaction
+// passes, and Notify after the commit.
+func (o *Outbox) Add(ctx context.Context, typ string, u *store.User) error {
+	id := primitive.NewObjectID()
+	now := time.Now().UTC()
+	msg, err := encode(&Event{
+		ID:            id.Hex(),
+		Type:          typ,
+		SchemaVersion: SchemaVersion,
+		Time:          now,
+		Tenant:        tenant.FromContext(ctx),
+		User:          u,
+	}, o.opts.Format)
+	if err != nil {
+		return err
+	}
+	_, err = o.mc.Collection("outbox").InsertOne(ctx, entry{
+		ID:          id,
+		Type:        typ,
+		Key:         msg.Key,
+		Value:       msg.Value,
+		ContentType: msg.ContentType,
+		Headers:     msg.Headers,
+		CreatedAt:   now,
+		DueAt:       now,
+	}, options.InsertOne().SetComment(db.Comment(ctx)))
+	return err
+}
+
+// Notify makes the relay look for new events now instead of at its next
+// poll.
+func (o *Outbox) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Transaction runs fn, which writes through ctx, in a transaction with
+// the outbox, so the events fn adds are stored if and only if its writes
+// are. On standalone servers, which have no transactions, a crash can
+// still separate the two.
+func (o *Outbox) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := o.mc.WithTransaction(ctx, fn); err != nil {
+		return err
+	}
+	o.Notify()
+	return nil
+}
+
+// Run relays due events, oldest first, until ctx is done. Relays of
+// several instances share the work. A failed event is retried with
+// backoff, so events after it may overtake it; the relay pauses until
+// the next poll after a failure. The publisher is closed on return.
+func (o *Outbox) Run(ctx context.Context) {
+	defer o.pub.Close()
+	t := time.NewTicker(o.opts.PollInterval)
+	defer t.Stop()
+	for {
+		for ctx.Err() == nil {
+			e, err := o.claim(ctx)
+			if err != nil {
+				if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+					slog.Error("failed to claim outbox event", "error", err)
+				}
+				break
+			}
+			if !o.relay(ctx, e) {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// claim leases the oldest due event.
+func (o *Outbox) claim(ctx context.Context) (*entry, error) {
+	now := time.Now().UTC()
+	var e entry
+	err := o.mc.Collection("outbox").FindOneAndUpdate(ctx,
+		bson.M{"sent_at": nil, "due_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"due_at": now.Add(o.opts.Lease)}, "$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// relay publishes e and records the outcome, reporting success.
+func (o *Outbox) relay(ctx context.Context, e *entry) bool {
+	err := o.pub.Publish(ctx, &Message{Key: e.Key, Value: e.Value, ContentType: e.ContentType, Headers: e.Headers})
+	now := time.Now().UTC()
+	var set bson.M
+	if err == nil {
+		set = bson.M{"sent_at": now, "last_error": ""}
+		published.WithLabelValues(e.Type, "success").Inc()
+	} else {
+		wait := o.opts.Backoff << min(e.Attempts-1, 20)
+		set = bson.M{"due_at": now.Add(min(wait, o.opts.MaxBackoff)), "last_error": err.Error()}
+		published.WithLabelValues(e.Type, "retry").Inc()
+		slog.Warn("failed to publish event", "event_id", e.ID.Hex(), "type", e.Type, "attempts", e.Attempts, "error", err)
+	}
+	// Record the outcome even when shutting down, so the event isn't
+	// published again needlessly
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if _, uerr := o.mc.Collection("outbox").UpdateByID(ctx, e.ID, bson.M{"$set": set}); uerr != nil {
+		slog.Error("failed to record outbox event", "event_id", e.ID.Hex(), "error", uerr)
+	}
+	return err == nil
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"time"
+
+	"golang/store"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// marshalProto encodes e as a users.events.v1.UserEvent of
+// user_event.proto. Zero fields are omitted, as proto3 does.
+func marshalProto(e *Event) []byte {
+	var b []byte
+	b = appendString(b, 1, e.ID)
+	b = appendString(b, 2, e.Type)
+	if e.SchemaVersion != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.SchemaVersion))
+	}
+	b = appendMessage(b, 4, appendTimestamp(e.Time))
+	b = appendString(b, 5, e.Tenant)
+	if e.User != nil {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalUser(e.User))
+	}
+	return b
+}
+
+func marshalUser(u *store.User) []byte {
+	var b []byte
+	b = appendString(b, 1, u.ID)
+	b = appendString(b, 2, u.Name)
+	b = appendString(b, 3, u.Email)
+	if u.Age != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(u.Age)))
+	}
+	b = appendMessage(b, 5, appendTimestamp(u.CreatedAt))
+	if u.EmailVerified != nil {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*u.EmailVerified))
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendMessage appends the embedded message m unless it is empty.
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	if len(m) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendTimestamp encodes t as a google.protobuf.Timestamp; the zero time
+// is left out.
+func appendTimestamp(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	var b []byte
+	if s := t.Unix(); s != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(s))
+	}
+	if n := t.Nanosecond(); n != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(n))
+	}
+	return b
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+
+	"golang/store"
+)
+
+// Users adds an event to the outbox for every write through the wrapped
+// UserRepository, in the same transaction, so a write whose event can't
+// be stored fails. It must wrap the repository directly: writes of other
+// decorators would join the transaction.
+type Users struct {
+	store.UserRepository
+	o *Outbox
+}
+
+// NewUsers returns next adding its events to o.
+func NewUsers(next store.UserRepository, o *Outbox) *Users {
+	return &Users{UserRepository: next, o: o}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	return u.o.Transaction(ctx, func(ctx context.Context) error {
+		if err := u.UserRepository.Create(ctx, user); err != nil {
+			return err
+		}
+		out := *user
+		out.Password, out.PasswordHash = "", ""
+		return u.o.Add(ctx, UserCreated, &out)
+	})
+}
+
+// Update publishes the user as stored after the update.
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	return u.o.Transaction(ctx, func(ctx context.Context) error {
+		if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+			return err
+		}
+		user, err := u.UserRepository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return u.o.Add(ctx, UserUpdated, user)
+	})
+}
+
+func (u *Users) Delete(ctx context.Context, id string) error {
+	return u.o.Transaction(ctx, func(ctx context.Context) error {
+		if err := u.UserRepository.Delete(ctx, id); err != nil {
+			return err
+		}
+		return u.o.Add(ctx, UserDeleted, &store.User{ID: id})
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang/config"
+	"golang/db"
+	"golang/s3"
+	"golang/secrets"
+)
+
+// parseDate accepts an RFC 3339 time or a date, read as midnight UTC.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// exportCmd connects to MongoDB and writes the selected collections.
+func exportCmd(args []string) error {
+	fs, configPath := newFlagSet("export")
+	dest := fs.String("out", ".", "directory to write <collection>.<format>.gz files to, s3://bucket/prefix to upload them (see EXPORT_S3_*), or - for stdout")
+	collections := fs.String("collections", "", "comma-separated collections to export; empty exports all")
+	format := fs.String("format", db.FormatJSON, "export format: json (Extended JSON lines) or bson (mongodump style)")
+	since := fs.String("since", "", "only export documents dated at or after this RFC 3339 time or YYYY-MM-DD date")
+	until := fs.String("until", "", "only export documents dated before this RFC 3339 time or YYYY-MM-DD date")
+	dateField := fs.String("date-field", "created_at", "document field -since and -until compare against")
+	anonymize := fs.Bool("anonymize", false, "replace emails, names, addresses and other personal data with consistent fakes (see EXPORT_ANONYMIZE_*), for loading into staging")
+	fs.Parse(args)
+
+	opts := db.ExportOptions{Format: *format, DateField: *dateField}
+	var err error
+	if opts.Since, err = parseDate(*since); err != nil {
+		return fmt.Errorf("invalid -since: %v", err)
+	}
+	if opts.Until, err = parseDate(*until); err != nil {
+		return fmt.Errorf("invalid -until: %v", err)
+	}
+	var colls []string
+	if *collections != "" {
+		colls = strings.Split(*collections, ",")
+	}
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+	opts.Workers, opts.BatchSize = cfg.Export.Workers, cfg.Export.BatchSize
+	if *anonymize {
+		if cfg.Export.AnonymizeKey == "" {
+			return fmt.Errorf("-anonymize needs EXPORT_ANONYMIZE_KEY")
+		}
+		if opts.Anonymize, err = db.NewAnonymizer(cfg.Export.AnonymizeKey, cfg.Export.AnonymizeFields); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var open func(coll string) (io.WriteCloser, error)
+	switch {
+	case *dest == "-":
+		if len(colls) != 1 {
+			return fmt.Errorf("exporting to stdout needs exactly one collection in -collections")
+		}
+		open = func(string) (io.WriteCloser, error) { return nopCloser{os.Stdout}, nil }
+	case strings.HasPrefix(*dest, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(*dest, "s3://"), "/")
+		if bucket == "" {
+			return fmt.Errorf("invalid -out %q: missing bucket", *dest)
+		}
+		client, err := s3.New(s3.Options{
+			Endpoint:        cfg.Export.S3Endpoint,
+			Region:          cfg.Export.S3Region,
+			AccessKeyID:     cfg.Export.S3AccessKey,
+			SecretAccessKey: cfg.Export.S3SecretKey,
+			SessionToken:    cfg.Export.S3SessionToken,
+			PathStyle:       cfg.Export.S3PathStyle,
+			PartSize:        cfg.Export.S3PartSize,
+		})
+		if err != nil {
+			return err
+		}
+		open = func(coll string) (io.WriteCloser, error) {
+			return client.Create(ctx, bucket, path.Join(prefix, coll+"."+opts.Format+".gz")), nil
+		}
+	default:
+		if err := os.MkdirAll(*dest, 0o755); err != nil {
+			return err
+		}
+		open = func(coll string) (io.WriteCloser, error) {
+			return os.Create(filepath.Join(*dest, coll+"."+opts.Format+".gz"))
+		}
+	}
+
+	mc, err := connectTool(cfg, sec)
+	if err != nil {
+		return err
+	}
+	defer mc.Disconnect()
+
+	start := time.Now()
+	counts, err := mc.Export(ctx, colls, opts, open)
+	for coll, n := range counts {
+		slog.Info("exported collection", "collection", coll, "documents", n)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("export complete", "collections", len(counts), "duration", time.Since(start))
+	return nil
+}
+
+// connectTool connects to MongoDB for the one-shot commands. Unlike
+// connectMongo it doesn't touch indexes, so the tools are safe to point at
+// production.
+func connectTool(cfg *config.Config, sec *secrets.Secrets) (*db.MongoClient, error) {
+	uri, err := sec.MongoURI(context.Background(), cfg.Mongo.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch MongoDB credentials: %v", err)
+	}
+	return db.Connect(uri, cfg.Mongo.Database, mongoConfig(cfg))
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
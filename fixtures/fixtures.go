@@ -0,0 +1,318 @@
+// Package fixtures loads named datasets from YAML or JSON files into the
+// database, for demo environments and integration runs.
+//
+// A dataset file maps collections to their documents:
+//
+//	users:
+//	  - name: John Doe
+//	    email: john.doe@example.com
+//	    created_at: 2023-01-01T00:00:00Z
+//
+// Collections are resources resolved like db.MongoClient.Collection. YAML
+// timestamps become BSON dates; in JSON, where there are none, write
+// {"$date": "2023-01-01T00:00:00Z"}. {"$oid": "..."} gives an ObjectID.
+// The datasets in this package's data directory, such as "sample", are
+// built in.
+package fixtures
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang/db"
+	"golang/store"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed data
+var builtin embed.FS
+
+// extensions are the dataset file extensions in order of lookup.
+var extensions = []string{".yaml", ".yml", ".json"}
+
+// Dataset is a set of documents per collection.
+type Dataset struct {
+	Name        string
+	Collections map[string][]bson.M
+}
+
+// Options controls how a dataset is applied.
+type Options struct {
+	// Reset deletes every document of the dataset's collections first,
+	// across all tenants, so the collections hold exactly the dataset.
+	Reset bool
+	// Tenant is set as tenant_id on documents that have none.
+	Tenant string
+}
+
+// Result counts the documents of one collection.
+type Result struct {
+	Collection string `json:"collection"`
+	Deleted    int64  `json:"deleted,omitempty"`
+	Inserted   int    `json:"inserted"`
+	// Skipped documents already existed by a unique key.
+	Skipped int `json:"skipped"`
+}
+
+// Find loads dataset name from dir, trying the extensions in turn, or from
+// the built-in datasets when dir has no such file.
+func Find(dir, name string) (*Dataset, error) {
+	if strings.ContainsAny(name, `/\`) || name == "" || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid dataset name %q", name)
+	}
+	if dir != "" {
+		for _, ext := range extensions {
+			data, err := os.ReadFile(filepath.Join(dir, name+ext))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return Parse(name, data)
+		}
+	}
+	for _, ext := range extensions {
+		if data, err := builtin.ReadFile("data/" + name + ext); err == nil {
+			return Parse(name, data)
+		}
+	}
+	return nil, fmt.Errorf("dataset %q not found", name)
+}
+
+// Load loads the dataset file at path, named after the file.
+func Load(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), data)
+}
+
+// Parse parses a dataset in YAML or JSON, which YAML includes.
+func Parse(name string, data []byte) (*Dataset, error) {
+	var raw map[string][]map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("dataset %s: %v", name, err)
+	}
+	d := &Dataset{Name: name, Collections: make(map[string][]bson.M, len(raw))}
+	for coll, docs := range raw {
+		out := make([]bson.M, len(docs))
+		for i, doc := range docs {
+			v, err := convert(doc)
+			if err != nil {
+				return nil, fmt.Errorf("dataset %s: %s[%d]: %v", name, coll, i, err)
+			}
+			out[i] = v.(bson.M)
+		}
+		d.Collections[coll] = out
+	}
+	return d, nil
+}
+
+// convert turns decoded YAML into BSON values, resolving the $date and
+// $oid wrappers.
+func convert(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 1 {
+			if s, ok := v["$oid"].(string); ok {
+				return primitive.ObjectIDFromHex(s)
+			}
+			if s, ok := v["$date"].(string); ok {
+				return time.Parse(time.RFC3339, s)
+			}
+		}
+		out := make(bson.M, len(v))
+		for k, e := range v {
+			c, err := convert(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+			out[k] = c
+		}
+		return out, nil
+	case []any:
+		out := make(bson.A, len(v))
+		for i, e := range v {
+			c, err := convert(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	case time.Time:
+		return v.UTC(), nil
+	}
+	return v, nil
+}
+
+// collections returns the dataset's collection names in order.
+func (d *Dataset) collections() []string {
+	names := make([]string, 0, len(d.Collections))
+	for c := range d.Collections {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply inserts the dataset into MongoDB. Documents conflicting with
+// existing ones on a unique index are skipped, so applying a dataset twice
+// doesn't duplicate it.
+func (d *Dataset) Apply(ctx context.Context, mc *db.MongoClient, opts Options) ([]Result, error) {
+	var out []Result
+	for _, name := range d.collections() {
+		coll := mc.Collection(name)
+		res := Result{Collection: name}
+		if opts.Reset {
+			dr, err := coll.DeleteMany(ctx, bson.M{})
+			if err != nil {
+				return out, fmt.Errorf("reset %s: %v", name, err)
+			}
+			res.Deleted = dr.DeletedCount
+		}
+
+		docs := make([]any, len(d.Collections[name]))
+		for i, doc := range d.Collections[name] {
+			if _, ok := doc["tenant_id"]; !ok && opts.Tenant != "" {
+				doc = copyDoc(doc)
+				doc["tenant_id"] = opts.Tenant
+			}
+			docs[i] = doc
+		}
+		if len(docs) > 0 {
+			ir, err := coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+			if ir != nil {
+				res.Inserted = len(ir.InsertedIDs)
+			}
+			var bwe mongo.BulkWriteException
+			if errors.As(err, &bwe) && bwe.WriteConcernError == nil && onlyDuplicates(bwe) {
+				res.Skipped, err = len(bwe.WriteErrors), nil
+			}
+			if err != nil {
+				return append(out, res), fmt.Errorf("insert into %s: %v", name, err)
+			}
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+func onlyDuplicates(e mongo.BulkWriteException) bool {
+	for _, we := range e.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func copyDoc(doc bson.M) bson.M {
+	out := make(bson.M, len(doc)+1)
+	for k, v := range doc {
+		out[k] = v
+	}
+	return out
+}
+
+// ApplyUsers inserts the dataset's users through a user repository, for
+// the backends other than MongoDB, into opts.Tenant or else the tenant in
+// ctx. Users whose email exists are skipped. Other collections and Reset
+// are not supported.
+func (d *Dataset) ApplyUsers(ctx context.Context, users store.UserRepository, opts Options) ([]Result, error) {
+	if opts.Reset {
+		return nil, errors.New("reset needs MongoDB")
+	}
+	for _, name := range d.collections() {
+		if name != "users" {
+			return nil, fmt.Errorf("collection %s needs MongoDB", name)
+		}
+	}
+	if opts.Tenant != "" {
+		ctx = tenant.NewContext(ctx, opts.Tenant)
+	}
+
+	res := Result{Collection: "users"}
+	var batch []store.User
+	for i, doc := range d.Collections["users"] {
+		u, err := userFromDoc(doc)
+		if err != nil {
+			return nil, fmt.Errorf("users[%d]: %v", i, err)
+		}
+		if u.Email != "" {
+			existing, err := users.List(ctx, store.UserFilter{Email: u.Email, Limit: 1})
+			if err != nil {
+				return nil, err
+			}
+			if len(existing) > 0 {
+				res.Skipped++
+				continue
+			}
+		}
+		batch = append(batch, u)
+	}
+	if len(batch) == 0 {
+		return []Result{res}, nil
+	}
+
+	var err error
+	if b, ok := users.(store.BulkUsers); ok {
+		err = b.CreateMany(ctx, batch)
+	} else {
+		for i := range batch {
+			if err = users.Create(ctx, &batch[i]); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return []Result{res}, err
+	}
+	res.Inserted = len(batch)
+	return []Result{res}, nil
+}
+
+// userFromDoc reads the user fields of doc.
+func userFromDoc(doc bson.M) (store.User, error) {
+	var u store.User
+	for k, v := range doc {
+		var ok bool
+		switch k {
+		case "name":
+			u.Name, ok = v.(string)
+		case "email":
+			u.Email, ok = v.(string)
+		case "password_hash":
+			u.PasswordHash, ok = v.(string)
+		case "age":
+			u.Age, ok = v.(int)
+		case "created_at":
+			u.CreatedAt, ok = v.(time.Time)
+		case "_id", "tenant_id":
+			ok = true // assigned by the repository
+		}
+		if !ok {
+			return u, fmt.Errorf("invalid field %s", k)
+		}
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	return u, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang/scaffold"
+)
+
+// gen generates code for a new resource following the users pattern. It
+// runs in the module root and never overwrites files unless -force is set.
+func gen(args []string) error {
+	fs := newBareFlagSet("gen")
+	fields := fs.String("fields", "", "comma-separated name:type fields, type being string, int, float, bool or time; a trailing ! marks required fields, e.g. title:string!,price:float")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Parse(args)
+	if fs.NArg() != 2 || fs.Arg(0) != "resource" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat("go.mod"); err != nil {
+		return errors.New("run gen from the module root")
+	}
+	res, err := scaffold.Parse(fs.Arg(1), *fields)
+	if err != nil {
+		return err
+	}
+	files, err := scaffold.Generate(res)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+		if _, err := os.Stat(p); err == nil && !*force {
+			return fmt.Errorf("%s exists; use -force to overwrite it", p)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := os.WriteFile(filepath.FromSlash(p), files[p], 0o644); err != nil {
+			return err
+		}
+		fmt.Println("wrote", p)
+	}
+	fmt.Printf("\nThe /%s routes are registered by the init function of api/%s.go, with api.Register;\nrun go test ./api and restart the server to serve them.\n", res.Plural, res.Name)
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang/store"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	firstNames = []string{"Abebe", "Ada", "Alan", "Amara", "Ana", "Chen", "Dawit", "Elena", "Fatima", "Grace",
+		"Hana", "Ivan", "Jamal", "Kenji", "Liya", "Lucas", "Maria", "Mohammed", "Nora", "Omar",
+		"Priya", "Rahel", "Sara", "Selam", "Tomas", "Yusuf", "Zoe"}
+	lastNames = []string{"Alemu", "Bekele", "Chen", "Garcia", "Haile", "Hopper", "Ivanova", "Kim", "Lovelace",
+		"Martin", "Mengistu", "Nakamura", "Okafor", "Patel", "Rossi", "Silva", "Tesfaye", "Turing", "Wang"}
+	emailDomains = []string{"example.com", "example.org", "example.net"}
+)
+
+// generate inserts fake users for load testing pagination and indexes.
+// Emails carry a per-run suffix so runs can be repeated against the same
+// database without hitting the unique email index.
+func generate(args []string) error {
+	fs, configPath := newFlagSet("generate")
+	count := fs.Int("n", 1000, "number of users to generate")
+	batchSize := fs.Int("batch-size", 500, "users inserted per round trip")
+	rate := fs.Float64("rate", 0, "maximum users inserted per second; 0 is unlimited")
+	span := fs.Duration("span", 365*24*time.Hour, "created_at times are spread over this long before now")
+	seedValue := fs.Int64("seed", 0, "random seed, to generate the same names and ages again; 0 picks one")
+	tenantID := fs.String("tenant", "", "tenant to create the users in")
+	fs.Parse(args)
+
+	if *count <= 0 || *batchSize <= 0 || *rate < 0 || *span <= 0 {
+		return errors.New("-n, -batch-size and -span must be positive and -rate not negative")
+	}
+	if *tenantID != "" && !tenant.Valid(*tenantID) {
+		return fmt.Errorf("invalid tenant %q", *tenantID)
+	}
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+
+	st, err := openStorage(cfg, sec, connectTool)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	bulk, ok := st.users.(store.BulkUsers)
+	if !ok {
+		return fmt.Errorf("storage %s can't create users in bulk", cfg.Storage.Backend)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = tenant.NewContext(ctx, *tenantID)
+
+	if *seedValue == 0 {
+		*seedValue = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(*seedValue))
+	run := primitive.NewObjectID().Hex()[16:] // the ObjectID counter differs per run
+	now := time.Now().UTC()
+
+	start := time.Now()
+	last := start
+	done := 0
+	for done < *count {
+		n := min(*batchSize, *count-done)
+		users := make([]store.User, n)
+		for i := range users {
+			users[i] = fakeUser(rnd, done+i, run, now, *span)
+		}
+		if err := bulk.CreateMany(ctx, users); err != nil {
+			slog.Error("generate stopped", "inserted", done, "error", err)
+			return err
+		}
+		done += n
+
+		if time.Since(last) >= 5*time.Second {
+			last = time.Now()
+			slog.Info("generate progress", "inserted", done, "of", *count)
+		}
+		// Wait until the batches so far fit within the rate
+		if *rate > 0 {
+			due := start.Add(time.Duration(float64(done) / *rate * float64(time.Second)))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	slog.Info("generate complete", "inserted", done, "duration", elapsed,
+		"per_second", int(float64(done)/elapsed.Seconds()), "seed", *seedValue)
+	return nil
+}
+
+// fakeUser returns the i-th generated user of run, created within span
+// before now.
+func fakeUser(rnd *rand.Rand, i int, run string, now time.Time, span time.Duration) store.User {
+	first := firstNames[rnd.Intn(len(firstNames))]
+	last := lastNames[rnd.Intn(len(lastNames))]
+	return store.User{
+		Name:      first + " " + last,
+		Email:     fmt.Sprintf("%s.%s.%s.%d@%s", strings.ToLower(first), strings.ToLower(last), run, i, emailDomains[rnd.Intn(len(emailDomains))]),
+		Age:       18 + rnd.Intn(63),
+		CreatedAt: now.Add(-time.Duration(rnd.Int63n(int64(span)))).Truncate(time.Millisecond),
+	}
+}
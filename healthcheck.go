@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// healthcheck asks the local server whether it is ready, for Docker
+// HEALTHCHECK and exec probes in images without curl:
+//
+//	HEALTHCHECK CMD ["/server", "healthcheck"]
+//
+// It exits 0 when /readyz answers 200 and 1 otherwise. It reads only PORT,
+// LISTEN_SOCKET, HTTP_TLS_CERT_FILE, ADMIN_ADDR and ADMIN_PATHS rather
+// than the whole configuration, so probes stay cheap and don't fetch
+// secrets. With PORT=0 it asks through the socket, and when ADMIN_PATHS
+// has /readyz the admin listener.
+func healthcheck(args []string) error {
+	fs := newBareFlagSet("healthcheck")
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	socket := os.Getenv("LISTEN_SOCKET")
+	if port != "0" {
+		socket = ""
+	}
+	scheme := "http"
+	if os.Getenv("HTTP_TLS_CERT_FILE") != "" && socket == "" {
+		scheme = "https"
+	}
+	host := "127.0.0.1:" + port
+	if admin := os.Getenv("ADMIN_ADDR"); admin != "" && slices.Contains(strings.Split(strings.ReplaceAll(os.Getenv("ADMIN_PATHS"), " ", ""), ","), "/readyz") {
+		h, p, _ := net.SplitHostPort(admin)
+		if h == "" {
+			h = "127.0.0.1"
+		}
+		host, scheme, socket = net.JoinHostPort(h, p), "http", ""
+	}
+	url := fs.String("url", scheme+"://"+host+"/readyz", "readiness endpoint to probe")
+	timeout := fs.Duration("timeout", 3*time.Second, "how long to wait for the answer")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: *timeout}
+	if socket != "" {
+		// The host of the URL is ignored
+		client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}}
+	} else if scheme == "https" {
+		// The certificate names the public host, not 127.0.0.1
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Get(*url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("not ready: " + resp.Status)
+	}
+	return nil
+}
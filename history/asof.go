@@ -0,0 +1,48 @@
+package history
+
+import (
+	"context"
+	"time"
+
+	"golang/store"
+)
+
+// AsOf returns u as it was at time at, by undoing the changes recorded
+// after it, newest first. It is only as complete as the history: changes
+// made before history was enabled or outside the API can't be undone. A
+// user created after at is returned as is; the caller tells from its
+// CreatedAt.
+func (s *Store) AsOf(ctx context.Context, u *store.User, at time.Time) (*store.User, error) {
+	// changed_at is stored with millisecond precision
+	changes, err := s.List(ctx, u.ID, Filter{Since: at.Truncate(time.Millisecond).Add(time.Millisecond)})
+	if err != nil {
+		return nil, err
+	}
+	out := *u
+	for _, c := range changes {
+		setUserField(&out, c.Field, c.Old)
+	}
+	return &out, nil
+}
+
+// setUserField sets field of u to v as stored in a change, the zero value
+// for nil.
+func setUserField(u *store.User, field string, v any) {
+	switch field {
+	case "name":
+		u.Name, _ = v.(string)
+	case "email":
+		u.Email, _ = v.(string)
+	case "age":
+		switch n := v.(type) {
+		case int32:
+			u.Age = int(n)
+		case int64:
+			u.Age = int(n)
+		case float64:
+			u.Age = int(n)
+		default:
+			u.Age = 0
+		}
+	}
+}
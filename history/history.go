@@ -0,0 +1,164 @@
+// Package history keeps the field-level history of users in the
+// "user_history" collection: one change per field an update set, with
+// the old and new value, who made it and when, so the state of a field can
+// be followed over time beyond the activity feed, which only names the
+// fields.
+//
+// Like activities, recording is best effort: an update that succeeded is
+// not failed because its history could not be stored. New values of
+// passwords are never kept, only that the password changed.
+package history
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang/db"
+	"golang/requestid"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "user_history", Name: "user_field", Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "field", Value: 1}, {Key: "_id", Value: -1}}},
+		db.IndexSpec{Collection: "user_history", Name: "user_changes", Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+	)
+}
+
+// Change is the change of one field of a user.
+type Change struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID string             `bson:"tenant_id,omitempty" json:"-"`
+	UserID   string             `bson:"user_id" json:"user_id"`
+	Field    string             `bson:"field" json:"field"`
+	// Old and New are the values before and after, null for a field that
+	// was unset or is being unset and for passwords.
+	Old any `bson:"old" json:"old"`
+	New any `bson:"new" json:"new"`
+	// Actor is who made the change, such as "admin" or "user:<id>", or
+	// empty for unauthenticated requests.
+	Actor     string    `bson:"actor,omitempty" json:"actor,omitempty"`
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+type actorKey struct{}
+
+// NewContext returns a copy of ctx naming actor as the maker of the
+// changes recorded with it.
+func NewContext(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored in ctx, or "".
+func ActorFromContext(ctx context.Context) string {
+	a, _ := ctx.Value(actorKey{}).(string)
+	return a
+}
+
+// Store stores changes.
+type Store struct {
+	mc *db.MongoClient
+}
+
+// New returns a store kept through mc.
+func New(mc *db.MongoClient) *Store {
+	return &Store{mc: mc}
+}
+
+// Record stores changes of user userID. Their ids, tenant, actor, request
+// id and time are taken from ctx and the clock.
+func (s *Store) Record(ctx context.Context, userID string, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	docs := make([]any, len(changes))
+	for i, c := range changes {
+		c.ID = primitive.NewObjectID()
+		c.TenantID = tenant.FromContext(ctx)
+		c.UserID = userID
+		c.Actor = ActorFromContext(ctx)
+		c.RequestID = requestid.FromContext(ctx)
+		c.ChangedAt = now
+		docs[i] = c
+	}
+	_, err := s.mc.Collection("user_history").InsertMany(ctx, docs, options.InsertMany().SetComment(db.Comment(ctx)))
+	return err
+}
+
+// Filter selects changes. Zero fields select everything.
+type Filter struct {
+	Field string
+	// Since and Until bound when the changes were made, Until excluded.
+	Since, Until time.Time
+	// Before continues a list after the change with this id.
+	Before primitive.ObjectID
+	Limit  int
+}
+
+// List returns the changes of user userID in the tenant of ctx that f
+// selects, newest first.
+func (s *Store) List(ctx context.Context, userID string, f Filter) ([]Change, error) {
+	filter := bson.M{"tenant_id": nil, "user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	if f.Field != "" {
+		filter["field"] = f.Field
+	}
+	// Ids start with the second of the change, so the time bounds also
+	// narrow the index scan; changed_at makes them exact
+	ids := bson.M{}
+	at := bson.M{}
+	if !f.Since.IsZero() {
+		ids["$gte"] = primitive.NewObjectIDFromTimestamp(f.Since)
+		at["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		ids["$lt"] = primitive.NewObjectIDFromTimestamp(f.Until.Add(time.Second))
+		at["$lt"] = f.Until
+	}
+	if !f.Before.IsZero() {
+		if until, ok := ids["$lt"].(primitive.ObjectID); !ok || bytes.Compare(f.Before[:], until[:]) < 0 {
+			ids["$lt"] = f.Before
+		}
+	}
+	if len(ids) > 0 {
+		filter["_id"] = ids
+	}
+	if len(at) > 0 {
+		filter["changed_at"] = at
+	}
+	cur, err := s.mc.ReadCollection("user_history").Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(f.Limit)).
+		SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Change{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Erase removes the history of user userID in the tenant of ctx, for
+// data subject erasure, and returns how many changes it removed.
+func (s *Store) Erase(ctx context.Context, userID string) (int64, error) {
+	filter := bson.M{"tenant_id": nil, "user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	res, err := s.mc.Collection("user_history").DeleteMany(ctx, filter, options.Delete().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
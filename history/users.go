@@ -0,0 +1,83 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"golang/store"
+)
+
+// Users records the changes of every successful update through the
+// wrapped UserRepository. The user is read before the update for the old
+// values, so a concurrent write between the two may show as the old value
+// of a change.
+type Users struct {
+	store.UserRepository
+	s *Store
+}
+
+// NewUsers returns next recording the changes of its updates in s.
+func NewUsers(next store.UserRepository, s *Store) *Users {
+	return &Users{UserRepository: next, s: s}
+}
+
+// Update records a change for each field whose value it changed; a new
+// password hash shows as a change of "password" without values.
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	// A failed read leaves the old values unknown; the update reports a
+	// missing user itself
+	before, _ := u.UserRepository.Get(ctx, id)
+	if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var changes []Change
+	for _, k := range names {
+		if k == "password_hash" {
+			changes = append(changes, Change{Field: "password"})
+			continue
+		}
+		old := userField(before, k)
+		if old != nil && fields[k] != nil && fmt.Sprint(old) == fmt.Sprint(fields[k]) {
+			continue
+		}
+		if old == nil && fields[k] == nil {
+			continue
+		}
+		changes = append(changes, Change{Field: k, Old: old, New: fields[k]})
+	}
+	if err := u.s.Record(ctx, id, changes); err != nil {
+		slog.ErrorContext(ctx, "failed to record user history", "user_id", id, "error", err)
+	}
+	return nil
+}
+
+// userField returns field of u as stored, or nil when it is unset or
+// unknown.
+func userField(u *store.User, field string) any {
+	if u == nil {
+		return nil
+	}
+	switch field {
+	case "name":
+		if u.Name != "" {
+			return u.Name
+		}
+	case "email":
+		if u.Email != "" {
+			return u.Email
+		}
+	case "age":
+		return u.Age
+	case "created_at":
+		return u.CreatedAt
+	}
+	return nil
+}
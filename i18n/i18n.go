@@ -0,0 +1,166 @@
+// Package i18n translates the API's error messages.
+//
+// Catalogs are JSON files in catalogs/, one per language and embedded in
+// the binary, mapping English messages to translations. A key may hold
+// placeholders {0}, {1}, ... standing for any text, such as a field name
+// or a limit, which the translation repeats in its own order; the text
+// they stand for is translated too when the catalog has it. Messages
+// without an entry stay English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language of the messages in the code.
+const Default = "en"
+
+//go:embed catalogs/*.json
+var files embed.FS
+
+// catalog is the translations of one language.
+type catalog struct {
+	exact    map[string]string
+	patterns []pattern
+}
+
+// pattern is a key with placeholders.
+type pattern struct {
+	re    *regexp.Regexp
+	args  []int // placeholder number of each capture
+	trans string
+}
+
+var placeholder = regexp.MustCompile(`\{(\d+)\}`)
+
+var catalogs = map[string]*catalog{}
+
+func init() {
+	entries, err := files.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		lang := strings.TrimSuffix(e.Name(), ".json")
+		c, err := load(path.Join("catalogs", e.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n catalog %s: %v", lang, err))
+		}
+		catalogs[lang] = c
+	}
+}
+
+func load(name string) (*catalog, error) {
+	b, err := files.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	c := &catalog{exact: map[string]string{}}
+	for key, trans := range m {
+		if !placeholder.MatchString(key) {
+			c.exact[key] = trans
+			continue
+		}
+		p := pattern{trans: trans}
+		expr := "^"
+		last := 0
+		for _, loc := range placeholder.FindAllStringSubmatchIndex(key, -1) {
+			n, _ := strconv.Atoi(key[loc[2]:loc[3]])
+			expr += regexp.QuoteMeta(key[last:loc[0]]) + "(.+?)"
+			p.args = append(p.args, n)
+			last = loc[1]
+		}
+		p.re = regexp.MustCompile(expr + regexp.QuoteMeta(key[last:]) + "$")
+		c.patterns = append(c.patterns, p)
+	}
+	// Longer keys are more specific, e.g. "invalid json body" over
+	// "invalid {0}"; sorting also makes matching deterministic
+	sort.Slice(c.patterns, func(i, j int) bool {
+		a, b := c.patterns[i].re.String(), c.patterns[j].re.String()
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return c, nil
+}
+
+// Languages returns the supported languages, Default first.
+func Languages() []string {
+	out := []string{Default}
+	for lang := range catalogs {
+		if lang != Default {
+			out = append(out, lang)
+		}
+	}
+	sort.Strings(out[1:])
+	return out
+}
+
+// Negotiate returns the supported language the Accept-Language header
+// value prefers, matching on the primary subtag (es-MX is es), or Default.
+func Negotiate(accept string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[lang]; (ok || lang == Default) && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Translate returns msg in lang, or msg itself without a translation.
+func Translate(lang, msg string) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		return msg
+	}
+	return c.translate(msg, 0)
+}
+
+// maxDepth bounds translating placeholder text within placeholder text.
+const maxDepth = 3
+
+func (c *catalog) translate(msg string, depth int) string {
+	if t, ok := c.exact[msg]; ok {
+		return t
+	}
+	if depth == maxDepth {
+		return msg
+	}
+	for _, p := range c.patterns {
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		args := map[string]string{}
+		for i, n := range p.args {
+			args[strconv.Itoa(n)] = c.translate(m[i+1], depth+1)
+		}
+		return placeholder.ReplaceAllStringFunc(p.trans, func(ph string) string {
+			return args[ph[1:len(ph)-1]]
+		})
+	}
+	return msg
+}
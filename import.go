@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang/db"
+)
+
+// importCmd connects to MongoDB and loads a dump into a collection.
+func importCmd(args []string) error {
+	fs, configPath := newFlagSet("import")
+	collection := fs.String("collection", "", "collection to import into; defaults to the file name up to the first dot")
+	format := fs.String("format", "", "dump format: json or bson; defaults to the file name extension, else json")
+	conflict := fs.String("conflict", db.ConflictFail, "what to do with documents whose _id exists: skip, overwrite or fail")
+	batchSize := fs.Int("batch-size", 1000, "documents written per round trip")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source := fs.Arg(0)
+
+	// users.json.gz names collection users in format json
+	name := filepath.Base(source)
+	parts := strings.Split(name, ".")
+	if *collection == "" && source != "-" {
+		*collection = parts[0]
+	}
+	if *collection == "" {
+		return fmt.Errorf("importing from stdin needs -collection")
+	}
+	if *format == "" {
+		*format = db.FormatJSON
+		for _, p := range parts[1:] {
+			if p == db.FormatBSON {
+				*format = db.FormatBSON
+			}
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+
+	mc, err := connectTool(cfg, sec)
+	if err != nil {
+		return err
+	}
+	defer mc.Disconnect()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	last := start
+	p, err := mc.Import(ctx, in, *collection, db.ImportOptions{
+		Format:    *format,
+		Conflict:  *conflict,
+		BatchSize: *batchSize,
+		Progress: func(p db.ImportProgress) {
+			if time.Since(last) >= 5*time.Second {
+				last = time.Now()
+				slog.Info("import progress", "collection", *collection, "read", p.Read,
+					"inserted", p.Inserted, "replaced", p.Replaced, "skipped", p.Skipped)
+			}
+		},
+	})
+	if err != nil {
+		slog.Error("import stopped", "collection", *collection, "read", p.Read,
+			"inserted", p.Inserted, "replaced", p.Replaced, "skipped", p.Skipped)
+		return err
+	}
+	slog.Info("import complete", "collection", *collection, "read", p.Read,
+		"inserted", p.Inserted, "replaced", p.Replaced, "skipped", p.Skipped, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,422 @@
+// Package jobs is a MongoDB backed queue for work that shouldn't run in
+// request handlers, such as webhook deliveries and emails.
+//
+// Jobs are documents of the "jobs" collection. Workers claim due jobs by
+// setting a lease (the visibility timeout) in one atomic update, so every
+// job runs on one worker at a time across all instances, and a job whose
+// worker died becomes due again when its lease runs out. Failed jobs are
+// retried with exponential backoff until they run out of attempts.
+// Handlers must therefore be idempotent: a job may run more than once.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang/db"
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job states.
+const (
+	StateQueued    = "queued" // waiting for RunAt
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed" // out of attempts or permanently failed
+)
+
+var (
+	// ErrNotFound is returned for unknown jobs.
+	ErrNotFound = errors.New("job not found")
+	// ErrNotFailed is returned by Retry for jobs that haven't failed.
+	ErrNotFailed = errors.New("job has not failed")
+)
+
+// retention is how long finished jobs are kept.
+const retention = 7 * 24 * time.Hour
+
+var jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jobs_runs_total",
+	Help: "Job runs by type and result (success, retry or failed).",
+}, []string{"type", "result"})
+
+func init() {
+	keep := retention
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "jobs", Name: "due", Keys: bson.D{{Key: "state", Value: 1}, {Key: "run_at", Value: 1}}},
+		db.IndexSpec{Collection: "jobs", Name: "finished_at_ttl", Keys: bson.D{{Key: "finished_at", Value: 1}}, ExpireAfter: &keep},
+	)
+}
+
+// Job is a unit of queued work.
+type Job struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Type        string             `json:"type" bson:"type"`
+	Payload     json.RawMessage    `json:"payload" bson:"payload"`
+	TenantID    string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	State       string             `json:"state" bson:"state"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	MaxAttempts int                `json:"max_attempts" bson:"max_attempts"`
+	Backoff     time.Duration      `json:"-" bson:"backoff"`
+	MaxBackoff  time.Duration      `json:"-" bson:"max_backoff"`
+	RunAt       time.Time          `json:"run_at" bson:"run_at"`
+	LastError   string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the job's payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job. The context carries the job's tenant and is
+// cancelled when the job's lease runs out. Returning an error retries the
+// job, unless it is wrapped by Permanent.
+type Handler func(ctx context.Context, j *Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying; the job fails at once.
+func Permanent(err error) error { return permanentError{err} }
+
+// EnqueueOptions tunes a job. Zero fields take the queue defaults.
+type EnqueueOptions struct {
+	// RunAt delays the first run; defaults to now.
+	RunAt time.Time
+	// MaxAttempts is how often the job runs before it fails.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for every
+	// further one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Options configures a Queue. Zero fields take the defaults.
+type Options struct {
+	// Workers is the number of jobs run at once; defaults to 4.
+	Workers int
+	// PollInterval is how often idle workers look for jobs due by time or
+	// enqueued by other instances; defaults to 5s.
+	PollInterval time.Duration
+	// Visibility is how long a claimed job is hidden from other workers,
+	// and so the longest a run may take; defaults to 5m.
+	Visibility time.Duration
+	// Defaults for EnqueueOptions: 5 attempts, 30s and 1h.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Queue enqueues jobs and, once Run is called, runs them.
+type Queue struct {
+	mc   *db.MongoClient
+	opts Options
+	wake chan struct{}
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// New returns a Queue storing its jobs through mc.
+func New(mc *db.MongoClient, opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.Visibility <= 0 {
+		opts.Visibility = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	return &Queue{mc: mc, opts: opts, wake: make(chan struct{}, 1), handlers: map[string]Handler{}}
+}
+
+// Handle registers the handler of jobs of type typ. Workers only claim
+// jobs of registered types.
+func (q *Queue) Handle(typ string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[typ] = h
+}
+
+func (q *Queue) types() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	out := make([]string, 0, len(q.handlers))
+	for t := range q.handlers {
+		out = append(out, t)
+	}
+	return out
+}
+
+// Enqueue stores a job of type typ with payload marshalled as JSON, in the
+// tenant of ctx.
+func (q *Queue) Enqueue(ctx context.Context, typ string, payload any, opts EnqueueOptions) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("job payload: %v", err)
+	}
+	now := time.Now().UTC()
+	j := &Job{
+		ID:          primitive.NewObjectID(),
+		Type:        typ,
+		Payload:     b,
+		TenantID:    tenant.FromContext(ctx),
+		State:       StateQueued,
+		MaxAttempts: opts.MaxAttempts,
+		Backoff:     opts.Backoff,
+		MaxBackoff:  opts.MaxBackoff,
+		RunAt:       opts.RunAt.UTC(),
+		CreatedAt:   now,
+	}
+	if j.MaxAttempts <= 0 {
+		j.MaxAttempts = q.opts.MaxAttempts
+	}
+	if j.Backoff <= 0 {
+		j.Backoff = q.opts.Backoff
+	}
+	if j.MaxBackoff <= 0 {
+		j.MaxBackoff = q.opts.MaxBackoff
+	}
+	if opts.RunAt.IsZero() {
+		j.RunAt = now
+	}
+	if _, err := q.mc.Collection("jobs").InsertOne(ctx, j); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return j, nil
+}
+
+// Get returns job id.
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var j Job
+	err = q.mc.Collection("jobs").FindOne(ctx, bson.M{"_id": oid}).Decode(&j)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Filter selects jobs for List. Zero fields don't filter.
+type Filter struct {
+	Type   string
+	State  string
+	Offset int
+	Limit  int // 0 means no limit
+}
+
+// List returns the jobs matching f, newest first.
+func (q *Queue) List(ctx context.Context, f Filter) ([]Job, error) {
+	filter := bson.M{}
+	if f.Type != "" {
+		filter["type"] = f.Type
+	}
+	if f.State != "" {
+		filter["state"] = f.State
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetSkip(int64(f.Offset))
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	cur, err := q.mc.Collection("jobs").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := []Job{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Retry queues a failed job again with a fresh set of attempts.
+func (q *Queue) Retry(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	res, err := q.mc.Collection("jobs").UpdateOne(ctx, bson.M{"_id": oid, "state": StateFailed}, bson.M{
+		"$set":   bson.M{"state": StateQueued, "attempts": 0, "run_at": time.Now().UTC()},
+		"$unset": bson.M{"finished_at": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := q.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrNotFailed
+	}
+	q.notify()
+	return nil
+}
+
+// notify wakes a worker for a newly due job.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs due jobs on Options.Workers workers until ctx is done, then
+// waits for the running jobs to return.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	t := time.NewTicker(q.opts.PollInterval)
+	defer t.Stop()
+	for {
+		// Drain everything due before waiting again
+		for ctx.Err() == nil {
+			j, err := q.claim(ctx)
+			if err != nil {
+				if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+					slog.Error("failed to claim job", "error", err)
+				}
+				break
+			}
+			q.run(ctx, j)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// claim takes the next due job: queued ones whose time has come and
+// running ones whose lease ran out.
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	types := q.types()
+	if len(types) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	now := time.Now().UTC()
+	var j Job
+	err := q.mc.Collection("jobs").FindOneAndUpdate(ctx,
+		bson.M{
+			"state":  bson.M{"$in": bson.A{StateQueued, StateRunning}},
+			"run_at": bson.M{"$lte": now},
+			"type":   bson.M{"$in": types},
+		},
+		bson.M{
+			"$set": bson.M{"state": StateRunning, "run_at": now.Add(q.opts.Visibility)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "run_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&j)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// run runs j and records the outcome.
+func (q *Queue) run(ctx context.Context, j *Job) {
+	q.mu.RLock()
+	h := q.handlers[j.Type]
+	q.mu.RUnlock()
+
+	jctx := ctx
+	if j.TenantID != "" {
+		jctx = tenant.NewContext(jctx, j.TenantID)
+	}
+	jctx, cancel := context.WithTimeout(jctx, q.opts.Visibility)
+	err := safeRun(jctx, h, j)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down: leave the lease to run out so another worker
+		// picks the job up
+		return
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{}
+	var perm permanentError
+	switch {
+	case err == nil:
+		set["state"] = StateSucceeded
+		set["finished_at"] = now
+		jobRuns.WithLabelValues(j.Type, "success").Inc()
+	case errors.As(err, &perm) || j.Attempts >= j.MaxAttempts:
+		set["state"] = StateFailed
+		set["finished_at"] = now
+		set["last_error"] = err.Error()
+		jobRuns.WithLabelValues(j.Type, "failed").Inc()
+		slog.Warn("job failed", "job_id", j.ID.Hex(), "type", j.Type, "attempts", j.Attempts, "error", err)
+	default:
+		set["state"] = StateQueued
+		set["run_at"] = now.Add(backoff(j))
+		set["last_error"] = err.Error()
+		jobRuns.WithLabelValues(j.Type, "retry").Inc()
+	}
+	if _, err := q.mc.Collection("jobs").UpdateByID(ctx, j.ID, bson.M{"$set": set}); err != nil {
+		slog.Error("failed to record job result", "job_id", j.ID.Hex(), "error", err)
+	}
+}
+
+// safeRun runs h, turning a panic into an error.
+func safeRun(ctx context.Context, h Handler, j *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h(ctx, j)
+}
+
+// backoff returns the wait after the job's failed attempts.
+func backoff(j *Job) time.Duration {
+	b := j.Backoff
+	for i := 1; i < j.Attempts && b < j.MaxBackoff; i++ {
+		b *= 2
+	}
+	return min(b, j.MaxBackoff)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// listenUnix listens on the Unix domain socket at path with permissions
+// perm and, unless group is empty, owned by group, a name or id. A socket
+// file left behind by a crashed server is removed first; one a running
+// server still answers on is not.
+func listenUnix(path string, perm os.FileMode, group string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		var unknown user.UnknownGroupError
+		if errors.As(err, &unknown) {
+			g, err = user.LookupGroupId(group)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("socket group: %v", err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("socket group %s: gid %q is not numeric", group, g.Gid)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Closing the listener removes the socket file
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if gid >= 0 {
+		if err := os.Lchown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
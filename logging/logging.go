@@ -0,0 +1,75 @@
+// Package logging configures the process-wide slog logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"golang/buildinfo"
+)
+
+// level is shared by the installed handler so SetLevel takes effect
+// immediately.
+var level slog.LevelVar
+
+// SetLevel changes the minimum level of the logger installed by Setup.
+func SetLevel(name string) error {
+	lvl, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// Setup installs a slog default logger writing to w. format is "json" or
+// "text" and level one of "debug", "info", "warn" or "error"; empty values
+// default to JSON at info level. Output of the standard log package is
+// routed through the same handler. Records carry the version of the build,
+// so logs name the build that wrote them, and are redacted as red says.
+func Setup(w io.Writer, format, levelName string, red Redaction) error {
+	lvl, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	redactor, err := newRedactor(red)
+	if err != nil {
+		return err
+	}
+
+	level.Set(lvl)
+	opts := &slog.HandlerOptions{Level: &level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want json or text)", format)
+	}
+
+	if len(redactor.keys) > 0 || len(redactor.patterns) > 0 {
+		h = redactHandler{h, redactor}
+	}
+	redaction.Store(redactor)
+	slog.SetDefault(slog.New(contextHandler{h}).With("version", buildinfo.Get().Version))
+	return nil
+}
+
+// ParseLevel converts a level name to a slog.Level.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q", level)
+}
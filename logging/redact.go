@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Redacted replaces redacted values.
+const Redacted = "[redacted]"
+
+// Redaction says what Setup redacts from log output.
+type Redaction struct {
+	// Keys are attribute keys whose values are replaced, matched
+	// case-insensitively against whole segments of the key between _, .
+	// and -, so password also matches new_password and api_key matches
+	// x.api_key, but token doesn't match tokens_used.
+	Keys []string
+	// Patterns are regular expressions whose matches are replaced in the
+	// message, in string values and in errors.
+	Patterns []string
+}
+
+// redactor applies a Redaction.
+type redactor struct {
+	keys     [][]string // segments of the keys
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(r Redaction) (*redactor, error) {
+	red := &redactor{}
+	for _, k := range r.Keys {
+		if segs := keySegments(k); len(segs) > 0 {
+			red.keys = append(red.keys, segs)
+		}
+	}
+	for _, p := range r.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", p, err)
+		}
+		red.patterns = append(red.patterns, re)
+	}
+	return red, nil
+}
+
+// redaction is the redactor installed by Setup, for Redact.
+var redaction atomic.Pointer[redactor]
+
+// Redact replaces the matches of the patterns installed by Setup in s, for
+// text that leaves the process other than through the logger, such as
+// error messages.
+func Redact(s string) string {
+	if red := redaction.Load(); red != nil {
+		return red.string(s)
+	}
+	return s
+}
+
+func (red *redactor) string(s string) string {
+	for _, re := range red.patterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// keySegments returns the parts of key between _, . and -, lowercased.
+func keySegments(key string) []string {
+	return strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '_' || r == '.' || r == '-'
+	})
+}
+
+// sensitive reports whether the segments of one of the keys appear in
+// order in those of key.
+func (red *redactor) sensitive(key string) bool {
+	segs := keySegments(key)
+	for _, k := range red.keys {
+		for i := 0; i+len(k) <= len(segs); i++ {
+			if slices.Equal(segs[i:i+len(k)], k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attr returns a with its value redacted. Values other than strings,
+// errors and groups are kept, as is anything a LogValuer doesn't resolve
+// to one of those.
+func (red *redactor) attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = red.attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	}
+	if red.sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, red.string(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, red.string(err.Error()))
+		}
+	}
+	return a
+}
+
+// redactHandler redacts records before passing them on.
+type redactHandler struct {
+	slog.Handler
+	red *redactor
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.red.string(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.red.attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = h.red.attr(a)
+	}
+	return redactHandler{h.Handler.WithAttrs(out), h.red}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name), h.red}
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+var testRedaction = Redaction{
+	Keys:     []string{"password", "token", "api_key", "Authorization"},
+	Patterns: []string{`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+`, `(?i)bearer [A-Za-z0-9._~+/=-]+`},
+}
+
+func TestRedactorSensitive(t *testing.T) {
+	red, err := newRedactor(testRedaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"new_password", true},
+		{"Password", true},
+		{"token", true},
+		{"csrf_token_age", true},
+		{"refresh-token", true},
+		{"session.token", true},
+		{"api_key", true},
+		{"x.api_key", true},
+		{"authorization", true},
+		{"tokens_used", false},
+		{"tokenizer", false},
+		{"passwords_reset", false},
+		{"key", false},
+		{"api", false},
+		{"api_key_id_hint", true},
+		{"user_id", false},
+	}
+	for _, tt := range tests {
+		if got := red.sensitive(tt.key); got != tt.want {
+			t.Errorf("sensitive(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	red, err := newRedactor(testRedaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	log := slog.New(redactHandler{slog.NewJSONHandler(&buf, nil), red})
+
+	log.With("api_key", "k-123", "service", "users").
+		WithGroup("req").
+		Info("login by ada@example.com",
+			"password", "hunter2",
+			"tokens_used", 42,
+			"csrf_token_age", "5m",
+			"header", "Bearer abc.def",
+			"error", errors.New("no user ada@example.com"),
+			slog.Group("user", "id", "u1", "email", "grace@example.com", "token", "t-1"))
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	req, _ := got["req"].(map[string]any)
+	user, _ := req["user"].(map[string]any)
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"msg", got["msg"], "login by " + Redacted},
+		{"api_key", got["api_key"], Redacted},
+		{"service", got["service"], "users"},
+		{"req.password", req["password"], Redacted},
+		{"req.tokens_used", req["tokens_used"], 42.0},
+		{"req.csrf_token_age", req["csrf_token_age"], Redacted},
+		{"req.header", req["header"], Redacted},
+		{"req.error", req["error"], "no user " + Redacted},
+		{"req.user.id", user["id"], "u1"},
+		{"req.user.email", user["email"], Redacted},
+		{"req.user.token", user["token"], Redacted},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "example.com") {
+		t.Errorf("log line leaks a redacted value: %s", buf.Bytes())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	prev := redaction.Load()
+	t.Cleanup(func() { redaction.Store(prev) })
+
+	redaction.Store(nil)
+	msg := `duplicate key error: { email: "ada@example.com" }`
+	if got := Redact(msg); got != msg {
+		t.Errorf("Redact before Setup = %q, want it unchanged", got)
+	}
+
+	red, err := newRedactor(testRedaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redaction.Store(red)
+	if got, want := Redact(msg), `duplicate key error: { email: "`+Redacted+`" }`; got != want {
+		t.Errorf("Redact(%q) = %q, want %q", msg, got, want)
+	}
+}
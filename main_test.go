@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang/api"
+	"golang/config"
+	"golang/secrets"
+	"golang/store"
+)
+
+func TestReloadConfigReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(readOnly string) {
+		t.Helper()
+		conf := "storage:\n  backend: memory\nread_only: " + readOnly + "\n"
+		if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sec := &secrets.Secrets{}
+	write("false")
+	cfg, err := config.Load(path, sec.Get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := api.NewRouter(nil, api.Options{Users: store.NewMemoryUsers()})
+
+	create := func() int {
+		body := strings.NewReader(`{"name":"Ada","email":"ada@example.com","age":36}`)
+		req := httptest.NewRequest(http.MethodPost, "/users", body)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	write("true")
+	cfg = reloadConfig(cfg, path, sec, router)
+	if !cfg.ReadOnly {
+		t.Error("applied config is not read-only after reloading read_only: true")
+	}
+	if code := create(); code != http.StatusServiceUnavailable {
+		t.Errorf("POST /users while read-only = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	write("false")
+	cfg = reloadConfig(cfg, path, sec, router)
+	if cfg.ReadOnly {
+		t.Error("applied config is still read-only after reloading read_only: false")
+	}
+	if code := create(); code != http.StatusCreated {
+		t.Errorf("POST /users after leaving read-only = %d, want %d", code, http.StatusCreated)
+	}
+}
+
+func TestReloadConfigCanaryWeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("storage:\n  backend: memory\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sec := &secrets.Secrets{}
+	cfg, err := config.Load(path, sec.Get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := api.NewRouter(nil, api.Options{Users: store.NewMemoryUsers()})
+
+	conf := "storage:\n  backend: memory\nhttp:\n  canary_weights:\n    /users: 10\n"
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg = reloadConfig(cfg, path, sec, router)
+	if got := cfg.HTTP.CanaryWeights["/users"]; got != 10 {
+		t.Errorf("applied canary weight of /users = %d, want 10", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Package metering meters the API per tenant and API key, so internal teams
+// can be billed for what they use: requests, and the MongoDB read and
+// write units (see db.Units) spent answering them. Counts are summed in
+// memory and added to one document per day, tenant and key in the "usage"
+// collection every flush interval.
+//
+// Monthly request quotas are enforced from the stored counts plus those
+// not flushed yet. With several instances a caller can exceed its quota
+// by what the other instances counted since their last flush.
+package metering
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DayFormat is the format of the days usage is summed by.
+const DayFormat = "2006-01-02"
+
+func init() {
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "usage", Name: "day_1_tenant_id_1_api_key_1", Keys: bson.D{{Key: "day", Value: 1}, {Key: "tenant_id", Value: 1}, {Key: "api_key", Value: 1}}},
+	)
+}
+
+// Subject is who usage is metered for. APIKey identifies the key without
+// revealing it, e.g. "key:3f2a9c01", or is "anonymous".
+type Subject struct {
+	TenantID string
+	APIKey   string
+}
+
+// Counts is the usage of a subject.
+type Counts struct {
+	Requests   int64 `bson:"requests" json:"requests"`
+	ReadUnits  int64 `bson:"read_units" json:"read_units"`
+	WriteUnits int64 `bson:"write_units" json:"write_units"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Requests += o.Requests
+	c.ReadUnits += o.ReadUnits
+	c.WriteUnits += o.WriteUnits
+}
+
+// day is the usage of a subject on a day.
+type day struct {
+	Subject
+	Day string
+}
+
+// month is the usage of a subject in a month, formatted YYYY-MM.
+type month struct {
+	Subject
+	Month string
+}
+
+// Options configures a Meter.
+type Options struct {
+	// MonthlyQuota is the number of requests each subject may make per
+	// calendar month (UTC); 0 is unlimited.
+	MonthlyQuota int64
+}
+
+// Meter records usage.
+type Meter struct {
+	mc   *db.MongoClient
+	opts Options
+
+	mu      sync.Mutex
+	pending map[day]*Counts
+	// stored are the requests of subjects in the collection, as last read
+	// for quota checks; flushes clear them.
+	stored map[month]int64
+}
+
+// New returns a meter storing usage through mc.
+func New(mc *db.MongoClient, opts Options) *Meter {
+	return &Meter{mc: mc, opts: opts, pending: map[day]*Counts{}, stored: map[month]int64{}}
+}
+
+// Record counts a request of s that used units.
+func (m *Meter) Record(s Subject, units *db.Units) {
+	d := day{Subject: s, Day: time.Now().UTC().Format(DayFormat)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.pending[d]
+	if !ok {
+		c = &Counts{}
+		m.pending[d] = c
+	}
+	c.add(Counts{Requests: 1, ReadUnits: units.Reads(), WriteUnits: units.Writes()})
+}
+
+// Allow reports whether s is within its monthly quota and, if it is not,
+// when the quota resets.
+func (m *Meter) Allow(ctx context.Context, s Subject) (bool, time.Time, error) {
+	if m.opts.MonthlyQuota <= 0 {
+		return true, time.Time{}, nil
+	}
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	reset := start.AddDate(0, 1, 0)
+	key := month{Subject: s, Month: start.Format("2006-01")}
+
+	m.mu.Lock()
+	stored, ok := m.stored[key]
+	m.mu.Unlock()
+	if !ok {
+		var err error
+		if stored, err = m.storedRequests(ctx, s, start, reset); err != nil {
+			return false, time.Time{}, err
+		}
+		m.mu.Lock()
+		m.stored[key] = stored
+		m.mu.Unlock()
+	}
+
+	total := stored
+	m.mu.Lock()
+	for d, c := range m.pending {
+		if d.Subject == s && d.Day >= start.Format(DayFormat) {
+			total += c.Requests
+		}
+	}
+	m.mu.Unlock()
+	return total < m.opts.MonthlyQuota, reset, nil
+}
+
+// storedRequests sums the stored requests of s on the days from start up
+// to end.
+func (m *Meter) storedRequests(ctx context.Context, s Subject, start, end time.Time) (int64, error) {
+	cur, err := m.mc.Collection("usage").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"day":       bson.M{"$gte": start.Format(DayFormat), "$lt": end.Format(DayFormat)},
+			"tenant_id": s.TenantID,
+			"api_key":   s.APIKey,
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "requests": bson.M{"$sum": "$requests"}}}},
+	}, options.Aggregate().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return 0, err
+	}
+	var out []Counts
+	if err := cur.All(ctx, &out); err != nil {
+		return 0, err
+	}
+	if len(out) == 0 {
+		return 0, nil
+	}
+	return out[0].Requests, nil
+}
+
+// Flush adds the counts recorded since the last flush to the collection.
+// Counts that fail to be stored are kept for the next flush.
+func (m *Meter) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[day]*Counts{}
+	m.stored = map[month]int64{}
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, 0, len(pending))
+	for d, c := range pending {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": bson.D{{Key: "day", Value: d.Day}, {Key: "tenant_id", Value: d.TenantID}, {Key: "api_key", Value: d.APIKey}}}).
+			SetUpdate(bson.M{
+				"$inc":         bson.M{"requests": c.Requests, "read_units": c.ReadUnits, "write_units": c.WriteUnits},
+				"$set":         bson.M{"updated_at": now},
+				"$setOnInsert": bson.M{"day": d.Day, "tenant_id": d.TenantID, "api_key": d.APIKey},
+			}).
+			SetUpsert(true))
+	}
+	_, err := m.mc.Collection("usage").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		// Some of the increments may have been applied; counting them
+		// again overbills less than losing them all underbills.
+		m.mu.Lock()
+		for d, c := range pending {
+			if p, ok := m.pending[d]; ok {
+				p.add(*c)
+			} else {
+				m.pending[d] = c
+			}
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes every interval until ctx is done. Flush once more after the
+// last request was recorded.
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := m.Flush(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("failed to store usage", "error", err)
+			}
+		}
+	}
+}
+
+// Query selects usage to report.
+type Query struct {
+	// From and To are the first and last day reported.
+	From, To time.Time
+	// TenantID and APIKey, when not empty, select a single tenant or key.
+	TenantID, APIKey string
+	// Daily reports each day separately instead of summing the range.
+	Daily bool
+}
+
+// Row is the usage of a subject over the days of a query, or on one day.
+type Row struct {
+	TenantID string `bson:"tenant_id" json:"tenant_id"`
+	APIKey   string `bson:"api_key" json:"api_key"`
+	Day      string `bson:"day,omitempty" json:"day,omitempty"`
+	Counts   `bson:",inline"`
+}
+
+// Report returns the stored usage q selects by tenant, key and, if daily,
+// day. Counts not flushed yet are not included.
+func (m *Meter) Report(ctx context.Context, q Query) ([]Row, error) {
+	match := bson.M{"day": bson.M{"$gte": q.From.UTC().Format(DayFormat), "$lte": q.To.UTC().Format(DayFormat)}}
+	if q.TenantID != "" {
+		match["tenant_id"] = q.TenantID
+	}
+	if q.APIKey != "" {
+		match["api_key"] = q.APIKey
+	}
+	group := bson.D{{Key: "tenant_id", Value: "$tenant_id"}, {Key: "api_key", Value: "$api_key"}}
+	sort := bson.D{{Key: "tenant_id", Value: 1}, {Key: "api_key", Value: 1}}
+	if q.Daily {
+		group = append(group, bson.E{Key: "day", Value: "$day"})
+		sort = append(sort, bson.E{Key: "day", Value: 1})
+	}
+	cur, err := m.mc.ReadCollection("usage").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         group,
+			"requests":    bson.M{"$sum": "$requests"},
+			"read_units":  bson.M{"$sum": "$read_units"},
+			"write_units": bson.M{"$sum": "$write_units"},
+		}}},
+		{{Key: "$replaceWith", Value: bson.M{"$mergeObjects": bson.A{"$_id", "$$ROOT"}}}},
+		{{Key: "$sort", Value: sort}},
+	}, options.Aggregate().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Row{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,280 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mode says which of readOnly and writeOnly properties are out of place.
+type mode int
+
+const (
+	modeRequest  mode = iota // readOnly properties are not sent
+	modeResponse             // writeOnly properties are not returned
+)
+
+// decodeJSON decodes b into maps, slices and float64s like the schemas.
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data")
+	}
+	return v, nil
+}
+
+// coerce converts a parameter value to the type of schema.
+func coerce(raw string, schema node) (any, bool) {
+	switch schema["type"] {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(raw, 64)
+		return f, err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		return b, err == nil
+	}
+	return raw, true
+}
+
+// number returns a numeric schema keyword as a float64; YAML decodes
+// integers as int.
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+var patterns sync.Map // pattern -> *regexp.Regexp, or error
+
+// validate checks v against schema, reporting problems at path.
+func (s *Spec) validate(schema node, v any, path string, m mode, errs *Errors) {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		errs.addf("spec: %v", err)
+		return
+	}
+
+	for _, sub := range list(schema["allOf"]) {
+		s.validate(sub, v, path, m, errs)
+	}
+	if subs := list(schema["oneOf"]); len(subs) > 0 {
+		if n := s.matching(subs, v, m); n != 1 {
+			errs.addf("%s: matches %d of the oneOf schemas instead of 1", path, n)
+		}
+	}
+	if subs := list(schema["anyOf"]); len(subs) > 0 {
+		if s.matching(subs, v, m) == 0 {
+			errs.addf("%s: matches none of the anyOf schemas", path)
+		}
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			errs.addf("%s: must not be null", path)
+		}
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !inEnum(enum, v) {
+		errs.addf("%s: %v is not one of %v", path, v, enum)
+	}
+
+	switch t := schema["type"]; t {
+	case nil:
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			errs.addf("%s: must be a string", path)
+			return
+		}
+		s.validateString(schema, str, path, errs)
+	case "integer", "number":
+		f, ok := v.(float64)
+		if !ok || (t == "integer" && f != math.Trunc(f)) {
+			want := "a number"
+			if t == "integer" {
+				want = "an integer"
+			}
+			errs.addf("%s: must be %s", path, want)
+			return
+		}
+		if min, ok := number(schema["minimum"]); ok && f < min {
+			errs.addf("%s: must be at least %v", path, min)
+		}
+		if max, ok := number(schema["maximum"]); ok && f > max {
+			errs.addf("%s: must be at most %v", path, max)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs.addf("%s: must be a boolean", path)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			errs.addf("%s: must be an array", path)
+			return
+		}
+		if n, ok := number(schema["minItems"]); ok && float64(len(items)) < n {
+			errs.addf("%s: must have at least %v items", path, n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(items)) > n {
+			errs.addf("%s: must have at most %v items", path, n)
+		}
+		if item, ok := schema["items"].(node); ok {
+			for i, elem := range items {
+				s.validate(item, elem, fmt.Sprintf("%s[%d]", path, i), m, errs)
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			errs.addf("%s: must be an object", path)
+			return
+		}
+		s.validateObject(schema, obj, path, m, errs)
+	default:
+		errs.addf("spec: %s: unknown type %v", path, t)
+	}
+}
+
+// matching returns how many of subs v matches.
+func (s *Spec) matching(subs []node, v any, m mode) int {
+	n := 0
+	for _, sub := range subs {
+		var errs Errors
+		s.validate(sub, v, "", m, &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Spec) validateString(schema node, str, path string, errs *Errors) {
+	if n, ok := number(schema["minLength"]); ok && float64(len([]rune(str))) < n {
+		errs.addf("%s: must be at least %v characters", path, n)
+	}
+	if n, ok := number(schema["maxLength"]); ok && float64(len([]rune(str))) > n {
+		errs.addf("%s: must be at most %v characters", path, n)
+	}
+	if p, ok := schema["pattern"].(string); ok {
+		re, err := compile(p)
+		if err != nil {
+			errs.addf("spec: %s: invalid pattern: %v", path, err)
+		} else if !re.MatchString(str) {
+			errs.addf("%s: must match %s", path, p)
+		}
+	}
+	var err error
+	switch schema["format"] {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, str)
+	case "date":
+		_, err = time.Parse(time.DateOnly, str)
+	case "email":
+		if str != "" {
+			_, err = mail.ParseAddress(str)
+		}
+	}
+	if err != nil {
+		errs.addf("%s: %q is not a valid %v", path, str, schema["format"])
+	}
+}
+
+func (s *Spec) validateObject(schema node, obj map[string]any, path string, m mode, errs *Errors) {
+	props, _ := schema["properties"].(node)
+	if req, ok := schema["required"].([]any); ok {
+		for _, r := range req {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				errs.addf("%s: missing property %s", path, name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub := path + "." + name
+		if prop, ok := props[name].(node); ok {
+			prop, err := s.resolve(prop)
+			if err != nil {
+				errs.addf("spec: %v", err)
+				continue
+			}
+			if ro, _ := prop["readOnly"].(bool); ro && m == modeRequest {
+				errs.addf("%s: is read-only", sub)
+			}
+			if wo, _ := prop["writeOnly"].(bool); wo && m == modeResponse {
+				errs.addf("%s: is write-only", sub)
+			}
+			s.validate(prop, obj[name], sub, m, errs)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				errs.addf("%s: unknown property", sub)
+			}
+		case node:
+			s.validate(extra, obj[name], sub, m, errs)
+		}
+	}
+}
+
+// list returns the schemas of a list keyword such as allOf.
+func list(v any) []node {
+	items, _ := v.([]any)
+	out := make([]node, 0, len(items))
+	for _, item := range items {
+		if n, ok := item.(node); ok {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if f, ok := number(e); ok {
+			e = f
+		}
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(p string) (*regexp.Regexp, error) {
+	if v, ok := patterns.Load(p); ok {
+		if re, ok := v.(*regexp.Regexp); ok {
+			return re, nil
+		}
+		return nil, v.(error)
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		patterns.Store(p, err)
+		return nil, err
+	}
+	patterns.Store(p, re)
+	return re, nil
+}
@@ -0,0 +1,352 @@
+// Package openapi checks HTTP requests and responses against an OpenAPI
+// 3.0 document, to catch drift between an API and its spec.
+//
+// It understands the parts of the format the API's spec uses: path,
+// query and header parameters, JSON request and response bodies, local
+// $refs, and schemas with type, nullable, enum, format, bounds, required,
+// properties, additionalProperties, items, allOf, oneOf, anyOf, readOnly
+// and writeOnly. Other keywords are ignored.
+package openapi
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// node is a decoded YAML or JSON object.
+type node = map[string]any
+
+// Spec is a loaded OpenAPI document.
+type Spec struct {
+	root  node
+	paths []*pathItem // most literal segments first
+}
+
+type pathItem struct {
+	template string
+	segments []string
+	params   int // templated segments
+	item     node
+}
+
+// Load parses an OpenAPI document in YAML or JSON.
+func Load(b []byte) (*Spec, error) {
+	var root node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	if v, _ := root["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q", v)
+	}
+	s := &Spec{root: root}
+	paths, _ := root["paths"].(node)
+	for template, v := range paths {
+		item, ok := v.(node)
+		if !ok {
+			return nil, fmt.Errorf("path %s is not an object", template)
+		}
+		p := &pathItem{template: template, segments: strings.Split(strings.Trim(template, "/"), "/"), item: item}
+		for _, seg := range p.segments {
+			if isParam(seg) {
+				p.params++
+			}
+		}
+		s.paths = append(s.paths, p)
+	}
+	// /users/bulk is preferred over /users/{id}
+	sort.Slice(s.paths, func(i, j int) bool {
+		a, b := s.paths[i], s.paths[j]
+		if a.params != b.params {
+			return a.params < b.params
+		}
+		return a.template < b.template
+	})
+	return s, nil
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+// match returns the values of the templated segments of p in path, or
+// false when path doesn't match it.
+func (p *pathItem) match(path string) (map[string]string, bool) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) != len(p.segments) {
+		return nil, false
+	}
+	vals := map[string]string{}
+	for i, seg := range p.segments {
+		if isParam(seg) {
+			if segs[i] == "" {
+				return nil, false
+			}
+			vals[seg[1:len(seg)-1]] = segs[i]
+		} else if seg != segs[i] {
+			return nil, false
+		}
+	}
+	return vals, true
+}
+
+// Operation is an operation of the spec, with the path parameters of the
+// request it was found for.
+type Operation struct {
+	spec *Spec
+	// Path is the path template, e.g. /users/{id}.
+	Path   string
+	Method string
+	op     node
+	item   node
+	vars   map[string]string
+}
+
+// Find returns the operation serving method on path. It returns false
+// for paths the spec doesn't document, and a nil Operation with true for
+// documented paths without that method.
+func (s *Spec) Find(method, path string) (*Operation, bool) {
+	for _, p := range s.paths {
+		vars, ok := p.match(path)
+		if !ok {
+			continue
+		}
+		op, ok := p.item[strings.ToLower(method)].(node)
+		if !ok && method == http.MethodHead {
+			op, ok = p.item["get"].(node)
+		}
+		if !ok {
+			return nil, true
+		}
+		return &Operation{spec: s, Path: p.template, Method: method, op: op, item: p.item, vars: vars}, true
+	}
+	return nil, false
+}
+
+// resolve follows the $ref of n, if any, within the document.
+func (s *Spec) resolve(n node) (node, error) {
+	for i := 0; i < 32; i++ {
+		ref, ok := n["$ref"].(string)
+		if !ok {
+			return n, nil
+		}
+		target, ok := strings.CutPrefix(ref, "#/")
+		if !ok {
+			return nil, fmt.Errorf("unsupported $ref %s", ref)
+		}
+		var cur any = s.root
+		for _, part := range strings.Split(target, "/") {
+			part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+			m, _ := cur.(node)
+			if cur = m[part]; cur == nil {
+				return nil, fmt.Errorf("unresolved $ref %s", ref)
+			}
+		}
+		if n, ok = cur.(node); !ok {
+			return nil, fmt.Errorf("$ref %s is not an object", ref)
+		}
+	}
+	return nil, fmt.Errorf("$ref cycle")
+}
+
+// Errors are the ways a request or response violates the spec.
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+func (e *Errors) addf(format string, args ...any) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateRequest checks the parameters of r and body, its body, against
+// the operation. body is nil when the request has none.
+func (op *Operation) ValidateRequest(r *http.Request, body []byte) error {
+	var errs Errors
+	seen := map[string]bool{}
+	// Operation parameters override the path's
+	for _, list := range []any{op.op["parameters"], op.item["parameters"]} {
+		params, _ := list.([]any)
+		for _, v := range params {
+			pn, _ := v.(node)
+			p, err := op.spec.resolve(pn)
+			if err != nil {
+				errs.addf("spec: %v", err)
+				continue
+			}
+			name, _ := p["name"].(string)
+			in, _ := p["in"].(string)
+			if seen[in+":"+name] {
+				continue
+			}
+			seen[in+":"+name] = true
+			op.validateParam(r, p, name, in, &errs)
+		}
+	}
+
+	rb, _ := op.op["requestBody"].(node)
+	if rb == nil {
+		if len(body) > 0 {
+			errs.addf("request body not expected")
+		}
+		return errs.err()
+	}
+	rb, err := op.spec.resolve(rb)
+	if err != nil {
+		errs.addf("spec: %v", err)
+		return errs.err()
+	}
+	if len(body) == 0 {
+		if required, _ := rb["required"].(bool); required {
+			errs.addf("request body is required")
+		}
+		return errs.err()
+	}
+	content, _ := rb["content"].(node)
+	op.validateBody("request body", content, r.Header.Get("Content-Type"), body, modeRequest, &errs)
+	return errs.err()
+}
+
+func (op *Operation) validateParam(r *http.Request, p node, name, in string, errs *Errors) {
+	var raw string
+	var present bool
+	switch in {
+	case "path":
+		raw, present = op.vars[name]
+	case "query":
+		if vals, ok := r.URL.Query()[name]; ok {
+			raw, present = vals[0], true
+		}
+	case "header":
+		if vals := r.Header.Values(name); len(vals) > 0 {
+			raw, present = vals[0], true
+		}
+	case "cookie":
+		if c, err := r.Cookie(name); err == nil {
+			raw, present = c.Value, true
+		}
+	}
+	if !present {
+		if required, _ := p["required"].(bool); required {
+			errs.addf("%s parameter %s is required", in, name)
+		}
+		return
+	}
+	schema, _ := p["schema"].(node)
+	if schema == nil {
+		return
+	}
+	schema, err := op.spec.resolve(schema)
+	if err != nil {
+		errs.addf("spec: %v", err)
+		return
+	}
+	v, ok := coerce(raw, schema)
+	if !ok {
+		errs.addf("%s parameter %s: %q is not a valid %v", in, name, raw, schema["type"])
+		return
+	}
+	op.spec.validate(schema, v, in+" parameter "+name, modeRequest, errs)
+}
+
+// ValidateResponse checks a response with status, header and body, nil
+// when there is none, against the operation. Statuses any operation may
+// answer, such as 429 or 503, are accepted with the Error schema of the
+// spec's components when the operation doesn't list them.
+func (op *Operation) ValidateResponse(status int, header http.Header, body []byte) error {
+	var errs Errors
+	responses, _ := op.op["responses"].(node)
+	resp, _ := responses[fmt.Sprint(status)].(node)
+	if resp == nil {
+		resp, _ = responses[fmt.Sprintf("%dXX", status/100)].(node)
+	}
+	if resp == nil {
+		resp, _ = responses["default"].(node)
+	}
+	if resp == nil && genericStatus(status) {
+		resp = node{"$ref": "#/components/responses/Error"}
+		if _, err := op.spec.resolve(resp); err != nil {
+			return nil
+		}
+	}
+	if resp == nil {
+		errs.addf("status %d not documented", status)
+		return errs.err()
+	}
+	resp, err := op.spec.resolve(resp)
+	if err != nil {
+		errs.addf("spec: %v", err)
+		return errs.err()
+	}
+	content, _ := resp["content"].(node)
+	if len(body) == 0 {
+		return nil
+	}
+	if content == nil {
+		errs.addf("status %d: response body not expected", status)
+		return errs.err()
+	}
+	op.validateBody(fmt.Sprintf("status %d body", status), content, header.Get("Content-Type"), body, modeResponse, &errs)
+	return errs.err()
+}
+
+// genericStatus reports whether any operation may answer with status,
+// from middleware such as rate limiting, CSRF checks or timeouts.
+func genericStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed,
+		http.StatusNotAcceptable, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType,
+		http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// validateBody checks body of type contentType against the media types
+// of content. Bodies that aren't JSON are only checked for their type.
+func (op *Operation) validateBody(what string, content node, contentType string, body []byte, m mode, errs *Errors) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		errs.addf("%s: invalid Content-Type %q", what, contentType)
+		return
+	}
+	media, ok := content[mt].(node)
+	if !ok {
+		major, _, _ := strings.Cut(mt, "/")
+		if media, ok = content[major+"/*"].(node); !ok {
+			media, ok = content["*/*"].(node)
+		}
+	}
+	if !ok {
+		errs.addf("%s: Content-Type %s not documented", what, mt)
+		return
+	}
+	schema, _ := media["schema"].(node)
+	if schema == nil || !isJSON(mt) {
+		return
+	}
+	v, err := decodeJSON(body)
+	if err != nil {
+		errs.addf("%s: invalid JSON: %v", what, err)
+		return
+	}
+	op.spec.validate(schema, v, what, m, errs)
+}
+
+// isJSON reports whether mt is application/json or a +json type.
+func isJSON(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,312 @@
+// Package query parses the filter expressions accepted by list endpoints,
+// such as
+//
+//	age >= 18 and (name ~ "jo" or email = "x@example.com")
+//
+// into a syntax tree the storage backends translate to their own queries.
+// Only allowlisted fields can be used, each with the operators of its kind.
+//
+// Operators are = != > >= < <= and ~ (case-insensitive substring match, for
+// strings only). Conditions combine with and, or, not and parentheses; and
+// binds tighter than or. Strings are double-quoted with \" and \\ escapes,
+// integers are plain, and times are quoted RFC 3339 times or dates.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits on expressions, so a filter can't become an expensive query.
+const (
+	MaxLength     = 1024
+	MaxConditions = 20
+	MaxDepth      = 8
+)
+
+// Kind is the type of a filterable field.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Time
+)
+
+// Node is a node of a parsed expression: Logic or Cond.
+type Node interface{ node() }
+
+// Logic combines Nodes with Op "and" or "or", or negates its single node
+// with Op "not".
+type Logic struct {
+	Op    string
+	Nodes []Node
+}
+
+// Cond compares Field with Value, which is a string, int64 or time.Time
+// according to the field's Kind.
+type Cond struct {
+	Field string
+	Op    string
+	Value any
+}
+
+func (Logic) node() {}
+func (Cond) node()  {}
+
+// Error is a syntax or validation error at byte offset Pos.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("filter: %s at position %d", e.Msg, e.Pos+1)
+}
+
+// Parse parses expr, allowing only the fields in fields.
+func Parse(expr string, fields map[string]Kind) (Node, error) {
+	if len(expr) > MaxLength {
+		return nil, fmt.Errorf("filter: longer than %d characters", MaxLength)
+	}
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, fields: fields}
+	n, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &Error{t.pos, "unexpected " + t.String()}
+	}
+	return n, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+		case c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && (s[j+1] == '"' || s[j+1] == '\\') {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, &Error{i, "unterminated string"}
+			}
+			toks = append(toks, token{tokString, b.String(), i})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			toks = append(toks, token{tokInt, s[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{">=", "<=", "!=", "=", ">", "<", "~"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &Error{i, fmt.Sprintf("unexpected character %q", c)}
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(s)}), nil
+}
+
+type parser struct {
+	toks   []token
+	pos    int
+	fields map[string]Kind
+	conds  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// or := and { "or" and }
+func (p *parser) or(depth int) (Node, error) {
+	return p.list(depth, "or", p.and)
+}
+
+// and := unary { "and" unary }
+func (p *parser) and(depth int) (Node, error) {
+	return p.list(depth, "and", p.unary)
+}
+
+func (p *parser) list(depth int, op string, sub func(int) (Node, error)) (Node, error) {
+	n, err := sub(depth)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{n}
+	for p.keyword(op) {
+		n, err := sub(depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Logic{Op: op, Nodes: nodes}, nil
+}
+
+// unary := "not" unary | "(" or ")" | cond
+func (p *parser) unary(depth int) (Node, error) {
+	if depth > MaxDepth {
+		return nil, &Error{p.peek().pos, fmt.Sprintf("nested deeper than %d levels", MaxDepth)}
+	}
+	if p.keyword("not") {
+		n, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Logic{Op: "not", Nodes: []Node{n}}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		n, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, &Error{t.pos, "expected ) but found " + t.String()}
+		}
+		return n, nil
+	}
+	return p.cond()
+}
+
+// cond := field op value
+func (p *parser) cond() (Node, error) {
+	ft := p.next()
+	if ft.kind != tokIdent {
+		return nil, &Error{ft.pos, "expected a field but found " + ft.String()}
+	}
+	kind, ok := p.fields[ft.text]
+	if !ok {
+		return nil, &Error{ft.pos, fmt.Sprintf("unknown field %q", ft.text)}
+	}
+	ot := p.next()
+	if ot.kind != tokOp {
+		return nil, &Error{ot.pos, "expected an operator but found " + ot.String()}
+	}
+	if ot.text == "~" && kind != String {
+		return nil, &Error{ot.pos, fmt.Sprintf("~ needs a string field, %q is not", ft.text)}
+	}
+
+	p.conds++
+	if p.conds > MaxConditions {
+		return nil, &Error{ft.pos, fmt.Sprintf("more than %d conditions", MaxConditions)}
+	}
+
+	vt := p.next()
+	v, err := value(vt, kind)
+	if err != nil {
+		return nil, &Error{vt.pos, fmt.Sprintf("invalid value for %q: %v", ft.text, err)}
+	}
+	return Cond{Field: ft.text, Op: ot.text, Value: v}, nil
+}
+
+func value(t token, kind Kind) (any, error) {
+	switch kind {
+	case Int:
+		if t.kind != tokInt {
+			return nil, errors.New("expected an integer")
+		}
+		return strconv.ParseInt(t.text, 10, 64)
+	case Time:
+		if t.kind != tokString {
+			return nil, errors.New("expected a quoted time")
+		}
+		if v, err := time.Parse(time.RFC3339, t.text); err == nil {
+			return v.UTC(), nil
+		}
+		v, err := time.Parse(time.DateOnly, t.text)
+		if err != nil {
+			return nil, errors.New("expected an RFC 3339 time or YYYY-MM-DD date")
+		}
+		return v, nil
+	default:
+		if t.kind != tokString {
+			return nil, errors.New("expected a quoted string")
+		}
+		return t.text, nil
+	}
+}
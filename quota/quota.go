@@ -0,0 +1,227 @@
+// Package quota caps the users stored, by count and by size, per tenant
+// and across all tenants.
+//
+// The limits come from the configuration, overridden per scope in the
+// "quotas" collection. Creates are checked against the current usage
+// before they are stored, so creates racing each other may exceed a limit
+// by the few users they store at once.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Global is the scope of the limits on the users of all tenants together.
+// Other scopes are tenant ids, which can't contain "_".
+const Global = "_global"
+
+// Resources limited.
+const (
+	ResourceUsers = "users"
+	ResourceBytes = "bytes"
+)
+
+// Limits caps the users of a scope; zero is unlimited.
+type Limits struct {
+	MaxUsers int64 `json:"max_users" bson:"max_users"`
+	MaxBytes int64 `json:"max_bytes" bson:"max_bytes"`
+}
+
+// Usage is what the users of a scope take up. Bytes is their BSON size,
+// not counting indexes or compression.
+type Usage struct {
+	Users int64 `json:"users"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Status is the quota of a scope. Custom says whether Limits were set for
+// it rather than being the configured defaults.
+type Status struct {
+	Scope     string     `json:"scope"`
+	Limits    Limits     `json:"limits"`
+	Custom    bool       `json:"custom"`
+	Usage     *Usage     `json:"usage,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ExceededError is returned for creates that would take a scope over its
+// limit of Resource.
+type ExceededError struct {
+	Scope    string
+	Resource string
+	Limit    int64
+	Used     int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %s exceeded: %d of %d used", e.Resource, e.Scope, e.Used, e.Limit)
+}
+
+// doc is a document of the "quotas" collection, keyed by scope.
+type doc struct {
+	Scope     string `bson:"_id"`
+	Limits    `bson:",inline"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Options are the default limits.
+type Options struct {
+	Global Limits
+	Tenant Limits
+}
+
+// Store keeps the limits and measures the usage.
+type Store struct {
+	mc   *db.MongoClient
+	opts Options
+}
+
+// New returns a store kept through mc, with opts as the defaults.
+func New(mc *db.MongoClient, opts Options) *Store {
+	return &Store{mc: mc, opts: opts}
+}
+
+func (s *Store) defaults(scope string) Limits {
+	if scope == Global {
+		return s.opts.Global
+	}
+	return s.opts.Tenant
+}
+
+// status returns the quota of scope, without usage.
+func (s *Store) status(ctx context.Context, scope string) (Status, error) {
+	st := Status{Scope: scope, Limits: s.defaults(scope)}
+	var d doc
+	err := s.mc.Collection("quotas").FindOne(ctx, bson.M{"_id": scope},
+		options.FindOne().SetComment(db.Comment(ctx))).Decode(&d)
+	switch {
+	case err == nil:
+		st.Limits, st.Custom, st.UpdatedAt = d.Limits, true, &d.UpdatedAt
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return st, err
+	}
+	return st, nil
+}
+
+// Get returns the quota of scope with its usage.
+func (s *Store) Get(ctx context.Context, scope string) (Status, error) {
+	st, err := s.status(ctx, scope)
+	if err != nil {
+		return st, err
+	}
+	u, err := s.usage(ctx, scope, true)
+	if err != nil {
+		return st, err
+	}
+	st.Usage = &u
+	return st, nil
+}
+
+// List returns the global quota and those set for tenants, without usage.
+func (s *Store) List(ctx context.Context) ([]Status, error) {
+	cur, err := s.mc.Collection("quotas").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	var docs []doc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := []Status{{Scope: Global, Limits: s.opts.Global}}
+	for _, d := range docs {
+		st := Status{Scope: d.Scope, Limits: d.Limits, Custom: true, UpdatedAt: &d.UpdatedAt}
+		if d.Scope == Global {
+			out[0] = st
+			continue
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Set sets the limits of scope.
+func (s *Store) Set(ctx context.Context, scope string, l Limits) error {
+	_, err := s.mc.Collection("quotas").ReplaceOne(ctx, bson.M{"_id": scope},
+		doc{Scope: scope, Limits: l, UpdatedAt: time.Now().UTC()},
+		options.Replace().SetUpsert(true).SetComment(db.Comment(ctx)))
+	return err
+}
+
+// Reset returns scope to the default limits.
+func (s *Store) Reset(ctx context.Context, scope string) error {
+	_, err := s.mc.Collection("quotas").DeleteOne(ctx, bson.M{"_id": scope},
+		options.Delete().SetComment(db.Comment(ctx)))
+	return err
+}
+
+// Check returns an ExceededError when adding users users of bytes bytes
+// takes tenant's users, or all users, over a limit. Tenant "" only has
+// the global limits.
+func (s *Store) Check(ctx context.Context, tenant string, users, bytes int64) error {
+	scopes := []string{Global}
+	if tenant != "" {
+		scopes = append(scopes, tenant)
+	}
+	for _, scope := range scopes {
+		st, err := s.status(ctx, scope)
+		if err != nil {
+			return err
+		}
+		l := st.Limits
+		if l.MaxUsers <= 0 && l.MaxBytes <= 0 {
+			continue
+		}
+		u, err := s.usage(ctx, scope, l.MaxBytes > 0)
+		if err != nil {
+			return err
+		}
+		if l.MaxUsers > 0 && u.Users+users > l.MaxUsers {
+			return &ExceededError{Scope: scope, Resource: ResourceUsers, Limit: l.MaxUsers, Used: u.Users}
+		}
+		if l.MaxBytes > 0 && u.Bytes+bytes > l.MaxBytes {
+			return &ExceededError{Scope: scope, Resource: ResourceBytes, Limit: l.MaxBytes, Used: u.Bytes}
+		}
+	}
+	return nil
+}
+
+// usage measures the users of scope, soft-deleted ones included since they
+// are still stored. Sizing them reads every document, so it is only done
+// when withBytes is set.
+func (s *Store) usage(ctx context.Context, scope string, withBytes bool) (Usage, error) {
+	filter := bson.M{}
+	if scope != Global {
+		filter["tenant_id"] = scope
+	}
+	users := s.mc.ReadCollection("users")
+	if !withBytes {
+		n, err := users.CountDocuments(ctx, filter, options.Count().SetComment(db.Comment(ctx)))
+		return Usage{Users: n}, err
+	}
+	cur, err := users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"users": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": bson.M{"$bsonSize": "$$ROOT"}},
+		}}},
+	}, options.Aggregate().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return Usage{}, err
+	}
+	var out []Usage
+	if err := cur.All(ctx, &out); err != nil || len(out) == 0 {
+		return Usage{}, err
+	}
+	return out[0], nil
+}
@@ -0,0 +1,32 @@
+package quota
+
+import (
+	"context"
+
+	"golang/store"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Users refuses creates through the wrapped UserRepository that would
+// exceed a quota, with an ExceededError.
+type Users struct {
+	store.UserRepository
+	s *Store
+}
+
+// NewUsers returns next checking its creates against the quotas of s.
+func NewUsers(next store.UserRepository, s *Store) *Users {
+	return &Users{UserRepository: next, s: s}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	// The size is close to the stored document's, which only adds the
+	// tenant and the id
+	b, _ := bson.Marshal(user)
+	if err := u.s.Check(ctx, tenant.FromContext(ctx), 1, int64(len(b))); err != nil {
+		return err
+	}
+	return u.UserRepository.Create(ctx, user)
+}
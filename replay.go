@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang/capture"
+)
+
+// replay re-issues captured requests against another environment and
+// compares the statuses, e.g. to try a release on staging with production
+// traffic:
+//
+//	server replay -token $ADMIN_TOKEN -url https://staging.example.com https://api.example.com
+//	server replay -url http://127.0.0.1:8080 captures.json
+//
+// SOURCE is a server whose /admin/captures are read, or a file holding a
+// response of it, or - for stdin. Only GET and HEAD requests are replayed
+// unless -writes is given: captured bodies are redacted, so writes replay
+// placeholders, and they change the target. Captures keep no credentials;
+// -header adds them. It prints each request with the captured and the
+// replayed status and fails if any differ.
+func replay(args []string) error {
+	fs := newBareFlagSet("replay")
+	target := fs.String("url", "", "base URL of the server to replay against")
+	token := fs.String("token", "", "ADMIN_TOKEN of the SOURCE server")
+	limit := fs.Int("limit", 100, "how many captures to replay, newest first")
+	path := fs.String("path", "", "replay only the captures of paths starting with this")
+	writes := fs.Bool("writes", false, "also replay POST, PUT, PATCH and DELETE requests")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long each request may take")
+	var header headerFlags
+	fs.Var(&header, "header", "header to send to the target, as `Name: value`; repeatable")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *target == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	client := &http.Client{Timeout: *timeout}
+
+	captures, err := readCaptures(client, fs.Arg(0), *token, *path, *limit)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(*target, "/")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	replayed, differ := 0, 0
+	// replay in the order the requests were made
+	for i := len(captures) - 1; i >= 0; i-- {
+		e := captures[i]
+		if e.Method != http.MethodGet && e.Method != http.MethodHead && !*writes {
+			fmt.Fprintf(tw, "SKIP\t%s %s\t%d\t\t\twrite; see -writes\n", e.Method, e.Path, e.Status)
+			continue
+		}
+		if e.Body == "" && e.BodyOmitted != "" {
+			fmt.Fprintf(tw, "SKIP\t%s %s\t%d\t\t\tbody not captured: %s\n", e.Method, e.Path, e.Status, e.BodyOmitted)
+			continue
+		}
+		replayed++
+		start := time.Now()
+		status, err := replayOne(client, base, e, http.Header(header))
+		took := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			differ++
+			fmt.Fprintf(tw, "FAIL\t%s %s\t%d\t\t%s\t%v\n", e.Method, e.Path, e.Status, took, err)
+			continue
+		}
+		result := "SAME"
+		if status != e.Status {
+			differ++
+			result = "DIFF"
+		}
+		fmt.Fprintf(tw, "%s\t%s %s\t%d\t%d\t%s\t\n", result, e.Method, e.Path, e.Status, status, took)
+	}
+	tw.Flush()
+	if differ > 0 {
+		return fmt.Errorf("%d of %d replayed requests got another status from %s", differ, replayed, base)
+	}
+	return nil
+}
+
+// replayOne sends the captured request e to base and returns the status.
+func replayOne(client *http.Client, base string, e capture.Exchange, header http.Header) (int, error) {
+	u := base + e.Path
+	if e.Query != "" {
+		u += "?" + e.Query
+	}
+	var body io.Reader
+	if e.Body != "" {
+		body = strings.NewReader(e.Body)
+	}
+	req, err := http.NewRequest(e.Method, u, body)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range e.Header {
+		req.Header.Set(k, v)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// readCaptures returns up to limit captures of paths starting with path,
+// newest first, from the /admin/captures of the server at source, or from
+// the file source holding a response of it.
+func readCaptures(client *http.Client, source, token, path string, limit int) ([]capture.Exchange, error) {
+	var out []capture.Exchange
+	keep := func(page []capture.Exchange) {
+		for _, e := range page {
+			if len(out) < limit && strings.HasPrefix(e.Path, path) {
+				out = append(out, e)
+			}
+		}
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		var in io.Reader = os.Stdin
+		if source != "-" {
+			f, err := os.Open(source)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			in = f
+		}
+		var page capturePage
+		if err := json.NewDecoder(in).Decode(&page); err != nil {
+			return nil, fmt.Errorf("%s: not a response of /admin/captures: %v", source, err)
+		}
+		keep(page.Data)
+		return out, nil
+	}
+
+	base := strings.TrimSuffix(source, "/")
+	q := url.Values{"limit": {strconv.Itoa(min(limit, 500))}}
+	if path != "" {
+		q.Set("path", path)
+	}
+	next := "/admin/captures?" + q.Encode()
+	for next != "" && len(out) < limit {
+		req, err := http.NewRequest(http.MethodGet, base+next, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page capturePage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", base+next, resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: invalid response: %v", base+next, err)
+		}
+		keep(page.Data)
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+	return out, nil
+}
+
+// capturePage is a response of /admin/captures.
+type capturePage struct {
+	Data []capture.Exchange `json:"data"`
+	Next *string            `json:"next"`
+}
+
+// headerFlags collects repeated -header flags.
+type headerFlags http.Header
+
+func (h *headerFlags) String() string {
+	return fmt.Sprint(http.Header(*h))
+}
+
+func (h *headerFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return errors.New("want Name: value")
+	}
+	if *h == nil {
+		*h = headerFlags{}
+	}
+	http.Header(*h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package requestid carries the per-request correlation id through contexts.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the HTTP header used to accept and return the request id.
+const Header = "X-Request-ID"
+
+type key struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the request id stored in ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// New returns a random 128-bit id in hex.
+func New() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Valid reports whether a client supplied id is safe to reuse: 1 to 128
+// characters drawn from letters, digits and "-_.:".
+func Valid(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type routeKey struct{}
+
+// WithRoute returns a copy of ctx carrying route, which names the route of
+// the request, e.g. "GET /users/{id}". It is a function because handlers
+// refine the route after the context is made.
+func WithRoute(ctx context.Context, route func() string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// Route returns the route of the request in ctx, or "".
+func Route(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey{}).(func() string); ok {
+		return route()
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+// Package s3 is a minimal client for S3 compatible object storage, such
+// as AWS S3 and MinIO, covering the streaming uploads of the export
+// command. Requests are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Part sizes S3 accepts; every part but the last must be at least
+// MinPartSize.
+const (
+	MinPartSize = 5 << 20
+	MaxPartSize = 5 << 30
+)
+
+// Options configures a Client.
+type Options struct {
+	// Endpoint is the base URL of the service, e.g. http://minio:9000.
+	// Empty uses AWS in Region.
+	Endpoint string
+	// Region requests are signed for; defaults to us-east-1.
+	Region string
+	// AccessKeyID, SecretAccessKey and, for temporary credentials,
+	// SessionToken sign the requests.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle addresses buckets as Endpoint/bucket instead of
+	// bucket.Endpoint, as MinIO needs.
+	PathStyle bool
+	// PartSize is the size of multipart upload parts and so the memory an
+	// upload buffers; defaults to 16 MiB.
+	PartSize int
+}
+
+// Client talks to one S3 compatible service.
+type Client struct {
+	opts     Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// New returns a client for the service of opts.
+func New(opts Options) (*Client, error) {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	if opts.PartSize == 0 {
+		opts.PartSize = 16 << 20
+	}
+	if opts.PartSize < MinPartSize || opts.PartSize > MaxPartSize {
+		return nil, fmt.Errorf("part size must be between %d and %d bytes", MinPartSize, MaxPartSize)
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", opts.Endpoint)
+	}
+	return &Client{opts: opts, endpoint: u, client: &http.Client{}}, nil
+}
+
+// Error is an error response of the service.
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// objectURL returns the URL of key in bucket with query q.
+func (c *Client) objectURL(bucket, key string, q url.Values) *url.URL {
+	u := *c.endpoint
+	if c.opts.PathStyle {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(q)
+	return &u
+}
+
+// do sends a signed request with body and returns the response of a
+// successful one; error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, u *url.URL, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(resp.StatusCode, resp.Body)
+	}
+	return resp, nil
+}
+
+// responseError reads the XML error document of a failed request.
+func responseError(status int, body io.Reader) error {
+	e := &Error{Status: status}
+	b, _ := io.ReadAll(io.LimitReader(body, 64<<10))
+	xml.Unmarshal(b, e)
+	return e
+}
+
+// sign adds the Signature Version 4 authorization of req to it.
+func (c *Client) sign(req *http.Request, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	scope := date + "/" + c.opts.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + c.opts.SecretAccessKey)
+	for _, s := range []string{date, c.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.opts.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes q sorted by key, with spaces as %20.
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// escapePath percent-encodes every byte of p but unreserved characters
+// and slashes, as signing requires.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		ch := p[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// partAttempts is how often a request of an upload is tried before the
+// upload fails.
+const partAttempts = 3
+
+// Upload streams an object to the service. Data is buffered up to the
+// part size; an object that fits one part is sent in one PUT, larger ones
+// as a multipart upload, so memory use doesn't grow with the object.
+//
+// Close completes the upload and Abort discards it; an object only
+// appears once Close succeeds.
+type Upload struct {
+	c      *Client
+	ctx    context.Context
+	bucket string
+	key    string
+
+	buf      []byte
+	uploadID string
+	parts    []completedPart
+	err      error
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// Create starts an upload of key to bucket, replacing the object when it
+// completes.
+func (c *Client) Create(ctx context.Context, bucket, key string) *Upload {
+	return &Upload{c: c, ctx: ctx, bucket: bucket, key: key, buf: make([]byte, 0, c.opts.PartSize)}
+}
+
+func (u *Upload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n := 0
+	for len(p) > 0 {
+		k := min(len(p), cap(u.buf)-len(u.buf))
+		u.buf = append(u.buf, p[:k]...)
+		p, n = p[k:], n+k
+		if len(u.buf) == cap(u.buf) {
+			if u.err = u.flush(); u.err != nil {
+				return n, u.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush uploads the buffer as the next part.
+func (u *Upload) flush() error {
+	if u.uploadID == "" {
+		var out struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := u.request(http.MethodPost, url.Values{"uploads": {""}}, nil, &out); err != nil {
+			return err
+		}
+		u.uploadID = out.UploadID
+	}
+
+	n := len(u.parts) + 1
+	resp, err := u.retry(http.MethodPut, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {u.uploadID}}, u.buf)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	u.parts = append(u.parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	u.buf = u.buf[:0]
+	return nil
+}
+
+// Close uploads the rest of the data and completes the upload. After a
+// failed Write it aborts the upload and returns the error of the Write.
+func (u *Upload) Close() error {
+	if u.err != nil {
+		u.Abort()
+		return u.err
+	}
+	if u.uploadID == "" {
+		resp, err := u.retry(http.MethodPut, nil, u.buf)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if len(u.buf) > 0 {
+		if err := u.flush(); err != nil {
+			u.Abort()
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	// The service may report a failure in the body of a 200 response
+	var out struct {
+		XMLName xml.Name
+		Error
+	}
+	if err := u.request(http.MethodPost, url.Values{"uploadId": {u.uploadID}}, body, &out); err != nil {
+		u.Abort()
+		return err
+	}
+	if out.XMLName.Local == "Error" {
+		u.Abort()
+		out.Error.Status = http.StatusOK
+		return &out.Error
+	}
+	return nil
+}
+
+// Abort discards the parts uploaded so far.
+func (u *Upload) Abort() error {
+	if u.err == nil {
+		u.err = errors.New("s3: upload aborted")
+	}
+	if u.uploadID == "" {
+		return nil
+	}
+	// The upload is abandoned whether or not its context is done
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(u.ctx), 30*time.Second)
+	defer cancel()
+	resp, err := u.c.do(ctx, http.MethodDelete, u.c.objectURL(u.bucket, u.key, url.Values{"uploadId": {u.uploadID}}), nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// request sends a request about the object and decodes the XML response
+// into out.
+func (u *Upload) request(method string, q url.Values, body []byte, out any) error {
+	resp, err := u.retry(method, q, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, out)
+}
+
+// retry sends a request about the object, retrying failed connections and
+// server errors.
+func (u *Upload) retry(method string, q url.Values, body []byte) (*http.Response, error) {
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		resp, err := u.c.do(u.ctx, method, u.c.objectURL(u.bucket, u.key, q), body)
+		var serr *Error
+		if err == nil || attempt == partAttempts || u.ctx.Err() != nil ||
+			(errors.As(err, &serr) && serr.Status < 500) {
+			return resp, err
+		}
+		select {
+		case <-time.After(wait):
+		case <-u.ctx.Done():
+			return nil, u.ctx.Err()
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,173 @@
+// Package scaffold generates the code of a new MongoDB backed resource
+// following the users pattern: a store model, repository interface and
+// Mongo and in-memory implementations, the API handlers with their routes
+// and input validation, and tests of the handlers over the in-memory
+// repository. The generated code compiles and passes its tests as is and
+// is meant to be edited from there.
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// Kinds are the field types a resource can have, by their Go type.
+var Kinds = map[string]string{
+	"string": "string",
+	"int":    "int",
+	"float":  "float64",
+	"bool":   "bool",
+	"time":   "time.Time",
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// Field is a field of the resource.
+type Field struct {
+	Name     string // JSON and BSON name, e.g. "published_at"
+	Kind     string // a key of Kinds
+	Required bool   // must be set on create
+}
+
+// Go returns the exported Go name of the field.
+func (f Field) Go() string { return camel(f.Name) }
+
+// GoType returns the Go type of the field.
+func (f Field) GoType() string { return Kinds[f.Kind] }
+
+// Examples returns two different JSON values of the field, for the
+// generated tests.
+func (f Field) Examples() []string {
+	switch f.Kind {
+	case "int":
+		return []string{"3", "4"}
+	case "float":
+		return []string{"1.5", "2.5"}
+	case "bool":
+		return []string{"true", "false"}
+	case "time":
+		return []string{`"2024-01-02T03:04:05Z"`, `"2025-06-07T08:09:10Z"`}
+	}
+	words := strings.ReplaceAll(f.Name, "_", " ")
+	return []string{`"a ` + words + `"`, `"another ` + words + `"`}
+}
+
+// Invalid returns a JSON value of the wrong type for the field.
+func (f Field) Invalid() string {
+	if f.Kind == "string" {
+		return "1"
+	}
+	return `"x"`
+}
+
+// Resource describes the resource to generate.
+type Resource struct {
+	Name   string // singular snake_case, e.g. "order_item"
+	Plural string // collection and route name, e.g. "order_items"
+	Fields []Field
+}
+
+// Type returns the Go type name, e.g. "OrderItem".
+func (r Resource) Type() string { return camel(r.Name) }
+
+// Types returns the plural Go name, e.g. "OrderItems".
+func (r Resource) Types() string { return camel(r.Plural) }
+
+// Parse builds a Resource from its singular name and a field list such as
+// "title:string!,price:float,published_at:time", where ! marks required
+// fields.
+func Parse(name, fields string) (Resource, error) {
+	if !namePattern.MatchString(name) {
+		return Resource{}, fmt.Errorf("invalid resource name %q: use lower snake_case", name)
+	}
+	r := Resource{Name: name, Plural: plural(name)}
+	seen := map[string]bool{"id": true, "tenant_id": true, "created_at": true}
+	for _, spec := range strings.Split(fields, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		spec, required := strings.CutSuffix(spec, "!")
+		fname, kind, ok := strings.Cut(spec, ":")
+		if !ok {
+			kind = "string"
+		}
+		f := Field{Name: fname, Kind: kind, Required: required}
+		if !namePattern.MatchString(f.Name) {
+			return r, fmt.Errorf("invalid field name %q: use lower snake_case", f.Name)
+		}
+		if seen[f.Name] {
+			return r, fmt.Errorf("field %s is duplicate or reserved", f.Name)
+		}
+		if _, ok := Kinds[f.Kind]; !ok {
+			return r, fmt.Errorf("field %s: unknown type %q; use string, int, float, bool or time", f.Name, f.Kind)
+		}
+		seen[f.Name] = true
+		r.Fields = append(r.Fields, f)
+	}
+	if len(r.Fields) == 0 {
+		return r, fmt.Errorf("resource %s needs at least one field", name)
+	}
+	return r, nil
+}
+
+// Generate returns the generated files by path relative to the module
+// root.
+func Generate(r Resource) (map[string][]byte, error) {
+	files := map[string]string{
+		"store/" + r.Name + ".go":    "templates/store.go.tmpl",
+		"api/" + r.Name + ".go":      "templates/api.go.tmpl",
+		"api/" + r.Name + "_test.go": "templates/api_test.go.tmpl",
+	}
+	out := make(map[string][]byte, len(files))
+	for path, tmpl := range files {
+		t, err := template.ParseFS(templates, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, r); err != nil {
+			return nil, err
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		out[path] = src
+	}
+	return out, nil
+}
+
+// camel turns snake_case into an exported Go name, keeping common
+// initialisms upper case.
+func camel(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		switch part {
+		case "id", "url", "ip", "api", "http", "json":
+			b.WriteString(strings.ToUpper(part))
+		default:
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// plural returns the English plural of the last word of s.
+func plural(s string) string {
+	switch {
+	case strings.HasSuffix(s, "y") && !strings.HasSuffix(s, "ay") && !strings.HasSuffix(s, "ey") && !strings.HasSuffix(s, "oy"):
+		return s[:len(s)-1] + "ies"
+	case strings.HasSuffix(s, "s"), strings.HasSuffix(s, "x"), strings.HasSuffix(s, "ch"), strings.HasSuffix(s, "sh"):
+		return s + "es"
+	}
+	return s + "s"
+}
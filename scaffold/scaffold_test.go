@@ -0,0 +1,106 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFields has a field of every kind.
+const testFields = "title:string!,price:float,quantity:int,paid:bool,published_at:time"
+
+func TestParse(t *testing.T) {
+	r, err := Parse("order_item", testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type() != "OrderItem" || r.Types() != "OrderItems" || r.Plural != "order_items" {
+		t.Errorf("names = %s, %s, %s", r.Type(), r.Types(), r.Plural)
+	}
+	if len(r.Fields) != 5 || !r.Fields[0].Required || r.Fields[4].GoType() != "time.Time" {
+		t.Errorf("fields = %+v", r.Fields)
+	}
+
+	for _, bad := range []struct{ name, fields string }{
+		{"OrderItem", "title"},
+		{"order_item", ""},
+		{"order_item", "title,title"},
+		{"order_item", "created_at:time"},
+		{"order_item", "title:text"},
+	} {
+		if _, err := Parse(bad.name, bad.fields); err == nil {
+			t.Errorf("Parse(%q, %q) succeeded", bad.name, bad.fields)
+		}
+	}
+}
+
+// generated writes the code generated for a resource with testFields to a
+// temporary directory and returns the module root and an overlay file
+// adding the code to it, for the go command's -overlay flag.
+func generated(t *testing.T) (root, overlay string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	r, err := Parse("order_item", testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Generate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err = filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	replace := map[string]string{}
+	for path, src := range files {
+		target := filepath.Join(root, filepath.FromSlash(path))
+		if _, err := os.Stat(target); err == nil {
+			t.Fatalf("%s exists in the module", path)
+		}
+		tmp := filepath.Join(dir, strings.ReplaceAll(path, "/", "_"))
+		if err := os.WriteFile(tmp, src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		replace[target] = tmp
+	}
+	b, err := json.Marshal(map[string]any{"Replace": replace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay = filepath.Join(dir, "overlay.json")
+	if err := os.WriteFile(overlay, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return root, overlay
+}
+
+// goCmd runs the go command in root, failing t with its output on error.
+func goCmd(t *testing.T, root string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestGeneratedCodeBuilds(t *testing.T) {
+	root, overlay := generated(t)
+	goCmd(t, root, "build", "-overlay", overlay, "./store", "./api")
+	goCmd(t, root, "vet", "-overlay", overlay, "./store", "./api")
+}
+
+func TestGeneratedTestsPass(t *testing.T) {
+	root, overlay := generated(t)
+	goCmd(t, root, "test", "-count=1", "-overlay", overlay, "-run", "^TestOrderItems", "./api")
+}
@@ -0,0 +1,266 @@
+// Package scheduler runs recurring tasks on cron schedules across a fleet
+// of instances.
+//
+// Every task has a document in the "schedules" collection holding its next
+// run time and a lock. Instances poll for due tasks and take the lock in
+// one atomic update, so each run happens on one instance only; a run whose
+// instance died is picked up again once its lock expires. Runs missed
+// while no instance was up are not made up: the next run is computed from
+// when the last one finished.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang/db"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned for unknown tasks.
+var ErrNotFound = errors.New("task not found")
+
+var taskRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "scheduled_task_runs_total",
+	Help: "Scheduled task runs by task and result (success or error).",
+}, []string{"task", "result"})
+
+// Task is the work of a scheduled task. It should return when ctx is done.
+type Task func(ctx context.Context) error
+
+// Status is the stored state of a task.
+type Status struct {
+	Name           string     `json:"name" bson:"_id"`
+	Schedule       string     `json:"schedule" bson:"schedule"`
+	NextRun        time.Time  `json:"next_run" bson:"next_run"`
+	Running        bool       `json:"running" bson:"-"`
+	Owner          string     `json:"owner,omitempty" bson:"owner,omitempty"`
+	LockedUntil    *time.Time `json:"-" bson:"locked_until,omitempty"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty" bson:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty" bson:"last_finished_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+}
+
+// Options configures a Scheduler. Zero fields take the defaults.
+type Options struct {
+	// PollInterval is how often due tasks are looked for; defaults to 15s.
+	PollInterval time.Duration
+	// Lease is how long a run holds its lock, and so the longest it may
+	// take; defaults to 1h.
+	Lease time.Duration
+}
+
+type task struct {
+	expr     string
+	schedule *Schedule
+	run      Task
+}
+
+// Scheduler runs the registered tasks once Run is called.
+type Scheduler struct {
+	mc    *db.MongoClient
+	opts  Options
+	owner string // identifies this instance in the locks
+	tasks map[string]*task
+}
+
+// New returns a Scheduler keeping its state through mc.
+func New(mc *db.MongoClient, opts Options) *Scheduler {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 15 * time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = time.Hour
+	}
+	host, _ := os.Hostname()
+	return &Scheduler{
+		mc:    mc,
+		opts:  opts,
+		owner: fmt.Sprintf("%s/%d", host, os.Getpid()),
+		tasks: map[string]*task{},
+	}
+}
+
+// Register adds task name running t on the cron schedule expr (see
+// Parse). It must be called before Run.
+func (s *Scheduler) Register(name, expr string, t Task) error {
+	sched, err := Parse(expr)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", name, err)
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return fmt.Errorf("task %s: schedule %q never runs", name, expr)
+	}
+	s.tasks[name] = &task{expr: expr, schedule: sched, run: t}
+	return nil
+}
+
+// Run runs due tasks until ctx is done, then waits for the running ones
+// to return.
+func (s *Scheduler) Run(ctx context.Context) {
+	if len(s.tasks) == 0 {
+		return
+	}
+	if err := s.sync(ctx); err != nil {
+		slog.Error("failed to set up scheduled tasks", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		for name, t := range s.tasks {
+			ok, err := s.lock(ctx, name)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("failed to lock scheduled task", "task", name, "error", err)
+				}
+				continue
+			}
+			if ok {
+				wg.Add(1)
+				go func(name string, t *task) {
+					defer wg.Done()
+					s.run(ctx, name, t)
+				}(name, t)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync creates the documents of new tasks and reschedules the tasks whose
+// expression changed.
+func (s *Scheduler) sync(ctx context.Context) error {
+	coll := s.mc.Collection("schedules")
+	now := time.Now().UTC()
+	for name, t := range s.tasks {
+		next := t.schedule.Next(now)
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": name},
+			bson.M{"$setOnInsert": bson.M{"schedule": t.expr, "next_run": next}},
+			options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": name, "schedule": bson.M{"$ne": t.expr}},
+			bson.M{"$set": bson.M{"schedule": t.expr, "next_run": next}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock takes the lock of task name if it is due and not locked.
+func (s *Scheduler) lock(ctx context.Context, name string) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.mc.Collection("schedules").UpdateOne(ctx, bson.M{
+		"_id":      name,
+		"next_run": bson.M{"$lte": now},
+		"$or":      bson.A{bson.M{"locked_until": nil}, bson.M{"locked_until": bson.M{"$lte": now}}},
+	}, bson.M{"$set": bson.M{
+		"locked_until":    now.Add(s.opts.Lease),
+		"owner":           s.owner,
+		"last_started_at": now,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// run runs t and records the outcome, releasing the lock.
+func (s *Scheduler) run(ctx context.Context, name string, t *task) {
+	start := time.Now()
+	runCtx, cancel := context.WithTimeout(ctx, s.opts.Lease)
+	err := safeRun(runCtx, t.run)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down: keep the lock so the run isn't repeated at once
+		// by another instance; it retries when the lock expires.
+		return
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"next_run": t.schedule.Next(now), "last_finished_at": now, "last_error": ""}
+	if err != nil {
+		set["last_error"] = err.Error()
+		taskRuns.WithLabelValues(name, "error").Inc()
+		slog.Error("scheduled task failed", "task", name, "error", err)
+	} else {
+		taskRuns.WithLabelValues(name, "success").Inc()
+		slog.Info("scheduled task finished", "task", name, "duration", time.Since(start))
+	}
+	if _, err := s.mc.Collection("schedules").UpdateOne(ctx, bson.M{"_id": name, "owner": s.owner},
+		bson.M{"$set": set, "$unset": bson.M{"locked_until": "", "owner": ""}}); err != nil {
+		slog.Error("failed to record scheduled task run", "task", name, "error", err)
+	}
+}
+
+func safeRun(ctx context.Context, t Task) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return t(ctx)
+}
+
+// Tasks returns the state of the registered tasks, by name.
+func (s *Scheduler) Tasks(ctx context.Context) ([]Status, error) {
+	names := make([]string, 0, len(s.tasks))
+	for name := range s.tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cur, err := s.mc.Collection("schedules").Find(ctx, bson.M{"_id": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+	var stored []Status
+	if err := cur.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+	byName := map[string]Status{}
+	for _, st := range stored {
+		byName[st.Name] = st
+	}
+
+	now := time.Now()
+	out := make([]Status, 0, len(names))
+	for _, name := range names {
+		st, ok := byName[name]
+		if !ok {
+			// Not synced yet
+			st = Status{Name: name, Schedule: s.tasks[name].expr, NextRun: s.tasks[name].schedule.Next(now)}
+		}
+		st.Running = st.LockedUntil != nil && st.LockedUntil.After(now)
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Trigger makes task name due now; it runs within the poll interval unless
+// it is running already.
+func (s *Scheduler) Trigger(ctx context.Context, name string) error {
+	if _, ok := s.tasks[name]; !ok {
+		return ErrNotFound
+	}
+	_, err := s.mc.Collection("schedules").UpdateOne(ctx, bson.M{"_id": name},
+		bson.M{"$set": bson.M{"next_run": time.Now().UTC()}, "$setOnInsert": bson.M{"schedule": s.tasks[name].expr}},
+		options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,64 @@
+// Package schemas is the registry of the JSON Schemas of the events the
+// service emits, so consumers can validate them and follow their changes.
+//
+// Schemas are named after the event: user.created for the messages
+// published to the broker (see package events), webhook.user.created for
+// the webhook bodies (see package webhooks). Each version of a schema is
+// data/<name>/<version>.json. A new version is added, and the old one
+// kept, only for incompatible changes; new fields are added to the
+// current one.
+package schemas
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed data
+var files embed.FS
+
+// Ref identifies a version of a schema.
+type Ref struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// Path returns where the schema is served.
+func (r Ref) Path() string {
+	return "/schemas/" + r.Name + "/" + strconv.Itoa(r.Version)
+}
+
+// Get returns the version of the schema name, or false if there is none.
+func Get(name string, version int) ([]byte, bool) {
+	if !fs.ValidPath(name) || path.Base(name) != name {
+		return nil, false
+	}
+	b, err := files.ReadFile("data/" + name + "/" + strconv.Itoa(version) + ".json")
+	return b, err == nil
+}
+
+// List returns every version of every schema, by name and version.
+func List() []Ref {
+	var refs []Ref
+	names, _ := files.ReadDir("data")
+	for _, n := range names {
+		versions, _ := files.ReadDir("data/" + n.Name())
+		for _, v := range versions {
+			version, err := strconv.Atoi(strings.TrimSuffix(v.Name(), ".json"))
+			if err == nil {
+				refs = append(refs, Ref{Name: n.Name(), Version: version})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Name != refs[j].Name {
+			return refs[i].Name < refs[j].Name
+		}
+		return refs[i].Version < refs[j].Version
+	})
+	return refs
+}
@@ -0,0 +1,226 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Secrets resolves configuration values that may be provided as plain
+// environment variables, as files (Docker/Kubernetes secrets) or by Vault.
+type Secrets struct {
+	vault  *Vault            // nil when VAULT_ADDR is unset
+	values map[string]string // data of the VAULT_SECRET_PATH secret
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Load reads the optional Vault settings from the environment:
+//
+//   - VAULT_ADDR              Vault server address; Vault is disabled when empty
+//   - VAULT_TOKEN(_FILE)      token used to authenticate
+//   - VAULT_SECRET_PATH       KV secret whose keys are used as config values,
+//     e.g. "secret/data/min-go-server" with a MONGODB_URI key
+//   - VAULT_MONGO_CREDS_PATH  dynamic credentials endpoint, e.g. "database/creds/api"
+//   - VAULT_JWT_KEY_PATH      secret whose "key" is the JWT signing key, e.g.
+//     "secret/data/min-go-server/jwt"
+//
+// When Vault is enabled the token is renewed in the background until Close.
+func Load(ctx context.Context) (*Secrets, error) {
+	s := &Secrets{values: map[string]string{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return s, nil
+	}
+
+	token, err := Lookup("VAULT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is set but VAULT_TOKEN is empty")
+	}
+	s.vault = NewVault(addr, token)
+
+	if path := os.Getenv("VAULT_SECRET_PATH"); path != "" {
+		sec, err := s.vault.Read(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		s.values = sec.Data
+		if sec.Lease.Renewable {
+			go s.vault.KeepLeaseAlive(s.ctx, sec.Lease)
+		}
+	}
+
+	go s.vault.KeepTokenAlive(s.ctx)
+	return s, nil
+}
+
+// Get returns the value for key from, in order of precedence, the
+// environment variable key, the file named by key_FILE, and the Vault
+// secret. It returns "" when the key is not set anywhere.
+func (s *Secrets) Get(key string) (string, error) {
+	v, err := Lookup(key)
+	if err != nil || v != "" {
+		return v, err
+	}
+	return s.values[key], nil
+}
+
+// MongoURI injects dynamic Mongo credentials from VAULT_MONGO_CREDS_PATH into
+// uri and keeps their lease renewed. Without that setting uri is returned
+// unchanged.
+func (s *Secrets) MongoURI(ctx context.Context, uri string) (string, error) {
+	path := os.Getenv("VAULT_MONGO_CREDS_PATH")
+	if s.vault == nil || path == "" {
+		return uri, nil
+	}
+
+	sec, err := s.vault.Read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	user, pass := sec.Data["username"], sec.Data["password"]
+	if user == "" {
+		return "", fmt.Errorf("vault secret %s has no username", path)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid MongoDB URI: %v", err)
+	}
+	u.User = url.UserPassword(user, pass)
+
+	if sec.Lease.Renewable {
+		go s.vault.KeepLeaseAlive(s.ctx, sec.Lease)
+	}
+	return u.String(), nil
+}
+
+// Key is a secret value that may be rotated while the server runs.
+type Key struct {
+	v atomic.Pointer[string]
+}
+
+// Get returns the current value of k.
+func (k *Key) Get() string {
+	return *k.v.Load()
+}
+
+func (k *Key) set(v string) {
+	k.v.Store(&v)
+}
+
+// keyRefresh is how often a signing key from a file, or from a Vault secret
+// without a lease, is read again to pick up rotations.
+var keyRefresh = 5 * time.Minute
+
+// SigningKey returns the JWT signing key from, in order of precedence,
+// JWT_SIGNING_KEY, the file named by JWT_SIGNING_KEY_FILE, the "key" of the
+// secret at VAULT_JWT_KEY_PATH and the JWT_SIGNING_KEY of the
+// VAULT_SECRET_PATH secret. It is an error if none is set.
+//
+// Keys from a file or VAULT_JWT_KEY_PATH are read again until Close, to
+// pick up rotations: at two thirds of the lease of the Vault secret, which
+// replaces the lease, or else every few minutes. A failed read keeps the
+// key in use.
+func (s *Secrets) SigningKey(ctx context.Context) (*Key, error) {
+	key := &Key{}
+	read, err := s.signingKeyReader()
+	if err != nil {
+		return nil, err
+	}
+	v, next, err := read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY is not set")
+	}
+	key.set(v)
+	if next > 0 {
+		go func() {
+			for sleep(s.ctx, next) {
+				v, n, err := read(s.ctx)
+				if err != nil || v == "" {
+					slog.Error("JWT signing key not refreshed", "error", err)
+					continue
+				}
+				key.set(v)
+				next = n
+			}
+		}()
+	}
+	return key, nil
+}
+
+// signingKeyReader returns a function reading the signing key from where
+// SigningKey finds it, and when to read it again; zero means never.
+func (s *Secrets) signingKeyReader() (func(context.Context) (string, time.Duration, error), error) {
+	if os.Getenv("JWT_SIGNING_KEY") != "" {
+		v, err := Lookup("JWT_SIGNING_KEY")
+		return func(context.Context) (string, time.Duration, error) { return v, 0, err }, nil
+	}
+	if os.Getenv("JWT_SIGNING_KEY_FILE") != "" {
+		return func(context.Context) (string, time.Duration, error) {
+			v, err := Lookup("JWT_SIGNING_KEY")
+			return v, keyRefresh, err
+		}, nil
+	}
+	if path := os.Getenv("VAULT_JWT_KEY_PATH"); path != "" {
+		if s.vault == nil {
+			return nil, fmt.Errorf("VAULT_JWT_KEY_PATH is set but VAULT_ADDR is empty")
+		}
+		return func(ctx context.Context) (string, time.Duration, error) {
+			sec, err := s.vault.Read(ctx, path)
+			if err != nil {
+				return "", 0, err
+			}
+			if sec.Data["key"] == "" {
+				return "", 0, fmt.Errorf("vault secret %s has no key", path)
+			}
+			if sec.Lease.Duration <= 0 {
+				return sec.Data["key"], keyRefresh, nil
+			}
+			// Reading it again gets a new lease, for a key rotated or not
+			return sec.Data["key"], sec.Lease.Duration * 2 / 3, nil
+		}, nil
+	}
+	return func(context.Context) (string, time.Duration, error) {
+		return s.values["JWT_SIGNING_KEY"], 0, nil
+	}, nil
+}
+
+// Close stops background renewal.
+func (s *Secrets) Close() {
+	s.cancel()
+}
+
+// Lookup returns the environment variable key. When it is unset and key_FILE
+// names a file, the file contents with surrounding whitespace trimmed are
+// returned instead. Setting both is an error.
+func Lookup(key string) (string, error) {
+	v := os.Getenv(key)
+	file := os.Getenv(key + "_FILE")
+	if file == "" {
+		return v, nil
+	}
+	if v != "" {
+		return "", fmt.Errorf("both %s and %s_FILE are set", key, key)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %v", key, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeVault serves the KV secret at path, whose key is returned by key.
+func fakeVault(t *testing.T, path string, key func() string, lease int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/" + path:
+			json.NewEncoder(w).Encode(map[string]any{
+				"lease_duration": lease,
+				"data":           map[string]any{"data": map[string]any{"key": key()}, "metadata": map[string]any{}},
+			})
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"renewable": false}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func loadSecrets(t *testing.T) *Secrets {
+	t.Helper()
+	s, err := Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// eventually waits for key to hold want.
+func eventually(t *testing.T, key *Key, want string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); key.Get() != want; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("key = %q, want %q", key.Get(), want)
+		}
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	defer func(d time.Duration) { keyRefresh = d }(keyRefresh)
+	keyRefresh = 10 * time.Millisecond
+	ctx := context.Background()
+
+	t.Run("unset", func(t *testing.T) {
+		if _, err := loadSecrets(t).SigningKey(ctx); err == nil {
+			t.Error("SigningKey succeeded without a key")
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEY", "from env")
+		key, err := loadSecrets(t).SigningKey(ctx)
+		if err != nil || key.Get() != "from env" {
+			t.Fatalf("SigningKey = %v, %v", key, err)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "key")
+		if err := os.WriteFile(file, []byte("old\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("JWT_SIGNING_KEY_FILE", file)
+		key, err := loadSecrets(t).SigningKey(ctx)
+		if err != nil || key.Get() != "old" {
+			t.Fatalf("SigningKey = %v, %v", key, err)
+		}
+		if err := os.WriteFile(file, []byte("new\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, key, "new")
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(3 * keyRefresh)
+		if key.Get() != "new" {
+			t.Errorf("key after the file was removed = %q, want the last one read", key.Get())
+		}
+	})
+
+	t.Run("vault", func(t *testing.T) {
+		var current atomic.Value
+		current.Store("old")
+		srv := fakeVault(t, "secret/data/jwt", func() string { return current.Load().(string) }, 0)
+		t.Setenv("VAULT_ADDR", srv.URL)
+		t.Setenv("VAULT_TOKEN", "token")
+		t.Setenv("VAULT_JWT_KEY_PATH", "secret/data/jwt")
+		key, err := loadSecrets(t).SigningKey(ctx)
+		if err != nil || key.Get() != "old" {
+			t.Fatalf("SigningKey = %v, %v", key, err)
+		}
+		current.Store("new")
+		eventually(t, key, "new")
+	})
+
+	t.Run("vault without key", func(t *testing.T) {
+		srv := fakeVault(t, "secret/data/jwt", func() string { return "" }, 0)
+		t.Setenv("VAULT_ADDR", srv.URL)
+		t.Setenv("VAULT_TOKEN", "token")
+		t.Setenv("VAULT_JWT_KEY_PATH", "secret/data/jwt")
+		if _, err := loadSecrets(t).SigningKey(ctx); err == nil {
+			t.Error("SigningKey succeeded with a secret without key")
+		}
+	})
+
+	t.Run("vault disabled", func(t *testing.T) {
+		t.Setenv("VAULT_JWT_KEY_PATH", "secret/data/jwt")
+		if _, err := loadSecrets(t).SigningKey(ctx); err == nil {
+			t.Error("SigningKey succeeded with VAULT_JWT_KEY_PATH but no VAULT_ADDR")
+		}
+	})
+}
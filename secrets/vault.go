@@ -0,0 +1,189 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault is a minimal client for the HashiCorp Vault HTTP API, covering
+// secret reads and token/lease renewal.
+type Vault struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// Lease describes how long a secret or token stays valid.
+type Lease struct {
+	ID        string
+	Duration  time.Duration
+	Renewable bool
+}
+
+// Secret is the string data of a Vault secret together with its lease.
+type Secret struct {
+	Data  map[string]string
+	Lease Lease
+}
+
+// NewVault returns a client for the Vault server at addr.
+func NewVault(addr, token string) *Vault {
+	return &Vault{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type vaultResponse struct {
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"`
+	Renewable     bool           `json:"renewable"`
+	Data          map[string]any `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body any) (*vaultResponse, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimLeft(path, "/"), rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var out vaultResponse
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("vault %s %s: decode error: %v", method, path, err)
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vault %s %s: status %d: %s", method, path, resp.StatusCode, strings.Join(out.Errors, "; "))
+	}
+	return &out, nil
+}
+
+// Read fetches the secret at path. KV version 2 responses are unwrapped so
+// Data always holds the secret's own keys.
+func (v *Vault) Read(ctx context.Context, path string) (*Secret, error) {
+	resp, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, kv2 := data["metadata"]; kv2 {
+			data = inner
+		}
+	}
+
+	sec := &Secret{
+		Data: map[string]string{},
+		Lease: Lease{
+			ID:        resp.LeaseID,
+			Duration:  time.Duration(resp.LeaseDuration) * time.Second,
+			Renewable: resp.Renewable,
+		},
+	}
+	for k, val := range data {
+		if s, ok := val.(string); ok {
+			sec.Data[k] = s
+		} else {
+			sec.Data[k] = fmt.Sprint(val)
+		}
+	}
+	return sec, nil
+}
+
+// KeepTokenAlive renews the client token at two thirds of its TTL until ctx
+// is cancelled. Non-renewable and root tokens are left alone.
+func (v *Vault) KeepTokenAlive(ctx context.Context) {
+	resp, err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		slog.Error("vault token lookup failed", "error", err)
+		return
+	}
+	renewable, _ := resp.Data["renewable"].(bool)
+	ttl, _ := resp.Data["ttl"].(float64)
+	if !renewable || ttl <= 0 {
+		return
+	}
+
+	next := time.Duration(ttl) * time.Second
+	for {
+		if !sleep(ctx, next*2/3) {
+			return
+		}
+		resp, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]any{})
+		if err != nil || resp.Auth == nil {
+			slog.Error("vault token renewal failed", "error", err)
+			next = 30 * time.Second
+			continue
+		}
+		next = time.Duration(resp.Auth.LeaseDuration) * time.Second
+		if !resp.Auth.Renewable || next <= 0 {
+			slog.Warn("vault token is no longer renewable")
+			return
+		}
+	}
+}
+
+// KeepLeaseAlive renews lease at two thirds of its duration until ctx is
+// cancelled or Vault stops extending it (max TTL reached).
+func (v *Vault) KeepLeaseAlive(ctx context.Context, lease Lease) {
+	next := lease.Duration
+	for next > 0 {
+		if !sleep(ctx, next*2/3) {
+			return
+		}
+		resp, err := v.do(ctx, http.MethodPut, "sys/leases/renew", map[string]any{"lease_id": lease.ID})
+		if err != nil {
+			slog.Error("vault lease renewal failed", "lease_id", lease.ID, "error", err)
+			next = 30 * time.Second
+			continue
+		}
+		d := time.Duration(resp.LeaseDuration) * time.Second
+		if !resp.Renewable || d < next {
+			slog.Warn("vault lease can no longer be extended", "lease_id", lease.ID, "expires_in", d)
+			return
+		}
+		next = d
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
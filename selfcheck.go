@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/config"
+	"golang/db"
+	"golang/fixtures"
+	"golang/selfcheck"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// minMongoVersion is the oldest MongoDB server the queries run on: quotas
+// size documents with $bsonSize, new in 4.4.
+var minMongoVersion = [2]int{4, 4}
+
+// startupChecks returns the checks run before serving. The sample data
+// is loaded as one of them, so a failure shows in the report.
+func startupChecks(cfg *config.Config, st *storage) []selfcheck.Check {
+	checks := []selfcheck.Check{{
+		Name:     "config",
+		Critical: true,
+		// Loading refuses invalid configurations; the check records which
+		// was loaded
+		Run: func(context.Context) (string, error) {
+			return "storage " + cfg.Storage.Backend, nil
+		},
+	}}
+	mc := st.mongo
+	if mc == nil {
+		return checks
+	}
+	return append(checks,
+		selfcheck.Check{Name: "mongodb", Critical: true, Run: func(ctx context.Context) (string, error) {
+			start := time.Now()
+			if err := mc.Client.Ping(ctx, nil); err != nil {
+				return "", err
+			}
+			return "ping " + time.Since(start).Round(time.Millisecond).String(), nil
+		}},
+		selfcheck.Check{Name: "mongodb_version", Critical: true, Run: func(ctx context.Context) (string, error) {
+			var build struct {
+				Version string `bson:"version"`
+			}
+			if err := mc.DB.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+				return "", err
+			}
+			if !versionAtLeast(build.Version, minMongoVersion) {
+				return "", fmt.Errorf("MongoDB %s is older than %d.%d", build.Version, minMongoVersion[0], minMongoVersion[1])
+			}
+			return "MongoDB " + build.Version, nil
+		}},
+		selfcheck.Check{Name: "indexes", Critical: true, Run: func(ctx context.Context) (string, error) {
+			status, err := mc.IndexStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+			var missing, drift []string
+			for _, s := range status {
+				switch s.State {
+				case db.IndexMissing:
+					missing = append(missing, s.Collection+"."+s.Name)
+				case db.IndexDrift:
+					drift = append(drift, s.Collection+"."+s.Name)
+				}
+			}
+			if len(missing) > 0 {
+				return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+			}
+			if len(drift) > 0 {
+				return "", selfcheck.Warnf("differing from the registry: %s; see the indexes command", strings.Join(drift, ", "))
+			}
+			return strconv.Itoa(len(status)) + " indexes", nil
+		}},
+		selfcheck.Check{Name: "collections", Critical: true, Run: func(ctx context.Context) (string, error) {
+			// The collections with registered indexes, which creating the
+			// indexes created
+			status, err := mc.IndexStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+			seen := map[string]bool{}
+			var missing []string
+			for _, s := range append([]db.IndexState{{Collection: "users"}}, status...) {
+				if seen[s.Collection] {
+					continue
+				}
+				seen[s.Collection] = true
+				coll := mc.Collection(s.Collection)
+				names, err := coll.Database().ListCollectionNames(ctx, bson.M{"name": coll.Name()})
+				if err != nil {
+					return "", err
+				}
+				if len(names) == 0 {
+					missing = append(missing, s.Collection)
+				}
+			}
+			if len(missing) > 0 {
+				return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+			}
+			return strconv.Itoa(len(seen)) + " collections", nil
+		}},
+		selfcheck.Check{Name: "write", Critical: true, Run: func(ctx context.Context) (string, error) {
+			// An update and a delete matching nothing need the same
+			// privileges and a writable primary as real ones, without
+			// changing anything
+			users, none := mc.Collection("users"), bson.M{"_id": primitive.NewObjectID()}
+			if _, err := users.UpdateOne(ctx, none, bson.M{"$set": bson.M{"self_check": true}}); err != nil {
+				return "", fmt.Errorf("update users: %v", err)
+			}
+			if _, err := users.DeleteOne(ctx, none); err != nil {
+				return "", fmt.Errorf("delete users: %v", err)
+			}
+			return "users writable", nil
+		}},
+		// Make the sample data visible in a fresh database
+		selfcheck.Check{Name: "sample_data", Run: func(ctx context.Context) (string, error) {
+			sample, err := fixtures.Find("", "sample")
+			if err != nil {
+				return "", err
+			}
+			if err := loadDataset(ctx, st, sample, fixtures.Options{}); err != nil {
+				return "", err
+			}
+			return "dataset " + sample.Name, nil
+		}},
+	)
+}
+
+// versionAtLeast reports whether version, such as 7.0.2, is least or newer.
+func versionAtLeast(version string, least [2]int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return major > least[0] || major == least[0] && minor >= least[1]
+}
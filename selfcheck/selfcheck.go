@@ -0,0 +1,115 @@
+// Package selfcheck runs checks of the server's environment, such as on
+// startup, and reports their outcome.
+package selfcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Statuses of a check.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Check is a named check. Run returns a detail for the report, or an
+// error when the check failed; a Warning only warns.
+type Check struct {
+	Name string
+	// Critical checks failing fail the report; others only warn.
+	Critical bool
+	Run      func(ctx context.Context) (string, error)
+}
+
+// Warning is the error of a check that passed with a concern.
+type Warning struct {
+	msg string
+}
+
+func (w *Warning) Error() string { return w.msg }
+
+// Warnf returns a Warning.
+func Warnf(format string, args ...any) error {
+	return &Warning{msg: fmt.Sprintf(format, args...)}
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the outcome of checks run together. OK is false when a
+// critical check failed.
+type Report struct {
+	OK        bool      `json:"ok"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Run runs checks in order, each within timeout. Checks after a failed
+// critical one still run, so the report is complete; they should not
+// depend on each other.
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	rep := Report{OK: true, CheckedAt: time.Now().UTC(), Checks: make([]Result, 0, len(checks))}
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		detail, err := c.Run(cctx)
+		cancel()
+
+		res := Result{Name: c.Name, Status: StatusOK, Critical: c.Critical, Detail: detail,
+			Duration: time.Since(start).Round(time.Millisecond).String()}
+		var warn *Warning
+		switch {
+		case err == nil:
+		case errors.As(err, &warn) || !c.Critical:
+			res.Status, res.Detail = StatusWarn, err.Error()
+		default:
+			res.Status, res.Detail = StatusFail, err.Error()
+			rep.OK = false
+		}
+		rep.Checks = append(rep.Checks, res)
+	}
+	return rep
+}
+
+// Failed returns the names of the failed checks.
+func (rep Report) Failed() []string {
+	var out []string
+	for _, c := range rep.Checks {
+		if c.Status == StatusFail {
+			out = append(out, c.Name)
+		}
+	}
+	return out
+}
+
+// Log logs each check, failed ones as errors and warnings as warnings,
+// and a summary.
+func (rep Report) Log(ctx context.Context) {
+	for _, c := range rep.Checks {
+		level := slog.LevelInfo
+		switch c.Status {
+		case StatusWarn:
+			level = slog.LevelWarn
+		case StatusFail:
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "self-check", "check", c.Name, "status", c.Status,
+			"critical", c.Critical, "detail", c.Detail, "duration", c.Duration)
+	}
+	if rep.OK {
+		slog.InfoContext(ctx, "self-check passed", "checks", len(rep.Checks))
+		return
+	}
+	slog.ErrorContext(ctx, "self-check failed", "checks", len(rep.Checks), "failed", rep.Failed())
+}
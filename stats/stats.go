@@ -0,0 +1,243 @@
+// Package stats maintains user statistics in the "stats" collection, one
+// document per tenant, so reading them doesn't scan the users.
+//
+// Writes through the API adjust the documents as they happen; a scheduled
+// Recompute rebuilds them from the users, correcting what the increments
+// miss: writes made elsewhere, such as imports, restores and archiving,
+// and increments racing a recompute.
+package stats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang/db"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// User statuses.
+const (
+	// Active users are those the API lists.
+	Active = "active"
+	// Deleted users are soft-deleted and can still be restored.
+	Deleted = "deleted"
+	// Archived users were moved to the archive for inactivity.
+	Archived = "archived"
+)
+
+// SignupDays is how many days of daily signup counts are kept, today
+// included.
+const SignupDays = 30
+
+// dayFormat is the key of a day in the signup counts.
+const dayFormat = "2006-01-02"
+
+// doc is a document of the "stats" collection, keyed by tenant id ("" for
+// users without a tenant).
+type doc struct {
+	Tenant       string           `bson:"_id"`
+	Total        int64            `bson:"total"`
+	ByStatus     map[string]int64 `bson:"by_status"`
+	Signups      map[string]int64 `bson:"signups"` // day -> users created
+	UpdatedAt    time.Time        `bson:"updated_at"`
+	RecomputedAt *time.Time       `bson:"recomputed_at,omitempty"`
+}
+
+// Stats are the statistics of the users of a tenant.
+type Stats struct {
+	// Total is the number of active users.
+	Total    int64            `json:"total"`
+	ByStatus map[string]int64 `json:"by_status"`
+	// DailySignups are the users created on each of the last SignupDays
+	// days, oldest first, whether they still exist or not.
+	DailySignups []DayCount `json:"daily_signups"`
+	UpdatedAt    *time.Time `json:"updated_at"`
+	// RecomputedAt is when the numbers were last rebuilt from the users;
+	// null until the first recompute.
+	RecomputedAt *time.Time `json:"recomputed_at"`
+}
+
+// DayCount is a count for a day, formatted YYYY-MM-DD.
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// Store reads and maintains the statistics.
+type Store struct {
+	mc *db.MongoClient
+	// softDelete says whether deleting a user keeps it as deleted.
+	softDelete bool
+}
+
+// New returns a store kept through mc. softDelete must match the user
+// repository's.
+func New(mc *db.MongoClient, softDelete bool) *Store {
+	return &Store{mc: mc, softDelete: softDelete}
+}
+
+func (s *Store) coll() *mongo.Collection {
+	return s.mc.Collection("stats")
+}
+
+// Get returns the statistics of the tenant of ctx.
+func (s *Store) Get(ctx context.Context) (*Stats, error) {
+	var d doc
+	err := s.mc.ReadCollection("stats").FindOne(ctx, bson.M{"_id": tenant.FromContext(ctx)},
+		options.FindOne().SetComment(db.Comment(ctx))).Decode(&d)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	out := &Stats{
+		Total:        d.Total,
+		ByStatus:     map[string]int64{Active: 0, Deleted: 0, Archived: 0},
+		RecomputedAt: d.RecomputedAt,
+	}
+	if !d.UpdatedAt.IsZero() {
+		out.UpdatedAt = &d.UpdatedAt
+	}
+	for k, n := range d.ByStatus {
+		out.ByStatus[k] = n
+	}
+	day := time.Now().UTC().AddDate(0, 0, 1-SignupDays)
+	for i := 0; i < SignupDays; i++ {
+		key := day.Format(dayFormat)
+		out.DailySignups = append(out.DailySignups, DayCount{Date: key, Count: d.Signups[key]})
+		day = day.AddDate(0, 0, 1)
+	}
+	return out, nil
+}
+
+// inc adds deltas to the fields of the tenant of ctx.
+func (s *Store) inc(ctx context.Context, deltas bson.M) error {
+	_, err := s.coll().UpdateOne(ctx, bson.M{"_id": tenant.FromContext(ctx)},
+		bson.M{"$inc": deltas, "$set": bson.M{"updated_at": time.Now().UTC()}},
+		options.Update().SetUpsert(true).SetComment(db.Comment(ctx)))
+	return err
+}
+
+// created counts a user created at t.
+func (s *Store) created(ctx context.Context, t time.Time) error {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return s.inc(ctx, bson.M{
+		"total":                                1,
+		"by_status." + Active:                  1,
+		"signups." + t.UTC().Format(dayFormat): 1,
+	})
+}
+
+// deleted counts a deleted user.
+func (s *Store) deleted(ctx context.Context) error {
+	deltas := bson.M{"total": -1, "by_status." + Active: -1}
+	if s.softDelete {
+		deltas["by_status."+Deleted] = 1
+	}
+	return s.inc(ctx, deltas)
+}
+
+// Erased counts a user removed for good other than through Users, as by
+// data subject erasure; live says whether it wasn't soft-deleted.
+func (s *Store) Erased(ctx context.Context, live bool) error {
+	if live {
+		return s.inc(ctx, bson.M{"total": -1, "by_status." + Active: -1})
+	}
+	if s.softDelete {
+		return s.inc(ctx, bson.M{"by_status." + Deleted: -1})
+	}
+	return nil
+}
+
+// Recompute rebuilds the statistics of every tenant from the users and the
+// archive. Signups are counted from the users still stored, so users
+// removed for good no longer count on the day they signed up.
+func (s *Store) Recompute(ctx context.Context) error {
+	since := time.Now().UTC().AddDate(0, 0, 1-SignupDays).Truncate(24 * time.Hour)
+	docs := map[string]*doc{}
+	get := func(t *string) *doc {
+		id := ""
+		if t != nil {
+			id = *t
+		}
+		if docs[id] == nil {
+			docs[id] = &doc{Tenant: id, ByStatus: map[string]int64{}, Signups: map[string]int64{}}
+		}
+		return docs[id]
+	}
+
+	var statuses []struct {
+		ID struct {
+			Tenant  *string `bson:"tenant"`
+			Deleted bool    `bson:"deleted"`
+		} `bson:"_id"`
+		N int64 `bson:"n"`
+	}
+	p := db.NewPipeline().Group(bson.M{
+		"tenant":  db.Ref("tenant_id"),
+		"deleted": bson.M{"$gt": bson.A{db.Ref("deleted_at"), nil}},
+	}, db.Count("n"))
+	if err := s.mc.Aggregate(ctx, "users", p, &statuses); err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		d := get(st.ID.Tenant)
+		if st.ID.Deleted {
+			d.ByStatus[Deleted] += st.N
+		} else {
+			d.ByStatus[Active] += st.N
+			d.Total += st.N
+		}
+	}
+
+	var signups []struct {
+		ID struct {
+			Tenant *string `bson:"tenant"`
+			Day    string  `bson:"day"`
+		} `bson:"_id"`
+		N int64 `bson:"n"`
+	}
+	p = db.NewPipeline().
+		Match(bson.M{"created_at": bson.M{"$gte": since}}).
+		Group(bson.M{
+			"tenant": db.Ref("tenant_id"),
+			"day":    bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": db.Ref("created_at")}},
+		}, db.Count("n"))
+	if err := s.mc.Aggregate(ctx, "users", p, &signups); err != nil {
+		return err
+	}
+	for _, su := range signups {
+		get(su.ID.Tenant).Signups[su.ID.Day] = su.N
+	}
+
+	var archived []struct {
+		Tenant *string `bson:"_id"`
+		N      int64   `bson:"n"`
+	}
+	p = db.NewPipeline().Group(db.Ref("tenant_id"), db.Count("n"))
+	if err := s.mc.Aggregate(ctx, "users_archive", p, &archived); err != nil {
+		return err
+	}
+	for _, a := range archived {
+		get(a.Tenant).ByStatus[Archived] = a.N
+	}
+
+	now := time.Now().UTC()
+	ids := make(bson.A, 0, len(docs))
+	for id, d := range docs {
+		d.UpdatedAt, d.RecomputedAt = now, &now
+		_, err := s.coll().ReplaceOne(ctx, bson.M{"_id": id}, d, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	// Tenants left without users
+	_, err := s.coll().DeleteMany(ctx, bson.M{"_id": bson.M{"$nin": ids}})
+	return err
+}
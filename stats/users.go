@@ -0,0 +1,41 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+
+	"golang/store"
+)
+
+// Users keeps the statistics up to date with the writes through the
+// wrapped UserRepository. Like activities, this is best effort: the next
+// Recompute corrects what failed.
+type Users struct {
+	store.UserRepository
+	s *Store
+}
+
+// NewUsers returns next counting its writes in s.
+func NewUsers(next store.UserRepository, s *Store) *Users {
+	return &Users{UserRepository: next, s: s}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	if err := u.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	if err := u.s.created(ctx, user.CreatedAt); err != nil {
+		slog.ErrorContext(ctx, "failed to update user stats", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+func (u *Users) Delete(ctx context.Context, id string) error {
+	if err := u.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := u.s.deleted(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to update user stats", "user_id", id, "error", err)
+	}
+	return nil
+}
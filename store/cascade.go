@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang/db"
+	"golang/jobs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRestricted is returned when deleting a user that documents of a
+// Restrict relation still refer to.
+var ErrRestricted = errors.New("user is still referenced")
+
+// OnDelete is what becomes of the documents referring to a user when the
+// user is deleted.
+type OnDelete string
+
+const (
+	// Cascade removes them along with the user.
+	Cascade OnDelete = "cascade"
+	// Nullify unsets their reference to the user.
+	Nullify OnDelete = "nullify"
+	// Restrict refuses to delete the user while any exist.
+	Restrict OnDelete = "restrict"
+)
+
+// Relation is a collection whose documents refer to users by the ObjectID
+// in Field.
+type Relation struct {
+	Name string
+	// Collection is the resource name, which MONGODB_COLLECTIONS may map
+	// to another collection.
+	Collection string
+	Field      string
+	OnDelete   OnDelete
+	// GridFS makes Collection a GridFS bucket, Field a field of its files
+	// documents; the chunks of removed files are removed too.
+	GridFS bool
+}
+
+// DefaultRelations are the relations of users unless configured
+// otherwise: a user's sessions are removed with it, and files uploaded
+// through a user's session are kept without an owner.
+var DefaultRelations = []Relation{
+	{Name: "sessions", Collection: "sessions", Field: "user_id", OnDelete: Cascade},
+	{Name: "files", Collection: "fs", Field: "metadata.user_id", OnDelete: Nullify, GridFS: true},
+}
+
+// ParseRelations returns DefaultRelations with the strategies of strategies
+// applied, by relation name: "sessions", "files", or collection:field for
+// other collections referring to users, such as posts:author_id.
+func ParseRelations(strategies map[string]string) ([]Relation, error) {
+	out := append([]Relation(nil), DefaultRelations...)
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := OnDelete(strategies[name])
+		switch s {
+		case Cascade, Nullify, Restrict:
+		default:
+			return nil, fmt.Errorf("%s: unknown strategy %q, want cascade, nullify or restrict", name, s)
+		}
+		i := relationIndex(out, name)
+		if i < 0 {
+			coll, field, ok := strings.Cut(name, ":")
+			if !ok || coll == "" || field == "" {
+				return nil, fmt.Errorf("%s: want sessions, files or collection:field", name)
+			}
+			out = append(out, Relation{Name: name, Collection: coll, Field: field})
+			i = len(out) - 1
+		}
+		out[i].OnDelete = s
+	}
+	return out, nil
+}
+
+func relationIndex(rels []Relation, name string) int {
+	for i, r := range rels {
+		if r.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// userCleanupJob is the type of the jobs removing or nullifying what
+// refers to a deleted user, with CleanupInBackground.
+const userCleanupJob = "user.cleanup"
+
+// CleanupInBackground makes Delete and Purge leave the cascade and nullify
+// relations of users to a job on q, queued in the transaction removing
+// the user, so deleting a user who owns much returns at once. Restrict
+// relations are still checked before the user is removed.
+func (m *MongoUsers) CleanupInBackground(q *jobs.Queue) {
+	m.cleanup = q
+	q.Handle(userCleanupJob, m.cleanupJob)
+}
+
+func (m *MongoUsers) relations() []Relation {
+	if m.Relations == nil {
+		return DefaultRelations
+	}
+	return m.Relations
+}
+
+// restrict fails with ErrRestricted when documents of a Restrict relation
+// of rels refer to user oid.
+func (m *MongoUsers) restrict(ctx context.Context, oid primitive.ObjectID, rels []Relation) error {
+	for _, rel := range rels {
+		if rel.OnDelete != Restrict {
+			continue
+		}
+		n, err := m.relationCollection(rel).CountDocuments(ctx, bson.M{rel.Field: oid},
+			options.Count().SetLimit(1).SetComment(db.Comment(ctx)))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w by %s", ErrRestricted, rel.Name)
+		}
+	}
+	return nil
+}
+
+// cascade removes or nullifies the documents referring to user oid, now or
+// through a cleanup job. Sessions are always handled at once, so the user
+// is logged out with the delete.
+func (m *MongoUsers) cascade(ctx context.Context, oid primitive.ObjectID) error {
+	if m.cleanup == nil {
+		return m.cleanupUser(ctx, oid, m.relations())
+	}
+	var sessions []Relation
+	for _, rel := range m.relations() {
+		if rel.Name == "sessions" {
+			sessions = append(sessions, rel)
+		}
+	}
+	if err := m.cleanupUser(ctx, oid, sessions); err != nil {
+		return err
+	}
+	_, err := m.cleanup.Enqueue(ctx, userCleanupJob, map[string]string{"user_id": oid.Hex()}, jobs.EnqueueOptions{})
+	return err
+}
+
+func (m *MongoUsers) cleanupJob(ctx context.Context, j *jobs.Job) error {
+	var in struct {
+		UserID string `json:"user_id"`
+	}
+	if err := j.Decode(&in); err != nil {
+		return jobs.Permanent(err)
+	}
+	oid, err := primitive.ObjectIDFromHex(in.UserID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	return m.done(m.cleanupUser(ctx, oid, m.relations()))
+}
+
+// cleanupUser applies the cascade and nullify relations of rels to user
+// oid. Run again after a failure, it picks up what is left.
+func (m *MongoUsers) cleanupUser(ctx context.Context, oid primitive.ObjectID, rels []Relation) error {
+	for _, rel := range rels {
+		coll := m.relationCollection(rel)
+		filter := bson.M{rel.Field: oid}
+		var err error
+		switch {
+		case rel.OnDelete == Cascade && rel.GridFS:
+			err = m.removeFiles(ctx, coll, filter)
+		case rel.OnDelete == Cascade:
+			_, err = coll.DeleteMany(ctx, filter, options.Delete().SetComment(db.Comment(ctx)))
+		case rel.OnDelete == Nullify:
+			_, err = coll.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{rel.Field: ""}}, options.Update().SetComment(db.Comment(ctx)))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel.Name, err)
+		}
+	}
+	return nil
+}
+
+// relationCollection returns the collection of rel, the files collection
+// of a GridFS bucket.
+func (m *MongoUsers) relationCollection(rel Relation) *mongo.Collection {
+	coll := m.mc.Collection(rel.Collection)
+	if rel.GridFS {
+		return coll.Database().Collection(coll.Name() + ".files")
+	}
+	return coll
+}
+
+// removeFiles removes the GridFS files matching filter from the files
+// collection files. Their chunks go first, so a cleanup run again after a
+// failure still finds the files.
+func (m *MongoUsers) removeFiles(ctx context.Context, files *mongo.Collection, filter bson.M) error {
+	cur, err := files.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return err
+	}
+	var docs []struct {
+		ID any `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	ids := make(bson.A, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	chunks := files.Database().Collection(strings.TrimSuffix(files.Name(), ".files") + ".chunks")
+	if _, err := chunks.DeleteMany(ctx, bson.M{"files_id": bson.M{"$in": ids}}, options.Delete().SetComment(db.Comment(ctx))); err != nil {
+		return err
+	}
+	_, err = files.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Delete().SetComment(db.Comment(ctx)))
+	return err
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"golang/db"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changesPoll is how long a getMore of Changes waits on the server for
+// events before the wait is checked again.
+const changesPoll = time.Second
+
+// Changes follows a change stream on the users collection, so it needs a
+// replica set; on a standalone server it fails with errors.ErrUnsupported.
+// Tokens are resume tokens, valid as long as the oplog reaches back to
+// them. Hard deletes are only seen by requests of a tenant when the
+// collection records pre-images (changeStreamPreAndPostImages), since the
+// deleted document no longer names its tenant.
+func (m *MongoUsers) Changes(ctx context.Context, token string, wait time.Duration, limit int) ([]UserEvent, string, error) {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetBatchSize(int32(limit)).
+		SetMaxAwaitTime(max(min(wait, changesPoll), time.Millisecond)).
+		SetComment(db.Comment(ctx))
+	if token != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || bson.Raw(raw).Validate() != nil {
+			return nil, "", ErrInvalidToken
+		}
+		opts.SetStartAfter(bson.Raw(raw))
+	}
+	var scope bson.M
+	if id := tenant.FromContext(ctx); id != "" {
+		opts.SetFullDocumentBeforeChange(options.WhenAvailable)
+		scope = bson.M{"$or": bson.A{
+			bson.M{"fullDocument.tenant_id": id},
+			bson.M{"fullDocument": nil, "fullDocumentBeforeChange.tenant_id": id},
+		}}
+	} else {
+		// Documents without a tenant have no tenant_id, which a delete's
+		// missing fullDocument matches as well
+		scope = bson.M{"fullDocument.tenant_id": nil}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		"$and":          bson.A{scope},
+	}}}}
+
+	cs, err := m.mc.Collection("users").Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, "", m.changesError(err)
+	}
+	defer cs.Close(context.WithoutCancel(ctx))
+
+	until := time.Now().Add(wait)
+	var out []UserEvent
+	for len(out) < limit {
+		if cs.TryNext(ctx) {
+			var e db.ChangeEvent
+			if err := cs.Decode(&e); err != nil {
+				return nil, "", err
+			}
+			out = append(out, userEvent(e))
+			continue
+		}
+		if err := cs.Err(); err != nil {
+			return nil, "", m.changesError(err)
+		}
+		// Once there are events, a poll that finds no more ends the wait
+		if len(out) > 0 || !time.Now().Before(until) {
+			break
+		}
+	}
+	return out, base64.RawURLEncoding.EncodeToString(cs.ResumeToken()), nil
+}
+
+func (m *MongoUsers) changesError(err error) error {
+	switch {
+	case db.NoChangeStreams(err):
+		return errors.ErrUnsupported
+	case db.TokenLost(err):
+		return ErrInvalidToken
+	}
+	return m.done(err)
+}
+
+// userEvent maps a change stream event of the users collection to a
+// UserEvent. Updates that soft-delete a user, and updates of users deleted
+// before the lookup, are deletes.
+func userEvent(e db.ChangeEvent) UserEvent {
+	ev := UserEvent{Op: UserUpdated, At: time.Unix(int64(e.ClusterTime.T), 0).UTC()}
+	if oid, ok := e.DocumentKey["_id"].(primitive.ObjectID); ok {
+		ev.ID = oid.Hex()
+	}
+	if e.FullDocument != nil {
+		u := userFromBSON(e.FullDocument)
+		ev.User = &u
+	}
+	switch {
+	case ev.User == nil || ev.User.DeletedAt != nil:
+		ev.Op, ev.User = UserDeleted, nil
+	case e.OperationType == "insert":
+		ev.Op = UserCreated
+	}
+	return ev
+}
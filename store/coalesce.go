@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+var coalescedReads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "user_reads_coalesced_total",
+	Help: "User reads that shared the query of an identical read in flight, by kind (get, list or count).",
+}, []string{"kind"})
+
+// CoalescedUsers lets identical concurrent reads of another UserRepository
+// share one query, so a burst of requests for the same user or list, such
+// as after a cache entry expires, reaches the backend once. Writes and the
+// optional interfaces pass through.
+//
+// The shared query outlives callers that give up waiting; it runs until
+// the deadline of the caller that started it.
+type CoalescedUsers struct {
+	next  UserRepository
+	group singleflight.Group
+}
+
+// NewCoalescedUsers wraps next.
+func NewCoalescedUsers(next UserRepository) *CoalescedUsers {
+	return &CoalescedUsers{next: next}
+}
+
+// do runs fn once for concurrent calls with the same kind and key within
+// the tenant of ctx, each caller waiting until its own ctx is done.
+func (c *CoalescedUsers) do(ctx context.Context, kind, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	led := false
+	ch := c.group.DoChan(kind+":"+tenant.FromContext(ctx)+":"+key, func() (any, error) {
+		led = true
+		run := context.WithoutCancel(ctx)
+		if d, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			run, cancel = context.WithDeadline(run, d)
+			defer cancel()
+		}
+		return fn(run)
+	})
+	select {
+	case res := <-ch:
+		if !led {
+			coalescedReads.WithLabelValues(kind).Inc()
+		}
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *CoalescedUsers) Get(ctx context.Context, id string) (*User, error) {
+	v, err := c.do(ctx, "get", id, func(ctx context.Context) (any, error) {
+		return c.next.Get(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Callers may change their user
+	u := *v.(*User)
+	return &u, nil
+}
+
+func (c *CoalescedUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	v, err := c.do(ctx, "list", filterKey(f), func(ctx context.Context) (any, error) {
+		return c.next.List(ctx, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]User{}, v.([]User)...), nil
+}
+
+// Count coalesces like List; paged lists count along.
+func (c *CoalescedUsers) Count(ctx context.Context, f UserFilter) (int64, error) {
+	n, ok := c.next.(UserCounter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	v, err := c.do(ctx, "count", filterKey(f), func(ctx context.Context) (any, error) {
+		return n.Count(ctx, f)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+func (c *CoalescedUsers) Create(ctx context.Context, u *User) error {
+	return c.next.Create(ctx, u)
+}
+
+func (c *CoalescedUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	return c.next.Update(ctx, id, fields)
+}
+
+func (c *CoalescedUsers) Delete(ctx context.Context, id string) error {
+	return c.next.Delete(ctx, id)
+}
+
+// CreateMany and UpdateMany pass through to the wrapped repository.
+func (c *CoalescedUsers) CreateMany(ctx context.Context, users []User) error {
+	b, ok := c.next.(BulkUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return b.CreateMany(ctx, users)
+}
+
+func (c *CoalescedUsers) UpdateMany(ctx context.Context, changes []UserChange) ([]error, error) {
+	b, ok := c.next.(BulkUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return b.UpdateMany(ctx, changes)
+}
+
+// ListDeleted, GetDeleted, Restore and Purge pass through to the wrapped
+// repository.
+func (c *CoalescedUsers) ListDeleted(ctx context.Context, f UserFilter) ([]User, error) {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return d.ListDeleted(ctx, f)
+}
+
+func (c *CoalescedUsers) GetDeleted(ctx context.Context, id string) (*User, error) {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return d.GetDeleted(ctx, id)
+}
+
+func (c *CoalescedUsers) Restore(ctx context.Context, id string) error {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return d.Restore(ctx, id)
+}
+
+func (c *CoalescedUsers) Purge(ctx context.Context, id string) error {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return d.Purge(ctx, id)
+}
+
+// Related and Erase pass through to the wrapped repository.
+func (c *CoalescedUsers) Related(ctx context.Context, id string) (map[string][]map[string]any, error) {
+	p, ok := c.next.(PersonalData)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return p.Related(ctx, id)
+}
+
+func (c *CoalescedUsers) Erase(ctx context.Context, id string) error {
+	p, ok := c.next.(PersonalData)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return p.Erase(ctx, id)
+}
+
+// Search, Suggest and EmailTaken pass through to the wrapped repository.
+func (c *CoalescedUsers) Search(ctx context.Context, q string, fields []string, limit int) ([]User, error) {
+	s, ok := c.next.(UserSearcher)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return s.Search(ctx, q, fields, limit)
+}
+
+func (c *CoalescedUsers) Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	s, ok := c.next.(UserSuggester)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return s.Suggest(ctx, prefix, fields, limit)
+}
+
+func (c *CoalescedUsers) EmailTaken(ctx context.Context, email string) (bool, error) {
+	e, ok := c.next.(EmailChecker)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	return e.EmailTaken(ctx, email)
+}
+
+// RecordLogin passes through to the wrapped repository.
+func (c *CoalescedUsers) RecordLogin(ctx context.Context, id string, at time.Time) error {
+	l, ok := c.next.(LoginRecorder)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return l.RecordLogin(ctx, id, at)
+}
+
+// Changes passes through to the wrapped repository; long polls are not
+// coalesced, since each waits from its own token.
+func (c *CoalescedUsers) Changes(ctx context.Context, token string, wait time.Duration, limit int) ([]UserEvent, string, error) {
+	cw, ok := c.next.(UserWatcher)
+	if !ok {
+		return nil, "", errors.ErrUnsupported
+	}
+	return cw.Changes(ctx, token, wait, limit)
+}
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUsers is a UserRepository kept in process memory, for local
+// development and tests. Data is lost on restart.
+type MemoryUsers struct {
+	mu    sync.RWMutex
+	users map[string]User
+	owner map[string]string // tenant of each user id
+	order []string          // ids in insertion order
+
+	// SoftDelete makes Delete mark users with deleted_at instead of
+	// removing them. See DeletedUsers.
+	SoftDelete bool
+}
+
+// NewMemoryUsers returns an empty in-memory UserRepository.
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{users: map[string]User{}, owner: map[string]string{}}
+}
+
+// owned returns the user with id if it belongs to the tenant in ctx.
+// Callers hold m.mu.
+func (m *MemoryUsers) owned(ctx context.Context, id string) (User, bool) {
+	u, ok := m.users[id]
+	return u, ok && m.owner[id] == tenant.FromContext(ctx)
+}
+
+// visible is owned, also hiding soft-deleted users when soft delete is on.
+// Every UserRepository method looks users up through it.
+func (m *MemoryUsers) visible(ctx context.Context, id string) (User, bool) {
+	u, ok := m.owned(ctx, id)
+	return u, ok && !(m.SoftDelete && u.DeletedAt != nil)
+}
+
+func (m *MemoryUsers) Create(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Same id format as Mongo so clients see no difference
+	u.ID = primitive.NewObjectID().Hex()
+	stored := *u
+	stored.Password = ""
+	// Mongo and SQL keep times in UTC, whatever offset they were given in
+	stored.CreatedAt = stored.CreatedAt.UTC()
+	m.users[u.ID] = stored
+	m.owner[u.ID] = tenant.FromContext(ctx)
+	m.order = append(m.order, u.ID)
+	return nil
+}
+
+// CreateMany creates users one at a time.
+func (m *MemoryUsers) CreateMany(ctx context.Context, users []User) error {
+	for i := range users {
+		if err := m.Create(ctx, &users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateMany applies changes one at a time.
+func (m *MemoryUsers) UpdateMany(ctx context.Context, changes []UserChange) ([]error, error) {
+	return UpdateEach(ctx, m, changes)
+}
+
+func (m *MemoryUsers) Get(ctx context.Context, id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.visible(ctx, id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (m *MemoryUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	return m.list(ctx, f, m.visible)
+}
+
+// Search scores every visible user.
+func (m *MemoryUsers) Search(ctx context.Context, q string, fields []string, limit int) ([]User, error) {
+	m.mu.RLock()
+	var candidates []User
+	for _, id := range m.order {
+		if u, ok := m.visible(ctx, id); ok {
+			candidates = append(candidates, u)
+		}
+	}
+	m.mu.RUnlock()
+	return rankUsers(q, candidates, fields, limit), nil
+}
+
+// Suggest checks every visible user.
+func (m *MemoryUsers) Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	prefix = strings.ToLower(prefix)
+	m.mu.RLock()
+	var matches []User
+	for _, id := range m.order {
+		if u, ok := m.visible(ctx, id); ok && suggests(u, prefix, fields) {
+			matches = append(matches, u)
+		}
+	}
+	m.mu.RUnlock()
+	return mergeSuggestions(limit, matches), nil
+}
+
+// list returns the users matching f among those lookup returns.
+func (m *MemoryUsers) list(ctx context.Context, f UserFilter, lookup func(context.Context, string) (User, bool)) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := []User{}
+	skipped := 0
+	for _, id := range m.order {
+		u, ok := lookup(ctx, id)
+		if !ok || !matchFilter(u, f) {
+			continue
+		}
+		if skipped < f.Offset {
+			skipped++
+			continue
+		}
+		out = append(out, u)
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Count counts the visible users matching f.
+func (m *MemoryUsers) Count(ctx context.Context, f UserFilter) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	for _, id := range m.order {
+		if u, ok := m.visible(ctx, id); ok && matchFilter(u, f) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// matchFilter reports whether u passes the conditions of f.
+func matchFilter(u User, f UserFilter) bool {
+	return (f.Name == "" || u.Name == f.Name) &&
+		(f.Email == "" || u.Email == f.Email) &&
+		(f.MinAge == nil || u.Age >= *f.MinAge) &&
+		(f.MaxAge == nil || u.Age <= *f.MaxAge) &&
+		(f.Where == nil || matchQuery(f.Where, u))
+}
+
+func (m *MemoryUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.visible(ctx, id)
+	if !ok {
+		return ErrNotFound
+	}
+	for k, v := range fields {
+		if err := setUserField(&u, k, v); err != nil {
+			return err
+		}
+	}
+	m.users[id] = u
+	return nil
+}
+
+func (m *MemoryUsers) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.visible(ctx, id)
+	if !ok {
+		return ErrNotFound
+	}
+	if m.SoftDelete {
+		now := time.Now().UTC()
+		u.DeletedAt = &now
+		m.users[id] = u
+		return nil
+	}
+	m.remove(id)
+	return nil
+}
+
+// ListDeleted lists the soft-deleted users of the tenant in ctx.
+func (m *MemoryUsers) ListDeleted(ctx context.Context, f UserFilter) ([]User, error) {
+	return m.list(ctx, f, func(ctx context.Context, id string) (User, bool) {
+		u, ok := m.owned(ctx, id)
+		return u, ok && u.DeletedAt != nil
+	})
+}
+
+// GetDeleted returns user id if it is soft-deleted.
+func (m *MemoryUsers) GetDeleted(ctx context.Context, id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.owned(ctx, id)
+	if !ok || u.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+// Restore undoes the soft delete of user id.
+func (m *MemoryUsers) Restore(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.owned(ctx, id)
+	if !ok || u.DeletedAt == nil {
+		return ErrNotFound
+	}
+	u.DeletedAt = nil
+	m.users[id] = u
+	return nil
+}
+
+// Purge removes user id for good, whether soft-deleted or not.
+func (m *MemoryUsers) Purge(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.owned(ctx, id); !ok {
+		return ErrNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+// remove deletes user id. Callers hold m.mu.
+func (m *MemoryUsers) remove(id string) {
+	delete(m.users, id)
+	delete(m.owner, id)
+	for i, oid := range m.order {
+		if oid == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// setUserField assigns a decoded JSON value to the named field of u; nil
+// resets it to its zero value.
+func setUserField(u *User, field string, v any) error {
+	var ok bool
+	if v == nil {
+		switch field {
+		case "name", "email", "password_hash":
+			v = ""
+		case "age":
+			v = 0
+		}
+	}
+	switch field {
+	case "name":
+		u.Name, ok = v.(string)
+	case "email":
+		u.Email, ok = v.(string)
+	case "password_hash":
+		u.PasswordHash, ok = v.(string)
+	case "age":
+		switch n := v.(type) {
+		case float64:
+			u.Age, ok = int(n), true
+		case int:
+			u.Age, ok = n, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidField, field)
+	}
+	return nil
+}
@@ -0,0 +1,701 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"golang/db"
+	"golang/jobs"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoUsers is the UserRepository backed by the "users" collection, or the
+// collection MONGODB_COLLECTIONS maps users to.
+// Failures that show Mongo is unavailable are reported to the client's
+// circuit breaker.
+type MongoUsers struct {
+	mc *db.MongoClient
+
+	// SoftDelete makes Delete mark users with deleted_at instead of
+	// removing them. See DeletedUsers.
+	SoftDelete bool
+	// SearchIndex is the Atlas Search index on name and email Search
+	// uses. When empty, or the server has no Atlas Search, Search scores
+	// candidates in process.
+	SearchIndex string
+	// AutocompleteIndex is the Atlas Search index with autocomplete
+	// mappings of name and email Suggest uses. When empty, or the server
+	// has no Atlas Search, Suggest matches prefixes through the indexes on
+	// tenant_id and name or email.
+	AutocompleteIndex string
+	// Relations are the collections referring to users and what becomes
+	// of their documents when a user is deleted; nil means
+	// DefaultRelations.
+	Relations []Relation
+
+	cleanup *jobs.Queue // see CleanupInBackground
+}
+
+// NewMongoUsers returns a UserRepository using mc.
+func NewMongoUsers(mc *db.MongoClient) *MongoUsers {
+	return &MongoUsers{mc: mc}
+}
+
+func init() {
+	db.RegisterIndexes(
+		db.IndexSpec{
+			Collection: "users",
+			Name:       "tenant_id_1_email_1",
+			Keys:       bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}},
+			Unique:     true,
+			// Emails are unique per tenant; users without an email don't
+			// collide with each other
+			Partial: bson.M{"email": bson.M{"$type": "string"}},
+		},
+		db.IndexSpec{
+			Collection: "users",
+			Name:       "created_at_-1",
+			Keys:       bson.D{{Key: "created_at", Value: -1}},
+		},
+		db.IndexSpec{
+			Collection: "users",
+			Name:       "tenant_id_1_name_1",
+			Keys:       bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}},
+		},
+		db.IndexSpec{
+			Collection: "users",
+			Name:       "name_text_email_text",
+			Keys:       bson.D{{Key: "name", Value: "text"}, {Key: "email", Value: "text"}},
+		},
+	)
+}
+
+// userDoc is the stored form of a User.
+type userDoc struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID     string             `bson:"tenant_id,omitempty"`
+	Name         string             `bson:"name,omitempty"`
+	Email        string             `bson:"email,omitempty"`
+	Age          int                `bson:"age,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty"`
+	PasswordHash string             `bson:"password_hash,omitempty"`
+}
+
+// scoped returns filter restricted to the tenant in ctx. Documents stored
+// without a tenant have no tenant_id field, which {tenant_id: null} matches.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	} else {
+		filter["tenant_id"] = nil
+	}
+	return filter
+}
+
+// live is scoped, also excluding soft-deleted users when soft delete is on.
+// Every UserRepository method filters through it.
+func (m *MongoUsers) live(ctx context.Context, filter bson.M) bson.M {
+	filter = scoped(ctx, filter)
+	if m.SoftDelete {
+		filter["deleted_at"] = nil
+	}
+	return filter
+}
+
+func (m *MongoUsers) done(err error) error {
+	if err != nil {
+		m.mc.Breaker.Record(err)
+	}
+	return err
+}
+
+func (m *MongoUsers) Create(ctx context.Context, u *User) error {
+	doc := userDoc{
+		TenantID:     tenant.FromContext(ctx),
+		Name:         u.Name,
+		Email:        u.Email,
+		Age:          u.Age,
+		CreatedAt:    u.CreatedAt,
+		PasswordHash: u.PasswordHash,
+	}
+	res, err := m.mc.Collection("users").InsertOne(ctx, doc, options.InsertOne().SetComment(db.Comment(ctx)))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateEmail
+	}
+	if err != nil {
+		return m.done(err)
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		u.ID = oid.Hex()
+	}
+	return nil
+}
+
+// CreateMany inserts users with one unordered InsertMany.
+func (m *MongoUsers) CreateMany(ctx context.Context, users []User) error {
+	docs := make([]any, len(users))
+	for i, u := range users {
+		users[i].ID = ""
+		docs[i] = userDoc{
+			ID:           primitive.NewObjectID(),
+			TenantID:     tenant.FromContext(ctx),
+			Name:         u.Name,
+			Email:        u.Email,
+			Age:          u.Age,
+			CreatedAt:    u.CreatedAt,
+			PasswordHash: u.PasswordHash,
+		}
+	}
+	res, err := m.mc.Collection("users").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false).SetComment(db.Comment(ctx)))
+	if res != nil {
+		// Only inserted documents are listed, so match them by _id
+		inserted := make(map[primitive.ObjectID]bool, len(res.InsertedIDs))
+		for _, id := range res.InsertedIDs {
+			if oid, ok := id.(primitive.ObjectID); ok {
+				inserted[oid] = true
+			}
+		}
+		for i, d := range docs {
+			if id := d.(userDoc).ID; inserted[id] {
+				users[i].ID = id.Hex()
+			}
+		}
+	}
+	return m.done(err)
+}
+
+// UpdateMany applies changes with one unordered BulkWrite. The users that
+// exist are looked up first, as its result only counts matches in total.
+func (m *MongoUsers) UpdateMany(ctx context.Context, changes []UserChange) ([]error, error) {
+	errs := make([]error, len(changes))
+	oids := make([]primitive.ObjectID, len(changes))
+	var want []primitive.ObjectID
+	for i, c := range changes {
+		oid, err := primitive.ObjectIDFromHex(c.ID)
+		if err != nil {
+			errs[i] = ErrInvalidID
+			continue
+		}
+		oids[i] = oid
+		want = append(want, oid)
+	}
+	if len(want) == 0 {
+		return errs, nil
+	}
+
+	coll := m.mc.Collection("users")
+	cur, err := coll.Find(ctx, m.live(ctx, bson.M{"_id": bson.M{"$in": want}}),
+		options.Find().SetProjection(bson.M{"_id": 1}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, m.done(err)
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, m.done(err)
+	}
+	exists := make(map[primitive.ObjectID]bool, len(found))
+	for _, f := range found {
+		exists[f.ID] = true
+	}
+
+	var models []mongo.WriteModel
+	var index []int // change of each model
+	for i, c := range changes {
+		if errs[i] != nil {
+			continue
+		}
+		if !exists[oids[i]] {
+			errs[i] = ErrNotFound
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(m.live(ctx, bson.M{"_id": oids[i]})).
+			SetUpdate(bson.M{"$set": c.Fields}))
+		index = append(index, i)
+	}
+	if len(models) == 0 {
+		return errs, nil
+	}
+	_, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false).SetComment(db.Comment(ctx)))
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+		// Documents the server refused, such as by validation
+		for _, we := range bwe.WriteErrors {
+			if we.HasErrorCode(11000) {
+				errs[index[we.Index]] = ErrDuplicateEmail
+				continue
+			}
+			errs[index[we.Index]] = fmt.Errorf("%w: %s", ErrInvalidField, we.Message)
+		}
+		return errs, nil
+	}
+	if err != nil {
+		return nil, m.done(err)
+	}
+	return errs, nil
+}
+
+func (m *MongoUsers) Get(ctx context.Context, id string) (*User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	var raw bson.M
+	err = m.mc.ReadCollection("users").FindOne(ctx, m.live(ctx, bson.M{"_id": oid}), options.FindOne().SetComment(db.Comment(ctx))).Decode(&raw)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, m.done(err)
+	}
+	u := userFromBSON(raw)
+	return &u, nil
+}
+
+func (m *MongoUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	return m.list(ctx, m.live(ctx, bson.M{}), f)
+}
+
+// EmailTaken looks email up in the unique index; soft-deleted users are
+// in it too.
+func (m *MongoUsers) EmailTaken(ctx context.Context, email string) (bool, error) {
+	n, err := m.mc.Collection("users").CountDocuments(ctx, scoped(ctx, bson.M{"email": email}),
+		options.Count().SetLimit(1).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return false, m.done(err)
+	}
+	return n > 0, nil
+}
+
+// Count counts the live users matching f.
+func (m *MongoUsers) Count(ctx context.Context, f UserFilter) (int64, error) {
+	n, err := m.mc.ReadCollection("users").CountDocuments(ctx, listFilter(m.live(ctx, bson.M{}), f),
+		options.Count().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return 0, m.done(err)
+	}
+	return n, nil
+}
+
+// list finds the users matching f within filter.
+func (m *MongoUsers) list(ctx context.Context, filter bson.M, f UserFilter) ([]User, error) {
+	filter = listFilter(filter, f)
+	opts := options.Find().SetComment(db.Comment(ctx)).SetSort(bson.D{{Key: "_id", Value: 1}})
+	if f.Offset > 0 {
+		opts.SetSkip(int64(f.Offset))
+	}
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+
+	cur, err := m.mc.ReadCollection("users").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, m.done(err)
+	}
+	defer cur.Close(ctx)
+
+	out := []User{}
+	for cur.Next(ctx) {
+		var raw bson.M
+		if err := cur.Decode(&raw); err != nil {
+			return nil, err
+		}
+		out = append(out, userFromBSON(raw))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, m.done(err)
+	}
+	return out, nil
+}
+
+// listFilter adds the conditions of f to filter.
+func listFilter(filter bson.M, f UserFilter) bson.M {
+	if f.Name != "" {
+		filter["name"] = f.Name
+	}
+	if f.Email != "" {
+		filter["email"] = f.Email
+	}
+	if f.MinAge != nil || f.MaxAge != nil {
+		age := bson.M{}
+		if f.MinAge != nil {
+			age["$gte"] = *f.MinAge
+		}
+		if f.MaxAge != nil {
+			age["$lte"] = *f.MaxAge
+		}
+		filter["age"] = age
+	}
+	if f.Where != nil {
+		filter["$and"] = bson.A{mongoQuery(f.Where)}
+	}
+	return filter
+}
+
+func (m *MongoUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	res, err := m.mc.Collection("users").UpdateOne(ctx, m.live(ctx, bson.M{"_id": oid}), updateDocument(fields), options.Update().SetComment(db.Comment(ctx)))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateEmail
+	}
+	if err != nil {
+		return m.done(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// updateDocument returns the update operators setting fields: $set, and
+// $unset for nil values.
+func updateDocument(fields map[string]any) bson.M {
+	set, unset := bson.M{}, bson.M{}
+	for k, v := range fields {
+		if v == nil {
+			unset[k] = ""
+		} else {
+			set[k] = v
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// Delete removes the user in one transaction with what refers to it,
+// following Relations: such documents are removed or their reference
+// unset, and ErrRestricted is returned instead while documents of a
+// Restrict relation refer to the user. With soft delete the user is marked
+// deleted instead, and only what refers to it is handled.
+func (m *MongoUsers) Delete(ctx context.Context, id string) error {
+	return m.remove(ctx, id, m.live, m.SoftDelete)
+}
+
+func (m *MongoUsers) remove(ctx context.Context, id string, scope func(context.Context, bson.M) bson.M, soft bool) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	err = m.mc.WithTransaction(ctx, func(ctx context.Context) error {
+		// Checked first, as deployments without transactions can't roll
+		// the removal back
+		if err := m.restrict(ctx, oid, m.relations()); err != nil {
+			return err
+		}
+		users := m.mc.Collection("users")
+		var n int64
+		if soft {
+			res, err := users.UpdateOne(ctx, scope(ctx, bson.M{"_id": oid}),
+				bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}}, options.Update().SetComment(db.Comment(ctx)))
+			if err != nil {
+				return err
+			}
+			n = res.MatchedCount
+		} else {
+			res, err := users.DeleteOne(ctx, scope(ctx, bson.M{"_id": oid}), options.Delete().SetComment(db.Comment(ctx)))
+			if err != nil {
+				return err
+			}
+			n = res.DeletedCount
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return m.cascade(ctx, oid)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	if errors.Is(err, ErrRestricted) {
+		return err
+	}
+	return m.done(err)
+}
+
+// ListDeleted lists the soft-deleted users of the tenant in ctx.
+func (m *MongoUsers) ListDeleted(ctx context.Context, f UserFilter) ([]User, error) {
+	return m.list(ctx, scoped(ctx, bson.M{"deleted_at": bson.M{"$ne": nil}}), f)
+}
+
+// GetDeleted returns user id if it is soft-deleted.
+func (m *MongoUsers) GetDeleted(ctx context.Context, id string) (*User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	var raw bson.M
+	err = m.mc.ReadCollection("users").FindOne(ctx, scoped(ctx, bson.M{"_id": oid, "deleted_at": bson.M{"$ne": nil}}),
+		options.FindOne().SetComment(db.Comment(ctx))).Decode(&raw)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, m.done(err)
+	}
+	u := userFromBSON(raw)
+	return &u, nil
+}
+
+// Restore undoes the soft delete of user id.
+func (m *MongoUsers) Restore(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	res, err := m.mc.Collection("users").UpdateOne(ctx,
+		scoped(ctx, bson.M{"_id": oid, "deleted_at": bson.M{"$ne": nil}}),
+		bson.M{"$unset": bson.M{"deleted_at": ""}}, options.Update().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return m.done(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Purge removes user id for good, whether soft-deleted or not.
+func (m *MongoUsers) Purge(ctx context.Context, id string) error {
+	return m.remove(ctx, id, scoped, false)
+}
+
+// PurgeDeleted removes the users of every tenant soft-deleted before
+// cutoff, with everything they own, and returns how many it removed.
+func (m *MongoUsers) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	cur, err := m.mc.Collection("users").Find(ctx, bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": cutoff}},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return 0, m.done(err)
+	}
+	defer cur.Close(ctx)
+	// Any tenant, as long as the user is still deleted
+	deleted := func(_ context.Context, filter bson.M) bson.M {
+		filter["deleted_at"] = bson.M{"$ne": nil}
+		return filter
+	}
+	n := 0
+	for cur.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+		switch err := m.remove(ctx, doc.ID.Hex(), deleted, false); {
+		case err == nil:
+			n++
+		case err == ErrNotFound: // restored or purged meanwhile
+		case errors.Is(err, ErrRestricted):
+			slog.WarnContext(ctx, "soft-deleted user not purged", "user_id", doc.ID.Hex(), "error", err)
+		default:
+			return n, err
+		}
+	}
+	return n, m.done(cur.Err())
+}
+
+// Search finds users by name and email, tolerating typos.
+func (m *MongoUsers) Search(ctx context.Context, q string, fields []string, limit int) ([]User, error) {
+	var paths []string
+	for _, f := range fields {
+		if suggestFields[f] {
+			paths = append(paths, f)
+		}
+	}
+	if len(paths) == 0 {
+		return []User{}, nil
+	}
+	if m.SearchIndex != "" {
+		users, err := m.atlasSearch(ctx, q, paths, limit)
+		if !db.SearchUnsupported(err) {
+			return users, m.done(err)
+		}
+		slog.WarnContext(ctx, "Atlas Search unavailable, using the fallback search", "error", err)
+	}
+
+	grams := searchGrams(q)
+	if len(grams) == 0 {
+		return []User{}, nil
+	}
+	pattern := searchPattern(grams)
+	var match bson.A
+	for _, f := range paths {
+		match = append(match, bson.M{f: bson.M{"$regex": pattern, "$options": "i"}})
+	}
+	filter := m.live(ctx, bson.M{"$or": match})
+	cur, err := m.mc.ReadCollection("users").Find(ctx, filter,
+		options.Find().SetLimit(searchCandidates).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, m.done(err)
+	}
+	var raws []bson.M
+	if err := cur.All(ctx, &raws); err != nil {
+		return nil, m.done(err)
+	}
+	candidates := make([]User, len(raws))
+	for i, raw := range raws {
+		candidates[i] = userFromBSON(raw)
+	}
+	return rankUsers(q, candidates, fields, limit), nil
+}
+
+// Suggest matches prefixes of each field in turn, so each query walks an
+// index in order and stops at limit. Ignoring case keeps the index from
+// bounding the scan, but not from sparing the documents.
+func (m *MongoUsers) Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	if m.AutocompleteIndex != "" {
+		users, err := m.autocomplete(ctx, prefix, fields, limit)
+		if !db.SearchUnsupported(err) {
+			return users, m.done(err)
+		}
+		slog.WarnContext(ctx, "Atlas Search unavailable, using the fallback suggestions", "error", err)
+	}
+
+	var lists [][]User
+	for _, f := range fields {
+		if !suggestFields[f] {
+			continue
+		}
+		cond := bson.M{"$regex": "^" + regexp.QuoteMeta(prefix), "$options": "i"}
+		if f == "email" {
+			// Within the partial unique index on emails
+			cond["$type"] = "string"
+		}
+		cur, err := m.mc.ReadCollection("users").Find(ctx, m.live(ctx, bson.M{f: cond}),
+			options.Find().SetSort(bson.D{{Key: f, Value: 1}}).SetLimit(int64(limit)).SetComment(db.Comment(ctx)))
+		if err != nil {
+			return nil, m.done(err)
+		}
+		var raws []bson.M
+		if err := cur.All(ctx, &raws); err != nil {
+			return nil, m.done(err)
+		}
+		list := make([]User, len(raws))
+		for i, raw := range raws {
+			list[i] = userFromBSON(raw)
+		}
+		lists = append(lists, list)
+	}
+	return mergeSuggestions(limit, lists...), nil
+}
+
+// autocomplete runs an autocomplete query of AutocompleteIndex.
+func (m *MongoUsers) autocomplete(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	var should bson.A
+	for _, f := range fields {
+		if suggestFields[f] {
+			should = append(should, bson.M{"autocomplete": bson.M{"query": prefix, "path": f}})
+		}
+	}
+	if len(should) == 0 {
+		return []User{}, nil
+	}
+	p := db.NewPipeline().
+		Search(m.AutocompleteIndex, bson.M{"compound": bson.M{"should": should, "minimumShouldMatch": 1}}).
+		Match(m.live(ctx, bson.M{})).
+		Limit(int64(limit))
+	var raws []bson.M
+	if err := m.mc.Aggregate(ctx, "users", p, &raws, options.Aggregate().SetComment(db.Comment(ctx))); err != nil {
+		return nil, err
+	}
+	out := make([]User, len(raws))
+	for i, raw := range raws {
+		out[i] = userFromBSON(raw)
+	}
+	return mergeSuggestions(limit, out), nil
+}
+
+// atlasSearch runs a fuzzy text query of SearchIndex over paths.
+func (m *MongoUsers) atlasSearch(ctx context.Context, q string, paths []string, limit int) ([]User, error) {
+	p := db.NewPipeline().
+		Search(m.SearchIndex, bson.M{"text": bson.M{
+			"query": q,
+			"path":  paths,
+			"fuzzy": bson.M{"maxEdits": 2},
+		}}).
+		Match(m.live(ctx, bson.M{}))
+	if limit > 0 {
+		p.Limit(int64(limit))
+	}
+	var raws []bson.M
+	if err := m.mc.Aggregate(ctx, "users", p, &raws, options.Aggregate().SetComment(db.Comment(ctx))); err != nil {
+		return nil, err
+	}
+	out := make([]User, len(raws))
+	for i, raw := range raws {
+		out[i] = userFromBSON(raw)
+	}
+	return out, nil
+}
+
+// RecordLogin sets last_login_at, which NewUserArchiver goes by.
+func (m *MongoUsers) RecordLogin(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	res, err := m.mc.Collection("users").UpdateOne(ctx, m.live(ctx, bson.M{"_id": oid}),
+		bson.M{"$set": bson.M{"last_login_at": at}}, options.Update().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return m.done(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// NewUserArchiver returns the job moving users inactive for longer than
+// window into "users_archive". Users are active when they logged in (see
+// last_login_at) or, if they never did, were created within the window.
+func NewUserArchiver(mc *db.MongoClient, window time.Duration, batchSize int) *db.ArchiveJob {
+	return mc.NewArchiveJob("users", "users_archive", batchSize, func(now time.Time) (bson.M, time.Time) {
+		cutoff := now.Add(-window)
+		return bson.M{"$or": bson.A{
+			bson.M{"last_login_at": bson.M{"$lt": cutoff}},
+			bson.M{"last_login_at": bson.M{"$exists": false}, "created_at": bson.M{"$lt": cutoff}},
+		}}, cutoff
+	})
+}
+
+// userFromBSON maps a stored document to a User. It is lenient about
+// types, since older documents were written with ints of various widths and
+// created_at as a string or {"$date": ...}; see db.Int and db.Time.
+func userFromBSON(raw bson.M) User {
+	var u User
+	if idv, ok := raw["_id"].(primitive.ObjectID); ok {
+		u.ID = idv.Hex()
+	}
+	u.Name, _ = raw["name"].(string)
+	u.Email, _ = raw["email"].(string)
+	u.PasswordHash, _ = raw["password_hash"].(string)
+	if t, ok := db.Time(raw["deleted_at"]); ok {
+		u.DeletedAt = &t
+	}
+	if v, ok := raw["email_verified"].(bool); ok {
+		u.EmailVerified = &v
+	}
+	u.Age, _ = db.Int(raw["age"])
+	u.CreatedAt, _ = db.Time(raw["created_at"])
+	return u
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// roundTrip stores doc as BSON and reads it back as the driver returns it.
+func roundTrip(t *testing.T, doc any) bson.M {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestUserBSONRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	created := time.Date(2023, 1, 2, 3, 4, 5, 123456789, time.FixedZone("CEST", 2*60*60))
+	raw := roundTrip(t, userDoc{ID: id, Name: "Ada", Email: "ada@example.com", Age: 36, CreatedAt: created, PasswordHash: "hash"})
+
+	u := userFromBSON(raw)
+	want := User{
+		ID:           id.Hex(),
+		Name:         "Ada",
+		Email:        "ada@example.com",
+		Age:          36,
+		CreatedAt:    time.Date(2023, 1, 2, 1, 4, 5, 123000000, time.UTC), // BSON dates keep milliseconds
+		PasswordHash: "hash",
+	}
+	if u != want {
+		t.Errorf("userFromBSON = %+v, want %+v", u, want)
+	}
+	if u.CreatedAt.Location() != time.UTC {
+		t.Errorf("created_at is in %s, want UTC", u.CreatedAt.Location())
+	}
+}
+
+// TestUserFromLegacyBSON reads documents as older versions wrote them.
+func TestUserFromLegacyBSON(t *testing.T) {
+	want := time.Date(2023, 1, 2, 1, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		createdAt any
+		age       any
+	}{
+		{"date", primitive.NewDateTimeFromTime(want), int32(36)},
+		{"RFC 3339 string", "2023-01-02T03:04:05+02:00", int64(36)},
+		{"extended JSON", bson.M{"$date": "2023-01-02T01:04:05Z"}, 36.0},
+		{"ordered extended JSON", bson.D{{Key: "$date", Value: "2023-01-02T01:04:05Z"}}, 36.7},
+		{"nested date", bson.M{"$date": primitive.NewDateTimeFromTime(want)}, int32(36)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := roundTrip(t, bson.M{"name": "Ada", "created_at": tt.createdAt, "age": tt.age})
+			u := userFromBSON(raw)
+			if !u.CreatedAt.Equal(want) || u.CreatedAt.Location() != time.UTC {
+				t.Errorf("created_at = %v, want %v", u.CreatedAt, want)
+			}
+			if u.Age != 36 {
+				t.Errorf("age = %d, want 36", u.Age)
+			}
+		})
+	}
+
+	u := userFromBSON(roundTrip(t, bson.M{"name": "Ada", "created_at": "yesterday"}))
+	if !u.CreatedAt.IsZero() {
+		t.Errorf("created_at of an unreadable date = %v, want zero", u.CreatedAt)
+	}
+}
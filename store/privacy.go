@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Related returns the records of the collection:field relations that
+// refer to user id. Sessions and files are left to their own endpoints.
+func (m *MongoUsers) Related(ctx context.Context, id string) (map[string][]map[string]any, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	out := map[string][]map[string]any{}
+	for _, rel := range m.relations() {
+		if rel.GridFS || rel.Name == "sessions" {
+			continue
+		}
+		cur, err := m.mc.ReadCollection(rel.Collection).Find(ctx, bson.M{rel.Field: oid}, options.Find().SetComment(db.Comment(ctx)))
+		if err != nil {
+			return nil, m.done(err)
+		}
+		var docs []bson.M
+		if err := cur.All(ctx, &docs); err != nil {
+			return nil, m.done(err)
+		}
+		records := make([]map[string]any, len(docs))
+		for i, d := range docs {
+			records[i] = db.ToJSON(d).(map[string]any)
+		}
+		out[rel.Name] = records
+	}
+	return out, nil
+}
+
+// Erase removes user id in one transaction with what refers to it. Unlike
+// Delete it ignores soft delete and background cleanup, and always
+// removes the user's sessions and files; Restrict relations still refuse.
+func (m *MongoUsers) Erase(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	rels := append([]Relation(nil), m.relations()...)
+	for i, rel := range rels {
+		if rel.GridFS || rel.Name == "sessions" {
+			rels[i].OnDelete = Cascade
+		}
+	}
+
+	err = m.mc.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := m.restrict(ctx, oid, rels); err != nil {
+			return err
+		}
+		res, err := m.mc.Collection("users").DeleteOne(ctx, scoped(ctx, bson.M{"_id": oid}), options.Delete().SetComment(db.Comment(ctx)))
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			// Records referring to id may belong to another tenant
+			return ErrNotFound
+		}
+		if err := m.cleanupUser(ctx, oid, rels); err != nil {
+			return err
+		}
+		_, err = m.mc.Collection("email_verifications").DeleteMany(ctx, bson.M{"user_id": oid}, options.Delete().SetComment(db.Comment(ctx)))
+		return err
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrRestricted) {
+		return err
+	}
+	return m.done(err)
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"golang/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongoOps maps comparison operators to Mongo query operators.
+var mongoOps = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+// mongoQuery translates a filter expression to a Mongo query.
+func mongoQuery(n query.Node) bson.M {
+	switch n := n.(type) {
+	case query.Logic:
+		subs := make(bson.A, len(n.Nodes))
+		for i, s := range n.Nodes {
+			subs[i] = mongoQuery(s)
+		}
+		if n.Op == "not" {
+			return bson.M{"$nor": subs}
+		}
+		return bson.M{"$" + n.Op: subs}
+	case query.Cond:
+		if n.Op == "~" {
+			return bson.M{n.Field: bson.M{"$regex": regexp.QuoteMeta(n.Value.(string)), "$options": "i"}}
+		}
+		return bson.M{n.Field: bson.M{mongoOps[n.Op]: n.Value}}
+	}
+	return bson.M{}
+}
+
+// sqlQuery translates a filter expression to a WHERE clause with ?
+// placeholders. Field names are safe to inline, since the parser only
+// accepts UserFields.
+func sqlQuery(n query.Node) (string, []any) {
+	switch n := n.(type) {
+	case query.Logic:
+		parts := make([]string, len(n.Nodes))
+		var args []any
+		for i, s := range n.Nodes {
+			var a []any
+			parts[i], a = sqlQuery(s)
+			args = append(args, a...)
+		}
+		if n.Op == "not" {
+			return "NOT (" + parts[0] + ")", args
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(n.Op)+" ") + ")", args
+	case query.Cond:
+		if n.Op == "~" {
+			like := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(n.Value.(string)))
+			return "LOWER(" + n.Field + `) LIKE ? ESCAPE '\'`, []any{"%" + like + "%"}
+		}
+		v := n.Value
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC()
+		}
+		op := n.Op
+		if op == "!=" {
+			op = "<>"
+		}
+		return n.Field + " " + op + " ?", []any{v}
+	}
+	return "1 = 1", nil
+}
+
+// matchQuery evaluates a filter expression against u.
+func matchQuery(n query.Node, u User) bool {
+	switch n := n.(type) {
+	case query.Logic:
+		switch n.Op {
+		case "not":
+			return !matchQuery(n.Nodes[0], u)
+		case "and":
+			for _, s := range n.Nodes {
+				if !matchQuery(s, u) {
+					return false
+				}
+			}
+			return true
+		default:
+			for _, s := range n.Nodes {
+				if matchQuery(s, u) {
+					return true
+				}
+			}
+			return false
+		}
+	case query.Cond:
+		var c int
+		switch n.Field {
+		case "name", "email":
+			s := u.Name
+			if n.Field == "email" {
+				s = u.Email
+			}
+			if n.Op == "~" {
+				return strings.Contains(strings.ToLower(s), strings.ToLower(n.Value.(string)))
+			}
+			c = strings.Compare(s, n.Value.(string))
+		case "age":
+			c = compareInt(int64(u.Age), n.Value.(int64))
+		case "created_at":
+			c = u.CreatedAt.Compare(n.Value.(time.Time))
+		default:
+			return false
+		}
+		switch n.Op {
+		case "=":
+			return c == 0
+		case "!=":
+			return c != 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		}
+	}
+	return false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,359 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"golang/db"
+	"golang/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "user_cache_requests_total",
+	Help: "User cache lookups by kind (get or list) and result (hit, miss or error).",
+}, []string{"kind", "result"})
+
+// CacheOptions configures CachedUsers.
+type CacheOptions struct {
+	TTL     time.Duration // lifetime of cached users; defaults to 5m
+	ListTTL time.Duration // lifetime of cached list results; defaults to 30s
+}
+
+// CachedUsers is a read-through Redis cache in front of another
+// UserRepository. Writes invalidate the cached user and all cached lists.
+// Redis failures are logged and fall through to the wrapped repository.
+type CachedUsers struct {
+	next UserRepository
+	rdb  *redis.Client
+	opts CacheOptions
+}
+
+// NewCachedUsers wraps next with a cache in rdb.
+func NewCachedUsers(next UserRepository, rdb *redis.Client, opts CacheOptions) *CachedUsers {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+	if opts.ListTTL <= 0 {
+		opts.ListTTL = 30 * time.Second
+	}
+	return &CachedUsers{next: next, rdb: rdb, opts: opts}
+}
+
+const (
+	cacheUserPrefix = "users:v1:id:"
+	cacheListPrefix = "users:v1:list:"
+	// cacheListGen is bumped on every write. List keys include it, so a
+	// write makes all earlier list results unreachable; they expire by TTL.
+	cacheListGen = "users:v1:listgen"
+)
+
+// cachedUser is the cached form of a User. Unlike the API representation it
+// keeps the password hash, which login needs.
+type cachedUser struct {
+	User
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Tenant scopes cached single users, which are keyed by id alone.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func toCached(ctx context.Context, u User) cachedUser {
+	return cachedUser{User: u, PasswordHash: u.PasswordHash, Tenant: tenant.FromContext(ctx)}
+}
+
+func (c cachedUser) user() User {
+	u := c.User
+	u.PasswordHash = c.PasswordHash
+	return u
+}
+
+func (c *CachedUsers) Create(ctx context.Context, u *User) error {
+	if err := c.next.Create(ctx, u); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "")
+	return nil
+}
+
+func (c *CachedUsers) Get(ctx context.Context, id string) (*User, error) {
+	key := cacheUserPrefix + id
+	var cu cachedUser
+	if c.load(ctx, "get", key, &cu) && cu.Tenant == tenant.FromContext(ctx) {
+		u := cu.user()
+		return &u, nil
+	}
+
+	u, err := c.next.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.store(ctx, key, toCached(ctx, *u), c.opts.TTL)
+	return u, nil
+}
+
+func (c *CachedUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	gen, err := c.rdb.Get(ctx, cacheListGen).Int64()
+	if err != nil && err != redis.Nil {
+		cacheRequests.WithLabelValues("list", "error").Inc()
+		slog.WarnContext(ctx, "user cache unavailable", "error", err)
+		return c.next.List(ctx, f)
+	}
+	key := cacheListPrefix + strconv.FormatInt(gen, 10) + ":" + tenant.FromContext(ctx) + ":" + filterKey(f)
+
+	var cached []cachedUser
+	if c.load(ctx, "list", key, &cached) {
+		out := make([]User, len(cached))
+		for i, cu := range cached {
+			out[i] = cu.user()
+		}
+		return out, nil
+	}
+
+	out, err := c.next.List(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	cached = make([]cachedUser, len(out))
+	for i, u := range out {
+		cached[i] = toCached(ctx, u)
+	}
+	c.store(ctx, key, cached, c.opts.ListTTL)
+	return out, nil
+}
+
+func (c *CachedUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	err := c.next.Update(ctx, id, fields)
+	if err == nil {
+		c.invalidate(ctx, id)
+	}
+	return err
+}
+
+func (c *CachedUsers) Delete(ctx context.Context, id string) error {
+	err := c.next.Delete(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, id)
+	}
+	return err
+}
+
+// CreateMany passes through to the wrapped repository.
+func (c *CachedUsers) CreateMany(ctx context.Context, users []User) error {
+	b, ok := c.next.(BulkUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := b.CreateMany(ctx, users)
+	c.invalidate(ctx, "")
+	return err
+}
+
+// UpdateMany passes through to the wrapped repository.
+func (c *CachedUsers) UpdateMany(ctx context.Context, changes []UserChange) ([]error, error) {
+	b, ok := c.next.(BulkUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	errs, err := b.UpdateMany(ctx, changes)
+	for i, ch := range changes {
+		if err != nil || errs[i] == nil {
+			c.invalidate(ctx, ch.ID)
+		}
+	}
+	return errs, err
+}
+
+// ListDeleted, GetDeleted, Restore and Purge pass through to the wrapped repository;
+// deleted users are never cached.
+func (c *CachedUsers) ListDeleted(ctx context.Context, f UserFilter) ([]User, error) {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return d.ListDeleted(ctx, f)
+}
+
+func (c *CachedUsers) GetDeleted(ctx context.Context, id string) (*User, error) {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return d.GetDeleted(ctx, id)
+}
+
+func (c *CachedUsers) Restore(ctx context.Context, id string) error {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := d.Restore(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, id)
+	}
+	return err
+}
+
+func (c *CachedUsers) Purge(ctx context.Context, id string) error {
+	d, ok := c.next.(DeletedUsers)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := d.Purge(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, id)
+	}
+	return err
+}
+
+// Related and Erase pass through to the wrapped repository.
+func (c *CachedUsers) Related(ctx context.Context, id string) (map[string][]map[string]any, error) {
+	p, ok := c.next.(PersonalData)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return p.Related(ctx, id)
+}
+
+func (c *CachedUsers) Erase(ctx context.Context, id string) error {
+	p, ok := c.next.(PersonalData)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := p.Erase(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, id)
+	}
+	return err
+}
+
+// Search passes through to the wrapped repository; results are not cached.
+func (c *CachedUsers) Search(ctx context.Context, q string, fields []string, limit int) ([]User, error) {
+	s, ok := c.next.(UserSearcher)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return s.Search(ctx, q, fields, limit)
+}
+
+// Suggest passes through to the wrapped repository; results are not
+// cached.
+func (c *CachedUsers) Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	s, ok := c.next.(UserSuggester)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return s.Suggest(ctx, prefix, fields, limit)
+}
+
+// Count passes through to the wrapped repository; counts are not cached.
+func (c *CachedUsers) Count(ctx context.Context, f UserFilter) (int64, error) {
+	n, ok := c.next.(UserCounter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return n.Count(ctx, f)
+}
+
+// EmailTaken passes through to the wrapped repository, which must see
+// writes the cache hasn't.
+func (c *CachedUsers) EmailTaken(ctx context.Context, email string) (bool, error) {
+	e, ok := c.next.(EmailChecker)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	return e.EmailTaken(ctx, email)
+}
+
+// RecordLogin passes through to the wrapped repository; users are cached
+// without their last login.
+func (c *CachedUsers) RecordLogin(ctx context.Context, id string, at time.Time) error {
+	l, ok := c.next.(LoginRecorder)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return l.RecordLogin(ctx, id, at)
+}
+
+// Changes passes through to the wrapped repository; changes are never
+// cached.
+func (c *CachedUsers) Changes(ctx context.Context, token string, wait time.Duration, limit int) ([]UserEvent, string, error) {
+	cw, ok := c.next.(UserWatcher)
+	if !ok {
+		return nil, "", errors.ErrUnsupported
+	}
+	return cw.Changes(ctx, token, wait, limit)
+}
+
+// load reads key into v, reporting whether it was a cache hit.
+func (c *CachedUsers) load(ctx context.Context, kind, key string, v any) bool {
+	b, err := c.rdb.Get(ctx, key).Bytes()
+	switch {
+	case err == redis.Nil:
+		cacheRequests.WithLabelValues(kind, "miss").Inc()
+		return false
+	case err != nil:
+		cacheRequests.WithLabelValues(kind, "error").Inc()
+		slog.WarnContext(ctx, "user cache unavailable", "error", err)
+		return false
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		cacheRequests.WithLabelValues(kind, "error").Inc()
+		return false
+	}
+	cacheRequests.WithLabelValues(kind, "hit").Inc()
+	return true
+}
+
+func (c *CachedUsers) store(ctx context.Context, key string, v any, ttl time.Duration) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := c.rdb.Set(ctx, key, b, ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "user cache write failed", "error", err)
+	}
+}
+
+// invalidate drops the cached user id, if any, and all cached lists.
+func (c *CachedUsers) invalidate(ctx context.Context, id string) {
+	// The write happened, so don't let a cancelled request skip this
+	ctx = context.WithoutCancel(ctx)
+	pipe := c.rdb.TxPipeline()
+	if id != "" {
+		pipe.Del(ctx, cacheUserPrefix+id)
+	}
+	pipe.Incr(ctx, cacheListGen)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Stale entries now live until their TTL runs out
+		slog.ErrorContext(ctx, "user cache invalidation failed", "id", id, "error", err)
+	}
+}
+
+// OnChange is a db.ChangeHandler for the users collection. It invalidates
+// entries for writes made outside this process, such as by other services
+// or directly in the database.
+func (c *CachedUsers) OnChange(ctx context.Context, e db.ChangeEvent) error {
+	id := ""
+	if oid, ok := e.DocumentKey["_id"].(primitive.ObjectID); ok {
+		id = oid.Hex()
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+// filterKey returns a short stable key for f.
+func filterKey(f UserFilter) string {
+	b, _ := json.Marshal(f)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
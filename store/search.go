@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// UserSearcher is implemented by the user repositories for typo tolerant
+// search, such as finding "John Doe" with "jon doe".
+type UserSearcher interface {
+	// Search returns up to limit users whose name or email, among fields,
+	// resemble q, best matches first.
+	Search(ctx context.Context, q string, fields []string, limit int) ([]User, error)
+}
+
+// Without a search engine, records sharing a letter pair with the query are
+// scored in process by similarity.
+const (
+	// searchCandidates caps the records one search scores.
+	searchCandidates = 1000
+	// minSearchScore drops weaker matches.
+	minSearchScore = 0.4
+	// maxSearchGrams caps the letter pairs a query selects candidates by.
+	maxSearchGrams = 16
+)
+
+// searchGrams returns the distinct letter pairs within the words of q,
+// lowercased, which select the candidates of a search. Queries of single
+// letters have none and find nothing.
+func searchGrams(q string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, word := range strings.Fields(strings.ToLower(q)) {
+		r := []rune(word)
+		for i := 0; i+1 < len(r) && len(out) < maxSearchGrams; i++ {
+			if g := string(r[i : i+2]); !seen[g] {
+				seen[g] = true
+				out = append(out, g)
+			}
+		}
+	}
+	return out
+}
+
+// searchPattern is a regular expression matching any of grams.
+func searchPattern(grams []string) string {
+	quoted := make([]string, len(grams))
+	for i, g := range grams {
+		quoted[i] = regexp.QuoteMeta(g)
+	}
+	return strings.Join(quoted, "|")
+}
+
+// bigrams returns the letter pairs of s lowercased and padded with spaces,
+// so word starts and ends count.
+func bigrams(s string) map[string]bool {
+	r := []rune(" " + strings.Join(strings.Fields(strings.ToLower(s)), " ") + " ")
+	out := make(map[string]bool, len(r))
+	for i := 0; i+1 < len(r); i++ {
+		out[string(r[i:i+2])] = true
+	}
+	return out
+}
+
+// similarity is the Dice coefficient of the letter pairs of a and b, from 0
+// for nothing in common to 1 for the same letters.
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for g := range a {
+		if b[g] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// rankUsers returns up to limit of users whose fields resemble q, best
+// first.
+func rankUsers(q string, users []User, fields []string, limit int) []User {
+	qg := bigrams(q)
+	type scored struct {
+		u     User
+		score float64
+	}
+	var matches []scored
+	for _, u := range users {
+		var score float64
+		for _, f := range fields {
+			switch f {
+			case "name":
+				score = max(score, similarity(qg, bigrams(u.Name)))
+			case "email":
+				local, _, _ := strings.Cut(u.Email, "@")
+				score = max(score, similarity(qg, bigrams(u.Email)), similarity(qg, bigrams(local)))
+			}
+		}
+		if score >= minSearchScore {
+			matches = append(matches, scored{u, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	out := []User{}
+	for _, m := range matches {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, m.u)
+	}
+	return out
+}
@@ -0,0 +1,439 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang/tenant"
+
+	_ "github.com/lib/pq"           // registers "postgres"
+	_ "github.com/mattn/go-sqlite3" // registers "sqlite3"; needs cgo
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SQLUsers is the UserRepository backed by a "users" table in PostgreSQL
+// or SQLite. Rows of users created without a tenant have an empty tenant_id.
+type SQLUsers struct {
+	db       *sql.DB
+	postgres bool
+
+	// SoftDelete makes Delete mark users with deleted_at instead of
+	// removing them. See DeletedUsers.
+	SoftDelete bool
+}
+
+// sqlDrivers maps the STORAGE names to database/sql driver names.
+var sqlDrivers = map[string]string{
+	"postgres": "postgres",
+	"sqlite":   "sqlite3",
+}
+
+// sqlSchema lists the statements creating the tables, run in order on
+// every start. Columns added after the first release come with their own
+// ALTER TABLE, whose "already exists" failure is ignored.
+var sqlSchema = map[string][]string{
+	"postgres": {
+		`CREATE TABLE IF NOT EXISTS users (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL DEFAULT '',
+	email         TEXT NOT NULL DEFAULT '',
+	age           INTEGER NOT NULL DEFAULT 0,
+	created_at    TIMESTAMPTZ NOT NULL,
+	password_hash TEXT NOT NULL DEFAULT ''
+)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS users_tenant_id ON users (tenant_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+)`,
+	},
+	"sqlite": {
+		`CREATE TABLE IF NOT EXISTS users (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL DEFAULT '',
+	email         TEXT NOT NULL DEFAULT '',
+	age           INTEGER NOT NULL DEFAULT 0,
+	created_at    TIMESTAMP NOT NULL,
+	password_hash TEXT NOT NULL DEFAULT ''
+)`,
+		`ALTER TABLE users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS users_tenant_id ON users (tenant_id)`,
+		`ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+)`,
+	},
+}
+
+// sqlColumns are the columns Update may set.
+var sqlColumns = map[string]bool{
+	"name":          true,
+	"email":         true,
+	"age":           true,
+	"password_hash": true,
+}
+
+// OpenSQLUsers connects to the database, kind being "postgres" or "sqlite",
+// and creates the users and tenants tables if they do not exist yet.
+func OpenSQLUsers(ctx context.Context, kind, dsn string) (*SQLUsers, error) {
+	driver, ok := sqlDrivers[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL backend %q", kind)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", kind, err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %v", kind, err)
+	}
+	for _, stmt := range sqlSchema[kind] {
+		if _, err := db.ExecContext(ctx, stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema: %v", err)
+		}
+	}
+	return &SQLUsers{db: db, postgres: kind == "postgres"}, nil
+}
+
+// Close closes the database.
+func (s *SQLUsers) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites ? placeholders to $n for PostgreSQL.
+func (s *SQLUsers) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+const userColumns = "id, name, email, age, created_at, password_hash, deleted_at"
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	var deleted sql.NullTime
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Age, &u.CreatedAt, &u.PasswordHash, &deleted)
+	u.CreatedAt = u.CreatedAt.UTC()
+	if deleted.Valid {
+		t := deleted.Time.UTC()
+		u.DeletedAt = &t
+	}
+	return u, err
+}
+
+// live is the condition restricting queries to the tenant in ctx, the
+// one argument, and with soft delete to users not deleted. Every
+// UserRepository method filters through it.
+func (s *SQLUsers) live() string {
+	if s.SoftDelete {
+		return "tenant_id = ? AND deleted_at IS NULL"
+	}
+	return "tenant_id = ?"
+}
+
+func (s *SQLUsers) Create(ctx context.Context, u *User) error {
+	// Same id format as Mongo so clients see no difference
+	id := primitive.NewObjectID().Hex()
+	_, err := s.db.ExecContext(ctx, s.rebind("INSERT INTO users (id, name, email, age, created_at, password_hash, tenant_id) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		id, u.Name, u.Email, u.Age, u.CreatedAt.UTC(), u.PasswordHash, tenant.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+	u.ID = id
+	return nil
+}
+
+// CreateMany inserts users in one transaction.
+func (s *SQLUsers) CreateMany(ctx context.Context, users []User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.rebind("INSERT INTO users (id, name, email, age, created_at, password_hash, tenant_id) VALUES (?, ?, ?, ?, ?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = primitive.NewObjectID().Hex()
+		if _, err := stmt.ExecContext(ctx, ids[i], u.Name, u.Email, u.Age, u.CreatedAt.UTC(), u.PasswordHash, tenant.FromContext(ctx)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i := range users {
+		users[i].ID = ids[i]
+	}
+	return nil
+}
+
+// UpdateMany applies changes one at a time; each is its own statement.
+func (s *SQLUsers) UpdateMany(ctx context.Context, changes []UserChange) ([]error, error) {
+	return UpdateEach(ctx, s, changes)
+}
+
+func (s *SQLUsers) Get(ctx context.Context, id string) (*User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, s.rebind("SELECT "+userColumns+" FROM users WHERE id = ? AND "+s.live()), id, tenant.FromContext(ctx)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *SQLUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	return s.list(ctx, s.live(), f)
+}
+
+// Search scores the users sharing a letter pair with q.
+func (s *SQLUsers) Search(ctx context.Context, q string, fields []string, limit int) ([]User, error) {
+	grams := searchGrams(q)
+	var match []string
+	args := []any{tenant.FromContext(ctx)}
+	for _, g := range grams {
+		like := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(g) + "%"
+		for _, f := range fields {
+			if suggestFields[f] {
+				match = append(match, "LOWER("+f+`) LIKE ? ESCAPE '\'`)
+				args = append(args, like)
+			}
+		}
+	}
+	if len(match) == 0 {
+		return []User{}, nil
+	}
+	query := "SELECT " + userColumns + " FROM users WHERE " + s.live() + " AND (" + strings.Join(match, " OR ") + ") LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append(args, searchCandidates)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankUsers(q, candidates, fields, limit), nil
+}
+
+// Suggest matches the lowercased fields with LIKE.
+func (s *SQLUsers) Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error) {
+	like := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(prefix)) + "%"
+	var match []string
+	args := []any{tenant.FromContext(ctx)}
+	for _, f := range fields {
+		if suggestFields[f] {
+			match = append(match, "LOWER("+f+`) LIKE ? ESCAPE '\'`)
+			args = append(args, like)
+		}
+	}
+	if len(match) == 0 {
+		return []User{}, nil
+	}
+	query := "SELECT " + userColumns + " FROM users WHERE " + s.live() + " AND (" + strings.Join(match, " OR ") + ") ORDER BY LOWER(name), LOWER(email) LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// Count counts the live users matching f.
+func (s *SQLUsers) Count(ctx context.Context, f UserFilter) (int64, error) {
+	where, args := listWhere(ctx, s.live(), f)
+	var n int64
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM users WHERE "+where), args...).Scan(&n)
+	return n, err
+}
+
+// list finds the users matching f and scope, a condition taking the tenant
+// id as its one argument.
+func (s *SQLUsers) list(ctx context.Context, scope string, f UserFilter) ([]User, error) {
+	where, args := listWhere(ctx, scope, f)
+	query := "SELECT " + userColumns + " FROM users WHERE " + where + " ORDER BY id"
+	switch {
+	case f.Limit > 0:
+		query, args = query+" LIMIT ?", append(args, f.Limit)
+	case f.Offset > 0 && !s.postgres:
+		query += " LIMIT -1" // SQLite only accepts OFFSET after LIMIT
+	}
+	if f.Offset > 0 {
+		query, args = query+" OFFSET ?", append(args, f.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// listWhere returns the condition selecting the users matching f and
+// scope, and its arguments.
+func listWhere(ctx context.Context, scope string, f UserFilter) (string, []any) {
+	where := []string{scope}
+	args := []any{tenant.FromContext(ctx)}
+	if f.Name != "" {
+		where, args = append(where, "name = ?"), append(args, f.Name)
+	}
+	if f.Email != "" {
+		where, args = append(where, "email = ?"), append(args, f.Email)
+	}
+	if f.MinAge != nil {
+		where, args = append(where, "age >= ?"), append(args, *f.MinAge)
+	}
+	if f.MaxAge != nil {
+		where, args = append(where, "age <= ?"), append(args, *f.MaxAge)
+	}
+	if f.Where != nil {
+		w, a := sqlQuery(f.Where)
+		where, args = append(where, w), append(args, a...)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+func (s *SQLUsers) Update(ctx context.Context, id string, fields map[string]any) error {
+	// Validate and convert values through the typed User fields
+	var u User
+	cols := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if !sqlColumns[k] {
+			return fmt.Errorf("%w: %s", ErrInvalidField, k)
+		}
+		if err := setUserField(&u, k, v); err != nil {
+			return err
+		}
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+
+	set := make([]string, len(cols))
+	args := make([]any, 0, len(cols)+1)
+	for i, c := range cols {
+		set[i] = c + " = ?"
+		switch c {
+		case "name":
+			args = append(args, u.Name)
+		case "email":
+			args = append(args, u.Email)
+		case "age":
+			args = append(args, u.Age)
+		case "password_hash":
+			args = append(args, u.PasswordHash)
+		}
+	}
+	args = append(args, id, tenant.FromContext(ctx))
+
+	res, err := s.db.ExecContext(ctx, s.rebind("UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = ? AND "+s.live()), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLUsers) Delete(ctx context.Context, id string) error {
+	if s.SoftDelete {
+		return s.exec(ctx, "UPDATE users SET deleted_at = ? WHERE id = ? AND "+s.live(), time.Now().UTC(), id, tenant.FromContext(ctx))
+	}
+	return s.exec(ctx, "DELETE FROM users WHERE id = ? AND "+s.live(), id, tenant.FromContext(ctx))
+}
+
+// ListDeleted lists the soft-deleted users of the tenant in ctx.
+func (s *SQLUsers) ListDeleted(ctx context.Context, f UserFilter) ([]User, error) {
+	return s.list(ctx, "tenant_id = ? AND deleted_at IS NOT NULL", f)
+}
+
+// GetDeleted returns user id if it is soft-deleted.
+func (s *SQLUsers) GetDeleted(ctx context.Context, id string) (*User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, s.rebind("SELECT "+userColumns+" FROM users WHERE id = ? AND tenant_id = ? AND deleted_at IS NOT NULL"), id, tenant.FromContext(ctx)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Restore undoes the soft delete of user id.
+func (s *SQLUsers) Restore(ctx context.Context, id string) error {
+	return s.exec(ctx, "UPDATE users SET deleted_at = NULL WHERE id = ? AND tenant_id = ? AND deleted_at IS NOT NULL", id, tenant.FromContext(ctx))
+}
+
+// Purge removes user id for good, whether soft-deleted or not.
+func (s *SQLUsers) Purge(ctx context.Context, id string) error {
+	return s.exec(ctx, "DELETE FROM users WHERE id = ? AND tenant_id = ?", id, tenant.FromContext(ctx))
+}
+
+// exec runs a statement on one user, returning ErrNotFound if it matched
+// no row.
+func (s *SQLUsers) exec(ctx context.Context, query string, args ...any) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package store defines the storage interfaces used by the API handlers and
+// their implementations.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang/query"
+)
+
+var (
+	// ErrNotFound is returned when no record matches.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidID is returned for ids the backend cannot parse.
+	ErrInvalidID = errors.New("invalid id")
+	// ErrInvalidField is returned for update values the backend cannot
+	// store in the named field.
+	ErrInvalidField = errors.New("invalid field value")
+	// ErrDuplicateEmail is returned when another user of the tenant has
+	// the email, where the backend enforces unique emails.
+	ErrDuplicateEmail = errors.New("email already in use")
+	// ErrInvalidToken is returned for change tokens the backend cannot
+	// continue from.
+	ErrInvalidToken = errors.New("invalid change token")
+)
+
+// User is a user record. The visible tags name the least role that sees
+// a field in API responses when field masking is on: viewer, user or
+// admin; untagged fields are visible to all. The validate tags are the
+// rules of the fields written through the API (see package validation).
+type User struct {
+	ID        string    `json:"id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Email     string    `json:"email,omitempty" visible:"user" validate:"email"`
+	Age       int       `json:"age,omitempty" validate:"min:0"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// DeletedAt is set on soft-deleted users, which only DeletedUsers
+	// returns.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" visible:"admin"`
+	// EmailVerified is false until the user confirms the address when
+	// email verification is on. It is nil for users created without it,
+	// and only MongoDB storage keeps it.
+	EmailVerified *bool `json:"email_verified,omitempty" visible:"admin"`
+
+	// Password is accepted on input only; just the bcrypt hash is stored.
+	// bcrypt hashes at most 72 bytes.
+	Password     string `json:"password,omitempty" validate:"maxbytes:72"`
+	PasswordHash string `json:"-"`
+}
+
+// UserFields are the fields filter expressions on users may use.
+var UserFields = map[string]query.Kind{
+	"name":       query.String,
+	"email":      query.String,
+	"age":        query.Int,
+	"created_at": query.Time,
+}
+
+// UserFilter selects users for List. Zero fields don't filter.
+type UserFilter struct {
+	Name   string
+	Email  string
+	MinAge *int
+	MaxAge *int
+	// Where is a parsed filter expression over UserFields.
+	Where query.Node
+
+	Offset int
+	Limit  int // 0 means no limit
+}
+
+// UserRepository stores users. Every implementation scopes its operations
+// to the tenant in the context (see the tenant package): records of other
+// tenants are invisible, and a context without a tenant only sees records
+// stored without one.
+type UserRepository interface {
+	// Create stores u and sets u.ID.
+	Create(ctx context.Context, u *User) error
+	Get(ctx context.Context, id string) (*User, error)
+	List(ctx context.Context, f UserFilter) ([]User, error)
+	// Update sets the given top-level fields, which must already be
+	// validated against the writable fields. A nil value removes the
+	// field, leaving its zero value.
+	Update(ctx context.Context, id string, fields map[string]any) error
+	Delete(ctx context.Context, id string) error
+}
+
+// DeletedUsers is implemented by the user repositories. When soft delete
+// is on, Delete only marks users deleted and every UserRepository method
+// ignores them; these methods bypass that and are meant for admin and
+// restore paths only.
+type DeletedUsers interface {
+	ListDeleted(ctx context.Context, f UserFilter) ([]User, error)
+	// GetDeleted returns a soft-deleted user.
+	GetDeleted(ctx context.Context, id string) (*User, error)
+	// Restore undoes a soft delete.
+	Restore(ctx context.Context, id string) error
+	// Purge removes a user for good, deleted or not.
+	Purge(ctx context.Context, id string) error
+}
+
+// UserCounter is implemented by the user repositories for paginated
+// lists.
+type UserCounter interface {
+	// Count returns how many users f matches, ignoring its Offset and
+	// Limit.
+	Count(ctx context.Context, f UserFilter) (int64, error)
+}
+
+// BulkUsers is implemented by the user repositories for bulk loads such
+// as generated test data, and bulk edits.
+type BulkUsers interface {
+	// CreateMany stores users in as few round trips as the backend allows
+	// and sets their IDs. On error some users may have been stored.
+	CreateMany(ctx context.Context, users []User) error
+	// UpdateMany applies changes independently of each other in as few
+	// round trips as the backend allows. It returns the outcome of each,
+	// nil or ErrNotFound, ErrInvalidID or ErrInvalidField as Update would,
+	// and an error when the batch as a whole failed, after which some
+	// changes may have been applied.
+	UpdateMany(ctx context.Context, changes []UserChange) ([]error, error)
+}
+
+// EmailChecker is implemented by the user repositories that enforce
+// unique emails, for checking an email before a write.
+type EmailChecker interface {
+	// EmailTaken reports whether a user of the tenant in ctx has email,
+	// including soft-deleted users, which keep theirs.
+	EmailTaken(ctx context.Context, email string) (bool, error)
+}
+
+// PersonalData is implemented by the user repositories that keep records
+// about users beyond the users themselves, for data subject requests.
+type PersonalData interface {
+	// Related returns the records of the collection:field relations that
+	// refer to user id, deleted or not, by relation name.
+	Related(ctx context.Context, id string) (map[string][]map[string]any, error)
+	// Erase removes user id for good, deleted or not, with its sessions,
+	// files and pending email verifications, and handles the records of
+	// other relations as Delete does.
+	Erase(ctx context.Context, id string) error
+}
+
+// LoginRecorder is implemented by the user repositories that keep when
+// users last logged in, which archiving stale users goes by.
+type LoginRecorder interface {
+	// RecordLogin sets the last login of user id, of the tenant in ctx,
+	// to at.
+	RecordLogin(ctx context.Context, id string, at time.Time) error
+}
+
+// UserWatcher is implemented by the user repositories that can follow
+// the writes to users, for long polling.
+type UserWatcher interface {
+	// Changes returns up to limit writes to the users of the tenant in ctx
+	// made after the position token, or from now for "", waiting up to
+	// wait for the first, and the token of the position after them. It
+	// fails with ErrInvalidToken for tokens it did not return or that are
+	// too old to continue from.
+	Changes(ctx context.Context, token string, wait time.Duration, limit int) ([]UserEvent, string, error)
+}
+
+// Operations of user events.
+const (
+	UserCreated = "created"
+	UserUpdated = "updated"
+	UserDeleted = "deleted"
+)
+
+// UserEvent is a write to a user. User is the user after it, nil for
+// deletes, soft or not, and when the user was deleted since.
+type UserEvent struct {
+	Op   string    `json:"op"`
+	ID   string    `json:"id"`
+	User *User     `json:"user"`
+	At   time.Time `json:"at"`
+}
+
+// UserChange is an update of UpdateMany: the top-level fields to set on
+// user ID, validated like those of Update.
+type UserChange struct {
+	ID     string
+	Fields map[string]any
+}
+
+// UpdateEach applies changes one at a time through users.Update, for
+// repositories without a bulk path. It returns like UpdateMany.
+func UpdateEach(ctx context.Context, users UserRepository, changes []UserChange) ([]error, error) {
+	errs := make([]error, len(changes))
+	for i, c := range changes {
+		err := users.Update(ctx, c.ID, c.Fields)
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrInvalidID) && !errors.Is(err, ErrInvalidField) {
+			return nil, err
+		}
+		errs[i] = err
+	}
+	return errs, nil
+}
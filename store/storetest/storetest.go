@@ -0,0 +1,225 @@
+// Package storetest provides fakes of the store interfaces, so handler
+// behavior such as status codes, validation and error mapping can be
+// exercised without a database:
+//
+//	users := storetest.NewUsers()
+//	users.Fail("Get", errors.New("boom")) // GET /users/{id} now fails with 500
+//	h := api.NewRouter(nil, api.Options{Users: users, Tenants: storetest.NewTenants()})
+//
+// The fakes keep data in memory like the memory backend, record every call
+// and return injected errors instead of running a method.
+package storetest
+
+import (
+	"context"
+	"sync"
+
+	"golang/store"
+)
+
+// Call is a recorded method call.
+type Call struct {
+	Method string
+	ID     string // the id argument, if the method takes one
+}
+
+// recorder records calls and holds injected errors.
+type recorder struct {
+	mu    sync.Mutex
+	calls []Call
+	fail  map[string]error
+}
+
+// Fail makes method return err until Fail is called again for it with a
+// nil error.
+func (r *recorder) Fail(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail == nil {
+		r.fail = map[string]error{}
+	}
+	if err == nil {
+		delete(r.fail, method)
+		return
+	}
+	r.fail[method] = err
+}
+
+// Calls returns the calls made so far, oldest first.
+func (r *recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Reset forgets the recorded calls and injected errors.
+func (r *recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls, r.fail = nil, nil
+}
+
+// record notes a call and returns the error injected for method, if any.
+func (r *recorder) record(method, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, ID: id})
+	return r.fail[method]
+}
+
+// Users is a fake store.UserRepository, store.DeletedUsers,
+// store.BulkUsers, store.UserSearcher and store.UserCounter.
+type Users struct {
+	recorder
+	// Store holds the data; set SoftDelete on it to fake soft delete.
+	Store *store.MemoryUsers
+}
+
+var (
+	_ store.UserRepository = (*Users)(nil)
+	_ store.DeletedUsers   = (*Users)(nil)
+	_ store.BulkUsers      = (*Users)(nil)
+	_ store.UserSearcher   = (*Users)(nil)
+	_ store.UserCounter    = (*Users)(nil)
+)
+
+// NewUsers returns an empty fake user repository.
+func NewUsers() *Users {
+	return &Users{Store: store.NewMemoryUsers()}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	if err := u.record("Create", ""); err != nil {
+		return err
+	}
+	return u.Store.Create(ctx, user)
+}
+
+func (u *Users) CreateMany(ctx context.Context, users []store.User) error {
+	if err := u.record("CreateMany", ""); err != nil {
+		return err
+	}
+	return u.Store.CreateMany(ctx, users)
+}
+
+func (u *Users) UpdateMany(ctx context.Context, changes []store.UserChange) ([]error, error) {
+	if err := u.record("UpdateMany", ""); err != nil {
+		return nil, err
+	}
+	return u.Store.UpdateMany(ctx, changes)
+}
+
+func (u *Users) Get(ctx context.Context, id string) (*store.User, error) {
+	if err := u.record("Get", id); err != nil {
+		return nil, err
+	}
+	return u.Store.Get(ctx, id)
+}
+
+func (u *Users) List(ctx context.Context, f store.UserFilter) ([]store.User, error) {
+	if err := u.record("List", ""); err != nil {
+		return nil, err
+	}
+	return u.Store.List(ctx, f)
+}
+
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	if err := u.record("Update", id); err != nil {
+		return err
+	}
+	return u.Store.Update(ctx, id, fields)
+}
+
+func (u *Users) Delete(ctx context.Context, id string) error {
+	if err := u.record("Delete", id); err != nil {
+		return err
+	}
+	return u.Store.Delete(ctx, id)
+}
+
+func (u *Users) ListDeleted(ctx context.Context, f store.UserFilter) ([]store.User, error) {
+	if err := u.record("ListDeleted", ""); err != nil {
+		return nil, err
+	}
+	return u.Store.ListDeleted(ctx, f)
+}
+
+func (u *Users) GetDeleted(ctx context.Context, id string) (*store.User, error) {
+	if err := u.record("GetDeleted", id); err != nil {
+		return nil, err
+	}
+	return u.Store.GetDeleted(ctx, id)
+}
+
+func (u *Users) Restore(ctx context.Context, id string) error {
+	if err := u.record("Restore", id); err != nil {
+		return err
+	}
+	return u.Store.Restore(ctx, id)
+}
+
+func (u *Users) Purge(ctx context.Context, id string) error {
+	if err := u.record("Purge", id); err != nil {
+		return err
+	}
+	return u.Store.Purge(ctx, id)
+}
+
+func (u *Users) Search(ctx context.Context, q string, fields []string, limit int) ([]store.User, error) {
+	if err := u.record("Search", ""); err != nil {
+		return nil, err
+	}
+	return u.Store.Search(ctx, q, fields, limit)
+}
+
+func (u *Users) Count(ctx context.Context, f store.UserFilter) (int64, error) {
+	if err := u.record("Count", ""); err != nil {
+		return 0, err
+	}
+	return u.Store.Count(ctx, f)
+}
+
+// Tenants is a fake store.TenantRepository.
+type Tenants struct {
+	recorder
+	Store *store.MemoryTenants
+}
+
+var _ store.TenantRepository = (*Tenants)(nil)
+
+// NewTenants returns a fake tenant repository holding tenants.
+func NewTenants(tenants ...store.Tenant) *Tenants {
+	t := &Tenants{Store: store.NewMemoryTenants()}
+	for i := range tenants {
+		_ = t.Store.Create(context.Background(), &tenants[i])
+	}
+	return t
+}
+
+func (t *Tenants) Create(ctx context.Context, tn *store.Tenant) error {
+	if err := t.record("Create", tn.ID); err != nil {
+		return err
+	}
+	return t.Store.Create(ctx, tn)
+}
+
+func (t *Tenants) Get(ctx context.Context, id string) (*store.Tenant, error) {
+	if err := t.record("Get", id); err != nil {
+		return nil, err
+	}
+	return t.Store.Get(ctx, id)
+}
+
+func (t *Tenants) List(ctx context.Context) ([]store.Tenant, error) {
+	if err := t.record("List", ""); err != nil {
+		return nil, err
+	}
+	return t.Store.List(ctx)
+}
+
+func (t *Tenants) Delete(ctx context.Context, id string) error {
+	if err := t.record("Delete", id); err != nil {
+		return err
+	}
+	return t.Store.Delete(ctx, id)
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// UserSuggester is implemented by the user repositories for typeahead.
+type UserSuggester interface {
+	// Suggest returns up to limit users whose name or email, among
+	// fields, starts with prefix ignoring case, ordered by name.
+	Suggest(ctx context.Context, prefix string, fields []string, limit int) ([]User, error)
+}
+
+// suggestFields are the fields Search and Suggest match.
+var suggestFields = map[string]bool{"name": true, "email": true}
+
+// suggests reports whether one of fields of u starts with prefix, which is
+// lowercase.
+func suggests(u User, prefix string, fields []string) bool {
+	for _, f := range fields {
+		var v string
+		switch f {
+		case "name":
+			v = u.Name
+		case "email":
+			v = u.Email
+		}
+		if strings.HasPrefix(strings.ToLower(v), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSuggestions returns up to limit of the users of lists, each once,
+// ordered by name and then email.
+func mergeSuggestions(limit int, lists ...[]User) []User {
+	seen := map[string]bool{}
+	out := []User{}
+	for _, list := range lists {
+		for _, u := range list {
+			if !seen[u.ID] {
+				seen[u.ID] = true
+				out = append(out, u)
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := strings.ToLower(out[i].Name), strings.ToLower(out[j].Name)
+		if a != b {
+			return a < b
+		}
+		return strings.ToLower(out[i].Email) < strings.ToLower(out[j].Email)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
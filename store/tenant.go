@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrExists is returned when creating a record whose id is taken.
+var ErrExists = errors.New("already exists")
+
+// Tenant is a provisioned tenant. User data is scoped to the tenant in the
+// request context; see the tenant package.
+type Tenant struct {
+	ID        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// TenantRepository stores tenants. Deleting a tenant does not delete the
+// data scoped to it.
+type TenantRepository interface {
+	Create(ctx context.Context, t *Tenant) error
+	Get(ctx context.Context, id string) (*Tenant, error)
+	List(ctx context.Context) ([]Tenant, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// MongoTenants is the TenantRepository backed by the "tenants" collection.
+type MongoTenants struct {
+	mc *db.MongoClient
+}
+
+// NewMongoTenants returns a TenantRepository using mc.
+func NewMongoTenants(mc *db.MongoClient) *MongoTenants {
+	return &MongoTenants{mc: mc}
+}
+
+func (m *MongoTenants) Create(ctx context.Context, t *Tenant) error {
+	_, err := m.mc.Collection("tenants").InsertOne(ctx, t, options.InsertOne().SetComment(db.Comment(ctx)))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func (m *MongoTenants) Get(ctx context.Context, id string) (*Tenant, error) {
+	var t Tenant
+	err := m.mc.Collection("tenants").FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetComment(db.Comment(ctx))).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (m *MongoTenants) List(ctx context.Context) ([]Tenant, error) {
+	cur, err := m.mc.Collection("tenants").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Tenant{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (m *MongoTenants) Delete(ctx context.Context, id string) error {
+	res, err := m.mc.Collection("tenants").DeleteOne(ctx, bson.M{"_id": id}, options.Delete().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MemoryTenants is a TenantRepository kept in process memory.
+type MemoryTenants struct {
+	mu      sync.RWMutex
+	tenants map[string]Tenant
+}
+
+// NewMemoryTenants returns an empty in-memory TenantRepository.
+func NewMemoryTenants() *MemoryTenants {
+	return &MemoryTenants{tenants: map[string]Tenant{}}
+}
+
+func (m *MemoryTenants) Create(_ context.Context, t *Tenant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[t.ID]; ok {
+		return ErrExists
+	}
+	m.tenants[t.ID] = *t
+	return nil
+}
+
+func (m *MemoryTenants) Get(_ context.Context, id string) (*Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tenants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (m *MemoryTenants) List(_ context.Context) ([]Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *MemoryTenants) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tenants, id)
+	return nil
+}
+
+// SQLTenants is the TenantRepository backed by the "tenants" table.
+type SQLTenants struct {
+	s *SQLUsers
+}
+
+// Tenants returns the TenantRepository sharing s's database.
+func (s *SQLUsers) Tenants() *SQLTenants {
+	return &SQLTenants{s: s}
+}
+
+func (t *SQLTenants) Create(ctx context.Context, in *Tenant) error {
+	_, err := t.s.db.ExecContext(ctx, t.s.rebind("INSERT INTO tenants (id, name, created_at) VALUES (?, ?, ?)"),
+		in.ID, in.Name, in.CreatedAt.UTC())
+	if err != nil && isUniqueViolation(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func (t *SQLTenants) Get(ctx context.Context, id string) (*Tenant, error) {
+	var out Tenant
+	err := t.s.db.QueryRowContext(ctx, t.s.rebind("SELECT id, name, created_at FROM tenants WHERE id = ?"), id).
+		Scan(&out.ID, &out.Name, &out.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	out.CreatedAt = out.CreatedAt.UTC()
+	return &out, nil
+}
+
+func (t *SQLTenants) List(ctx context.Context) ([]Tenant, error) {
+	rows, err := t.s.db.QueryContext(ctx, "SELECT id, name, created_at FROM tenants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Tenant{}
+	for rows.Next() {
+		var tn Tenant
+		if err := rows.Scan(&tn.ID, &tn.Name, &tn.CreatedAt); err != nil {
+			return nil, err
+		}
+		tn.CreatedAt = tn.CreatedAt.UTC()
+		out = append(out, tn)
+	}
+	return out, rows.Err()
+}
+
+func (t *SQLTenants) Delete(ctx context.Context, id string) error {
+	res, err := t.s.db.ExecContext(ctx, t.s.rebind("DELETE FROM tenants WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// isUniqueViolation reports a primary key or unique constraint failure in
+// either supported SQL database.
+func isUniqueViolation(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "duplicate key") || strings.Contains(msg, "UNIQUE constraint failed")
+}
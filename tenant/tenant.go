@@ -0,0 +1,35 @@
+// Package tenant carries the tenant a request is scoped to through contexts.
+package tenant
+
+import "context"
+
+type key struct{}
+
+// NewContext returns a copy of ctx scoped to tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the tenant id stored in ctx, or "" when the request
+// is not tenant scoped.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Valid reports whether id is a well-formed tenant id: 1 to 63 lowercase
+// letters, digits and hyphens, starting with a letter or digit, so it is
+// also usable as a DNS label.
+func Valid(id string) bool {
+	if id == "" || len(id) > 63 || id[0] == '-' {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
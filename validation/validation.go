@@ -0,0 +1,330 @@
+// Package validation checks the fields of records against rules declared
+// in `validate` struct tags, so every entry point that accepts a record
+// applies the same ones.
+//
+// A tag lists rules separated by commas or semicolons, each a name with
+// an optional parameter after a colon:
+//
+//	Email string `json:"email" validate:"required,email,domain:example.com|example.org"`
+//	Age   int    `json:"age" validate:"min:0"`
+//
+// Fields are named and checked in their JSON form, as decoded into maps
+// from a request body: a partial update checks just the fields it sets,
+// and a whole record the fields it doesn't omit. Rules pass values of
+// types they don't check, which are left to the store. Null clears a
+// field and fails only required, as does an empty string.
+//
+// The built-in rules are required, email, min:N, max:N, maxbytes:N,
+// maxlen:N (in characters), domain:A|B, for emails at one of the domains,
+// and majority:CC, for ages of at least the age of majority in country CC.
+// Register adds others.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Check checks the value of field.
+type Check func(field string, v any) error
+
+// Rule returns the check of a rule with param, the text after its colon,
+// or an error if param is invalid.
+type Rule func(param string) (Check, error)
+
+var rules = struct {
+	sync.Mutex
+	m map[string]Rule
+}{m: map[string]Rule{}}
+
+// Register adds the rule name, for the validators created after. It panics
+// if name is taken, as by a built-in rule.
+func Register(name string, rule Rule) {
+	rules.Lock()
+	defer rules.Unlock()
+	if _, ok := rules.m[name]; ok || name == "required" {
+		panic("validation: rule " + name + " registered twice")
+	}
+	rules.m[name] = rule
+}
+
+func lookup(name string) (Rule, bool) {
+	rules.Lock()
+	defer rules.Unlock()
+	r, ok := rules.m[name]
+	return r, ok
+}
+
+// FieldError is a field that failed a rule.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+// field holds the checks of a field.
+type field struct {
+	required bool
+	checks   []Check
+}
+
+// Validator checks the fields of a record type.
+type Validator struct {
+	names  []string // JSON names of the fields with rules, in order
+	fields map[string]*field
+}
+
+// New returns a validator for records like v, a struct, from the
+// `validate` tags of its fields. overrides replaces the rules of fields
+// by JSON name, e.g. email=email;domain:example.com, with the rules
+// separated as in tags; empty rules drop those of the tag.
+func New(v any, overrides map[string]string) (*Validator, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("validation: %s is not a struct", t)
+	}
+	tags := map[string]string{}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+		tags[name] = f.Tag.Get("validate")
+	}
+	for name, spec := range overrides {
+		if _, ok := tags[name]; !ok {
+			return nil, fmt.Errorf("validation: %s has no field %s", t, name)
+		}
+		tags[name] = spec
+	}
+
+	val := &Validator{fields: map[string]*field{}}
+	for _, name := range names {
+		f, err := parse(tags[name])
+		if err != nil {
+			return nil, fmt.Errorf("validation: field %s: %v", name, err)
+		}
+		if f != nil {
+			val.names = append(val.names, name)
+			val.fields[name] = f
+		}
+	}
+	return val, nil
+}
+
+// parse returns the field with the rules of spec, or nil if it has none.
+func parse(spec string) (*field, error) {
+	var f *field
+	for _, r := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' }) {
+		name, param, _ := strings.Cut(strings.TrimSpace(r), ":")
+		if f == nil {
+			f = &field{}
+		}
+		if name == "required" {
+			f.required = true
+			continue
+		}
+		rule, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %q; the rules are %s", name, strings.Join(Rules(), ", "))
+		}
+		check, err := rule(param)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", name, err)
+		}
+		f.checks = append(f.checks, check)
+	}
+	return f, nil
+}
+
+// Field checks the value of field, as decoded from JSON. Fields without
+// rules pass.
+func (val *Validator) Field(name string, v any) error {
+	f := val.fields[name]
+	if f == nil {
+		return nil
+	}
+	if v == nil || v == "" {
+		if f.required {
+			return fmt.Errorf("%s is required", name)
+		}
+		if v == nil {
+			return nil
+		}
+	}
+	for _, check := range f.checks {
+		if err := check(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Struct checks the fields of record, a struct or a pointer to one of the
+// type of the validator, in their JSON form. It returns the failed fields
+// in order, at most one error each.
+func (val *Validator) Struct(record any) []FieldError {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return []FieldError{{Err: err}}
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return []FieldError{{Err: err}}
+	}
+	var errs []FieldError
+	for _, name := range val.names {
+		v, ok := m[name]
+		if !ok && !val.fields[name].required {
+			continue
+		}
+		if err := val.Field(name, v); err != nil {
+			errs = append(errs, FieldError{Field: name, Err: err})
+		}
+	}
+	return errs
+}
+
+func init() {
+	Register("email", func(string) (Check, error) {
+		return func(field string, v any) error {
+			s, ok := v.(string)
+			if !ok || !validEmail(s) {
+				return errors.New("invalid email address")
+			}
+			return nil
+		}, nil
+	})
+	Register("domain", func(param string) (Check, error) {
+		domains := map[string]bool{}
+		for _, d := range strings.Split(param, "|") {
+			if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+				domains[d] = true
+			}
+		}
+		if len(domains) == 0 {
+			return nil, fmt.Errorf("want domains separated by |, e.g. domain:example.com|example.org")
+		}
+		list := strings.ReplaceAll(param, "|", ", ")
+		return func(field string, v any) error {
+			s, ok := v.(string)
+			if !ok {
+				return nil
+			}
+			_, domain, _ := strings.Cut(s, "@")
+			if !domains[strings.ToLower(domain)] {
+				return fmt.Errorf("%s must be an address at %s", field, list)
+			}
+			return nil
+		}, nil
+	})
+	Register("min", func(param string) (Check, error) {
+		min, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("want a number, e.g. min:0")
+		}
+		return func(field string, v any) error {
+			if n, ok := v.(float64); ok && n < min {
+				if min == 0 {
+					return fmt.Errorf("%s must not be negative", field)
+				}
+				return fmt.Errorf("%s must be at least %s", field, param)
+			}
+			return nil
+		}, nil
+	})
+	Register("max", func(param string) (Check, error) {
+		max, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("want a number, e.g. max:150")
+		}
+		return func(field string, v any) error {
+			if n, ok := v.(float64); ok && n > max {
+				return fmt.Errorf("%s must be at most %s", field, param)
+			}
+			return nil
+		}, nil
+	})
+	Register("maxbytes", func(param string) (Check, error) {
+		max, err := strconv.Atoi(param)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("want a length, e.g. maxbytes:72")
+		}
+		return func(field string, v any) error {
+			if s, ok := v.(string); ok && len(s) > max {
+				return fmt.Errorf("%s is longer than %d bytes", field, max)
+			}
+			return nil
+		}, nil
+	})
+	Register("maxlen", func(param string) (Check, error) {
+		max, err := strconv.Atoi(param)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("want a length, e.g. maxlen:100")
+		}
+		return func(field string, v any) error {
+			if s, ok := v.(string); ok && utf8.RuneCountInString(s) > max {
+				return fmt.Errorf("%s is longer than %d characters", field, max)
+			}
+			return nil
+		}, nil
+	})
+	Register("majority", func(param string) (Check, error) {
+		country := strings.ToUpper(param)
+		if len(country) != 2 {
+			return nil, fmt.Errorf("want an ISO 3166 country code, e.g. majority:KR")
+		}
+		age, ok := majority[country]
+		if !ok {
+			age = defaultMajority
+		}
+		return func(field string, v any) error {
+			if n, ok := v.(float64); ok && n < float64(age) {
+				return fmt.Errorf("%s must be at least %d", field, age)
+			}
+			return nil
+		}, nil
+	})
+}
+
+// defaultMajority is the age of majority in most countries; majority
+// holds the exceptions, by ISO 3166 code.
+const defaultMajority = 18
+
+var majority = map[string]int{
+	"KR": 19,
+	"NZ": 20,
+	"TH": 20,
+}
+
+// Rules returns the names of the registered rules, sorted.
+func Rules() []string {
+	rules.Lock()
+	defer rules.Unlock()
+	names := []string{"required"}
+	for name := range rules.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validEmail reports whether s is a bare address such as a@example.com,
+// without a display name or angle brackets.
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s
+}
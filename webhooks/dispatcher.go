@@ -0,0 +1,184 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang/db"
+	"golang/jobs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var deliveryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_delivery_attempts_total",
+	Help: "Webhook delivery attempts by event and result (success, retry or failed).",
+}, []string{"event", "result"})
+
+// deliverJob is the job type sending one delivery.
+const deliverJob = "webhook.deliver"
+
+// Options configures a Dispatcher. Zero fields take the defaults.
+type Options struct {
+	// Timeout bounds one delivery request; defaults to 10s.
+	Timeout time.Duration
+	// MaxAttempts is how often a delivery is tried before it fails;
+	// defaults to 8.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for every
+	// further one up to MaxBackoff; default 30s and 1h.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Dispatcher manages subscriptions and sends deliveries as jobs of the
+// queue.
+type Dispatcher struct {
+	mc     *db.MongoClient
+	queue  *jobs.Queue
+	opts   Options
+	client *http.Client
+}
+
+// New returns a Dispatcher storing its data through mc and registers its
+// job handler with q.
+func New(mc *db.MongoClient, q *jobs.Queue, opts Options) *Dispatcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	d := &Dispatcher{
+		mc:    mc,
+		queue: q,
+		opts:  opts,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// A redirect could send the signed body elsewhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+	q.Handle(deliverJob, d.deliver)
+	return d
+}
+
+// enqueue queues the job sending delivery id and records it on the
+// delivery.
+func (d *Dispatcher) enqueue(ctx context.Context, id primitive.ObjectID) error {
+	j, err := d.queue.Enqueue(ctx, deliverJob, map[string]string{"delivery_id": id.Hex()}, jobs.EnqueueOptions{
+		MaxAttempts: d.opts.MaxAttempts,
+		Backoff:     d.opts.Backoff,
+		MaxBackoff:  d.opts.MaxBackoff,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.mc.Collection("webhook_deliveries").UpdateByID(ctx, id, bson.M{"$set": bson.M{"job_id": j.ID}})
+	return err
+}
+
+// deliver is the job handler: it sends the delivery once and records the
+// outcome. Errors make the queue retry.
+func (d *Dispatcher) deliver(ctx context.Context, j *jobs.Job) error {
+	var in struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := j.Decode(&in); err != nil {
+		return jobs.Permanent(err)
+	}
+	id, err := primitive.ObjectIDFromHex(in.DeliveryID)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	var dl Delivery
+	err = d.mc.Collection("webhook_deliveries").FindOne(ctx, bson.M{"_id": id}).Decode(&dl)
+	if err == mongo.ErrNoDocuments {
+		return jobs.Permanent(errors.New("delivery expired from the log"))
+	}
+	if err != nil {
+		return err
+	}
+	if dl.State == StateSucceeded {
+		return nil
+	}
+
+	var sub Subscription
+	err = d.mc.Collection("webhooks").FindOne(ctx, bson.M{"_id": dl.SubscriptionID}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		d.record(ctx, &dl, bson.M{"state": StateFailed, "last_error": "subscription deleted"})
+		return jobs.Permanent(errors.New("subscription deleted"))
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := d.send(ctx, &sub, &dl)
+	set := bson.M{"attempts": dl.Attempts + 1, "last_status": status}
+	switch {
+	case err == nil:
+		set["state"] = StateSucceeded
+		set["delivered_at"] = time.Now().UTC()
+		set["last_error"] = ""
+		deliveryAttempts.WithLabelValues(dl.Event, "success").Inc()
+	case j.Attempts >= j.MaxAttempts:
+		set["state"] = StateFailed
+		set["last_error"] = err.Error()
+		deliveryAttempts.WithLabelValues(dl.Event, "failed").Inc()
+		slog.Warn("webhook delivery failed", "delivery_id", dl.ID.Hex(), "url", sub.URL, "attempts", dl.Attempts+1, "error", err)
+	default:
+		set["state"] = StatePending
+		set["last_error"] = err.Error()
+		deliveryAttempts.WithLabelValues(dl.Event, "retry").Inc()
+	}
+	d.record(ctx, &dl, set)
+	return err
+}
+
+func (d *Dispatcher) record(ctx context.Context, dl *Delivery, set bson.M) {
+	if _, err := d.mc.Collection("webhook_deliveries").UpdateByID(ctx, dl.ID, bson.M{"$set": set}); err != nil {
+		slog.Error("failed to record webhook delivery", "delivery_id", dl.ID.Hex(), "error", err)
+	}
+}
+
+// send POSTs the delivery, returning the response status.
+func (d *Dispatcher) send(ctx context.Context, sub *Subscription, dl *Delivery) (int, error) {
+	body := []byte(dl.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "users-api-webhooks")
+	req.Header.Set("X-Webhook-ID", dl.ID.Hex())
+	req.Header.Set("X-Webhook-Event", dl.Event)
+	req.Header.Set("X-Webhook-Schema", schemaRef(dl.Event).Path())
+	req.Header.Set("X-Webhook-Signature", Sign(sub.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
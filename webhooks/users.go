@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"context"
+	"log/slog"
+
+	"golang/store"
+)
+
+// Users publishes an event for every successful write through the wrapped
+// UserRepository. Publishing failures are logged and don't fail the write,
+// which has already happened.
+type Users struct {
+	store.UserRepository
+	d *Dispatcher
+}
+
+// NewUsers returns next publishing its writes through d.
+func NewUsers(next store.UserRepository, d *Dispatcher) *Users {
+	return &Users{UserRepository: next, d: d}
+}
+
+func (u *Users) Create(ctx context.Context, user *store.User) error {
+	if err := u.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	out := *user
+	out.Password, out.PasswordHash = "", ""
+	u.publish(ctx, UserCreated, out)
+	return nil
+}
+
+// Update publishes the user as stored after the update.
+func (u *Users) Update(ctx context.Context, id string, fields map[string]any) error {
+	if err := u.UserRepository.Update(ctx, id, fields); err != nil {
+		return err
+	}
+	user, err := u.UserRepository.Get(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to publish webhook event", "event", UserUpdated, "user_id", id, "error", err)
+		return nil
+	}
+	u.publish(ctx, UserUpdated, user)
+	return nil
+}
+
+func (u *Users) Delete(ctx context.Context, id string) error {
+	if err := u.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	u.publish(ctx, UserDeleted, map[string]string{"id": id})
+	return nil
+}
+
+func (u *Users) publish(ctx context.Context, event string, data any) {
+	if err := u.d.Publish(ctx, event, data); err != nil {
+		slog.ErrorContext(ctx, "failed to publish webhook event", "event", event, "error", err)
+	}
+}
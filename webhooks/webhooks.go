@@ -0,0 +1,313 @@
+// Package webhooks delivers user events to subscribed URLs.
+//
+// Subscriptions and the delivery log are kept in MongoDB. Publishing an
+// event stores one delivery per matching subscription and queues a job
+// (see package jobs) that POSTs it, retried with exponential backoff until
+// it succeeds or runs out of attempts.
+//
+// Every request is signed with the subscription's secret:
+//
+//	X-Webhook-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+//
+// Receivers should recompute the HMAC, compare it in constant time and
+// reject old timestamps. X-Webhook-ID identifies the delivery, so
+// receivers can drop the duplicates retries may cause. Bodies carry their
+// schema_version, and X-Webhook-Schema names their JSON Schema, served by
+// the API under /schemas.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang/db"
+	"golang/schemas"
+	"golang/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Events that can be subscribed to.
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+)
+
+// Events lists every event type.
+var Events = []string{UserCreated, UserUpdated, UserDeleted}
+
+// SchemaVersion is the version of the webhook bodies, whose JSON Schema
+// is webhook.<event> in package schemas. It changes only with
+// incompatible changes; new fields are added without a new version.
+const SchemaVersion = 1
+
+// schemaRef returns the schema of the bodies of event.
+func schemaRef(event string) schemas.Ref {
+	return schemas.Ref{Name: "webhook." + event, Version: SchemaVersion}
+}
+
+// Delivery states.
+const (
+	StatePending   = "pending" // waiting for its next attempt
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed" // out of attempts, or the subscription is gone
+)
+
+var (
+	// ErrNotFound is returned for unknown subscriptions and deliveries.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid wraps validation errors of subscriptions.
+	ErrInvalid = errors.New("invalid subscription")
+)
+
+// deliveryRetention is how long the delivery log keeps entries.
+const deliveryRetention = 30 * 24 * time.Hour
+
+func init() {
+	retention := deliveryRetention
+	db.RegisterIndexes(
+		db.IndexSpec{Collection: "webhooks", Name: "tenant_events", Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "events", Value: 1}}},
+		db.IndexSpec{Collection: "webhook_deliveries", Name: "subscription_created", Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		db.IndexSpec{Collection: "webhook_deliveries", Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, ExpireAfter: &retention},
+	)
+}
+
+// Subscription sends the listed events to URL.
+type Subscription struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	URL       string             `json:"url" bson:"url"`
+	Events    []string           `json:"events" bson:"events"`
+	Secret    string             `json:"-" bson:"secret"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Delivery is one event sent, or to be sent, to one subscription.
+type Delivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	SubscriptionID primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	TenantID       string             `json:"-" bson:"tenant_id,omitempty"`
+	JobID          primitive.ObjectID `json:"job_id" bson:"job_id,omitempty"`
+	Event          string             `json:"event" bson:"event"`
+	Body           string             `json:"body" bson:"body"`
+	State          string             `json:"state" bson:"state"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	LastStatus     int                `json:"last_status,omitempty" bson:"last_status,omitempty"`
+	LastError      string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	DeliveredAt    *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// scoped restricts filter to the tenant in ctx, like the user store.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	} else {
+		filter["tenant_id"] = nil
+	}
+	return filter
+}
+
+// Subscribe validates and stores s, setting its id and, if empty, a random
+// secret.
+func (d *Dispatcher) Subscribe(ctx context.Context, s *Subscription) error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	if len(s.Events) == 0 {
+		return fmt.Errorf("%w: events is required", ErrInvalid)
+	}
+	for _, e := range s.Events {
+		if !knownEvent(e) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalid, e)
+		}
+	}
+	if s.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		s.Secret = hex.EncodeToString(buf)
+	}
+	s.ID = primitive.NewObjectID()
+	s.TenantID = tenant.FromContext(ctx)
+	s.CreatedAt = time.Now().UTC()
+	_, err = d.mc.Collection("webhooks").InsertOne(ctx, s, options.InsertOne().SetComment(db.Comment(ctx)))
+	return err
+}
+
+func knownEvent(e string) bool {
+	for _, k := range Events {
+		if e == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriptions lists the subscriptions of the tenant in ctx.
+func (d *Dispatcher) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	cur, err := d.mc.Collection("webhooks").Find(ctx, scoped(ctx, bson.M{}),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	out := []Subscription{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Subscription returns subscription id of the tenant in ctx.
+func (d *Dispatcher) Subscription(ctx context.Context, id string) (*Subscription, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var s Subscription
+	err = d.mc.Collection("webhooks").FindOne(ctx, scoped(ctx, bson.M{"_id": oid}),
+		options.FindOne().SetComment(db.Comment(ctx))).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Unsubscribe deletes subscription id. Its pending deliveries fail on
+// their next attempt; the log is kept.
+func (d *Dispatcher) Unsubscribe(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	res, err := d.mc.Collection("webhooks").DeleteOne(ctx, scoped(ctx, bson.M{"_id": oid}),
+		options.Delete().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Deliveries lists the deliveries of subscription id, newest first.
+func (d *Dispatcher) Deliveries(ctx context.Context, id string, offset, limit int) ([]Delivery, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetSkip(int64(offset)).SetComment(db.Comment(ctx))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := d.mc.Collection("webhook_deliveries").Find(ctx, scoped(ctx, bson.M{"subscription_id": oid}), opts)
+	if err != nil {
+		return nil, err
+	}
+	out := []Delivery{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Redeliver queues delivery did of subscription id again with a fresh set
+// of attempts, whatever its state.
+func (d *Dispatcher) Redeliver(ctx context.Context, id, did string) error {
+	oid, err1 := primitive.ObjectIDFromHex(id)
+	doid, err2 := primitive.ObjectIDFromHex(did)
+	if err1 != nil || err2 != nil {
+		return ErrNotFound
+	}
+	res, err := d.mc.Collection("webhook_deliveries").UpdateOne(ctx,
+		scoped(ctx, bson.M{"_id": doid, "subscription_id": oid}),
+		bson.M{"$set": bson.M{"state": StatePending, "attempts": 0}},
+		options.Update().SetComment(db.Comment(ctx)))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return d.enqueue(ctx, doid)
+}
+
+// Publish stores a delivery of event for every subscription of the tenant
+// in ctx that wants it. data is sent as the "data" member of the body.
+func (d *Dispatcher) Publish(ctx context.Context, event string, data any) error {
+	cur, err := d.mc.Collection("webhooks").Find(ctx, scoped(ctx, bson.M{"events": event}),
+		options.Find().SetProjection(bson.M{"_id": 1}).SetComment(db.Comment(ctx)))
+	if err != nil {
+		return err
+	}
+	var subs []Subscription
+	if err := cur.All(ctx, &subs); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	docs := make([]any, len(subs))
+	for i, s := range subs {
+		id := primitive.NewObjectID()
+		body, err := json.Marshal(map[string]any{
+			"id":             id.Hex(),
+			"event":          event,
+			"schema_version": SchemaVersion,
+			"created_at":     now,
+			"tenant_id":      tenant.FromContext(ctx),
+			"data":           data,
+		})
+		if err != nil {
+			return err
+		}
+		docs[i] = Delivery{
+			ID:             id,
+			SubscriptionID: s.ID,
+			TenantID:       tenant.FromContext(ctx),
+			Event:          event,
+			Body:           string(body),
+			State:          StatePending,
+			CreatedAt:      now,
+		}
+	}
+	if _, err := d.mc.Collection("webhook_deliveries").InsertMany(ctx, docs, options.InsertMany().SetComment(db.Comment(ctx))); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := d.enqueue(ctx, doc.(Delivery).ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sign returns the X-Webhook-Signature value for body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang/config"
+	"golang/db"
+	"golang/email"
+	"golang/events"
+	"golang/jobs"
+	"golang/scheduler"
+	"golang/stats"
+	"golang/store"
+	"golang/webhooks"
+)
+
+// background is the job queue and the enabled features sending work
+// through it, and the event outbox.
+type background struct {
+	queue  *jobs.Queue
+	hooks  *webhooks.Dispatcher // nil unless WEBHOOKS_ENABLED
+	mailer *email.Mailer        // nil unless EMAIL_ENABLED
+	outbox *events.Outbox       // nil unless EVENTS_BROKER is set
+}
+
+// newJobs returns the job queue with the handlers of every enabled feature
+// registered. users is the storage backend, without the cache.
+func newJobs(cfg *config.Config, mc *db.MongoClient, users store.UserRepository) (*background, error) {
+	bg := &background{queue: jobs.New(mc, jobs.Options{
+		Workers:      cfg.Jobs.Workers,
+		PollInterval: cfg.Jobs.PollInterval,
+		Visibility:   cfg.Jobs.Visibility,
+	})}
+	if mongoUsers, ok := users.(*store.MongoUsers); ok && cfg.Storage.UserCleanup == "background" {
+		mongoUsers.CleanupInBackground(bg.queue)
+	}
+	if cfg.Webhooks.Enabled {
+		bg.hooks = webhooks.New(mc, bg.queue, webhooks.Options{
+			Timeout:     cfg.Webhooks.Timeout,
+			MaxAttempts: cfg.Webhooks.MaxAttempts,
+			Backoff:     cfg.Webhooks.Backoff,
+			MaxBackoff:  cfg.Webhooks.MaxBackoff,
+		})
+	}
+	if cfg.Email.Enabled {
+		mailer, err := email.New(bg.queue, email.Options{
+			Host:         cfg.Email.Host,
+			Port:         cfg.Email.Port,
+			Username:     cfg.Email.Username,
+			Password:     cfg.Email.Password,
+			TLS:          cfg.Email.TLS,
+			Timeout:      cfg.Email.Timeout,
+			From:         cfg.Email.From,
+			TemplatesDir: cfg.Email.TemplatesDir,
+		})
+		if err != nil {
+			return nil, err
+		}
+		bg.mailer = mailer
+	}
+	if cfg.Events.Broker != "" {
+		pub, err := newPublisher(cfg)
+		if err != nil {
+			return nil, err
+		}
+		bg.outbox = events.NewOutbox(mc, pub, events.Options{
+			Format:       cfg.Events.Format,
+			PollInterval: cfg.Events.RelayInterval,
+		})
+	}
+	return bg, nil
+}
+
+// newPublisher returns the client of the EVENTS_BROKER.
+func newPublisher(cfg *config.Config) (events.Publisher, error) {
+	if cfg.Events.Broker == "kafka" {
+		return events.NewKafka(events.KafkaOptions{
+			Brokers: cfg.Events.KafkaBrokers,
+			Topic:   cfg.Events.KafkaTopic,
+			TLS:     cfg.Events.KafkaTLS,
+			Timeout: cfg.Events.PublishTimeout,
+		})
+	}
+	return events.NewNATS(events.NATSOptions{
+		URL:     cfg.Events.NATSURL,
+		Token:   cfg.Events.NATSToken,
+		Subject: cfg.Events.NATSSubject,
+		Timeout: cfg.Events.PublishTimeout,
+	})
+}
+
+// newScheduler returns the scheduler with the enabled recurring tasks
+// registered. users is the storage backend, without the cache.
+func newScheduler(cfg *config.Config, mc *db.MongoClient, users store.UserRepository) (*scheduler.Scheduler, error) {
+	sched := scheduler.New(mc, scheduler.Options{Lease: cfg.Scheduler.LockTimeout})
+	if mongoUsers, ok := users.(*store.MongoUsers); ok && cfg.Storage.SoftDelete && cfg.Scheduler.PurgeDeleted != "" {
+		after := cfg.Scheduler.PurgeDeletedAfter
+		err := sched.Register("purge_deleted_users", cfg.Scheduler.PurgeDeleted, func(ctx context.Context) error {
+			n, err := mongoUsers.PurgeDeleted(ctx, time.Now().Add(-after))
+			slog.Info("purged soft-deleted users", "count", n, "deleted_before", after)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCHEDULE_PURGE_DELETED: %v", err)
+		}
+	}
+	if cfg.Stats.Enabled && cfg.Scheduler.Stats != "" {
+		st := stats.New(mc, cfg.Storage.SoftDelete)
+		if err := sched.Register("recompute_stats", cfg.Scheduler.Stats, st.Recompute); err != nil {
+			return nil, fmt.Errorf("invalid SCHEDULE_STATS: %v", err)
+		}
+	}
+	return sched, nil
+}
+
+// worker runs the background jobs, scheduled tasks and event relay
+// without serving the API, so slow work can be scaled apart from the servers (set
+// JOBS_IN_PROCESS=false and SCHEDULER_ENABLED=false there).
+func worker(args []string) error {
+	fs, configPath := newFlagSet("worker")
+	fs.Parse(args)
+
+	cfg, sec, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	defer sec.Close()
+	if cfg.Storage.Backend != "mongodb" {
+		return errors.New("the job queue requires STORAGE=mongodb")
+	}
+
+	st, err := openStorage(cfg, sec, connectMongo)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+
+	bg, err := newJobs(cfg, st.mongo, st.users)
+	if err != nil {
+		return err
+	}
+	sched, err := newScheduler(cfg, st.mongo, st.users)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("starting job worker", "workers", cfg.Jobs.Workers, "scheduler", cfg.Scheduler.Enabled)
+	var wg sync.WaitGroup
+	if cfg.Scheduler.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sched.Run(ctx)
+		}()
+	}
+	if bg.outbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bg.outbox.Run(ctx)
+		}()
+	}
+	bg.queue.Run(ctx)
+	wg.Wait()
+	slog.Info("job worker stopped")
+	return nil
+}